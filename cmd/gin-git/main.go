@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/G-Node/gin-repo/git"
@@ -15,18 +16,23 @@ func main() {
 	usage := `gin git tool.
 
 Usage:
-  gin-git show-pack <pack>
+  gin-git show-pack (<pack> | --midx)
   gin-git show-delta <pack> <sha1>
   gin-git cat-file <sha1>
-  gin-git rev-parse <ref>
+  gin-git rev-parse <rev>
   gin-git graph-common <base> <ref>
+  gin-git diff [-U <n>] <old> [<new>]
+  gin-git fsck [--no-dangling]
  
   gin-git -h | --help
   gin-git --version
 
 Options:
-  -h --help     Show this screen.
-  --version     Show version.
+  -h --help      Show this screen.
+  --version      Show version.
+  --midx         Show the multi-pack-index.
+  -U <n>         Number of context lines [default: 3].
+  --no-dangling  Do not report dangling objects.
 `
 	args, _ := docopt.Parse(usage, nil, true, "gin-git 0.1", false)
 	//fmt.Fprintf(os.Stderr, "%#v\n", args)
@@ -38,9 +44,18 @@ Options:
 	}
 
 	if val, ok := args["rev-parse"].(bool); ok && val {
-		revParse(repo, args["<ref>"].(string))
+		revParse(repo, args["<rev>"].(string))
 	} else if val, ok := args["show-pack"].(bool); ok && val {
-		showPack(repo, args["<pack>"].(string))
+		if midx, ok := args["--midx"].(bool); ok && midx {
+			showMultiPackIndex(repo)
+		} else {
+			showPack(repo, args["<pack>"].(string))
+		}
+	} else if val, ok := args["diff"].(bool); ok && val {
+		newRev, _ := args["<new>"].(string)
+		diff(repo, args["<old>"].(string), newRev, args["-U"].(string))
+	} else if val, ok := args["fsck"].(bool); ok && val {
+		fsck(repo, args["--no-dangling"].(bool))
 	} else if val, ok := args["show-delta"].(bool); ok && val {
 		showDelta(repo, args["<pack>"].(string), args["<sha1>"].(string))
 	} else if oid, ok := args["<sha1>"].(string); ok {
@@ -50,25 +65,44 @@ Options:
 	}
 }
 
-func revParse(repo *git.Repository, refstr string) {
-	ref, err := repo.OpenRef(refstr)
+func revParse(repo *git.Repository, rev string) {
+	id, err := repo.RevParse(rev)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(3)
 	}
 
-	id, err := ref.Resolve()
-	var idstr string
+	fmt.Printf("%s\n", rev)
+
+	ref, err := repo.OpenRef(rev)
 	if err != nil {
-		idstr = fmt.Sprintf("ERROR: %v", err)
-	} else {
-		idstr = fmt.Sprintf("%s", id)
+		fmt.Printf(" └── SHA1: %s\n", id)
+		return
 	}
 
-	fmt.Printf("%s\n", refstr)
 	fmt.Printf(" └┬─ name: %s\n", ref.Name())
 	fmt.Printf("  ├─ full: %s\n", ref.Fullname())
-	fmt.Printf("  └─ SHA1: %s\n", idstr)
+	fmt.Printf("  └─ SHA1: %s\n", id)
+}
+
+func fsck(repo *git.Repository, noDangling bool) {
+	report, err := repo.Fsck()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(3)
+	}
+
+	for _, issue := range report.Issues {
+		if !issue.IsError() && noDangling {
+			continue
+		}
+		fmt.Println(issue)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d objects checked (%d packs), %d issues\n", report.Objects, report.Packs, len(report.Issues))
+	if !report.OK() {
+		os.Exit(1)
+	}
 }
 
 func catFile(repo *git.Repository, idstr string) {
@@ -286,6 +320,51 @@ func showPack(repo *git.Repository, packid string) {
 	}
 }
 
+func showMultiPackIndex(repo *git.Repository) {
+	path := filepath.Join(repo.Path, "objects", "pack", "multi-pack-index")
+	midx, err := git.MultiPackIndexOpen(path)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer midx.Close()
+
+	fmt.Printf("MIDX v%d [%d objects]\n", midx.Version, midx.Count())
+	fmt.Printf("├─┬ packs\n")
+	for i, name := range midx.Packs {
+		lead := "├─"
+		if i == len(midx.Packs)-1 {
+			lead = "└─"
+		}
+		fmt.Printf("│ %s [%d] %s\n", lead, i, name)
+	}
+
+	fmt.Printf("└─┬ objects\n")
+	var oid git.SHA1
+	for k := 0; k < midx.Count(); k++ {
+		lead := "├─"
+		if k == midx.Count()-1 {
+			lead = "└─"
+		}
+
+		fmt.Printf("  %s ", lead)
+		err := midx.ReadSHA1(&oid, k)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			continue
+		}
+
+		pack, off, err := midx.ReadOffset(k)
+		if err != nil {
+			fmt.Printf("%s ERROR: %v\n", oid, err)
+			continue
+		}
+
+		fmt.Printf("%s [%d] @ %d\n", oid, pack, off)
+	}
+}
+
 func graphCommon(repo *git.Repository, basestr, refstr string) {
 	baseid, err := git.ParseSHA1(basestr)
 	if err != nil {
@@ -335,3 +414,96 @@ func graphCommon(repo *git.Repository, basestr, refstr string) {
 
 	fmt.Printf("}\n")
 }
+
+//diff shows the changes between two revisions, or, if
+//newRev is empty, between a commit and its first parent.
+func diff(repo *git.Repository, oldRev, newRev string, context string) {
+	var opts git.BlobDiffOptions
+	var err error
+
+	opts.Context, err = strconv.Atoi(context)
+	if err != nil || opts.Context < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid number of context lines: %q\n", context)
+		os.Exit(3)
+	}
+
+	if newRev == "" {
+		oldRev, newRev = oldRev+"^", oldRev
+	}
+
+	var ids [2]git.SHA1
+	for i, rev := range []string{oldRev, newRev} {
+		ids[i], err = repo.RevParse(rev)
+		if err != nil && i == 0 && strings.HasSuffix(rev, "^") {
+			//a root commit, diff against the empty tree
+			err = nil
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(3)
+		}
+	}
+
+	changes, err := repo.DiffTrees(ids[0], ids[1], git.DiffOptions{DetectRenames: true})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for i := range changes {
+		c := &changes[i]
+
+		oldName, newName := "a/"+c.OldPath, "b/"+c.NewPath
+		fmt.Printf("diff --git %s %s\n", "a/"+pathOr(c.OldPath, c.NewPath), "b/"+pathOr(c.NewPath, c.OldPath))
+
+		switch c.Status {
+		case git.ChangeAdded:
+			oldName = "/dev/null"
+			fmt.Printf("new file mode %06o\n", uint32(c.NewMode))
+		case git.ChangeDeleted:
+			newName = "/dev/null"
+			fmt.Printf("deleted file mode %06o\n", uint32(c.OldMode))
+		case git.ChangeRenamed, git.ChangeCopied:
+			verb := "rename"
+			if c.Status == git.ChangeCopied {
+				verb = "copy"
+			}
+			fmt.Printf("similarity index %d%%\n", c.Similarity)
+			fmt.Printf("%s from %s\n%s to %s\n", verb, c.OldPath, verb, c.NewPath)
+		default:
+			if c.OldMode != c.NewMode {
+				fmt.Printf("old mode %06o\nnew mode %06o\n", uint32(c.OldMode), uint32(c.NewMode))
+			}
+		}
+
+		if c.OldAnnexKey != "" || c.NewAnnexKey != "" {
+			fmt.Printf("annexed content: %s -> %s\n", keyOr(c.OldAnnexKey), keyOr(c.NewAnnexKey))
+			continue
+		}
+
+		d, err := repo.DiffChange(c, opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		err = d.WriteUnified(os.Stdout, oldName, newName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func pathOr(path, alt string) string {
+	if path == "" {
+		return alt
+	}
+	return path
+}
+
+func keyOr(key string) string {
+	if key == "" {
+		return "(not annexed)"
+	}
+	return key
+}
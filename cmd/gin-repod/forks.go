@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/G-Node/gin-repo/store"
+	"github.com/G-Node/gin-repo/wire"
+	"github.com/gorilla/mux"
+)
+
+// forkRepo forks the repository into the namespace of the requesting
+// user, under the name given in the (optional) request body.
+func (s *Server) forkRepo(w http.ResponseWriter, r *http.Request) {
+	rid, err := s.varsToRepoID(mux.Vars(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var creat wire.CreateFork
+	err = json.NewDecoder(r.Body).Decode(&creat)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	} else if creat.Name == "" {
+		creat.Name = rid.Name
+	}
+
+	if !checkName(creat.Name) {
+		http.Error(w, "Invalid repository name", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	} else if user == nil {
+		http.Error(w, "Authentication missing", http.StatusBadRequest)
+		return
+	}
+
+	fid := store.RepoId{Owner: user.Uid, Name: creat.Name}
+	repo, err := s.repos.ForkRepo(rid, fid)
+	if os.IsExist(err) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		s.log(WARN, "could not fork %s to %s: %v", rid, fid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	wr, err := s.repoToWire(fid, repo)
+	if err != nil {
+		s.log(WARN, "repo serialization error for %q [%v]", fid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(wr)
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
+
+// listRepoForks lists the forks of the repository
+// the requesting user has (at least) pull access to.
+func (s *Server) listRepoForks(w http.ResponseWriter, r *http.Request) {
+	rid, err := s.varsToRepoID(mux.Vars(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
+
+	uid := ""
+	if user != nil {
+		uid = user.Uid
+	}
+
+	ids, err := s.repos.ListForks(rid)
+	if err != nil {
+		s.log(WARN, "could not list forks of %s: %v", rid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	repos := []wire.Repo{}
+	for _, fid := range ids {
+		level, err := s.repos.GetAccessLevel(fid, uid)
+		if err != nil {
+			s.log(WARN, "Getting access level for %q failed: %v", fid, err)
+			continue
+		} else if level < store.PullAccess {
+			continue
+		}
+
+		repo, err := s.repos.OpenGitRepo(fid)
+		if err != nil {
+			s.log(WARN, "could not open repo @ %q", fid)
+			continue
+		}
+
+		wr, err := s.repoToWire(fid, repo)
+		if err != nil {
+			s.log(WARN, "repo serialization error for %q [%v]", fid, err)
+			continue
+		}
+
+		repos = append(repos, wr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(repos)
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
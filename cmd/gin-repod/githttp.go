@@ -0,0 +1,267 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/G-Node/gin-repo/git"
+	"github.com/G-Node/gin-repo/store"
+	"github.com/gorilla/mux"
+)
+
+//gitServices maps the services of the git smart HTTP
+//protocol to the access level they require.
+var gitServices = map[string]store.AccessLevel{
+	"git-upload-pack":  store.PullAccess,
+	"git-receive-pack": store.PushAccess,
+}
+
+//pktLine encodes s as git pkt-line, i.e. prefixed
+//with its length (including the prefix) in hex.
+func pktLine(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+//basicToBearer turns the token sent as password via Basic auth,
+//which is what git clients send, into a Bearer token. The user
+//name is ignored, the token alone identifies the user.
+func basicToBearer(r *http.Request) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Basic ") {
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header[6:]))
+	if err != nil {
+		return
+	}
+
+	idx := strings.Index(string(data), ":")
+	if token := string(data[idx+1:]); idx > -1 && token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		r.Header.Del("Authorization")
+	}
+}
+
+//gitAccess checks access to the repository for the git service. In
+//contrast to checkAccess, anonymous requests for repositories that
+//need authentication are answered with 401, so that git clients ask
+//for credentials.
+func (s *Server) gitAccess(w http.ResponseWriter, r *http.Request, service string) (store.RepoId, *store.User, bool) {
+	want, ok := gitServices[service]
+	if !ok {
+		http.Error(w, "Unsupported service", http.StatusForbidden)
+		return store.RepoId{}, nil, false
+	}
+
+	rid, err := s.varsToRepoID(mux.Vars(r))
+	if err != nil {
+		http.Error(w, "Nothing here. Move along.", http.StatusNotFound)
+		return rid, nil, false
+	}
+
+	basicToBearer(r)
+	if r.Header.Get("Authorization") == "" {
+		have, err := s.repos.GetAccessLevel(rid, "")
+		if err == nil && have < want {
+			w.Header().Set("WWW-Authenticate", `Basic realm="GIN"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return rid, nil, false
+		}
+	}
+
+	user, ok := s.checkAccess(w, r, rid, want)
+	return rid, user, ok
+}
+
+//gitCommand prepares the git command for service with the repository
+//at path, in stateless RPC mode, as the HTTP protocol needs it.
+func gitCommand(service, path string, user *store.User, r *http.Request, args ...string) *exec.Cmd {
+	args = append([]string{service[4:], "--stateless-rpc"}, args...)
+	cmd := exec.Command("git", append(args, path)...)
+	cmd.Stderr = os.Stderr
+
+	cmd.Env = os.Environ()
+	if user != nil {
+		cmd.Env = append(cmd.Env, "GIN_UID="+user.Uid)
+	}
+
+	if proto := r.Header.Get("Git-Protocol"); proto != "" {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+proto)
+	}
+
+	return cmd
+}
+
+//nativeService checks if the request is served by the git package
+//instead of the git binary, i.e. for all pushes and for protocol v2
+//fetches.
+func nativeService(service string, r *http.Request) bool {
+	return service == "git-receive-pack" || strings.Contains(r.Header.Get("Git-Protocol"), "version=2")
+}
+
+//receivePack prepares the receive-pack for a push of user,
+//with the GIN policy for ref updates.
+func receivePack(repo *git.Repository, user *store.User) *git.ReceivePack {
+	var who git.Signature
+	if user != nil {
+		who.Name = user.Uid
+	}
+
+	rp := git.NewReceivePack(repo, who)
+	rp.Check = func(cmd *git.RefCommand) error {
+		return checkPush(repo, cmd)
+	}
+	return rp
+}
+
+//checkPush checks ref updates of pushes: only branches and tags can
+//be pushed and the branch HEAD points to cannot be deleted.
+func checkPush(repo *git.Repository, cmd *git.RefCommand) error {
+	if !strings.HasPrefix(cmd.Name, "refs/heads/") && !strings.HasPrefix(cmd.Name, "refs/tags/") {
+		return fmt.Errorf("only branches and tags can be pushed")
+	}
+
+	if cmd.New != (git.SHA1{}) {
+		return nil
+	}
+
+	head, err := repo.OpenRef("HEAD")
+	if sym, ok := head.(*git.SymbolicRef); err == nil && ok && sym.Symbol == cmd.Name {
+		return fmt.Errorf("the default branch cannot be deleted")
+	}
+
+	return nil
+}
+
+func noCache(w http.ResponseWriter) {
+	w.Header().Set("Expires", "Fri, 01 Jan 1980 00:00:00 GMT")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+}
+
+func (s *Server) gitInfoRefs(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("service")
+	if service == "" {
+		//the dumb protocol is not supported
+		http.Error(w, "Only the smart HTTP protocol is supported", http.StatusForbidden)
+		return
+	}
+
+	rid, user, ok := s.gitAccess(w, r, service)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		s.log(WARN, "could not open repo @ %q: %v", rid, err)
+		http.Error(w, "Nothing here. Move along.", http.StatusNotFound)
+		return
+	}
+
+	noCache(w)
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+
+	if nativeService(service, r) {
+		if service == "git-receive-pack" {
+			io.WriteString(w, pktLine(fmt.Sprintf("# service=%s\n", service))+"0000")
+			err = receivePack(repo, user).Advertise(w)
+		} else {
+			err = git.NewUploadPack(repo).Advertise(w)
+		}
+
+		if err != nil {
+			s.log(WARN, "advertisement failed for %q: %v", rid, err)
+		}
+		return
+	}
+
+	cmd := gitCommand(service, repo.Path, user, r, "--advertise-refs")
+	out, err := cmd.Output()
+	if err != nil {
+		s.log(WARN, "%s --advertise-refs failed for %q: %v", service, rid, err)
+		http.Error(w, "Internal server error :(", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	//protocol v2 responses start with the capabilities directly
+	if !strings.Contains(r.Header.Get("Git-Protocol"), "version=2") {
+		io.WriteString(w, pktLine(fmt.Sprintf("# service=%s\n", service))+"0000")
+	}
+	w.Write(out)
+}
+
+func (s *Server) gitUploadPack(w http.ResponseWriter, r *http.Request) {
+	s.gitServiceRPC(w, r, "git-upload-pack")
+}
+
+func (s *Server) gitReceivePack(w http.ResponseWriter, r *http.Request) {
+	s.gitServiceRPC(w, r, "git-receive-pack")
+}
+
+func (s *Server) gitServiceRPC(w http.ResponseWriter, r *http.Request, service string) {
+	rid, user, ok := s.gitAccess(w, r, service)
+	if !ok {
+		return
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != fmt.Sprintf("application/x-%s-request", service) {
+		http.Error(w, "Unexpected content type", http.StatusBadRequest)
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		s.log(WARN, "could not open repo @ %q: %v", rid, err)
+		http.Error(w, "Nothing here. Move along.", http.StatusNotFound)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	noCache(w)
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
+
+	if nativeService(service, r) {
+		if service == "git-receive-pack" {
+			err = receivePack(repo, user).Serve(body, w)
+		} else {
+			err = git.NewUploadPack(repo).Serve(body, w)
+		}
+
+		if err != nil {
+			s.log(WARN, "%s failed for %q: %v", service, rid, err)
+		}
+		return
+	}
+
+	cmd := gitCommand(service, repo.Path, user, r)
+	cmd.Stdin = body
+	cmd.Stdout = w
+
+	//NB: the status is sent with the first output, errors
+	// after that can only be logged
+	err = cmd.Run()
+	if err != nil {
+		s.log(WARN, "%s failed for %q: %v", service, rid, err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/gin-repo/store"
+)
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=/bin/false",
+		"GIT_AUTHOR_NAME=A U Thor", "GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_COMMITTER_NAME=C O Mitter", "GIT_COMMITTER_EMAIL=committer@example.com")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestGitHTTP(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("[W] Could not find git binary. Skipping test")
+	}
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "gin-repod-http")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	token, err := server.users.TokenForUser("alice")
+	if err != nil {
+		t.Fatalf("could not make token for alice: %v", err)
+	}
+
+	withAuth := func(user, token string) string {
+		return strings.Replace(ts.URL, "http://", "http://"+user+":"+token+"@", 1)
+	}
+
+	//public repositories can be cloned anonymously
+	out, err := runGit(dir, "clone", "-q", ts.URL+"/alice/auth.git", "public")
+	if err != nil {
+		t.Fatalf("could not clone public repository: %v\n%s", err, out)
+	}
+
+	//private ones need authentication
+	resp, err := http.Get(ts.URL + "/alice/exrepo.git/info/refs?service=git-upload-pack")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 with WWW-Authenticate, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/alice/exrepo.git/info/refs")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected dumb protocol to be forbidden, got %d", resp.StatusCode)
+	}
+
+	out, err = runGit(dir, "clone", "-q", ts.URL+"/alice/exrepo.git", "anonymous")
+	if err == nil {
+		t.Fatalf("expected anonymous clone of private repository to fail")
+	}
+
+	//the token is sent as password via Basic auth
+	out, err = runGit(dir, "clone", "-q", withAuth("alice", token)+"/alice/exrepo.git", "private")
+	if err != nil {
+		t.Fatalf("could not clone private repository: %v\n%s", err, out)
+	}
+
+	//bearer tokens work as well
+	req, _ := http.NewRequest("GET", ts.URL+"/alice/exrepo.git/info/refs?service=git-upload-pack", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(data), "001e# service=git-upload-pack\n0000") {
+		t.Fatalf("unexpected ref advertisement: %d %q", resp.StatusCode, data)
+	} else if ct := resp.Header.Get("Content-Type"); ct != "application/x-git-upload-pack-advertisement" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	rid := store.RepoId{Owner: "alice", Name: "httppush"}
+	if _, err := server.repos.CreateRepo(rid); err != nil {
+		t.Fatalf("could not create repository: %v", err)
+	}
+	defer os.RemoveAll(server.repos.IdToPath(rid))
+
+	local := filepath.Join(dir, "private")
+	out, err = runGit(local, "push", "-q", ts.URL+"/alice/httppush.git", "master")
+	if err == nil {
+		t.Fatalf("expected anonymous push to fail")
+	}
+
+	bob, err := server.users.TokenForUser("bob")
+	if err != nil {
+		t.Fatalf("could not make token for bob: %v", err)
+	}
+
+	out, err = runGit(local, "push", "-q", withAuth("bob", bob)+"/alice/httppush.git", "master")
+	if err == nil {
+		t.Fatalf("expected push without access to fail")
+	}
+
+	out, err = runGit(local, "push", "-q", withAuth("alice", token)+"/alice/httppush.git", "master")
+	if err != nil {
+		t.Fatalf("could not push: %v\n%s", err, out)
+	}
+
+	repo, err := server.repos.OpenGitRepo(rid)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+
+	pushed, err := repo.RevParse("master")
+	if err != nil {
+		t.Fatalf("pushed branch not found: %v", err)
+	}
+
+	head, _ := runGit(local, "rev-parse", "master")
+	if pushed.String() != strings.TrimSpace(head) {
+		t.Fatalf("pushed branch points to %s, expected %s", pushed, head)
+	}
+
+	//GIN policy: only branches and tags, the default branch stays
+	for _, spec := range []string{"master:refs/notes/x", ":master"} {
+		out, err = runGit(local, "push", "-q", withAuth("alice", token)+"/alice/httppush.git", spec)
+		if err == nil {
+			t.Fatalf("expected push of %q to be rejected", spec)
+		}
+	}
+
+	out, err = runGit(local, "push", "-q", withAuth("alice", token)+"/alice/httppush.git", "master:side")
+	if err != nil {
+		t.Fatalf("could not push branch: %v\n%s", err, out)
+	} else if _, err := repo.RevParse("side"); err != nil {
+		t.Fatalf("pushed branch not found: %v", err)
+	}
+
+	out, err = runGit(local, "push", "-q", withAuth("alice", token)+"/alice/httppush.git", ":side")
+	if err != nil {
+		t.Fatalf("could not delete branch: %v\n%s", err, out)
+	} else if _, err := repo.RevParse("side"); err == nil {
+		t.Fatalf("deleted branch still exists")
+	}
+}
@@ -6,9 +6,13 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/G-Node/gin-repo/git"
 	"github.com/G-Node/gin-repo/store"
 	"github.com/G-Node/gin-repo/wire"
+	"github.com/gorilla/mux"
 )
 
 func (s *Server) repoAccess(w http.ResponseWriter, r *http.Request) {
@@ -105,3 +109,71 @@ func (s *Server) hooksFire(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 }
+
+func (s *Server) repoFsck(w http.ResponseWriter, r *http.Request) {
+	rid, err := s.varsToRepoID(mux.Vars(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// maintenance must not remove packs while we check them
+	lock, err := repo.LockShared()
+	if err != nil {
+		s.log(WARN, "repoFsck: could not lock %q: %v", rid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer lock.Unlock()
+
+	report, err := repo.Fsck()
+	if err != nil {
+		s.log(WARN, "repoFsck: fsck of %q failed: %v", rid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !report.OK() {
+		s.log(WARN, "repoFsck: %q has %d issues", rid, len(report.Issues))
+	}
+
+	data, err := json.Marshal(fsckToWire(repo, report))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func fsckToWire(repo *git.Repository, report *git.FsckReport) wire.FsckReport {
+	res := wire.FsckReport{
+		Objects: report.Objects,
+		Packs:   report.Packs,
+		OK:      report.OK(),
+		Issues:  []wire.FsckIssue{},
+	}
+
+	for _, issue := range report.Issues {
+		wi := wire.FsckIssue{
+			Kind:    string(issue.Kind),
+			Path:    strings.TrimPrefix(issue.Path, repo.Path+string(filepath.Separator)),
+			Message: issue.Message,
+			Error:   issue.IsError(),
+		}
+
+		if issue.ID != (git.SHA1{}) {
+			wi.Object = issue.ID.String()
+		}
+
+		res.Issues = append(res.Issues, wi)
+	}
+
+	return res
+}
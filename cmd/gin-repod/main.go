@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/G-Node/gin-repo/auth"
 	"github.com/G-Node/gin-repo/store"
@@ -26,6 +27,8 @@ type Server struct {
 
 	users store.UserStore
 	repos *store.RepoStore
+
+	pruneExpire time.Duration
 }
 
 type LogLevel int
@@ -220,7 +223,7 @@ func (s *Server) ListenAndServe() error {
 }
 
 func NewServer(addr string) *Server {
-	s := &Server{Server: http.Server{Addr: addr}, Root: mux.NewRouter()}
+	s := &Server{Server: http.Server{Addr: addr}, Root: mux.NewRouter(), pruneExpire: defaultPruneExpire}
 	s.Handler = s
 	return s
 }
@@ -229,7 +232,7 @@ func main() {
 	usage := `gin repo daemon.
 
 Usage:
-  gin-repod [--listen=<address>]
+  gin-repod [--listen=<address>] [--gc-interval=<d>] [--prune-expire=<d>]
   gin-repod make-token <user>
   gin-repod -h | --help
   gin-repod --version
@@ -239,6 +242,8 @@ Options:
   -h --help            Show this screen.
   --version            Show version.
   --listen=<address>   Address to listen on [default: :8082]
+  --gc-interval=<d>    Interval of the repository maintenance, 0 disables it [default: 24h]
+  --prune-expire=<d>   Grace period for unreachable objects [default: 336h]
   `
 
 	args, err := docopt.Parse(usage, nil, true, "gin repod 0.1a", false)
@@ -250,7 +255,19 @@ Options:
 
 	fmt.Println(args)
 
+	gcInterval, err := time.ParseDuration(args["--gc-interval"].(string))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid maintenance interval: %v\n", err)
+		os.Exit(-1)
+	}
+
 	s := NewServer(args["--listen"].(string))
+	s.pruneExpire, err = time.ParseDuration(args["--prune-expire"].(string))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid prune grace period: %v\n", err)
+		os.Exit(-1)
+	}
+
 	s.SetupRoutes()
 	s.SetupServiceSecret()
 	s.SetupStores()
@@ -264,5 +281,9 @@ Options:
 	// a command line "command"
 	s.handleCommands(args)
 
+	if gcInterval > 0 {
+		go s.runMaintenance(gcInterval)
+	}
+
 	s.ListenAndServe()
 }
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/G-Node/gin-repo/auth"
 	"github.com/G-Node/gin-repo/internal/testbed"
 	"github.com/G-Node/gin-repo/store"
+	"github.com/G-Node/gin-repo/wire"
 )
 
 var server *Server
@@ -143,3 +149,170 @@ func TestObjectAccess(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestBlobRange(t *testing.T) {
+	repo, err := server.repos.OpenGitRepo(store.RepoId{Name: "exrepo", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the symlink to analysis/paper.sh
+	id, err := repo.RevParse("master:paper.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := fmt.Sprintf("/users/alice/repos/exrepo/objects/%s", id)
+	req := NewGet(t, url, "alice")
+	rr, err := makeRequest(req, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	} else if body := rr.Body.String(); body != "analysis/paper.sh" {
+		t.Fatalf("unexpected blob content: %q", body)
+	}
+
+	req = NewGet(t, url, "alice")
+	req.Header.Set("Range", "bytes=9-")
+	rr, err = makeRequest(req, http.StatusPartialContent)
+	if err != nil {
+		t.Fatal(err)
+	} else if body := rr.Body.String(); body != "paper.sh" {
+		t.Fatalf("unexpected blob range: %q", body)
+	}
+}
+
+func TestRepoFsck(t *testing.T) {
+	url := "/intern/repos/alice/exrepo/fsck"
+
+	//only for services, not users
+	req := NewGet(t, url, "alice")
+	_, err := makeRequest(req, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := auth.MakeServiceToken(server.srvKey)
+	if err != nil {
+		t.Fatalf("could not make service token: %v", err)
+	}
+
+	req = NewGet(t, "/intern/repos/alice/nonexistent/fsck", "")
+	req.Header.Add("Authorization", "Bearer "+token)
+	_, err = makeRequest(req, http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = NewGet(t, url, "")
+	req.Header.Add("Authorization", "Bearer "+token)
+	rr, err := makeRequest(req, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report wire.FsckReport
+	err = json.Unmarshal(rr.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatalf("could not decode report: %v", err)
+	} else if !report.OK || report.Objects == 0 {
+		t.Fatalf("unexpected report for healthy repository: %+v", report)
+	}
+}
+
+func TestRepoMaintenance(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("[W] Could not find git binary. Skipping test")
+	}
+
+	// a repository of its own, since other tests leave
+	// unreachable objects in the shared ones
+	rid := store.RepoId{Owner: "bob", Name: "maintained"}
+	repo, err := server.repos.CreateRepo(rid)
+	if err != nil {
+		t.Fatalf("could not create repository: %v", err)
+	}
+	defer os.RemoveAll(repo.Path)
+
+	src, err := filepath.Abs(server.repos.IdToPath(store.RepoId{Owner: "bob", Name: "repod"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit(repo.Path, "fetch", "-q", src, "+refs/heads/*:refs/heads/*"); err != nil {
+		t.Fatalf("could not fetch objects: %v\n%s", err, out)
+	}
+
+	url := "/intern/repos/bob/maintained/gc"
+
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := server.users.TokenForUser("bob")
+	if err != nil {
+		t.Fatalf("could not make token for bob: %v", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+	_, err = makeRequest(req, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err = auth.MakeServiceToken(server.srvKey)
+	if err != nil {
+		t.Fatalf("could not make service token: %v", err)
+	}
+	header := map[string]string{"Authorization": "Bearer " + token}
+
+	_, err = RunRequest("POST", "/intern/repos/bob/nonexistent/gc", nil, header, http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := repo.RevParse("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//no maintenance during pushes
+	lock, err := repo.LockShared()
+	if err != nil {
+		t.Fatalf("could not lock repository: %v", err)
+	}
+
+	_, err = RunRequest("POST", url, nil, header, http.StatusConflict)
+	lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := RunRequest("POST", url, nil, header, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res wire.Maintenance
+	err = json.Unmarshal(rr.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatalf("could not decode result: %v", err)
+	}
+
+	// all reachable objects are in the one pack
+	objects, err := runGit(repo.Path, "rev-list", "--objects", "--all")
+	if err != nil {
+		t.Fatalf("could not list objects: %v\n%s", err, objects)
+	}
+
+	loose, packs, err := repo.CountObjects()
+	if n := strings.Count(objects, "\n"); res.Objects != n {
+		t.Fatalf("expected %d objects in the pack, got %+v", n, res)
+	} else if err != nil || loose != 0 || packs != 1 {
+		t.Fatalf("expected 1 pack and no loose objects, got %d and %d (%v)", packs, loose, err)
+	} else if id, err := repo.RevParse("master"); err != nil || id != head {
+		t.Fatalf("master is %s after maintenance (%v), expected %s", id, err, head)
+	}
+
+	report, err := repo.Fsck()
+	if err != nil || !report.OK() {
+		t.Fatalf("repository broken after maintenance: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/G-Node/gin-repo/git"
+	"github.com/G-Node/gin-repo/store"
+	"github.com/G-Node/gin-repo/wire"
+	"github.com/gorilla/mux"
+)
+
+//defaultPruneExpire is the grace period for unreachable
+//objects, the same as git's default of two weeks.
+const defaultPruneExpire = 14 * 24 * time.Hour
+
+//maintainRepo repacks the repository, if it has loose objects or
+//more than one pack, prunes unreachable objects older than the grace
+//period and packs the refs. Repositories with forks are repacked with
+//a cruft pack for the unreachable objects instead and nothing is
+//pruned. It holds the exclusive repository lock while doing so, i.e.
+//it returns git.ErrRepoBusy during pushes.
+func (s *Server) maintainRepo(rid store.RepoId) (wire.Maintenance, error) {
+	var res wire.Maintenance
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		return res, err
+	}
+
+	lock, err := repo.TryLockExclusive()
+	if err != nil {
+		return res, err
+	}
+	defer lock.Unlock()
+
+	// forks borrow objects via alternates, which need not be
+	// reachable from our refs (anymore), so we must not prune
+	forks, err := s.repos.ListForks(rid)
+	if err != nil {
+		return res, err
+	}
+
+	loose, packs, err := repo.CountObjects()
+	if err != nil {
+		return res, err
+	}
+
+	cruft := len(forks) > 0
+	if cruft {
+		// cruft packs stay, they do not need repacking
+		n, err := repo.CountCruftPacks()
+		if err != nil {
+			return res, err
+		}
+		packs -= n
+	}
+
+	if loose > 0 || packs > 1 {
+		expire := time.Now().Add(-s.pruneExpire)
+
+		var rr *git.RepackResult
+		if cruft {
+			rr, err = repo.RepackCruft()
+		} else {
+			rr, err = repo.Repack(expire)
+		}
+		if err != nil {
+			return res, err
+		}
+
+		if rr.Pack != (git.SHA1{}) {
+			res.Pack = rr.Pack.String()
+		}
+		res.Objects = rr.Objects
+		res.PacksRemoved = rr.Removed
+		res.LooseRemoved = rr.Loose
+		res.Unpacked = rr.Unpacked
+		res.Cruft = rr.Cruft
+
+		if !cruft {
+			res.Pruned, err = repo.PruneLoose(expire)
+			if err != nil {
+				return res, err
+			}
+		}
+	}
+
+	res.RefsPacked, err = repo.PackRefs()
+	return res, err
+}
+
+//maintainAll runs maintainRepo for all repositories. Repositories
+//that are busy are skipped, they are done in the next run.
+func (s *Server) maintainAll() {
+	ids, err := s.repos.ListRepos()
+	if err != nil {
+		s.log(WARN, "maintenance: could not list repos: %v", err)
+		return
+	}
+
+	for _, rid := range ids {
+		res, err := s.maintainRepo(rid)
+		if err == git.ErrRepoBusy {
+			s.log(INFO, "maintenance: %q is busy, skipped", rid)
+		} else if err != nil {
+			s.log(WARN, "maintenance: %q failed: %v", rid, err)
+		} else if res.Pack != "" || res.Cruft > 0 || res.Pruned > 0 || res.RefsPacked > 0 {
+			s.log(INFO, "maintenance: %q: %d objects packed, %d kept as cruft, %d packs and %d loose objects removed, %d pruned, %d refs packed",
+				rid, res.Objects, res.Cruft, res.PacksRemoved, res.LooseRemoved, res.Pruned, res.RefsPacked)
+		}
+	}
+}
+
+//runMaintenance runs the maintenance of all
+//repositories every interval, forever.
+func (s *Server) runMaintenance(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.maintainAll()
+	}
+}
+
+func (s *Server) repoMaintenance(w http.ResponseWriter, r *http.Request) {
+	rid, err := s.varsToRepoID(mux.Vars(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if ok, err := s.repos.RepoExists(rid); err != nil || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	res, err := s.maintainRepo(rid)
+	if err == git.ErrRepoBusy {
+		http.Error(w, "Repository is busy", http.StatusConflict)
+		return
+	} else if err != nil {
+		s.log(WARN, "repoMaintenance: %q failed: %v", rid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
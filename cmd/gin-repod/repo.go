@@ -10,7 +10,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/G-Node/gin-repo/git"
 	"github.com/G-Node/gin-repo/store"
@@ -125,6 +129,12 @@ func (s *Server) repoToWire(id store.RepoId, repo *git.Repository) (wire.Repo, e
 		Shared:      shared,
 	}
 
+	if src, ok, err := s.repos.ForkOf(id); err != nil {
+		s.log(WARN, "could not read origin of %s: %v", id, err)
+	} else if ok {
+		wr.ForkOf = src.String()
+	}
+
 	return wr, nil
 }
 
@@ -463,9 +473,14 @@ func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	oid, err := git.ParseSHA1(isha1)
+	// abbreviated ids only match objects of the repository itself,
+	// full ones could still be found in the origin of a fork
+	oid, err := repo.RevParse(isha1)
+	if err == nil {
+		err = repo.CheckReachable(oid)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
@@ -475,10 +490,36 @@ func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.objectToWire(w, repo, obj)
+	if _, ok := obj.(*git.Blob); ok {
+		obj.Close()
+		s.serveBlob(w, r, repo, oid)
+		return
+	}
+
+	s.objectToWire(w, repo, oid, obj, nil)
+}
+
+// serveBlob serves the content of the blob oid with support for range
+// requests. Large blobs are read on demand, so memory use is bounded.
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request, repo *git.Repository, oid git.SHA1) {
+	br, err := repo.OpenBlobReader(oid)
+	if err != nil {
+		s.log(WARN, "serveBlob: could not open blob %s in %q: %v", oid, repo.Path, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer br.Close()
+
+	// git objects never change
+	w.Header().Set("ETag", fmt.Sprintf("%q", oid))
+	http.ServeContent(w, r, "", time.Time{}, br)
 }
 
-func (s *Server) objectToWire(w http.ResponseWriter, repo *git.Repository, obj git.Object) {
+// objectToWire writes the object obj, with the id oid, to w: blobs as they
+// are, the other objects as JSON. For signed commits and tags the result
+// of the signature verification is included. Submodules, i.e. entries of
+// trees or obj itself, are linked via links, keyed by name, if present.
+func (s *Server) objectToWire(w http.ResponseWriter, repo *git.Repository, oid git.SHA1, obj git.Object, links map[string]submoduleLink) {
 	out := bufio.NewWriter(w)
 	switch obj := obj.(type) {
 	case *git.Commit:
@@ -491,6 +532,9 @@ func (s *Server) objectToWire(w http.ResponseWriter, repo *git.Repository, obj g
 		}
 		out.WriteString(fmt.Sprintf("%q: %q,\n", "author", obj.Author))
 		out.WriteString(fmt.Sprintf("%q: %q,\n", "commiter", obj.Committer))
+		if obj.GPGSig != "" {
+			s.writeSignature(out, repo, oid)
+		}
 		out.WriteString(fmt.Sprintf("%q: %q", "message", obj.Message))
 		out.WriteString("}")
 
@@ -508,6 +552,11 @@ func (s *Server) objectToWire(w http.ResponseWriter, repo *git.Repository, obj g
 			}
 			entry := obj.Entry()
 			out.WriteString("{")
+			submodule := entry.IsSubmodule()
+			if submodule {
+				out.WriteString(fmt.Sprintf("%q: %q,\n", "type", "submodule"))
+				writeSubmoduleLink(out, links[entry.Name])
+			}
 			symlink := entry.Mode == 00120000
 			if symlink {
 				//refactor: too much nesting
@@ -535,7 +584,7 @@ func (s *Server) objectToWire(w http.ResponseWriter, repo *git.Repository, obj g
 				}
 			}
 
-			if !symlink {
+			if !symlink && !submodule {
 				out.WriteString(fmt.Sprintf("%q: %q,\n", "type", entry.Type))
 			}
 			out.WriteString(fmt.Sprintf("%q: %q,\n", "id", entry.ID))
@@ -551,20 +600,50 @@ func (s *Server) objectToWire(w http.ResponseWriter, repo *git.Repository, obj g
 			n = m
 		}
 		buf := make([]byte, n)
-		_, err := obj.Read(buf[:])
+		k, err := obj.Read(buf[:])
 		if err != nil {
-			panic("IO error")
+			//most likely a corrupted object, see repoFsck
+			s.log(WARN, "objectToWire: could not read blob in %q: %v", repo.Path, err)
+			obj.Close()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
-		mtype := http.DetectContentType(buf)
+		mtype := http.DetectContentType(buf[:k])
 		w.Header().Set("Content-Type", mtype)
 
-		w.Write(buf)
-		_, err = io.Copy(w, obj)
+		w.Write(buf[:k])
+		// NB: not io.Copy, which would use Blob.WriteTo and so
+		// write the object header as well
+		_, err = io.CopyN(w, obj, obj.Size()-int64(k))
 		if err != nil {
 			s.log(WARN, "io error, but data already written")
 		}
 		obj.Close()
 
+	case *git.Tag:
+		w.Header().Set("Content-Type", "application/json")
+		out.WriteString("{")
+		out.WriteString(fmt.Sprintf("%q: %q,\n", "type", "tag"))
+		out.WriteString(fmt.Sprintf("%q: %q,\n", "object", obj.Object))
+		out.WriteString(fmt.Sprintf("%q: %q,\n", "objtype", obj.ObjType))
+		out.WriteString(fmt.Sprintf("%q: %q,\n", "tag", obj.Tag))
+		out.WriteString(fmt.Sprintf("%q: %q,\n", "tagger", obj.Tagger))
+		if obj.GPGSig != "" {
+			out.WriteString(fmt.Sprintf("%q: %q,\n", "gpgsig", obj.GPGSig))
+			s.writeSignature(out, repo, oid)
+		}
+		out.WriteString(fmt.Sprintf("%q: %q", "message", obj.Message))
+		out.WriteString("}")
+
+	case *git.Submodule:
+		w.Header().Set("Content-Type", "application/json")
+		out.WriteString("{")
+		out.WriteString(fmt.Sprintf("%q: %q,\n", "type", "submodule"))
+		out.WriteString(fmt.Sprintf("%q: %q,\n", "commit", obj.Commit))
+		writeSubmoduleLink(out, links[path.Base(obj.Path)])
+		out.WriteString(fmt.Sprintf("%q: %q", "path", obj.Path))
+		out.WriteString("}")
+
 	default:
 		w.Header().Set("Content-Type", "application/json")
 		out.WriteString("{")
@@ -584,17 +663,21 @@ func (s *Server) browseRepo(w http.ResponseWriter, r *http.Request) {
 	ibranch := ivars["branch"]
 	ipath := ivars["path"]
 
-	//for now we only support master :(
-	if err != nil || ibranch != "master" {
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	user, ok := s.checkAccess(w, r, rid, store.PullAccess)
 	if !ok {
 		return
 	}
 
+	uid := ""
+	if user != nil {
+		uid = user.Uid
+	}
+
 	s.log(DEBUG, "branch: %q, ipath: %q", ibranch, ipath)
 
 	repo, err := s.repos.OpenGitRepo(rid)
@@ -603,15 +686,12 @@ func (s *Server) browseRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ref, err := repo.OpenRef(ibranch)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+	id, err := repo.RevParse(ibranch)
+	if err == nil {
+		err = repo.CheckReachable(id)
 	}
-
-	id, err := ref.Resolve()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
@@ -631,7 +711,19 @@ func (s *Server) browseRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.objectToWire(w, repo, obj)
+	var links map[string]submoduleLink
+	switch obj := obj.(type) {
+	case *git.Tree:
+		links, err = s.treeSubmodules(r, rid, uid, repo, id, ipath)
+		if err != nil {
+			s.log(WARN, "could not get submodules of %s in %s: %v", id, rid, err)
+		}
+	case *git.Submodule:
+		links = map[string]submoduleLink{path.Base(obj.Path): s.submoduleToLink(r, rid, uid, obj)}
+	}
+
+	// only trees, blobs and submodules are found by path, which need no id
+	s.objectToWire(w, repo, git.SHA1{}, obj, links)
 }
 
 // patchRepoSettings patches repository description and public status.
@@ -938,17 +1030,23 @@ func (s *Server) listRepoCommits(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ok, err = repo.BranchExists(ibranch)
+	head, err := repo.RevParse(ibranch)
+	if err == nil {
+		err = repo.CheckReachable(head)
+	}
 	if err != nil {
-		s.log(WARN, "error checking branch %q [%v]", ibranch, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	} else if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	comList, err := repo.CommitsForRef(ibranch)
+	opts, err := logOptionsFromQuery(r)
+	if err != nil {
+		s.log(DEBUG, "invalid log options: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	comList, err := repo.Log(ibranch, opts)
 	if err != nil {
 		s.log(WARN, "error fetching commits [%v]", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -968,6 +1066,164 @@ func (s *Server) listRepoCommits(w http.ResponseWriter, r *http.Request) {
 		res[i].DateRelative = v.DateRelative
 		res[i].Subject = v.Subject
 		res[i].Changes = v.Changes
+
+		if id, err := git.ParseSHA1(v.Commit); err == nil {
+			res[i].Signature = s.verifySignature(repo, id)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(res)
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
+
+//logOptionsFromQuery parses the optional "max-count", "skip", "since"
+//and "until" query parameters of a commit listing request. Dates can
+//be given as RFC 3339 timestamps or as plain dates (YYYY-MM-DD).
+func logOptionsFromQuery(r *http.Request) (git.LogOptions, error) {
+	var opts git.LogOptions
+	query := r.URL.Query()
+
+	var err error
+	if val := query.Get("max-count"); val != "" {
+		opts.MaxCount, err = strconv.Atoi(val)
+		if err != nil || opts.MaxCount < 0 {
+			return opts, fmt.Errorf("invalid max-count %q", val)
+		}
+	}
+
+	if val := query.Get("skip"); val != "" {
+		opts.Skip, err = strconv.Atoi(val)
+		if err != nil || opts.Skip < 0 {
+			return opts, fmt.Errorf("invalid skip %q", val)
+		}
+	}
+
+	parseDate := func(val string) (time.Time, error) {
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", val)
+		}
+		return t, err
+	}
+
+	if val := query.Get("since"); val != "" {
+		opts.Since, err = parseDate(val)
+		if err != nil {
+			return opts, fmt.Errorf("invalid since %q", val)
+		}
+	}
+
+	if val := query.Get("until"); val != "" {
+		opts.Until, err = parseDate(val)
+		if err != nil {
+			return opts, fmt.Errorf("invalid until %q", val)
+		}
+	}
+
+	return opts, nil
+}
+
+// diffRepoRevision returns the changes introduced by a revision, i.e. the
+// differences to its first parent, or, if given, to the "base" revision.
+// The number of context lines can be set via the "context" parameter.
+func (s *Server) diffRepoRevision(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+	rid, err := s.varsToRepoID(ivars)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	irev := ivars["rev"]
+	query := r.URL.Query()
+
+	opts := git.BlobDiffOptions{Context: git.DefaultDiffContext}
+	if val := query.Get("context"); val != "" {
+		opts.Context, err = strconv.Atoi(val)
+		if err != nil || opts.Context < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	cur, err := repo.RevParse(irev)
+	if err == nil {
+		err = repo.CheckReachable(cur)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// without base, root commits are compared to the empty tree
+	var base git.SHA1
+	if val := query.Get("base"); val != "" {
+		base, err = repo.RevParse(val)
+		if err == nil {
+			err = repo.CheckReachable(base)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	} else if parent, err := repo.RevParse(irev + "^"); err == nil {
+		base = parent
+	}
+
+	changes, err := repo.DiffTrees(base, cur, git.DiffOptions{DetectRenames: true})
+	if err != nil {
+		s.log(WARN, "error computing diff of %q [%v]", irev, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]wire.FileDiff, len(changes))
+	for i := range changes {
+		c := &changes[i]
+
+		d, err := repo.DiffChange(c, opts)
+		if err != nil {
+			s.log(WARN, "error computing diff of %q [%v]", c.Path(), err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		fd := wire.FileDiff{
+			Status:      string(c.Status),
+			OldPath:     c.OldPath,
+			NewPath:     c.NewPath,
+			Similarity:  c.Similarity,
+			OldAnnexKey: c.OldAnnexKey,
+			NewAnnexKey: c.NewAnnexKey,
+			Binary:      d.Binary,
+			TooLarge:    d.TooLarge,
+		}
+
+		for _, h := range d.Hunks {
+			hunk := wire.DiffHunk{Header: h.Header()}
+			for _, l := range h.Lines {
+				hunk.Lines = append(hunk.Lines, string(l.Op)+l.Text)
+			}
+			fd.Hunks = append(fd.Hunks, hunk)
+		}
+
+		res[i] = fd
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -978,3 +1234,89 @@ func (s *Server) listRepoCommits(w http.ResponseWriter, r *http.Request) {
 		s.log(WARN, "error after status ok sent [%v]", err)
 	}
 }
+
+// archiveFormats maps the file extensions of archives to their
+// format and content type.
+var archiveFormats = []struct {
+	ext    string
+	format git.ArchiveFormat
+	ctype  string
+}{
+	{".tar.gz", git.ArchiveTarGz, "application/gzip"},
+	{".tgz", git.ArchiveTarGz, "application/gzip"},
+	{".tar", git.ArchiveTar, "application/x-tar"},
+	{".zip", git.ArchiveZip, "application/zip"},
+}
+
+// archiveRepo streams the tree of a revision as tar, tar.gz or zip
+// archive. The revision and the format are given as "{rev}.{fmt}",
+// e.g. "master.zip". With the query parameter "annex=true" annexed
+// files are stored with their content, if that is present.
+func (s *Server) archiveRepo(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+	rid, err := s.varsToRepoID(ivars)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	iarchive := ivars["archive"]
+	irev, ext, ctype := "", "", ""
+	var format git.ArchiveFormat
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(iarchive, f.ext) {
+			irev, ext, ctype, format = iarchive[:len(iarchive)-len(f.ext)], f.ext, f.ctype, f.format
+			break
+		}
+	}
+
+	if irev == "" {
+		http.Error(w, "Unsupported archive format", http.StatusNotFound)
+		return
+	}
+
+	annex := false
+	if val := r.URL.Query().Get("annex"); val != "" {
+		annex, err = strconv.ParseBool(val)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	id, err := repo.RevParse(irev)
+	if err == nil {
+		err = repo.CheckReachable(id)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// like GitHub, the files are put into the directory "{repo}-{rev}"
+	name := rid.Name + "-" + strings.Replace(irev, "/", "-", -1)
+
+	aw := git.NewArchiveWriter(repo, format)
+	aw.Prefix = name + "/"
+	aw.Annex = annex
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+ext))
+	w.WriteHeader(http.StatusOK)
+
+	err = aw.Write(w, id)
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -1001,3 +1007,517 @@ func Test_listRepoCommits(t *testing.T) {
 		t.Fatal("Expected a list of commits, but got none")
 	}
 }
+
+func Test_diffRepoRevision(t *testing.T) {
+	const method = "GET"
+	const urlTemplate = "/users/%s/repos/%s/diff/%s"
+
+	const validUser = "bob"
+	const validRepo = "repod"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(validUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", validUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	// test request fail for insufficient access.
+	url := fmt.Sprintf(urlTemplate, validUser, validRepo, "master")
+	_, err = RunRequest(method, url, nil, nil, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// test request fail for invalid revision.
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "iDoNotExist")
+	_, err = RunRequest(method, url, nil, headerMap, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// test request fail for invalid context parameter.
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "master") + "?context=-1"
+	_, err = RunRequest(method, url, nil, headerMap, http.StatusBadRequest)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// a revision compared to itself has no changes
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "master") + "?base=master"
+	resp, err := RunRequest(method, url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	result := []wire.FileDiff{}
+	err = json.Unmarshal(resp.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	} else if len(result) != 0 {
+		t.Fatalf("Expected no changes, got %d", len(result))
+	}
+
+	// test valid revision against its parent
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "master")
+	resp, err = RunRequest(method, url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	err = json.Unmarshal(resp.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	for _, fd := range result {
+		if fd.Status == "" || (fd.OldPath == "" && fd.NewPath == "") {
+			t.Fatalf("Invalid file diff: %+v", fd)
+		}
+	}
+}
+
+func Test_archiveRepo(t *testing.T) {
+	const method = "GET"
+	const urlTemplate = "/users/%s/repos/%s/archive/%s"
+
+	const validUser = "bob"
+	const validRepo = "repod"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(validUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", validUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	// test request fail for insufficient access.
+	url := fmt.Sprintf(urlTemplate, validUser, validRepo, "master.tar")
+	_, err = RunRequest(method, url, nil, nil, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// test request fail for unsupported format and invalid revision.
+	for _, archive := range []string{"master.rar", "iDoNotExist.zip", ".zip"} {
+		url = fmt.Sprintf(urlTemplate, validUser, validRepo, archive)
+		_, err = RunRequest(method, url, nil, headerMap, http.StatusNotFound)
+		if err != nil {
+			t.Fatalf("%s: %v\n", archive, err)
+		}
+	}
+
+	// test request fail for invalid annex parameter.
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "master.zip") + "?annex=maybe"
+	_, err = RunRequest(method, url, nil, headerMap, http.StatusBadRequest)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "master.tar.gz") + "?annex=true"
+	resp, err := RunRequest(method, url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if ct := resp.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Fatalf("Unexpected content type %q", ct)
+	} else if cd := resp.Header().Get("Content-Disposition"); cd != `attachment; filename="repod-master.tar.gz"` {
+		t.Fatalf("Unexpected content disposition %q", cd)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("Could not read archive: %v", err)
+	}
+
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("Could not read archive: %v", err)
+	} else if hdr.Name != "repod-master/" {
+		t.Fatalf("Unexpected first entry %q", hdr.Name)
+	}
+
+	n := 1
+	for ; err == nil; n++ {
+		_, err = tr.Next()
+	}
+	if err != io.EOF || n < 3 {
+		t.Fatalf("Unexpected end of archive after %d entries: %v", n, err)
+	}
+
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "master.zip")
+	resp, err = RunRequest(method, url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	data := resp.Body.Bytes()
+	_, err = zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Could not read zip archive: %v", err)
+	}
+}
+
+func Test_repoTags(t *testing.T) {
+	const urlTemplate = "/users/%s/repos/%s/tags"
+
+	const validUser = "bob"
+	const validRepo = "repod"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(validUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", validUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	url := fmt.Sprintf(urlTemplate, validUser, validRepo)
+	body := `{"name": "v0.1", "target": "master", "message": "First release"}`
+
+	// test request fail for insufficient access.
+	_, err = RunRequest("GET", url, nil, nil, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	_, err = RunRequest("POST", url, strings.NewReader(body), nil, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// test request fail for invalid tag name and unknown target.
+	for _, invalid := range []string{`{"name": "v0..1", "target": "master"}`, `{"target": "master"}`, `{"name": "v0.1"`} {
+		_, err = RunRequest("POST", url, strings.NewReader(invalid), headerMap, http.StatusBadRequest)
+		if err != nil {
+			t.Fatalf("%s: %v\n", invalid, err)
+		}
+	}
+	_, err = RunRequest("POST", url, strings.NewReader(`{"name": "v0.1", "target": "iDoNotExist"}`), headerMap, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := RunRequest("POST", url, strings.NewReader(body), headerMap, http.StatusCreated)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var tag wire.Tag
+	err = json.Unmarshal(resp.Body.Bytes(), &tag)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response: %v\n", err)
+	}
+
+	if !tag.Annotated || tag.Name != "v0.1" || tag.TargetType != "commit" || tag.Target != tag.Peeled ||
+		tag.Message != "First release\n" || !strings.HasPrefix(tag.Tagger, validUser+" <") {
+		t.Fatalf("Unexpected tag: %+v", tag)
+	}
+
+	_, err = RunRequest("POST", url, strings.NewReader(body), headerMap, http.StatusConflict)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	_, err = RunRequest("POST", url, strings.NewReader(`{"name": "light/v0.1", "target": "master"}`), headerMap, http.StatusCreated)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = RunRequest("GET", url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var tags []wire.Tag
+	err = json.Unmarshal(resp.Body.Bytes(), &tags)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response: %v\n", err)
+	}
+
+	byName := make(map[string]wire.Tag)
+	for _, t := range tags {
+		byName[t.Name] = t
+	}
+
+	// repod has the tag "paper/jossa" already
+	light := byName["light/v0.1"]
+	if len(tags) != 3 || byName["v0.1"] != tag || light.Annotated ||
+		light.Object != tag.Target || light.Peeled != tag.Peeled || byName["paper/jossa"].Name == "" {
+		t.Fatalf("Unexpected tags: %+v", tags)
+	}
+
+	// the tag object itself
+	objURL := fmt.Sprintf("/users/%s/repos/%s/objects/%s", validUser, validRepo, tag.Object)
+	resp, err = RunRequest("GET", objURL, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var obj map[string]string
+	err = json.Unmarshal(resp.Body.Bytes(), &obj)
+	if err != nil {
+		t.Fatalf("Error unmarshalling tag object %q: %v\n", resp.Body.String(), err)
+	} else if obj["type"] != "tag" || obj["object"] != tag.Target || obj["tag"] != "v0.1" || obj["message"] != tag.Message {
+		t.Fatalf("Unexpected tag object: %v", obj)
+	}
+
+	for _, name := range []string{"v0.1", "light/v0.1"} {
+		_, err = RunRequest("GET", url+"/"+name, nil, headerMap, http.StatusOK)
+		if err != nil {
+			t.Fatalf("%s: %v\n", name, err)
+		}
+		_, err = RunRequest("DELETE", url+"/"+name, nil, headerMap, http.StatusOK)
+		if err != nil {
+			t.Fatalf("%s: %v\n", name, err)
+		}
+		_, err = RunRequest("GET", url+"/"+name, nil, headerMap, http.StatusNotFound)
+		if err != nil {
+			t.Fatalf("%s: %v\n", name, err)
+		}
+	}
+
+	_, err = RunRequest("DELETE", url+"/v0.1", nil, headerMap, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+}
+
+func Test_repoForks(t *testing.T) {
+	const urlTemplate = "/users/%s/repos/%s/forks"
+
+	const srcUser = "alice"
+	const srcRepo = "repod"
+	const forkUser = "bob"
+	const forkRepo = "repod-fork"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(forkUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", forkUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	url := fmt.Sprintf(urlTemplate, srcUser, srcRepo)
+
+	// test request fail for missing authentication, insufficient access,
+	// invalid names and existing repositories.
+	_, err = RunRequest("POST", url, strings.NewReader(""), nil, http.StatusBadRequest)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	_, err = RunRequest("POST", fmt.Sprintf(urlTemplate, srcUser, "exrepo"), strings.NewReader(""), headerMap, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	_, err = RunRequest("POST", url, strings.NewReader(`{"name": "a/b"}`), headerMap, http.StatusBadRequest)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	_, err = RunRequest("POST", url, strings.NewReader(""), headerMap, http.StatusConflict)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	fid := store.RepoId{Owner: forkUser, Name: forkRepo}
+	defer os.RemoveAll(server.repos.IdToPath(fid))
+
+	resp, err := RunRequest("POST", url, strings.NewReader(`{"name": "`+forkRepo+`"}`), headerMap, http.StatusCreated)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var fork wire.Repo
+	err = json.Unmarshal(resp.Body.Bytes(), &fork)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response: %v\n", err)
+	} else if fork.Owner != forkUser || fork.Name != forkRepo || fork.ForkOf != srcUser+"/"+srcRepo || fork.Public {
+		t.Fatalf("Unexpected fork: %+v", fork)
+	}
+
+	// the fork borrows the objects of its origin
+	src, _ := server.repos.OpenGitRepo(store.RepoId{Owner: srcUser, Name: srcRepo})
+	repo, _ := server.repos.OpenGitRepo(fid)
+	head, err := src.RevParse("HEAD")
+	if err != nil {
+		t.Fatalf("Could not resolve HEAD: %v", err)
+	} else if id, err := repo.RevParse("HEAD"); err != nil || id != head {
+		t.Fatalf("HEAD of fork is %s (%v), expected %s", id, err, head)
+	}
+
+	_, err = RunRequest("GET", fmt.Sprintf("/users/%s/repos/%s/objects/%s", forkUser, forkRepo, head), nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// but not the ones its refs do not reach, e.g. pushed to the
+	// origin after the fork was made
+	obj, err := src.OpenObject(head)
+	if err != nil {
+		t.Fatalf("Could not open HEAD: %v", err)
+	}
+	commit := obj.(*git.Commit)
+	commit.Message = "unreachable from the fork\n"
+	private, err := src.WriteObject(commit)
+	if err != nil {
+		t.Fatalf("Could not write commit: %v", err)
+	}
+	defer os.Remove(filepath.Join(src.Path, "objects", private.String()[:2], private.String()[2:]))
+
+	for _, path := range []string{
+		"objects/" + private.String(),
+		"objects/" + private.String()[:10],
+		"browse/" + private.String() + "/",
+		"commits/" + private.String(),
+		"diff/" + private.String(),
+		"archive/" + private.String() + ".zip",
+	} {
+		_, err = RunRequest("GET", fmt.Sprintf("/users/%s/repos/%s/%s", forkUser, forkRepo, path), nil, headerMap, http.StatusNotFound)
+		if err != nil {
+			t.Fatalf("%s: %v\n", path, err)
+		}
+	}
+
+	// the fork is private, i.e. only listed for its owner
+	for _, c := range []struct {
+		header map[string]string
+		forks  int
+	}{{headerMap, 1}, {nil, 0}} {
+		resp, err = RunRequest("GET", url, nil, c.header, http.StatusOK)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		var forks []wire.Repo
+		err = json.Unmarshal(resp.Body.Bytes(), &forks)
+		if err != nil {
+			t.Fatalf("Error unmarshalling response: %v\n", err)
+		} else if len(forks) != c.forks || (c.forks > 0 && forks[0] != fork) {
+			t.Fatalf("Unexpected forks: %+v", forks)
+		}
+	}
+}
+
+func Test_browseRepoSubmodules(t *testing.T) {
+	const urlTemplate = "http://gin.example.com/users/alice/repos/submods/browse/master/%s"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser("alice")
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", "alice", token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	rid := store.RepoId{Owner: "alice", Name: "submods"}
+	repo, err := server.repos.CreateRepo(rid)
+	if err != nil {
+		t.Fatalf("Could not create repository: %v", err)
+	}
+	defer os.RemoveAll(server.repos.IdToPath(rid))
+
+	src, _ := server.repos.OpenGitRepo(store.RepoId{Owner: "alice", Name: "repod"})
+	pinned, err := src.RevParse("HEAD")
+	if err != nil {
+		t.Fatalf("Could not resolve HEAD: %v", err)
+	}
+
+	// a repository on this server via a relative and an absolute url, a
+	// private one of another user and one on a different host
+	gitmodules := `[submodule "lib"]
+	path = lib
+	url = ../repod.git
+[submodule "pub"]
+	path = deps/pub
+	url = https://GIN.example.com:443/alice/auth
+[submodule "priv"]
+	path = deps/priv
+	url = git@gin.example.com:bob/repod.git
+[submodule "ext"]
+	path = ext
+	url = git@github.com:alice/repod.git
+`
+	gitAt := func(stdin string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"--git-dir", repo.Path}, args...)...)
+		cmd.Stdin = strings.NewReader(stdin)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=A U Thor", "GIT_AUTHOR_EMAIL=author@example.com",
+			"GIT_COMMITTER_NAME=C O Mitter", "GIT_COMMITTER_EMAIL=committer@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v, %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	blob := gitAt(gitmodules, "hash-object", "-w", "--stdin")
+	deps := gitAt(fmt.Sprintf("160000 commit %s\tpriv\n160000 commit %s\tpub\n", pinned, pinned), "mktree")
+	tree := gitAt(fmt.Sprintf("100644 blob %s\t.gitmodules\n040000 tree %s\tdeps\n160000 commit %s\text\n160000 commit %s\tlib\n",
+		blob, deps, pinned, pinned), "mktree")
+	commit := gitAt("", "commit-tree", "-m", "submodules", tree)
+	gitAt("", "update-ref", "refs/heads/master", commit)
+
+	type entry struct {
+		Type string
+		ID   string
+		Name string
+		URL  string
+		Repo string
+	}
+
+	for _, c := range []struct {
+		dir     string
+		entries []entry
+	}{
+		{"", []entry{
+			{"blob", blob, ".gitmodules", "", ""},
+			{"tree", deps, "deps", "", ""},
+			{"submodule", pinned.String(), "ext", "git@github.com:alice/repod.git", ""},
+			{"submodule", pinned.String(), "lib", "../repod.git", "/users/alice/repos/repod"},
+		}},
+		{"deps", []entry{
+			{"submodule", pinned.String(), "priv", "git@gin.example.com:bob/repod.git", ""},
+			{"submodule", pinned.String(), "pub", "https://GIN.example.com:443/alice/auth", "/users/alice/repos/auth"},
+		}},
+	} {
+		resp, err := RunRequest("GET", fmt.Sprintf(urlTemplate, c.dir), nil, headerMap, http.StatusOK)
+		if err != nil {
+			t.Fatalf("%q: %v\n", c.dir, err)
+		}
+
+		var listing struct {
+			Type    string
+			Entries []entry
+		}
+		err = json.Unmarshal(resp.Body.Bytes(), &listing)
+		if err != nil {
+			t.Fatalf("%q: error unmarshalling response: %v\n", c.dir, err)
+		} else if len(listing.Entries) != len(c.entries) {
+			t.Fatalf("%q: unexpected entries %+v", c.dir, listing.Entries)
+		}
+
+		for i, e := range listing.Entries {
+			if e != c.entries[i] {
+				t.Fatalf("%q: unexpected entry %+v, expected %+v", c.dir, e, c.entries[i])
+			}
+		}
+	}
+
+	resp, err := RunRequest("GET", fmt.Sprintf(urlTemplate, "lib"), nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var sm map[string]string
+	err = json.Unmarshal(resp.Body.Bytes(), &sm)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response: %v\n", err)
+	} else if sm["type"] != "submodule" || sm["commit"] != pinned.String() || sm["path"] != "lib" ||
+		sm["url"] != "../repod.git" || sm["repo"] != "/users/alice/repos/repod" {
+		t.Fatalf("Unexpected submodule %v", sm)
+	}
+
+	// the content of submodules is not in the repository
+	_, err = RunRequest("GET", fmt.Sprintf(urlTemplate, "lib/README.md"), nil, headerMap, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+}
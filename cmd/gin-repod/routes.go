@@ -5,6 +5,8 @@ func (s *Server) SetupRoutes() {
 
 	r.HandleFunc("/intern/user/lookup", s.lookupUser).Methods("GET")
 	r.HandleFunc("/intern/repos/access", s.repoAccess).Methods("POST")
+	r.HandleFunc("/intern/repos/{user}/{repo}/fsck", s.repoFsck).Methods("GET")
+	r.HandleFunc("/intern/repos/{user}/{repo}/gc", s.repoMaintenance).Methods("POST")
 
 	r.HandleFunc("/intern/hooks/fire", s.hooksFire).Methods("POST")
 
@@ -25,9 +27,22 @@ func (s *Server) SetupRoutes() {
 	r.HandleFunc("/users/{user}/repos/{repo}/collaborators/{username}", s.putRepoCollaborator).Methods("PUT")
 	r.HandleFunc("/users/{user}/repos/{repo}/collaborators/{username}", s.deleteRepoCollaborator).Methods("DELETE")
 
+	r.HandleFunc("/users/{user}/repos/{repo}/forks", s.listRepoForks).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/forks", s.forkRepo).Methods("POST")
+
 	r.HandleFunc("/users/{user}/repos/{repo}/branches/{branch}", s.getBranch).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/tags", s.listRepoTags).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/tags", s.createRepoTag).Methods("POST")
+	r.HandleFunc("/users/{user}/repos/{repo}/tags/{tag:.+}", s.getRepoTag).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/tags/{tag:.+}", s.deleteRepoTag).Methods("DELETE")
 	r.HandleFunc("/users/{user}/repos/{repo}/objects/{object}", s.getObject).Methods("GET")
 	r.HandleFunc("/users/{user}/repos/{repo}/browse/{branch}", s.browseRepo).Methods("GET")
 	r.HandleFunc("/users/{user}/repos/{repo}/browse/{branch}/{path:.*}", s.browseRepo).Methods("GET")
 	r.HandleFunc("/users/{user}/repos/{repo}/commits/{branch}", s.listRepoCommits).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/diff/{rev}", s.diffRepoRevision).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/archive/{archive:.+}", s.archiveRepo).Methods("GET")
+
+	r.HandleFunc("/{user}/{repo}.git/info/refs", s.gitInfoRefs).Methods("GET")
+	r.HandleFunc("/{user}/{repo}.git/git-upload-pack", s.gitUploadPack).Methods("POST")
+	r.HandleFunc("/{user}/{repo}.git/git-receive-pack", s.gitReceivePack).Methods("POST")
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/G-Node/gin-repo/git"
+	"github.com/G-Node/gin-repo/store"
+)
+
+// submoduleLink is what is written for a submodule besides its commit:
+// the URL from .gitmodules and, if that is a repository on this server
+// the user has access to, the API path of that repository.
+type submoduleLink struct {
+	URL  string
+	Repo string
+}
+
+// submoduleRepo resolves the URL of a submodule of the repository rid
+// to a repository on this server. Relative URLs are relative to rid, as
+// for git, absolute ones must point to the host of the request. Only
+// repositories the user uid has (at least) pull access to are resolved.
+func (s *Server) submoduleRepo(r *http.Request, rid store.RepoId, uid string, rawurl string) (store.RepoId, bool) {
+	var host, rpath string
+
+	switch {
+	case strings.HasPrefix(rawurl, "./") || strings.HasPrefix(rawurl, "../"):
+		rpath = path.Join("/", rid.Owner, rid.Name, rawurl)
+	case strings.Contains(rawurl, "://"):
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return store.RepoId{}, false
+		}
+		host, rpath = u.Hostname(), u.Path
+	default:
+		// scp-like syntax, i.e. [user@]host:path
+		i := strings.Index(rawurl, ":")
+		if i < 0 || strings.Contains(rawurl[:i], "/") {
+			return store.RepoId{}, false
+		}
+		host, rpath = rawurl[strings.Index(rawurl[:i], "@")+1:i], rawurl[i+1:]
+	}
+
+	if host != "" {
+		self := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			self = h
+		}
+		if !strings.EqualFold(host, self) {
+			return store.RepoId{}, false
+		}
+	}
+
+	target, err := store.RepoIdParse(rpath)
+	if err != nil {
+		return store.RepoId{}, false
+	}
+
+	exists, err := s.repos.RepoExists(target)
+	if err != nil || !exists {
+		return store.RepoId{}, false
+	}
+
+	level, err := s.repos.GetAccessLevel(target, uid)
+	if err != nil {
+		s.log(WARN, "Getting access level for %q failed: %v", target, err)
+		return store.RepoId{}, false
+	}
+
+	return target, level >= store.PullAccess
+}
+
+// submoduleToLink returns the link written for the submodule sm of the
+// repository rid.
+func (s *Server) submoduleToLink(r *http.Request, rid store.RepoId, uid string, sm *git.Submodule) submoduleLink {
+	link := submoduleLink{URL: sm.URL}
+	if sm.URL == "" {
+		return link
+	}
+
+	if target, ok := s.submoduleRepo(r, rid, uid, sm.URL); ok {
+		link.Repo = fmt.Sprintf("/users/%s/repos/%s", target.Owner, target.Name)
+	}
+	return link
+}
+
+// treeSubmodules returns the links for the submodules of the directory
+// dir at the revision id, keyed by their name within dir.
+func (s *Server) treeSubmodules(r *http.Request, rid store.RepoId, uid string, repo *git.Repository, id git.SHA1, dir string) (map[string]submoduleLink, error) {
+	root, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	modules, err := repo.Submodules(root)
+	if err != nil {
+		return nil, err
+	}
+
+	dir = path.Clean(strings.Trim(dir, " /"))
+	links := make(map[string]submoduleLink)
+	for mpath, sm := range modules {
+		if path.Dir(mpath) == dir {
+			links[path.Base(mpath)] = s.submoduleToLink(r, rid, uid, sm)
+		}
+	}
+
+	return links, nil
+}
+
+// writeSubmoduleLink writes the members of link, for a JSON object.
+func writeSubmoduleLink(out *bufio.Writer, link submoduleLink) {
+	if link.URL != "" {
+		out.WriteString(fmt.Sprintf("%q: %q,\n", "url", link.URL))
+	}
+	if link.Repo != "" {
+		out.WriteString(fmt.Sprintf("%q: %q,\n", "repo", link.Repo))
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/G-Node/gin-repo/git"
+	"github.com/G-Node/gin-repo/store"
+	"github.com/G-Node/gin-repo/wire"
+	"github.com/gorilla/mux"
+)
+
+// tagToWire converts info, for signed tags with the
+// result of the signature verification.
+func (s *Server) tagToWire(repo *git.Repository, info git.TagInfo) wire.Tag {
+	tag := wire.Tag{
+		Name:   info.Name,
+		Object: info.ID.String(),
+		Target: info.ID.String(),
+		Peeled: info.Peeled.String(),
+	}
+
+	if t := info.Tag; t != nil {
+		tag.Annotated = true
+		tag.Target = t.Object.String()
+		tag.TargetType = t.ObjType.String()
+		tag.Tagger = t.Tagger.Name + " <" + t.Tagger.Email + ">"
+		tag.Date = t.Tagger.Date.In(t.Tagger.Offset).Format(time.RFC3339)
+		tag.Message = t.Message
+		tag.Signed = t.GPGSig != ""
+		if tag.Signed {
+			tag.Signature = s.verifySignature(repo, info.ID)
+		}
+	}
+
+	return tag
+}
+
+// tagger is the identity recorded for tags created by user.
+func tagger(user *store.User) git.Signature {
+	var name string
+	if user != nil {
+		name = user.Uid
+	}
+	return git.NewSignature(name, "", time.Now())
+}
+
+func (s *Server) listRepoTags(w http.ResponseWriter, r *http.Request) {
+	rid, err := s.varsToRepoID(mux.Vars(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	tags, err := repo.ListTags()
+	if err != nil {
+		s.log(WARN, "could not list tags of %s: %v", rid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]wire.Tag, len(tags))
+	for i, info := range tags {
+		res[i] = s.tagToWire(repo, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
+
+func (s *Server) getRepoTag(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+	rid, err := s.varsToRepoID(ivars)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	info, err := repo.ReadTag(ivars["tag"])
+	if err == git.ErrTagNotFound || git.CheckRefName("refs/tags/"+ivars["tag"]) != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.log(WARN, "could not read tag %q in %s: %v", ivars["tag"], rid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(s.tagToWire(repo, info))
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
+
+func (s *Server) createRepoTag(w http.ResponseWriter, r *http.Request) {
+	rid, err := s.varsToRepoID(mux.Vars(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var creat wire.CreateTag
+	err = json.NewDecoder(r.Body).Decode(&creat)
+	if err != nil || creat.Name == "" || creat.Target == "" {
+		http.Error(w, "Tag name or target missing", http.StatusBadRequest)
+		return
+	} else if git.CheckRefName("refs/tags/"+creat.Name) != nil {
+		http.Error(w, "Invalid tag name", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.checkAccess(w, r, rid, store.PushAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	target, err := repo.RevParse(creat.Target)
+	if err != nil {
+		http.Error(w, "Unknown target", http.StatusNotFound)
+		return
+	}
+
+	info, err := repo.CreateTag(creat.Name, target, tagger(user), creat.Message)
+	if err == git.ErrTagExists {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err == git.ErrTagTarget {
+		http.Error(w, "Unknown target", http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.log(WARN, "could not create tag %q in %s: %v", creat.Name, rid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(s.tagToWire(repo, info))
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
+
+func (s *Server) deleteRepoTag(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+	rid, err := s.varsToRepoID(ivars)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.checkAccess(w, r, rid, store.PushAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = repo.DeleteTag(ivars["tag"], tagger(user))
+	if err == git.ErrTagNotFound || git.CheckRefName("refs/tags/"+ivars["tag"]) != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.log(WARN, "could not delete tag %q in %s: %v", ivars["tag"], rid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+
+	"github.com/G-Node/gin-repo/git"
+	"github.com/G-Node/gin-repo/store"
+	"github.com/G-Node/gin-repo/wire"
+	"golang.org/x/crypto/openpgp"
+)
+
+// userKeyRing is the key ring of the public OpenPGP keys
+// registered by the users in the user store.
+type userKeyRing struct {
+	users store.UserStore
+}
+
+func (kr userKeyRing) KeysById(id uint64) []openpgp.Key {
+	user, err := kr.users.LookupUserByGPGKey(id)
+	if err != nil {
+		return nil
+	}
+	return user.GPGKeys.KeysById(id)
+}
+
+func (kr userKeyRing) KeysByIdUsage(id uint64, usage byte) []openpgp.Key {
+	user, err := kr.users.LookupUserByGPGKey(id)
+	if err != nil {
+		return nil
+	}
+	return user.GPGKeys.KeysByIdUsage(id, usage)
+}
+
+func (kr userKeyRing) DecryptionKeys() []openpgp.Key {
+	return nil
+}
+
+// verifySignature verifies the signature of the commit or tag id
+// against the keys of the users. It returns nil for unsigned objects
+// and if the signature could not be checked at all.
+func (s *Server) verifySignature(repo *git.Repository, id git.SHA1) *wire.Signature {
+	check, err := repo.VerifySignature(id, userKeyRing{s.users})
+	if err != nil {
+		s.log(WARN, "could not verify signature of %s in %q: %v", id, repo.Path, err)
+		return nil
+	} else if check.Status == git.SigNone {
+		return nil
+	}
+
+	sig := &wire.Signature{Status: string(check.Status)}
+	if check.KeyID != 0 {
+		sig.KeyID = fmt.Sprintf("%016X", check.KeyID)
+	}
+
+	if check.Status == git.SigValid {
+		if user, err := s.users.LookupUserByGPGKey(check.KeyID); err == nil {
+			sig.Signer = user.Uid
+		}
+	}
+
+	return sig
+}
+
+// writeSignature writes the verified signature of the commit or tag
+// id as "signature" member of the JSON object written by objectToWire.
+func (s *Server) writeSignature(out *bufio.Writer, repo *git.Repository, id git.SHA1) {
+	sig := s.verifySignature(repo, id)
+	if sig == nil {
+		return
+	}
+
+	data, err := json.Marshal(sig)
+	if err != nil {
+		return
+	}
+
+	out.WriteString(fmt.Sprintf("%q: %s,\n", "signature", data))
+}
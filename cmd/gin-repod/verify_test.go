@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/G-Node/gin-repo/git"
+	"github.com/G-Node/gin-repo/store"
+	"github.com/G-Node/gin-repo/wire"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// signObject writes obj, signed by signer, to repo. The signature is
+// made over the object as written by WriteTo, without the signature.
+func signObject(t *testing.T, repo *git.Repository, obj git.Object, signer *openpgp.Entity) git.SHA1 {
+	var buf bytes.Buffer
+	_, err := obj.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Could not serialize object: %v", err)
+	}
+	payload := buf.Bytes()[bytes.IndexByte(buf.Bytes(), 0)+1:]
+
+	var sig bytes.Buffer
+	err = openpgp.ArmoredDetachSign(&sig, signer, bytes.NewReader(payload), nil)
+	if err != nil {
+		t.Fatalf("Could not sign object: %v", err)
+	}
+
+	switch o := obj.(type) {
+	case *git.Commit:
+		o.GPGSig = sig.String()
+	case *git.Tag:
+		o.GPGSig = sig.String()
+	}
+
+	id, err := repo.WriteObject(obj)
+	if err != nil {
+		t.Fatalf("Could not write object: %v", err)
+	}
+	return id
+}
+
+func TestSignatureVerification(t *testing.T) {
+	const validUser = "bob"
+	const validRepo = "repod"
+
+	bob, err := openpgp.NewEntity("Bob", "", "bob@example.com", nil)
+	if err != nil {
+		t.Fatalf("Could not create key: %v", err)
+	}
+	mallory, err := openpgp.NewEntity("Mallory", "", "mallory@example.com", nil)
+	if err != nil {
+		t.Fatalf("Could not create key: %v", err)
+	}
+
+	// register the key of bob and reload the user store
+	var key bytes.Buffer
+	w, _ := armor.Encode(&key, openpgp.PublicKeyType, nil)
+	bob.Serialize(w)
+	w.Close()
+
+	keyFile := filepath.Join("users", validUser, validUser+".asc")
+	err = ioutil.WriteFile(keyFile, key.Bytes(), 0644)
+	if err != nil {
+		t.Fatalf("Could not write key: %v", err)
+	}
+	defer os.Remove(keyFile)
+
+	server.users, err = store.NewUserStore(".")
+	if err != nil {
+		t.Fatalf("Could not reload user store: %v", err)
+	}
+
+	repo, err := server.repos.OpenGitRepo(store.RepoId{Owner: validUser, Name: validRepo})
+	if err != nil {
+		t.Fatalf("Could not open repository: %v", err)
+	}
+
+	head, err := repo.RevParse("master")
+	if err != nil {
+		t.Fatalf("Could not resolve master: %v", err)
+	}
+	obj, err := repo.OpenObject(head)
+	if err != nil {
+		t.Fatalf("Could not open commit: %v", err)
+	}
+	obj.Close()
+
+	who := git.NewSignature("Bob", "bob@example.com", time.Now())
+	commit := signObject(t, repo, &git.Commit{
+		Tree:      obj.(*git.Commit).Tree,
+		Parent:    []git.SHA1{head},
+		Author:    who,
+		Committer: who,
+		Message:   "Signed commit\n",
+	}, bob)
+
+	tag := func(name string, signer *openpgp.Entity) git.SHA1 {
+		return signObject(t, repo, &git.Tag{
+			Object:  commit,
+			ObjType: git.ObjCommit,
+			Tag:     name,
+			Tagger:  who,
+			Message: "Signed release\n",
+		}, signer)
+	}
+
+	tx := repo.NewRefTransaction(who, "test")
+	tx.Create("refs/heads/signed", commit)
+	tx.Create("refs/tags/signed-bob", tag("signed-bob", bob))
+	tx.Create("refs/tags/signed-mallory", tag("signed-mallory", mallory))
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Could not create refs: %v", err)
+	}
+
+	defer func() {
+		tx := repo.NewRefTransaction(who, "test")
+		for _, name := range []string{"refs/heads/signed", "refs/tags/signed-bob", "refs/tags/signed-mallory"} {
+			id, _ := repo.RevParse(name)
+			tx.Delete(name, id)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Could not delete refs: %v", err)
+		}
+	}()
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(validUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", validUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	bobKey := fmt.Sprintf("%016X", bob.PrimaryKey.KeyId)
+	valid := wire.Signature{Status: "valid", KeyID: bobKey, Signer: validUser}
+
+	// the commit object
+	url := fmt.Sprintf("/users/%s/repos/%s/objects/%s", validUser, validRepo, commit)
+	resp, err := RunRequest("GET", url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var res struct {
+		Signature wire.Signature `json:"signature"`
+	}
+	err = json.Unmarshal(resp.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatalf("Error unmarshalling commit %q: %v\n", resp.Body.String(), err)
+	} else if res.Signature != valid {
+		t.Fatalf("Unexpected signature of commit: %+v", res.Signature)
+	}
+
+	// the commit listing, where only the first commit is signed
+	url = fmt.Sprintf("/users/%s/repos/%s/commits/signed?max-count=2", validUser, validRepo)
+	resp, err = RunRequest("GET", url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var commits []wire.CommitSummary
+	err = json.Unmarshal(resp.Body.Bytes(), &commits)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response: %v\n", err)
+	} else if len(commits) != 2 || commits[0].Signature == nil || *commits[0].Signature != valid || commits[1].Signature != nil {
+		t.Fatalf("Unexpected commits: %+v", commits)
+	}
+
+	// the tags, one signed with an unknown key
+	url = fmt.Sprintf("/users/%s/repos/%s/tags", validUser, validRepo)
+	resp, err = RunRequest("GET", url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var tags []wire.Tag
+	err = json.Unmarshal(resp.Body.Bytes(), &tags)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response: %v\n", err)
+	}
+
+	unknown := wire.Signature{Status: "unknown key", KeyID: fmt.Sprintf("%016X", mallory.PrimaryKey.KeyId)}
+	signatures := map[string]wire.Signature{"signed-bob": valid, "signed-mallory": unknown}
+	for _, tag := range tags {
+		wanted, ok := signatures[tag.Name]
+		if !ok {
+			continue
+		} else if !tag.Signed || tag.Signature == nil || *tag.Signature != wanted || tag.Message != "Signed release\n" {
+			t.Fatalf("Unexpected tag %s: %+v (%+v)", tag.Name, tag, tag.Signature)
+		}
+		delete(signatures, tag.Name)
+	}
+	if len(signatures) != 0 {
+		t.Fatalf("Tags missing: %v", signatures)
+	}
+
+	// the tag object
+	id, _ := repo.RevParse("signed-bob")
+	url = fmt.Sprintf("/users/%s/repos/%s/objects/%s", validUser, validRepo, id)
+	resp, err = RunRequest("GET", url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	err = json.Unmarshal(resp.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatalf("Error unmarshalling tag %q: %v\n", resp.Body.String(), err)
+	} else if res.Signature != valid {
+		t.Fatalf("Unexpected signature of tag: %+v", res.Signature)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/G-Node/gin-repo/auth"
@@ -51,9 +52,58 @@ func gitCommand(client *client.Client, args []string, push bool, uid string) int
 		return -11
 	}
 
+	if args[0] == "git-upload-pack" && strings.Contains(os.Getenv("GIT_PROTOCOL"), "version=2") {
+		return nativeUploadPack(path)
+	} else if args[0] == "git-receive-pack" {
+		return lockedReceivePack(path)
+	}
+
 	return execGitCommand(args[0], path)
 }
 
+//lockedReceivePack runs git-receive-pack while holding the shared
+//repository lock, so that the maintenance done by gin-repod does
+//not run during the push.
+func lockedReceivePack(path string) int {
+	repo, err := git.OpenRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Could not open repository.")
+		return -15
+	}
+
+	lock, err := repo.LockShared()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[E] could not lock repository: %v\n", err)
+		return -15
+	}
+	defer lock.Unlock()
+
+	return execGitCommand("git-receive-pack", path)
+}
+
+//nativeUploadPack serves protocol v2 fetches with the
+//upload-pack implementation of the git package.
+func nativeUploadPack(path string) int {
+	repo, err := git.OpenRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Could not open repository.")
+		return -15
+	}
+
+	up := git.NewUploadPack(repo)
+	err = up.Advertise(os.Stdout)
+	if err == nil {
+		err = up.Serve(os.Stdin, os.Stdout)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[E] upload-pack: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
 func gitAnnex(client *client.Client, args []string, uid string) int {
 
 	if len(args) < 3 {
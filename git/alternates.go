@@ -0,0 +1,347 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//MaxAlternateDepth is the maximum depth of nested alternates, i.e.
+//alternates of alternates, that are followed. Same limit as git.
+const MaxAlternateDepth = 5
+
+//alternatesCache keeps the parsed "info/alternates" files, keyed by
+//the object directory they belong to. An entry is re-read when the
+//modification time or the size of the file changes.
+var alternatesCache = struct {
+	sync.Mutex
+	m map[string]alternatesEntry
+}{m: make(map[string]alternatesEntry)}
+
+type alternatesEntry struct {
+	mtime time.Time
+	size  int64
+	dirs  []string
+}
+
+//readAlternates returns the (absolute) object directories listed in
+//the "info/alternates" file of the object directory objdir. Relative
+//paths are relative to objdir. Empty lines and comments are ignored.
+func readAlternates(objdir string) ([]string, error) {
+	path := filepath.Join(objdir, "info", "alternates")
+	fi, err := os.Stat(path)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("git: could not stat alternates: %v", err)
+	}
+
+	alternatesCache.Lock()
+	entry, ok := alternatesCache.m[objdir]
+	alternatesCache.Unlock()
+
+	if ok && entry.mtime.Equal(fi.ModTime()) && entry.size == fi.Size() {
+		return entry.dirs, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read alternates: %v", err)
+	}
+
+	var dirs []string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		//paths with special characters are quoted like C strings
+		if line[0] == '"' {
+			line, err = strconv.Unquote(line)
+			if err != nil {
+				return nil, fmt.Errorf("git: invalid alternate %q", s.Text())
+			}
+		}
+
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(objdir, line)
+		}
+		dirs = append(dirs, filepath.Clean(line))
+	}
+
+	alternatesCache.Lock()
+	alternatesCache.m[objdir] = alternatesEntry{fi.ModTime(), fi.Size(), dirs}
+	alternatesCache.Unlock()
+
+	return dirs, nil
+}
+
+//Alternates returns the object directories the repository borrows
+//objects from, following nested alternates up to MaxAlternateDepth.
+//The repository's own object directory is not included.
+func (repo *Repository) Alternates() ([]string, error) {
+	dirs, err := repo.objectDirs()
+	if err != nil {
+		return nil, err
+	}
+	return dirs[1:], nil
+}
+
+//objectDirs returns the object directory of the repository followed
+//by the ones of its alternates, in the order they are searched.
+func (repo *Repository) objectDirs() ([]string, error) {
+	own, err := filepath.Abs(filepath.Join(repo.Path, "objects"))
+	if err != nil {
+		return nil, fmt.Errorf("git: could not determine absolute path: %v", err)
+	}
+
+	dirs := []string{own}
+	seen := map[string]bool{own: true}
+
+	level := dirs
+	for depth := 0; len(level) > 0; depth++ {
+		var next []string
+		for _, dir := range level {
+			alts, err := readAlternates(dir)
+			if err != nil {
+				return nil, err
+			} else if len(alts) > 0 && depth == MaxAlternateDepth {
+				return nil, fmt.Errorf("git: alternates nested too deeply")
+			}
+
+			for _, alt := range alts {
+				if !seen[alt] {
+					seen[alt] = true
+					next = append(next, alt)
+				}
+			}
+		}
+
+		dirs = append(dirs, next...)
+		level = next
+	}
+
+	return dirs, nil
+}
+
+//AddAlternate adds the object directory objdir to the alternates
+//of the repository, unless it is listed there already.
+func (repo *Repository) AddAlternate(objdir string) error {
+	objdir, err := filepath.Abs(objdir)
+	if err != nil {
+		return fmt.Errorf("git: could not determine absolute path: %v", err)
+	}
+
+	fi, err := os.Stat(objdir)
+	if err != nil || !fi.IsDir() {
+		return fmt.Errorf("git: %q is not an object directory", objdir)
+	}
+
+	own, err := filepath.Abs(filepath.Join(repo.Path, "objects"))
+	if err != nil {
+		return fmt.Errorf("git: could not determine absolute path: %v", err)
+	}
+
+	alts, err := readAlternates(own)
+	if err != nil {
+		return err
+	}
+
+	for _, alt := range alts {
+		if alt == objdir {
+			return nil
+		}
+	}
+
+	info := filepath.Join(own, "info")
+	err = os.MkdirAll(info, 0777)
+	if err != nil {
+		return fmt.Errorf("git: could not write alternates: %v", err)
+	}
+
+	fd, err := os.OpenFile(filepath.Join(info, "alternates"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("git: could not write alternates: %v", err)
+	}
+
+	line := objdir
+	if strconv.Quote(line) != `"`+line+`"` {
+		line = strconv.Quote(line)
+	}
+
+	_, err = fmt.Fprintln(fd, line)
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("git: could not write alternates: %v", err)
+	}
+
+	return nil
+}
+
+//CheckReachable makes sure the objects ids are stored in the repository
+//itself or reachable from its refs. Objects that are only found in the
+//alternates, e.g. in the repository a fork was made of, might belong to
+//history the refs do not (or no longer) point to, like commits pushed
+//to the origin of a fork later on.
+func (repo *Repository) CheckReachable(ids ...SHA1) error {
+	alts, err := repo.Alternates()
+	if err != nil {
+		return err
+	} else if len(alts) == 0 {
+		return nil
+	}
+
+	var borrowed []SHA1
+	for _, id := range ids {
+		if !repo.hasLocalObject(id) {
+			borrowed = append(borrowed, id)
+		}
+	}
+
+	if len(borrowed) == 0 {
+		return nil
+	}
+
+	oid, found, err := repo.findUnreachable(NewCommitGraph(repo), borrowed)
+	if err != nil {
+		return err
+	} else if found {
+		return fmt.Errorf("git: object %s is not reachable", oid)
+	}
+
+	return nil
+}
+
+//findUnreachable returns one of ids that is neither a ref tip, nor
+//an object tags among them peel to, nor reachable from the refs. The
+//boolean is false if there is none. Trees are only walked for ids that
+//are not commits, e.g. for blobs fetched on demand by partial clones.
+func (repo *Repository) findUnreachable(graph *CommitGraph, ids []SHA1) (SHA1, bool, error) {
+	refs, err := repo.listRefs()
+	if err != nil {
+		return SHA1{}, false, err
+	}
+
+	tips := make(map[SHA1]bool)
+	var queue []SHA1
+	for _, ref := range refs {
+		oid := ref.id
+		for oid != (SHA1{}) && !tips[oid] {
+			tips[oid] = true
+
+			obj, err := repo.OpenObject(oid)
+			if err != nil {
+				break
+			}
+			obj.Close()
+
+			switch o := obj.(type) {
+			case *Tag:
+				oid = o.Object
+				continue
+			case *Commit:
+				queue = append(queue, oid)
+			}
+			break
+		}
+	}
+
+	pending := make(map[SHA1]bool)
+	objects := 0 //pending ids that are not commits
+	for _, oid := range ids {
+		if tips[oid] || pending[oid] {
+			continue
+		} else if _, err := graph.openObject(oid); err != nil {
+			objects++
+		}
+		pending[oid] = true
+	}
+
+	seen := make(map[SHA1]bool)
+	for _, oid := range queue {
+		seen[oid] = true
+	}
+
+	var walkTree func(id SHA1) error
+	walkTree = func(id SHA1) error {
+		entries, err := repo.readTree(id)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if e.Mode&gitModeTypeMask == gitModeGitlink || seen[e.ID] {
+				continue
+			}
+			seen[e.ID] = true
+
+			if pending[e.ID] {
+				delete(pending, e.ID)
+				objects--
+			}
+
+			if e.Type == ObjTree {
+				err = walkTree(e.ID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	for len(queue) > 0 && len(pending) > 0 {
+		node, err := graph.openObject(queue[0])
+		queue = queue[1:]
+		if err != nil {
+			return SHA1{}, false, err
+		}
+
+		delete(pending, node.ID)
+		for _, pid := range node.parentIDs {
+			if !seen[pid] {
+				seen[pid] = true
+				queue = append(queue, pid)
+			}
+		}
+
+		if objects == 0 {
+			continue
+		}
+
+		commit, err := graph.Commit(node)
+		if err != nil {
+			return SHA1{}, false, err
+		}
+
+		if pending[commit.Tree] {
+			delete(pending, commit.Tree)
+			objects--
+		}
+
+		if !seen[commit.Tree] {
+			seen[commit.Tree] = true
+			err = walkTree(commit.Tree)
+			if err != nil {
+				return SHA1{}, false, err
+			}
+		}
+	}
+
+	for oid := range pending {
+		return oid, true, nil
+	}
+
+	return SHA1{}, false, nil
+}
@@ -0,0 +1,226 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAlternates(t *testing.T) {
+	parent := newTestRepo(t)
+	defer parent.cleanup()
+
+	parent.commit("first", map[string]string{"a.txt": "a\n", "dir/b.txt": "b\n"})
+	parent.git("repack", "-q", "-a", "-d")
+	parent.git("multi-pack-index", "write")
+	parent.commit("second", map[string]string{"a.txt": "aa\n"})
+	parent.git("repack", "-q")
+	head := parent.commit("third", map[string]string{"dir/c.txt": "c\n"})
+
+	fork := newTestRepo(t)
+	defer fork.cleanup()
+
+	err := fork.AddAlternate(filepath.Join(parent.Path, "objects"))
+	if err != nil {
+		t.Fatalf("could not add alternate: %v", err)
+	}
+
+	//adding it twice is a no-op
+	err = fork.AddAlternate(filepath.Join(parent.Path, "objects"))
+	if err != nil {
+		t.Fatalf("could not add alternate again: %v", err)
+	} else if alts, err := fork.Alternates(); err != nil || len(alts) != 1 {
+		t.Fatalf("unexpected alternates %v (%v)", alts, err)
+	}
+
+	fork.git("update-ref", "refs/heads/master", head.String())
+	fork.git("reset", "-q", "--hard")
+	forked := fork.commit("fork", map[string]string{"a.txt": "fork\n"})
+
+	//loose, packed and via the multi-pack-index
+	for _, rev := range []string{"master", "master~1", "master~2", "master~3:dir/b.txt", "master:a.txt"} {
+		id := fork.revParse(rev)
+
+		obj, err := fork.OpenObject(id)
+		if err != nil {
+			t.Fatalf("could not open %s (%s): %v", rev, id, err)
+		}
+		obj.Close()
+
+		//abbreviations are only resolved for objects of the fork itself
+		found, err := fork.RevParse(id.String()[:10])
+		if local := rev == "master" || rev == "master:a.txt"; local && (err != nil || found != id) {
+			t.Fatalf("could not find abbreviated %s: %s, %v", id, found, err)
+		} else if !local && err == nil {
+			t.Fatalf("abbreviated %s found in the alternates", id)
+		}
+	}
+
+	report, err := fork.Fsck()
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	} else if !report.OK() {
+		t.Fatalf("unexpected fsck issues: %v", report.Issues)
+	}
+
+	//only the objects of the fork are packed
+	res, err := fork.Repack(time.Time{})
+	if err != nil {
+		t.Fatalf("repack failed: %v", err)
+	} else if res.Objects != 3 {
+		t.Fatalf("expected the commit, its tree and a blob in the pack, got %d objects", res.Objects)
+	}
+	fork.git("fsck", "--strict", "--no-dangling")
+
+	//alternates of alternates, with a relative path
+	nested := newTestRepo(t)
+	defer nested.cleanup()
+
+	rel, err := filepath.Rel(filepath.Join(nested.Path, "objects"), filepath.Join(fork.Path, "objects"))
+	if err != nil {
+		t.Fatalf("could not make relative path: %v", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(nested.Path, "objects", "info", "alternates"), []byte("# the fork\n"+rel+"\n"), 0644)
+	if err != nil {
+		t.Fatalf("could not write alternates: %v", err)
+	}
+
+	alts, err := nested.Alternates()
+	if err != nil || len(alts) != 2 {
+		t.Fatalf("unexpected alternates %v (%v)", alts, err)
+	}
+
+	for _, id := range []SHA1{forked, head, parent.revParse("master~2:dir/b.txt")} {
+		if !nested.hasObject(id) {
+			t.Fatalf("object %s not found via nested alternates", id)
+		} else if nested.hasLocalObject(id) {
+			t.Fatalf("object %s found locally", id)
+		}
+	}
+}
+
+func TestCheckReachable(t *testing.T) {
+	parent := newTestRepo(t)
+	defer parent.cleanup()
+
+	first := parent.commit("first", map[string]string{"a.txt": "a\n"})
+	head := parent.commit("second", map[string]string{"a.txt": "aa\n"})
+
+	fork := newTestRepo(t)
+	defer fork.cleanup()
+
+	err := fork.AddAlternate(filepath.Join(parent.Path, "objects"))
+	if err != nil {
+		t.Fatalf("could not add alternate: %v", err)
+	}
+	fork.git("update-ref", "refs/heads/master", head.String())
+	fork.git("reset", "-q", "--hard")
+	forked := fork.commit("fork", map[string]string{"b.txt": "b\n"})
+
+	//pushed to the parent after the fork, then force-pushed away
+	private := parent.commit("private", map[string]string{"secret.txt": "secret\n"})
+	secret := parent.revParse("HEAD:secret.txt")
+	parent.git("reset", "-q", "--hard", head.String())
+
+	for _, id := range []SHA1{forked, fork.revParse("HEAD:b.txt"), head, first, parent.revParse("HEAD~1:a.txt")} {
+		if err := fork.CheckReachable(id); err != nil {
+			t.Fatalf("%s is not reachable: %v", id, err)
+		}
+	}
+
+	who := NewSignature("Alice", "alice@example.com", time.Now())
+	for _, id := range []SHA1{private, secret} {
+		if err := fork.CheckReachable(id); err == nil {
+			t.Fatalf("expected %s of the parent to be unreachable", id)
+		} else if _, err := fork.RevParse(id.String()[:10]); err == nil {
+			t.Fatalf("abbreviated %s of the parent found", id)
+		} else if _, err := fork.CreateTag("leak", id, who, ""); err != ErrTagTarget {
+			t.Fatalf("expected ErrTagTarget for tag of %s, got %v", id, err)
+		}
+	}
+
+	if _, err := fork.CreateTag("first", first, who, "first"); err != nil {
+		t.Fatalf("could not tag reachable commit of the parent: %v", err)
+	}
+
+	//without alternates, everything is reachable
+	if err := parent.CheckReachable(private); err != nil {
+		t.Fatalf("unexpected error for repository without alternates: %v", err)
+	}
+}
+
+func TestCopyRefs(t *testing.T) {
+	src := newTestRepo(t)
+	defer src.cleanup()
+
+	src.commit("first", map[string]string{"a.txt": "a\n"})
+	src.git("tag", "-a", "-m", "tag", "v1.0")
+	src.git("checkout", "-q", "-b", "topic")
+	topic := src.commit("second", map[string]string{"a.txt": "b\n"})
+	src.git("pack-refs", "--all")
+
+	dst := newTestRepo(t)
+	defer dst.cleanup()
+
+	err := dst.AddAlternate(filepath.Join(src.Path, "objects"))
+	if err != nil {
+		t.Fatalf("could not add alternate: %v", err)
+	}
+
+	err = dst.CopyRefs(src.Repository, NewSignature("alice", "", time.Now()))
+	if err != nil {
+		t.Fatalf("could not copy refs: %v", err)
+	}
+
+	for _, ref := range []string{"HEAD", "master", "topic", "v1.0", "v1.0^{commit}"} {
+		if got, wanted := dst.revParse(ref), src.revParse(ref); got != wanted {
+			t.Fatalf("%s is %s, expected %s", ref, got, wanted)
+		}
+	}
+
+	if head := dst.git("symbolic-ref", "HEAD"); head != "refs/heads/topic" || dst.revParse("HEAD") != topic {
+		t.Fatalf("unexpected HEAD %q", head)
+	}
+	dst.git("fsck", "--strict", "--no-dangling")
+
+	//refs must not exist yet
+	if err := dst.CopyRefs(src.Repository, NewSignature("alice", "", time.Now())); err == nil {
+		t.Fatalf("expected error when copying refs again")
+	}
+}
+
+func TestLinkAnnexObjects(t *testing.T) {
+	src := newTestRepo(t)
+	defer src.cleanup()
+
+	content := filepath.Join("annex", "objects", "f87", "4d5", "SHA256E-s5--abc", "SHA256E-s5--abc")
+	path := filepath.Join(src.Path, content)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("could not create annex dir: %v", err)
+	} else if err := ioutil.WriteFile(path, []byte("data\n"), 0444); err != nil {
+		t.Fatalf("could not write annex object: %v", err)
+	}
+
+	dst := newTestRepo(t)
+	defer dst.cleanup()
+
+	for i, wanted := range []int{1, 0} {
+		n, err := dst.LinkAnnexObjects(src.Repository)
+		if err != nil || n != wanted {
+			t.Fatalf("[%d] linked %d objects (%v), expected %d", i, n, err, wanted)
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("could not stat annex object: %v", err)
+	}
+
+	linked, err := os.Stat(filepath.Join(dst.Path, content))
+	if err != nil || !os.SameFile(fi, linked) {
+		t.Fatalf("annex object not linked (%v)", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -140,9 +141,7 @@ func (repo *Repository) Astat(target string) (*AnnexStat, error) {
 		return nil, err
 	}
 
-	// we are in a bare repository, therefore we use hasdirlower
-	p := filepath.Join(repo.Path, "annex", "objects", ki.HashDirLower(), ki.Key, ki.Key)
-	fi, err := os.Stat(p)
+	fi, err := os.Stat(repo.annexContentPath(ki))
 
 	if err == nil {
 		sbuf.Have = true
@@ -155,3 +154,86 @@ func (repo *Repository) Astat(target string) (*AnnexStat, error) {
 
 	return &sbuf, nil
 }
+
+//annexContentPath returns the path of the content of key in
+//the annex object store of the repository.
+func (repo *Repository) annexContentPath(key *AnnexKey) string {
+	// we are in a bare repository, therefore we use hasdirlower
+	return filepath.Join(repo.Path, "annex", "objects", key.HashDirLower(), key.Key, key.Key)
+}
+
+//LinkAnnexObjects hard-links the content of all annexed files of src
+//into the annex object store of the repository, where it is missing.
+//If linking fails, e.g. because the repositories are on different
+//file systems, the content is copied. It returns the number of files
+//linked or copied.
+func (repo *Repository) LinkAnnexObjects(src *Repository) (int, error) {
+	base := filepath.Join(src.Path, "annex", "objects")
+	n := 0
+
+	err := filepath.Walk(base, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == base {
+				return nil
+			}
+			return err
+		} else if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src.Path, path)
+		if err != nil {
+			return err
+		}
+
+		dst := filepath.Join(repo.Path, rel)
+		if _, err := os.Stat(dst); err == nil {
+			return nil
+		}
+
+		err = os.MkdirAll(filepath.Dir(dst), 0775)
+		if err != nil {
+			return err
+		}
+
+		err = os.Link(path, dst)
+		if err != nil {
+			err = copyFile(dst, path, fi.Mode())
+		}
+		if err != nil {
+			return err
+		}
+
+		n++
+		return nil
+	})
+
+	if err != nil {
+		return n, fmt.Errorf("git: could not link annex objects: %v", err)
+	}
+
+	return n, nil
+}
+
+//copyFile copies the file src to dst, which is created with mode.
+func copyFile(dst, src string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
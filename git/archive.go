@@ -0,0 +1,318 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//ArchiveFormat is the format of the archives written by
+//ArchiveWriter. The values are the usual file extensions.
+type ArchiveFormat string
+
+//The supported archive formats.
+const (
+	ArchiveTar   ArchiveFormat = "tar"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+	ArchiveZip   ArchiveFormat = "zip"
+)
+
+//ParseArchiveFormat parses the archive format, given as file
+//extension; "tgz" is accepted for ArchiveTarGz as well.
+func ParseArchiveFormat(s string) (ArchiveFormat, error) {
+	switch s {
+	case "tar":
+		return ArchiveTar, nil
+	case "tar.gz", "tgz":
+		return ArchiveTarGz, nil
+	case "zip":
+		return ArchiveZip, nil
+	}
+
+	return "", fmt.Errorf("git: unknown archive format %q", s)
+}
+
+//ArchiveWriter writes the tree of a commit as archive, like
+//"git archive" does: files and directories get the modification
+//time of the commit, symbolic links are stored as such and
+//submodules as empty directories.
+type ArchiveWriter struct {
+	Repo   *Repository
+	Format ArchiveFormat
+
+	//Prefix is prepended to all paths, e.g. "name/"
+	//to put the files into the directory "name".
+	Prefix string
+
+	//Annex, if true, stores annexed files with their content,
+	//if that is present in the repository, instead of the
+	//symbolic link into the annex.
+	Annex bool
+}
+
+//NewArchiveWriter creates an ArchiveWriter for
+//archives of format of the repository.
+func NewArchiveWriter(repo *Repository, format ArchiveFormat) *ArchiveWriter {
+	return &ArchiveWriter{Repo: repo, Format: format}
+}
+
+//archiver is the format specific part of ArchiveWriter.
+type archiver interface {
+	dir(name string) error
+	file(name string, mode os.FileMode, size int64, r io.Reader) error
+	symlink(name, target string) error
+	close() error
+}
+
+//Write writes the archive of the tree of id, which can be a commit, a
+//tag or a tree, to w. The modification time of the entries is the
+//commit time or, for trees, the current time.
+func (aw *ArchiveWriter) Write(w io.Writer, id SHA1) error {
+	tree, mtime, err := aw.root(id)
+	if err != nil {
+		return err
+	}
+
+	var ar archiver
+	switch aw.Format {
+	case ArchiveTar:
+		ar = &tarArchiver{w: tar.NewWriter(w), mtime: mtime}
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		ar = &tarArchiver{w: tar.NewWriter(gz), gz: gz, mtime: mtime}
+	case ArchiveZip:
+		ar = &zipArchiver{w: zip.NewWriter(w), mtime: mtime}
+	default:
+		return fmt.Errorf("git: unknown archive format %q", aw.Format)
+	}
+
+	if strings.HasSuffix(aw.Prefix, "/") {
+		err = ar.dir(aw.Prefix)
+	}
+
+	if err == nil {
+		err = aw.writeTree(ar, tree, aw.Prefix)
+	}
+
+	if cerr := ar.close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+//root peels id to its tree and determines the modification time.
+func (aw *ArchiveWriter) root(id SHA1) (SHA1, time.Time, error) {
+	for {
+		obj, err := aw.Repo.OpenObject(id)
+		if err != nil {
+			return id, time.Time{}, err
+		}
+		obj.Close()
+
+		switch o := obj.(type) {
+		case *Tag:
+			id = o.Object
+		case *Commit:
+			return o.Tree, o.Committer.Date, nil
+		case *Tree:
+			return id, time.Now(), nil
+		default:
+			return id, time.Time{}, fmt.Errorf("git: cannot archive %s %s", obj.Type(), id)
+		}
+	}
+}
+
+//writeTree adds the entries of the tree id below prefix.
+func (aw *ArchiveWriter) writeTree(ar archiver, id SHA1, prefix string) error {
+	obj, err := aw.Repo.OpenObject(id)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	tree, ok := obj.(*Tree)
+	if !ok {
+		return fmt.Errorf("git: %s is a %s, not a tree", id, obj.Type())
+	}
+
+	for tree.Next() {
+		e := tree.Entry()
+		name := prefix + e.Name
+
+		switch {
+		case e.Type == ObjTree:
+			err = ar.dir(name + "/")
+			if err == nil {
+				err = aw.writeTree(ar, e.ID, name+"/")
+			}
+		case e.Mode&gitModeTypeMask == gitModeGitlink:
+			err = ar.dir(name + "/")
+		case e.Mode&gitModeTypeMask == gitModeSymlink:
+			err = aw.writeSymlink(ar, name, e.ID)
+		default:
+			err = aw.writeBlob(ar, name, e.Mode&0111 != 0, e.ID)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tree.Err()
+}
+
+func (aw *ArchiveWriter) writeBlob(ar archiver, name string, executable bool, id SHA1) error {
+	obj, err := aw.Repo.OpenObject(id)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		return fmt.Errorf("git: %s is a %s, not a blob", id, obj.Type())
+	}
+
+	mode := os.FileMode(0644)
+	if executable {
+		mode = 0755
+	}
+
+	return ar.file(name, mode, blob.Size(), blob)
+}
+
+//writeSymlink adds the symbolic link name, or the annexed
+//file it points to, if requested and present.
+func (aw *ArchiveWriter) writeSymlink(ar archiver, name string, id SHA1) error {
+	target, err := aw.Repo.Readlink(id)
+	if err != nil {
+		return err
+	}
+
+	if !aw.Annex || !strings.Contains(target, ".git/annex/objects/") {
+		return ar.symlink(name, target)
+	}
+
+	key, err := AnnexExamineKey(path.Base(target))
+	if err != nil {
+		return ar.symlink(name, target)
+	}
+
+	fd, err := os.Open(aw.Repo.annexContentPath(key))
+	if os.IsNotExist(err) {
+		return ar.symlink(name, target)
+	} else if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	return ar.file(name, 0644, fi.Size(), fd)
+}
+
+//tarArchiver writes tar archives, optionally gzip compressed.
+type tarArchiver struct {
+	w     *tar.Writer
+	gz    *gzip.Writer
+	mtime time.Time
+}
+
+//tarUmask is applied to the modes, like git's default tar.umask.
+const tarUmask = 0002
+
+func (ta *tarArchiver) dir(name string) error {
+	return ta.w.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeDir,
+		Mode:     0777 &^ tarUmask,
+		ModTime:  ta.mtime,
+	})
+}
+
+func (ta *tarArchiver) file(name string, mode os.FileMode, size int64, r io.Reader) error {
+	err := ta.w.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     int64(mode|0666) &^ tarUmask,
+		Size:     size,
+		ModTime:  ta.mtime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(ta.w, r, size)
+	return err
+}
+
+func (ta *tarArchiver) symlink(name, target string) error {
+	return ta.w.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeSymlink,
+		Linkname: target,
+		Mode:     0777,
+		ModTime:  ta.mtime,
+	})
+}
+
+func (ta *tarArchiver) close() error {
+	err := ta.w.Close()
+	if ta.gz != nil {
+		if cerr := ta.gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+//zipArchiver writes zip archives, with deflated files.
+type zipArchiver struct {
+	w     *zip.Writer
+	mtime time.Time
+}
+
+func (za *zipArchiver) create(name string, mode os.FileMode, method uint16) (io.Writer, error) {
+	hdr := &zip.FileHeader{Name: name, Method: method, Modified: za.mtime}
+	hdr.SetMode(mode)
+	return za.w.CreateHeader(hdr)
+}
+
+func (za *zipArchiver) dir(name string) error {
+	_, err := za.create(name, os.ModeDir|0755, zip.Store)
+	return err
+}
+
+func (za *zipArchiver) file(name string, mode os.FileMode, size int64, r io.Reader) error {
+	w, err := za.create(name, mode, zip.Deflate)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(w, r, size)
+	return err
+}
+
+func (za *zipArchiver) symlink(name, target string) error {
+	w, err := za.create(name, os.ModeSymlink|0777, zip.Store)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (za *zipArchiver) close() error {
+	return za.w.Close()
+}
@@ -0,0 +1,167 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//archiveEntry is an entry of a tar or zip archive.
+type archiveEntry struct {
+	name  string
+	mode  os.FileMode
+	mtime int64
+	data  string //content, or the target of symlinks
+}
+
+func (e archiveEntry) String() string {
+	return fmt.Sprintf("%s %v %d %q", e.name, e.mode, e.mtime, e.data)
+}
+
+func readTar(t *testing.T, r io.Reader) []archiveEntry {
+	var entries []archiveEntry
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("could not read tar: %v", err)
+		} else if hdr.Typeflag == tar.TypeXGlobalHeader {
+			//git stores the commit id there
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("could not read tar: %v", err)
+		}
+
+		e := archiveEntry{hdr.Name, hdr.FileInfo().Mode(), hdr.ModTime.Unix(), string(data)}
+		if hdr.Typeflag == tar.TypeSymlink {
+			e.data = hdr.Linkname
+		}
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+func TestArchive(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	key := "SHA256E-s8--0123456789abcdef.txt"
+	annexed := ".git/annex/objects/Xx/Yy/" + key + "/" + key
+
+	tr.write(map[string]string{
+		"a.txt":         "a\n",
+		"dir/b.txt":     "b\n",
+		"dir/sub/c.txt": strings.Repeat("c\n", 1000),
+		"run.sh":        "#!/bin/sh\n",
+	})
+	os.Chmod(filepath.Join(tr.work, "run.sh"), 0755)
+	os.Symlink("dir/b.txt", filepath.Join(tr.work, "link"))
+	os.Symlink(annexed, filepath.Join(tr.work, "data.txt"))
+	tr.commit("first", nil)
+	tr.git("tag", "-a", "-m", "tag", "v1.0")
+
+	//the content of the annexed file is present
+	ak, _ := AnnexExamineKey(key)
+	path := tr.annexContentPath(ak)
+	os.MkdirAll(filepath.Dir(path), 0777)
+	ioutil.WriteFile(path, []byte("annexed\n"), 0444)
+
+	//compare to git's idea of it
+	cmd := exec.Command("git", "--git-dir="+tr.Path, "archive", "--format=tar", "--prefix=repo/", "v1.0")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git archive failed: %v", err)
+	}
+	wanted := readTar(t, bytes.NewReader(out))
+
+	id := tr.revParse("v1.0")
+	aw := NewArchiveWriter(tr.Repository, ArchiveTar)
+	aw.Prefix = "repo/"
+
+	var buf bytes.Buffer
+	if err := aw.Write(&buf, id); err != nil {
+		t.Fatalf("could not write tar: %v", err)
+	}
+
+	entries := readTar(t, &buf)
+	if fmt.Sprint(entries) != fmt.Sprint(wanted) {
+		t.Fatalf("unexpected tar entries:\n%v\nexpected:\n%v", entries, wanted)
+	}
+
+	//compressed and with annexed content
+	aw.Format = ArchiveTarGz
+	aw.Annex = true
+	buf.Reset()
+	if err := aw.Write(&buf, id); err != nil {
+		t.Fatalf("could not write tar.gz: %v", err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("could not read tar.gz: %v", err)
+	}
+	entries = readTar(t, gz)
+
+	for i, e := range wanted {
+		if e.name == "repo/data.txt" {
+			wanted[i] = archiveEntry{e.name, 0664, e.mtime, "annexed\n"}
+		}
+	}
+	if fmt.Sprint(entries) != fmt.Sprint(wanted) {
+		t.Fatalf("unexpected tar.gz entries:\n%v\nexpected:\n%v", entries, wanted)
+	}
+
+	aw = NewArchiveWriter(tr.Repository, ArchiveZip)
+	buf.Reset()
+	if err := aw.Write(&buf, id); err != nil {
+		t.Fatalf("could not write zip: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("could not read zip: %v", err)
+	}
+
+	var names []string
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		files[f.Name] = f
+	}
+
+	if list := strings.Join(names, " "); list != "a.txt data.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt link run.sh" {
+		t.Fatalf("unexpected zip entries: %s", list)
+	}
+
+	for name, mode := range map[string]os.FileMode{"a.txt": 0644, "run.sh": 0755, "dir/": os.ModeDir | 0755, "link": os.ModeSymlink | 0777} {
+		if m := files[name].Mode(); m != mode {
+			t.Errorf("unexpected mode %v of %s in zip, expected %v", m, name, mode)
+		}
+	}
+
+	rc, err := files["dir/sub/c.txt"].Open()
+	if err != nil {
+		t.Fatalf("could not open zip entry: %v", err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != strings.Repeat("c\n", 1000) {
+		t.Fatalf("unexpected content of zip entry (%v)", err)
+	}
+}
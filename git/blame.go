@@ -0,0 +1,307 @@
+package git
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+//BlameLine attributes a single line of a file to the
+//commit that introduced it.
+type BlameLine struct {
+	Commit SHA1
+	Author Signature
+
+	OrigPath string //path of the file in Commit
+	OrigLine int    //one-based line number in Commit's version of the file
+
+	Text string //without the trailing newline
+}
+
+//blameRef is a line still to be attributed: its (zero-based)
+//index in the final file and in the current version of the file.
+type blameRef struct {
+	final int
+	orig  int
+}
+
+//blameEntry is a set of lines of the file at path in a commit.
+type blameEntry struct {
+	node  *CommitNode
+	path  string
+	blob  SHA1
+	lines []blameRef
+}
+
+//blameQueue orders the blame entries youngest commit first,
+//so that a commit is (usually) processed after its children.
+type blameQueue []*blameEntry
+
+func (q blameQueue) Len() int           { return len(q) }
+func (q blameQueue) Less(i, j int) bool { return q[i].node.Date().After(q[j].node.Date()) }
+func (q blameQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *blameQueue) Push(x interface{}) {
+	*q = append(*q, x.(*blameEntry))
+}
+
+func (q *blameQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[0 : n-1]
+	return x
+}
+
+type blamer struct {
+	repo  *Repository
+	graph *CommitGraph
+	queue blameQueue
+
+	//pending entries in the queue, by commit and path
+	pending map[string]*blameEntry
+	blobs   map[SHA1][]string
+	result  []BlameLine
+}
+
+//Blame attributes every line of the file at path in the commit start
+//to the commit that introduced it, following the history backwards.
+//Lines unchanged in a parent (according to the line diff) are passed
+//on to that parent; for merges, parents are tried in order. Renames
+//of the file are followed.
+func (repo *Repository) Blame(start SHA1, path string) ([]BlameLine, error) {
+	start, err := repo.peel(start, ObjCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &blamer{
+		repo:    repo,
+		graph:   NewCommitGraph(repo),
+		pending: make(map[string]*blameEntry),
+		blobs:   make(map[SHA1][]string),
+	}
+
+	node, err := b.graph.openObject(start)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := b.graph.Commit(node)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := repo.lookupPath(commit.Tree, path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := b.lines(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]blameRef, len(lines))
+	b.result = make([]BlameLine, len(lines))
+	for i, l := range lines {
+		refs[i] = blameRef{final: i, orig: i}
+		b.result[i].Text = trimNewline(l)
+	}
+
+	b.add(node, path, blob, refs)
+
+	for b.queue.Len() > 0 {
+		e := heap.Pop(&b.queue).(*blameEntry)
+		delete(b.pending, blameKey(e.node.ID, e.path))
+
+		err = b.process(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b.result, nil
+}
+
+func blameKey(oid SHA1, path string) string {
+	return oid.String() + ":" + path
+}
+
+func trimNewline(l string) string {
+	if n := len(l); n > 0 && l[n-1] == '\n' {
+		return l[:n-1]
+	}
+	return l
+}
+
+//add queues the lines for the file at path in the commit node.
+func (b *blamer) add(node *CommitNode, path string, blob SHA1, refs []blameRef) {
+	if len(refs) == 0 {
+		return
+	}
+
+	key := blameKey(node.ID, path)
+	if e, ok := b.pending[key]; ok {
+		e.lines = append(e.lines, refs...)
+		return
+	}
+
+	e := &blameEntry{node: node, path: path, blob: blob, lines: refs}
+	b.pending[key] = e
+	heap.Push(&b.queue, e)
+}
+
+//lines returns the (cached) lines of the blob.
+func (b *blamer) lines(blob SHA1) ([]string, error) {
+	if lines, ok := b.blobs[blob]; ok {
+		return lines, nil
+	}
+
+	data, err := b.repo.readBlob(blob, DefaultMaxDiffSize)
+	if err != nil {
+		return nil, err
+	} else if isBinary(data) {
+		return nil, fmt.Errorf("git: cannot blame binary file")
+	}
+
+	lines := splitLines(data)
+	b.blobs[blob] = lines
+	return lines, nil
+}
+
+//parentBlob finds the version of the file at path in the parent
+//commit, following renames. It returns false if the file does not
+//exist in the parent.
+func (b *blamer) parentBlob(commit, parent *Commit, path string) (string, SHA1, bool, error) {
+	blob, err := b.repo.lookupPath(parent.Tree, path)
+	if err == nil {
+		obj, err := b.repo.OpenObject(blob)
+		if err != nil {
+			return "", blob, false, err
+		}
+		obj.Close()
+
+		if obj.Type() == ObjBlob {
+			return path, blob, true, nil
+		}
+	}
+
+	changes, err := b.repo.DiffTrees(parent.Tree, commit.Tree, DiffOptions{DetectRenames: true})
+	if err != nil {
+		return "", blob, false, err
+	}
+
+	for _, c := range changes {
+		if c.Status == ChangeRenamed && c.NewPath == path {
+			return c.OldPath, c.OldID, true, nil
+		}
+	}
+
+	return "", blob, false, nil
+}
+
+//process passes the lines of the entry that are unchanged in one
+//of the parents on to that parent and attributes the rest to the
+//commit itself.
+func (b *blamer) process(e *blameEntry) error {
+	commit, err := b.graph.Commit(e.node)
+	if err != nil {
+		return err
+	}
+
+	type parentFile struct {
+		node *CommitNode
+		path string
+		blob SHA1
+	}
+
+	//NB: shallow commits have no parents in the graph
+	var parents []parentFile
+	for _, pid := range e.node.parentIDs {
+		pnode, err := b.graph.openObject(pid)
+		if err != nil {
+			return err
+		}
+
+		pc, err := b.graph.Commit(pnode)
+		if err != nil {
+			return err
+		}
+
+		path, blob, ok, err := b.parentBlob(commit, pc, e.path)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		//unchanged in this parent, it gets everything
+		if blob == e.blob {
+			b.add(pnode, path, blob, e.lines)
+			return nil
+		}
+
+		parents = append(parents, parentFile{pnode, path, blob})
+	}
+
+	remaining := e.lines
+	if len(parents) > 0 {
+		cur, err := b.lines(e.blob)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range parents {
+			if len(remaining) == 0 {
+				break
+			}
+
+			old, err := b.lines(p.blob)
+			if err != nil {
+				//e.g. a binary file in the parent
+				continue
+			}
+
+			//map the unchanged lines to their index in the parent;
+			//if the versions differ too much, nothing is passed on
+			del, ins, err := diffLines(old, cur, DefaultMaxDiffCost)
+			if err != nil {
+				continue
+			}
+			mapping := make([]int, len(cur))
+			for i, j := 0, 0; j < len(cur); j++ {
+				if ins[j] {
+					mapping[j] = -1
+					continue
+				}
+				for del[i] {
+					i++
+				}
+				mapping[j] = i
+				i++
+			}
+
+			var passed, kept []blameRef
+			for _, ref := range remaining {
+				if idx := mapping[ref.orig]; idx > -1 {
+					passed = append(passed, blameRef{ref.final, idx})
+				} else {
+					kept = append(kept, ref)
+				}
+			}
+
+			b.add(p.node, p.path, p.blob, passed)
+			remaining = kept
+		}
+	}
+
+	for _, ref := range remaining {
+		l := &b.result[ref.final]
+		l.Commit = e.node.ID
+		l.Author = commit.Author
+		l.OrigPath = e.path
+		l.OrigLine = ref.orig + 1
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package git
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBlame(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, fmt.Sprintf("param%d = %d", i, i))
+	}
+	content := func() map[string]string {
+		return map[string]string{"params.txt": strings.Join(lines, "\n") + "\n"}
+	}
+
+	tr.commit("initial", content())
+
+	lines[3] = "param3 = 42"
+	lines = append(lines[:7], lines[8:]...)
+	tr.commit("change 3, remove 7", content())
+
+	tr.git("checkout", "-q", "-b", "side")
+	lines[15] = "param16 = side"
+	lines = append(lines, "added on side")
+	tr.commit("side change", content())
+
+	tr.git("checkout", "-q", "master")
+	lines = lines[:len(lines)-1]
+	lines[15] = "param16 = 16"
+	lines[1] = "param1 = master"
+	lines = append([]string{"# header"}, lines...)
+	tr.commit("master change", content())
+
+	tr.git("merge", "-q", "--no-edit", "side")
+	tr.git("mv", "params.txt", "renamed.txt")
+	tr.commit("rename", nil)
+
+	lines = append(lines[:10], append([]string{"inserted a", "inserted b"}, lines[10:]...)...)
+	tr.write(map[string]string{"renamed.txt": strings.Join(lines, "\n") + "\n"})
+	tr.commit("insert", nil)
+
+	head := tr.revParse("HEAD")
+	res, err := tr.Blame(head, "renamed.txt")
+	if err != nil {
+		t.Fatalf("Blame failed: %v", err)
+	}
+
+	//parse "git blame --porcelain", the header lines are:
+	//<sha1> <orig line> <final line> [<count>]
+	var wanted []BlameLine
+	paths := make(map[string]string)
+	var cur string
+	for _, l := range strings.Split(tr.git("blame", "--porcelain", head.String(), "--", "renamed.txt"), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) >= 3 && len(fields[0]) == 40 {
+			oid, err := ParseSHA1(fields[0])
+			if err != nil {
+				t.Fatalf("could not parse blame output %q", l)
+			}
+			orig, _ := strconv.Atoi(fields[1])
+			cur = fields[0]
+			wanted = append(wanted, BlameLine{Commit: oid, OrigLine: orig})
+		} else if strings.HasPrefix(l, "filename ") {
+			paths[cur] = strings.TrimPrefix(l, "filename ")
+		} else if strings.HasPrefix(l, "\t") {
+			wanted[len(wanted)-1].Text = l[1:]
+		}
+	}
+
+	if len(res) != len(wanted) {
+		t.Fatalf("expected %d lines, got %d", len(wanted), len(res))
+	}
+
+	for i, l := range res {
+		w := wanted[i]
+		if l.Commit != w.Commit || l.OrigLine != w.OrigLine || l.Text != w.Text || l.OrigPath != paths[w.Commit.String()] {
+			t.Fatalf("line %d: got %s %s:%d %q, expected %s %s:%d %q", i+1,
+				l.Commit, l.OrigPath, l.OrigLine, l.Text,
+				w.Commit, paths[w.Commit.String()], w.OrigLine, w.Text)
+		}
+
+		if l.Author.Name != "A U Thor" || l.Author.Date.IsZero() {
+			t.Fatalf("line %d: unexpected author: %v", i+1, l.Author)
+		}
+	}
+
+	if _, err := tr.Blame(head, "doesnotexist"); err == nil {
+		t.Fatalf("expected error for non-existing file")
+	}
+}
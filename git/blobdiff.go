@@ -0,0 +1,460 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+//DefaultDiffContext is the number of context lines used for
+//hunks if BlobDiffOptions.Context is negative.
+const DefaultDiffContext = 3
+
+//DefaultMaxDiffSize is the size limit for blobs to be diffed
+//if BlobDiffOptions.MaxSize is zero.
+const DefaultMaxDiffSize = 2 * 1024 * 1024
+
+//DefaultMaxDiffCost is the limit of the work done to diff two blobs,
+//in diagonals and snake steps searched, if BlobDiffOptions.MaxCost is
+//zero. Like the cost limit of xdiff, it bounds the run time, which is
+//quadratic in the number of differing lines otherwise.
+const DefaultMaxDiffCost = 1 << 24
+
+//errDiffCost is returned by diffLines if the cost limit is exceeded.
+var errDiffCost = fmt.Errorf("git: diff: cost limit exceeded")
+
+//binaryCheckSize is the number of bytes checked for NUL bytes
+//to detect binary content (as git does).
+const binaryCheckSize = 8000
+
+//BlobDiffOptions controls the output of DiffBlobs.
+type BlobDiffOptions struct {
+	Context int   //number of unchanged lines around changes, <0 means default
+	MaxSize int64 //maximal size of either blob in bytes, 0 means default
+	MaxCost int64 //maximal work of the diff, 0 means default
+}
+
+//DiffLine is a single line of a Hunk, i.e. an unchanged (' '),
+//a deleted ('-') or an added ('+') line.
+type DiffLine struct {
+	Op   byte
+	Text string //without the trailing newline
+
+	//NoNewline is set if the line is the last line of
+	//the file and is not terminated by a newline.
+	NoNewline bool
+}
+
+//Hunk is a group of changed lines and the context around them.
+//Start lines are one-based, like in unified diffs.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+
+	Lines []DiffLine
+}
+
+//Header returns the hunk header, e.g. "@@ -1,3 +1,4 @@".
+func (h *Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+//BlobDiff is the line-based difference of two blobs. If either blob
+//is binary or too large, or the blobs differ too much to be diffed
+//within the cost limit, no hunks are computed and Binary or TooLarge
+//is set instead.
+type BlobDiff struct {
+	Binary   bool
+	TooLarge bool
+
+	Hunks []Hunk
+}
+
+//WriteUnified writes the diff in the unified format, with oldName
+//and newName used in the "---" and "+++" lines. If both names are
+//empty, the file header is omitted.
+func (d *BlobDiff) WriteUnified(w io.Writer, oldName, newName string) error {
+	var buf bytes.Buffer
+
+	switch {
+	case d.Binary:
+		fmt.Fprintf(&buf, "Binary files %s and %s differ\n", oldName, newName)
+	case d.TooLarge:
+		fmt.Fprintf(&buf, "Files %s and %s differ (too large to diff)\n", oldName, newName)
+	case len(d.Hunks) == 0:
+	default:
+		if oldName != "" || newName != "" {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+		}
+
+		for i := range d.Hunks {
+			h := &d.Hunks[i]
+			fmt.Fprintf(&buf, "%s\n", h.Header())
+			for _, l := range h.Lines {
+				fmt.Fprintf(&buf, "%c%s\n", l.Op, l.Text)
+				if l.NoNewline {
+					buf.WriteString("\\ No newline at end of file\n")
+				}
+			}
+		}
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+//DiffBlobs computes the line-based diff of the blobs a and b, either
+//of which can be nil for an empty (i.e. added or deleted) file. The
+//blobs are read, but not closed.
+func DiffBlobs(a, b *Blob, opts BlobDiffOptions) (*BlobDiff, error) {
+	limit := opts.MaxSize
+	if limit <= 0 {
+		limit = DefaultMaxDiffSize
+	}
+
+	var data [2][]byte
+	for i, blob := range []*Blob{a, b} {
+		if blob == nil {
+			continue
+		} else if blob.Size() > limit {
+			return &BlobDiff{TooLarge: true}, nil
+		}
+
+		var err error
+		data[i], err = ioutil.ReadAll(blob)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return DiffData(data[0], data[1], opts), nil
+}
+
+//DiffData computes the line-based diff of a and b,
+//see DiffBlobs. The size limit is not checked.
+func DiffData(a, b []byte, opts BlobDiffOptions) *BlobDiff {
+	if bytes.Equal(a, b) {
+		return &BlobDiff{}
+	} else if isBinary(a) || isBinary(b) {
+		return &BlobDiff{Binary: true}
+	}
+
+	context := opts.Context
+	if context < 0 {
+		context = DefaultDiffContext
+	}
+
+	maxCost := opts.MaxCost
+	if maxCost <= 0 {
+		maxCost = DefaultMaxDiffCost
+	}
+
+	la, lb := splitLines(a), splitLines(b)
+	del, ins, err := diffLines(la, lb, maxCost)
+	if err != nil {
+		return &BlobDiff{TooLarge: true}
+	}
+
+	return &BlobDiff{Hunks: makeHunks(la, lb, del, ins, context)}
+}
+
+//diffLines returns which lines of a are deleted and
+//which lines of b are inserted to transform a into b.
+//If that takes more than maxCost, errDiffCost is returned.
+func diffLines(la, lb []string, maxCost int64) (del, ins []bool, err error) {
+	//map lines to ints, so that they can be compared cheaply
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		res := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			res[i] = id
+		}
+		return res
+	}
+
+	m := &myers{a: intern(la), b: intern(lb), maxCost: maxCost}
+	m.del = make([]bool, len(la))
+	m.ins = make([]bool, len(lb))
+	err = m.compare(0, len(la), 0, len(lb))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m.del, m.ins, nil
+}
+
+func isBinary(data []byte) bool {
+	if len(data) > binaryCheckSize {
+		data = data[:binaryCheckSize]
+	}
+	return bytes.IndexByte(data, 0) > -1
+}
+
+//splitLines splits data into lines, keeping the newlines.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n') + 1
+		if n == 0 {
+			n = len(data)
+		}
+		lines = append(lines, string(data[:n]))
+		data = data[n:]
+	}
+	return lines
+}
+
+//myers implements the linear space variant of the
+//O(ND) difference algorithm by Eugene W. Myers.
+//The result are the lines of a that are deleted
+//and the lines of b that are inserted.
+type myers struct {
+	a, b     []int
+	del, ins []bool
+
+	cost    int64 //work done so far
+	maxCost int64
+}
+
+func (m *myers) compare(aLo, aHi, bLo, bHi int) error {
+	for aLo < aHi && bLo < bHi && m.a[aLo] == m.b[bLo] {
+		aLo++
+		bLo++
+	}
+
+	for aLo < aHi && bLo < bHi && m.a[aHi-1] == m.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	if aLo == aHi {
+		for ; bLo < bHi; bLo++ {
+			m.ins[bLo] = true
+		}
+		return nil
+	} else if bLo == bHi {
+		for ; aLo < aHi; aLo++ {
+			m.del[aLo] = true
+		}
+		return nil
+	}
+
+	x, y, err := m.split(aLo, aHi, bLo, bHi)
+	if err != nil {
+		return err
+	}
+
+	err = m.compare(aLo, x, bLo, y)
+	if err != nil {
+		return err
+	}
+	return m.compare(x, aHi, y, bHi)
+}
+
+//split returns a point on an optimal edit path between the ranges
+//of a and b by searching for the middle snake. The ranges must be
+//non-empty and must not have a common prefix or suffix.
+//The work done is added to m.cost and errDiffCost is returned
+//if it exceeds m.maxCost.
+func (m *myers) split(aLo, aHi, bLo, bHi int) (int, int, error) {
+	n, k := aHi-aLo, bHi-bLo
+	delta := n - k
+	odd := delta&1 != 0
+
+	max := (n + k + 1) / 2
+	off := max + 1
+
+	//vf[off+d] is the furthest x on diagonal d (x - y = d) of the
+	//forward search; vb the same for the backward search, with x
+	//and y counted from the end of the ranges
+	vf := make([]int, 2*max+3)
+	vb := make([]int, 2*max+3)
+
+	for d := 0; d <= max; d++ {
+		if m.cost > m.maxCost {
+			return 0, 0, errDiffCost
+		}
+
+		for diag := -d; diag <= d; diag += 2 {
+			var x int
+			if diag == -d || (diag != d && vf[off+diag-1] < vf[off+diag+1]) {
+				x = vf[off+diag+1]
+			} else {
+				x = vf[off+diag-1] + 1
+			}
+
+			y := x - diag
+			start := x
+			for x < n && y < k && m.a[aLo+x] == m.b[bLo+y] {
+				x++
+				y++
+			}
+			vf[off+diag] = x
+			m.cost += int64(x-start) + 1
+
+			if odd && diag >= delta-(d-1) && diag <= delta+(d-1) {
+				if x+vb[off+delta-diag] >= n {
+					return aLo + x, bLo + y, nil
+				}
+			}
+		}
+
+		for diag := -d; diag <= d; diag += 2 {
+			var x int
+			if diag == -d || (diag != d && vb[off+diag-1] < vb[off+diag+1]) {
+				x = vb[off+diag+1]
+			} else {
+				x = vb[off+diag-1] + 1
+			}
+
+			y := x - diag
+			start := x
+			for x < n && y < k && m.a[aHi-1-x] == m.b[bHi-1-y] {
+				x++
+				y++
+			}
+			vb[off+diag] = x
+			m.cost += int64(x-start) + 1
+
+			if !odd && diag >= delta-d && diag <= delta+d {
+				if x+vf[off+delta-diag] >= n {
+					return aHi - x, bHi - y, nil
+				}
+			}
+		}
+	}
+
+	return 0, 0, fmt.Errorf("git: diff: no middle snake found")
+}
+
+//makeHunks groups the changes into hunks with context lines.
+func makeHunks(a, b []string, del, ins []bool, context int) []Hunk {
+	type edit struct {
+		op   byte
+		i, j int //line index in a and b
+	}
+
+	var edits []edit
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case i < len(a) && del[i]:
+			edits = append(edits, edit{'-', i, j})
+			i++
+		case j < len(b) && ins[j]:
+			edits = append(edits, edit{'+', i, j})
+			j++
+		default:
+			edits = append(edits, edit{' ', i, j})
+			i++
+			j++
+		}
+	}
+
+	var hunks []Hunk
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+
+		//extend the hunk as long as changes are at
+		//most 2*context unchanged lines apart
+		end := start
+		for k := start; k < len(edits) && k-end-1 <= 2*context; k++ {
+			if edits[k].op != ' ' {
+				end = k
+			}
+		}
+
+		lo := start - context
+		if lo < 0 {
+			lo = 0
+		}
+		hi := end + context + 1
+		if hi > len(edits) {
+			hi = len(edits)
+		}
+
+		h := Hunk{OldStart: edits[lo].i + 1, NewStart: edits[lo].j + 1}
+		for _, e := range edits[lo:hi] {
+			var text string
+			switch e.op {
+			case '-':
+				text = a[e.i]
+				h.OldLines++
+			case '+':
+				text = b[e.j]
+				h.NewLines++
+			default:
+				text = a[e.i]
+				h.OldLines++
+				h.NewLines++
+			}
+
+			l := DiffLine{Op: e.op, Text: text}
+			if n := len(text); n > 0 && text[n-1] == '\n' {
+				l.Text = text[:n-1]
+			} else {
+				l.NoNewline = true
+			}
+			h.Lines = append(h.Lines, l)
+		}
+
+		//like diff, empty ranges start at the line before
+		if h.OldLines == 0 {
+			h.OldStart--
+		}
+		if h.NewLines == 0 {
+			h.NewStart--
+		}
+
+		hunks = append(hunks, h)
+		start = hi
+	}
+
+	return hunks
+}
+
+//DiffChange computes the line-based diff for a change returned by
+//DiffTrees. Links to annexed files and submodules are not diffed,
+//i.e. the returned diff has no hunks.
+func (repo *Repository) DiffChange(c *TreeChange, opts BlobDiffOptions) (*BlobDiff, error) {
+	if c.OldAnnexKey != "" || c.NewAnnexKey != "" ||
+		c.OldMode&gitModeTypeMask == gitModeGitlink || c.NewMode&gitModeTypeMask == gitModeGitlink {
+		return &BlobDiff{}, nil
+	}
+
+	var blobs [2]*Blob
+	for i, id := range []SHA1{c.OldID, c.NewID} {
+		if id == (SHA1{}) {
+			continue
+		}
+
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Close()
+
+		blob, ok := obj.(*Blob)
+		if !ok {
+			return nil, fmt.Errorf("git: object [%s] not of type blob", id)
+		}
+		blobs[i] = blob
+	}
+
+	return DiffBlobs(blobs[0], blobs[1], opts)
+}
@@ -0,0 +1,237 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//lcsLen returns the length of the longest common subsequence.
+func lcsLen(a, b []int) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else if prev[j+1] > cur[j] {
+				cur[j+1] = prev[j+1]
+			} else {
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func TestMyersOptimal(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+
+	for n := 0; n < 2000; n++ {
+		a := make([]int, rnd.Intn(30))
+		b := make([]int, rnd.Intn(30))
+		alphabet := 1 + rnd.Intn(5)
+		for i := range a {
+			a[i] = rnd.Intn(alphabet)
+		}
+		for i := range b {
+			b[i] = rnd.Intn(alphabet)
+		}
+
+		m := &myers{a: a, b: b, del: make([]bool, len(a)), ins: make([]bool, len(b)), maxCost: DefaultMaxDiffCost}
+		if err := m.compare(0, len(a), 0, len(b)); err != nil {
+			t.Fatalf("diff of %v -> %v failed: %v", a, b, err)
+		}
+
+		var kept, res []int
+		edits := 0
+		for i, d := range m.del {
+			if d {
+				edits++
+			} else {
+				kept = append(kept, a[i])
+			}
+		}
+		for j, in := range m.ins {
+			if in {
+				edits++
+			} else {
+				res = append(res, b[j])
+			}
+		}
+
+		if fmt.Sprint(kept) != fmt.Sprint(res) {
+			t.Fatalf("invalid edit script for %v -> %v", a, b)
+		} else if wanted := len(a) + len(b) - 2*lcsLen(a, b); edits != wanted {
+			t.Fatalf("non-minimal edit script for %v -> %v: %d vs %d", a, b, edits, wanted)
+		}
+	}
+}
+
+func TestDiffData(t *testing.T) {
+	var lines []string
+	for i := 0; i < 30; i++ {
+		lines = append(lines, fmt.Sprintf("line %d\n", i))
+	}
+	old := strings.Join(lines, "")
+
+	lines[1] = "changed 1\n"
+	lines = append(lines[:10], lines[12:]...)
+	lines = append(lines[:20], append([]string{"new a\n", "new b\n"}, lines[20:]...)...)
+	cur := strings.Join(lines, "") + "no newline"
+
+	var buf bytes.Buffer
+	err := DiffData([]byte(old), []byte(cur), BlobDiffOptions{Context: -1}).WriteUnified(&buf, "a/file", "b/file")
+	if err != nil {
+		t.Fatalf("could not write diff: %v", err)
+	}
+
+	wanted := `--- a/file
++++ b/file
+@@ -1,5 +1,5 @@
+ line 0
+-line 1
++changed 1
+ line 2
+ line 3
+ line 4
+@@ -8,8 +8,6 @@
+ line 7
+ line 8
+ line 9
+-line 10
+-line 11
+ line 12
+ line 13
+ line 14
+@@ -20,6 +18,8 @@
+ line 19
+ line 20
+ line 21
++new a
++new b
+ line 22
+ line 23
+ line 24
+@@ -28,3 +28,4 @@
+ line 27
+ line 28
+ line 29
++no newline
+\ No newline at end of file
+`
+	if buf.String() != wanted {
+		t.Fatalf("unexpected diff:\n%s\nexpected:\n%s", buf.String(), wanted)
+	}
+
+	d := DiffData([]byte(old), []byte("bin\x00ary"), BlobDiffOptions{})
+	if !d.Binary || len(d.Hunks) != 0 {
+		t.Fatalf("expected binary diff")
+	}
+
+	d = DiffData(nil, []byte("a\nb\n"), BlobDiffOptions{Context: 0})
+	if len(d.Hunks) != 1 || d.Hunks[0].Header() != "@@ -0,0 +1,2 @@" {
+		t.Fatalf("unexpected hunks for new file: %+v", d.Hunks)
+	}
+}
+
+func TestDiffCost(t *testing.T) {
+	var a, b bytes.Buffer
+	for i := 0; i < 80000; i++ {
+		fmt.Fprintf(&a, "old line %d\n", i)
+		fmt.Fprintf(&b, "new line %d\n", i)
+	}
+
+	//a full rewrite is too costly
+	start := time.Now()
+	d := DiffData(a.Bytes(), b.Bytes(), BlobDiffOptions{})
+	if !d.TooLarge || len(d.Hunks) != 0 {
+		t.Fatalf("expected too large diff for full rewrite")
+	} else if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("diff took %v", elapsed)
+	}
+
+	//but can be diffed with a higher limit
+	old, cur := []byte("a\nb\nc\nd\n"), []byte("w\nx\ny\nz\n")
+	if d := DiffData(old, cur, BlobDiffOptions{MaxCost: 2}); !d.TooLarge {
+		t.Fatalf("expected too large diff for low cost limit")
+	} else if d := DiffData(old, cur, BlobDiffOptions{}); d.TooLarge || len(d.Hunks) != 1 {
+		t.Fatalf("unexpected diff %+v", d)
+	}
+}
+
+func TestDiffBlobs(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	var data []string
+	for i := 0; i < 200; i++ {
+		data = append(data, fmt.Sprintf("%d", i%17))
+	}
+
+	old := strings.Join(data, "\n") + "\n"
+	tr.commit("old", map[string]string{"file.txt": old})
+
+	rnd := rand.New(rand.NewSource(23))
+	for i := 0; i < 40; i++ {
+		k := rnd.Intn(len(data))
+		switch rnd.Intn(3) {
+		case 0:
+			data = append(data[:k], data[k+1:]...)
+		case 1:
+			data[k] = "x"
+		default:
+			data = append(data[:k], append([]string{"y"}, data[k:]...)...)
+		}
+	}
+	tr.commit("new", map[string]string{"file.txt": strings.Join(data, "\n") + "\n"})
+
+	open := func(rev string) *Blob {
+		obj, err := tr.OpenObject(tr.revParse(rev))
+		if err != nil {
+			t.Fatalf("could not open %s: %v", rev, err)
+		}
+		return obj.(*Blob)
+	}
+
+	a, b := open("HEAD~:file.txt"), open("HEAD:file.txt")
+	defer a.Close()
+	defer b.Close()
+
+	d, err := DiffBlobs(a, b, BlobDiffOptions{Context: -1})
+	if err != nil {
+		t.Fatalf("DiffBlobs failed: %v", err)
+	}
+
+	//the exact hunks might differ from git's, but applying
+	//the patch must result in the new content
+	var patch bytes.Buffer
+	d.WriteUnified(&patch, "a/file.txt", "b/file.txt")
+
+	tr.git("checkout", "-q", "HEAD~")
+	path := filepath.Join(tr.work, "change.patch")
+	f, err := os.Create(path)
+	if err == nil {
+		_, err = patch.WriteTo(f)
+		f.Close()
+	}
+	if err != nil {
+		t.Fatalf("could not write patch: %v", err)
+	}
+
+	tr.git("apply", "change.patch")
+	if res := tr.git("diff", "--stat", "master", "--", "file.txt"); res != "" {
+		t.Fatalf("patched file differs from new content: %s", res)
+	}
+
+	d, err = DiffBlobs(open("HEAD:file.txt"), open("master:file.txt"), BlobDiffOptions{MaxSize: 100})
+	if err != nil || !d.TooLarge {
+		t.Fatalf("expected blob to be too large to diff: %v", err)
+	}
+}
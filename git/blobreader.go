@@ -0,0 +1,418 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+//StreamBlobSize is the size (in bytes) above which delta compressed
+//blobs are not resolved in memory by OpenObject, but read on demand
+//via a BlobReader. MaxDeltaSize does not apply to such blobs.
+var StreamBlobSize int64 = 16 << 20
+
+//BlobReader reads the content of a blob. Besides sequential reading,
+//it supports random access via ReadAt and Seek. Delta compressed blobs
+//are not resolved in memory, but the deltas are applied incrementally
+//for the requested ranges, so the memory needed does not depend on the
+//size of the blob. BlobReader is safe for concurrent use via ReadAt.
+type BlobReader struct {
+	*io.SectionReader
+	closers []io.Closer
+}
+
+//Close releases the resources of the reader.
+func (br *BlobReader) Close() error {
+	var err error
+	for _, c := range br.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+//OpenBlobReader returns a BlobReader for the blob id.
+func (repo *Repository) OpenBlobReader(id SHA1) (*BlobReader, error) {
+	obj, err := repo.openRawObject(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsStandardObject(obj.otype) {
+		if obj.otype != ObjBlob {
+			obj.Close()
+			return nil, fmt.Errorf("git: %s is a %s, not a blob", id, obj.otype)
+		}
+
+		stream := &objectStream{r: obj.source, open: func() (io.ReadCloser, error) {
+			obj, err := repo.openRawObject(id)
+			return obj.source, err
+		}}
+
+		return &BlobReader{io.NewSectionReader(stream, 0, obj.size), []io.Closer{stream}}, nil
+	} else if !IsDeltaObject(obj.otype) {
+		obj.Close()
+		return nil, fmt.Errorf("git: unsupported object")
+	}
+
+	delta, err := parseDelta(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := buildDeltaChain(delta, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.blobReader(repo)
+}
+
+//blobReader returns a BlobReader for the object the chain resolves to,
+//which must be a blob. Small blobs are resolved in memory, otherwise
+//every link of the chain is read via a deltaReader.
+func (c *deltaChain) blobReader(s objectSource) (*BlobReader, error) {
+	otype := c.objectType()
+	size := c.links[0].SizeTarget
+	if otype != ObjBlob {
+		c.baseObj.Close()
+		return nil, fmt.Errorf("git: object is a %s, not a blob", otype)
+	} else if size <= StreamBlobSize {
+		_, data, err := c.resolveData()
+		c.baseObj.Close()
+		if err != nil {
+			return nil, err
+		}
+		return &BlobReader{io.NewSectionReader(bytes.NewReader(data), 0, size), nil}, nil
+	}
+
+	br := &BlobReader{}
+
+	var base io.ReaderAt
+	if c.base != nil {
+		base = bytes.NewReader(c.base.data)
+	} else if c.baseObj.size <= StreamBlobSize {
+		_, data, err := c.readBase()
+		c.baseObj.Close()
+		if err != nil {
+			return nil, err
+		}
+		base = bytes.NewReader(data)
+	} else {
+		base = c.baseStream(s)
+	}
+
+	if bs, ok := base.(*objectStream); ok {
+		br.closers = append(br.closers, bs)
+	}
+
+	baseSize := c.baseObj.size
+	if c.base != nil {
+		baseSize = int64(len(c.base.data))
+	}
+
+	for i := len(c.links); i > 0; i-- {
+		lk := c.links[i-1]
+		if lk.SizeSource != baseSize {
+			br.Close()
+			return nil, fmt.Errorf("git: source size mismatch while patching delta object")
+		}
+		baseSize = lk.SizeTarget
+
+		stream := &objectStream{r: lk.source, open: lk.reopen}
+		br.closers = append(br.closers, stream)
+
+		dr, err := newDeltaReader(base, &lk, stream)
+		if err != nil {
+			br.Close()
+			return nil, err
+		}
+		base = dr
+	}
+
+	br.SectionReader = io.NewSectionReader(base, 0, size)
+	return br, nil
+}
+
+//baseStream returns an objectStream for the (not yet read) base
+//object of the chain. Bases of offset deltas are always packed,
+//bases of ref deltas are opened by their id.
+func (c *deltaChain) baseStream(s objectSource) *objectStream {
+	stream := &objectStream{r: c.baseObj.source}
+
+	last := c.links[len(c.links)-1]
+	if pf, off, packed := c.baseObj.packLocation(); packed {
+		stream.open = func() (io.ReadCloser, error) {
+			obj, err := pf.readRawObject(off)
+			return obj.source, err
+		}
+	} else {
+		stream.open = func() (io.ReadCloser, error) {
+			obj, err := s.openRawObject(last.BaseRef)
+			return obj.source, err
+		}
+	}
+
+	return stream
+}
+
+//reopen opens the delta again and returns its data,
+//positioned at the first operation.
+func (d *Delta) reopen() (io.ReadCloser, error) {
+	obj, err := d.pf.readRawObject(d.off)
+	if err != nil {
+		return nil, err
+	}
+
+	nd, err := parseDelta(obj)
+	if err != nil {
+		return nil, err
+	}
+	return nd.source, nil
+}
+
+//objectStream provides random access to the data of an object by
+//reading its (compressed) data sequentially. Reading backwards opens
+//the object again and starts over, so objectStream is meant for mostly
+//forward reading.
+type objectStream struct {
+	mu   sync.Mutex
+	open func() (io.ReadCloser, error)
+	r    io.ReadCloser
+	pos  int64
+}
+
+//seek positions the stream at off. Must be called with s.mu held.
+func (s *objectStream) seek(off int64) error {
+	if s.r == nil || off < s.pos {
+		if s.r != nil {
+			s.r.Close()
+			s.r = nil
+		}
+
+		r, err := s.open()
+		if err != nil {
+			return err
+		}
+		s.r, s.pos = r, 0
+	}
+
+	if skip := off - s.pos; skip > 0 {
+		n, err := io.CopyN(ioutil.Discard, s.r, skip)
+		s.pos += n
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Read reads from the current position. Must be called with s.mu held.
+func (s *objectStream) Read(p []byte) (int, error) {
+	if s.r == nil {
+		if err := s.seek(s.pos); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := s.r.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (s *objectStream) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.seek(off); err != nil {
+		return 0, err
+	}
+
+	return io.ReadFull(s, p)
+}
+
+func (s *objectStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.r == nil {
+		return nil
+	}
+
+	err := s.r.Close()
+	s.r = nil
+	return err
+}
+
+//deltaMarkInterval is the (minimal) distance in the target
+//between two marks of a deltaReader.
+const deltaMarkInterval = 64 << 10
+
+//deltaMark is the position of an operation of a delta: dst is the
+//offset in the target, src the one in the delta data.
+type deltaMark struct {
+	dst, src int64
+}
+
+//deltaReader applies a delta to its base on demand, for the requested
+//ranges of the target. The operations are not kept in memory, but
+//read from the delta data, starting at the closest mark; marks are
+//collected about every deltaMarkInterval bytes of the target.
+type deltaReader struct {
+	mu sync.Mutex
+
+	base  io.ReaderAt
+	delta *objectStream
+	size  int64
+
+	marks []deltaMark
+
+	//the current operation, i.e. the last one read
+	op      DeltaOp
+	opDst   int64
+	opData  int64 //offset of its data in the delta data
+	opValid bool
+}
+
+//newDeltaReader reads all operations of the delta d, whose data is
+//read via stream, to check them and to collect the marks.
+func newDeltaReader(base io.ReaderAt, d *Delta, stream *objectStream) (*deltaReader, error) {
+	dr := &deltaReader{base: base, delta: stream, size: d.SizeTarget}
+
+	var dst int64
+	for next := int64(0); ; {
+		src := stream.pos
+		op, err := readDeltaOp(stream)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if dst >= next {
+			dr.marks = append(dr.marks, deltaMark{dst, src})
+			next = dst + deltaMarkInterval
+		}
+
+		switch op.Op {
+		case DeltaOpCopy:
+			if op.Offset+op.Size > d.SizeSource {
+				return nil, fmt.Errorf("git: delta copies beyond the end of its source")
+			}
+		case DeltaOpInsert:
+			if _, err := io.CopyN(ioutil.Discard, stream, op.Size); err != nil {
+				return nil, err
+			}
+		}
+
+		dst += op.Size
+	}
+
+	if dst != d.SizeTarget {
+		return nil, fmt.Errorf("git: size mismatch while patching delta object")
+	}
+
+	return dr, nil
+}
+
+func (dr *deltaReader) ReadAt(p []byte, off int64) (int, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	if off >= dr.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && off < dr.size {
+		if !dr.opValid || off < dr.opDst || off >= dr.opDst+dr.op.Size {
+			if err := dr.findOp(off); err != nil {
+				return n, err
+			}
+		}
+
+		skip := off - dr.opDst
+		want := dr.op.Size - skip
+		if rest := int64(len(p) - n); want > rest {
+			want = rest
+		}
+
+		buf := p[n : n+int(want)]
+		var err error
+		if dr.op.Op == DeltaOpCopy {
+			_, err = dr.base.ReadAt(buf, dr.op.Offset+skip)
+		} else {
+			_, err = dr.delta.ReadAt(buf, dr.opData+skip)
+		}
+
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return n, err
+		}
+
+		n += len(buf)
+		off += want
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+//findOp reads the operation that produces the byte at off of the
+//target, either continuing after the current operation or starting
+//at the closest mark. Must be called with dr.mu held.
+func (dr *deltaReader) findOp(off int64) error {
+	//the first mark after off
+	i := sort.Search(len(dr.marks), func(i int) bool {
+		return dr.marks[i].dst > off
+	})
+	m := dr.marks[i-1]
+
+	if dr.opValid && dr.opDst >= m.dst && dr.opDst <= off {
+		//continue after the current operation
+		m.dst = dr.opDst + dr.op.Size
+		m.src = dr.opData
+		if dr.op.Op == DeltaOpInsert {
+			m.src += dr.op.Size
+		}
+	}
+
+	dr.delta.mu.Lock()
+	defer dr.delta.mu.Unlock()
+
+	dr.opValid = false
+	if err := dr.delta.seek(m.src); err != nil {
+		return err
+	}
+
+	for dst := m.dst; ; {
+		op, err := readDeltaOp(dr.delta)
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+
+		if dst+op.Size > off {
+			dr.op, dr.opDst, dr.opData, dr.opValid = op, dst, dr.delta.pos, true
+			return nil
+		}
+
+		if op.Op == DeltaOpInsert {
+			if err := dr.delta.seek(dr.delta.pos + op.Size); err != nil {
+				return err
+			}
+		}
+		dst += op.Size
+	}
+}
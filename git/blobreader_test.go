@@ -0,0 +1,125 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestBlobReader(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	rnd := rand.New(rand.NewSource(42))
+	lines := make([]string, 20000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d %x\n", i, rnd.Int63())
+	}
+
+	//the versions are stored as deltas, some of them with copies that
+	//go backwards (moved blocks), some with large inserts
+	var versions []string
+	edit := func(l []string) {
+		lines = l
+		content := strings.Join(lines, "")
+		versions = append(versions, content)
+		tr.commit(fmt.Sprintf("version %d", len(versions)), map[string]string{"data.txt": content})
+	}
+
+	edit(lines)
+	edit(append(append(append([]string{}, lines[:100]...), lines[200:5000]...), append([]string{"inserted\n"}, lines[5000:]...)...))
+	edit(append(append([]string{}, lines[15000:]...), lines[:15000]...))
+
+	var added []string
+	for i := 0; i < 5000; i++ {
+		added = append(added, fmt.Sprintf("added %x\n", rnd.Int63()))
+	}
+	edit(append(append([]string{}, lines...), added...))
+
+	tr.git("repack", "-a", "-d", "-f", "-q", "--window=10", "--depth=10")
+
+	var ids []SHA1
+	deltas := 0
+	for i := range versions {
+		id := tr.revParse(fmt.Sprintf("HEAD~%d:data.txt", len(versions)-1-i))
+		ids = append(ids, id)
+
+		obj, err := tr.openRawObject(id)
+		if err != nil {
+			t.Fatalf("could not open %s: %v", id, err)
+		}
+		obj.Close()
+		if IsDeltaObject(obj.otype) {
+			deltas++
+		}
+	}
+	if deltas < 2 {
+		t.Fatalf("expected delta compressed blobs, got %d", deltas)
+	}
+
+	defer func(size int64) { StreamBlobSize = size }(StreamBlobSize)
+
+	//streamed (with and without cached bases) and resolved in memory
+	for _, size := range []int64{1024, StreamBlobSize, 1024} {
+		StreamBlobSize = size
+
+		for i, id := range ids {
+			wanted := versions[i]
+
+			br, err := tr.OpenBlobReader(id)
+			if err != nil {
+				t.Fatalf("could not open blob reader for %s: %v", id, err)
+			} else if br.Size() != int64(len(wanted)) {
+				t.Fatalf("unexpected size %d of %s, expected %d", br.Size(), id, len(wanted))
+			}
+
+			data, err := ioutil.ReadAll(br)
+			if err != nil {
+				t.Fatalf("could not read %s: %v", id, err)
+			} else if string(data) != wanted {
+				t.Fatalf("unexpected content of version %d", i+1)
+			}
+
+			//random access, backwards as well
+			for _, off := range []int64{int64(len(wanted)) - 100, 70000, 5, 300000, 200000, 0} {
+				buf := make([]byte, 4000)
+				n, err := br.ReadAt(buf, off)
+				end := off + int64(n)
+				if (err != nil && err != io.EOF) || (n < len(buf) && end != int64(len(wanted))) {
+					t.Fatalf("could not read %s at %d: %d, %v", id, off, n, err)
+				} else if string(buf[:n]) != wanted[off:end] {
+					t.Fatalf("unexpected content of version %d at %d", i+1, off)
+				}
+			}
+
+			pos, err := br.Seek(-10, io.SeekEnd)
+			if err != nil || pos != int64(len(wanted)-10) {
+				t.Fatalf("could not seek %s: %d, %v", id, pos, err)
+			}
+			data, err = ioutil.ReadAll(br)
+			if err != nil || string(data) != wanted[len(wanted)-10:] {
+				t.Fatalf("unexpected content after seek: %q, %v", data, err)
+			}
+			br.Close()
+
+			obj, err := tr.OpenObject(id)
+			if err != nil {
+				t.Fatalf("could not open %s: %v", id, err)
+			}
+
+			data, err = ioutil.ReadAll(obj.(*Blob))
+			obj.Close()
+			if err != nil || string(data) != wanted {
+				t.Fatalf("unexpected content of blob object %s (%v)", id, err)
+			}
+		}
+	}
+
+	commit := tr.revParse("HEAD")
+	if _, err := tr.OpenBlobReader(commit); err == nil {
+		t.Fatalf("expected error for commit")
+	}
+}
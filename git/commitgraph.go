@@ -0,0 +1,445 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Resources:
+//  https://github.com/git/git/blob/master/Documentation/gitformat-commit-graph.txt
+
+//Chunk ids used in commit-graph files.
+const (
+	graphChunkOIDFanout  = 0x4f494446 // "OIDF"
+	graphChunkOIDLookup  = 0x4f49444c // "OIDL"
+	graphChunkCommitData = 0x43444154 // "CDAT"
+	graphChunkExtraEdges = 0x45444745 // "EDGE"
+	graphChunkBaseGraphs = 0x42415345 // "BASE"
+)
+
+//Special values in the parent fields of the commit data.
+const (
+	graphParentNone      = 0x70000000
+	graphParentExtraEdge = 0x80000000
+	graphLastEdge        = 0x80000000
+)
+
+//GenerationInfinity is the generation number of commits
+//that are not contained in the commit-graph.
+const GenerationInfinity = ^uint32(0)
+
+//CommitGraphFile represents a single commit-graph file, i.e. either
+//"objects/info/commit-graph" or one layer of a split commit-graph.
+type CommitGraphFile struct {
+	*os.File
+
+	Version byte
+	FO      FanOut
+	Bases   []SHA1 //ids of the base layers, for split commit-graphs
+
+	oidLookup  int64
+	commitData int64
+	extraEdges int64
+}
+
+//CommitGraphEntry holds the information about a single
+//commit stored in the commit-graph.
+type CommitGraphEntry struct {
+	Tree       SHA1
+	Parents    []SHA1
+	Generation uint32 //topological level, starting at 1 for root commits
+	Time       int64  //committer time, seconds since epoch
+}
+
+//CommitGraphFileOpen opens the commit-graph file at path and reads
+//its header, the chunk table and the fan-out table.
+func CommitGraphFileOpen(path string) (*CommitGraphFile, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read commit-graph: %v", err)
+	}
+
+	g, err := readCommitGraphFile(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func readCommitGraphFile(fd *os.File) (*CommitGraphFile, error) {
+	var header struct {
+		Sig        [4]byte
+		Version    byte
+		OIDVersion byte
+		Chunks     byte
+		Bases      byte
+	}
+
+	err := binary.Read(fd, binary.BigEndian, &header)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read commit-graph header: %v", err)
+	}
+
+	if string(header.Sig[:]) != "CGPH" {
+		return nil, fmt.Errorf("git: commit-graph signature error")
+	} else if header.Version != 1 {
+		return nil, fmt.Errorf("git: unsupported commit-graph version: %d", header.Version)
+	} else if header.OIDVersion != 1 {
+		return nil, fmt.Errorf("git: unsupported commit-graph object id version: %d", header.OIDVersion)
+	}
+
+	table := make([]struct {
+		ID     uint32
+		Offset uint64
+	}, int(header.Chunks)+1)
+
+	err = binary.Read(fd, binary.BigEndian, table)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read commit-graph chunks: %v", err)
+	}
+
+	g := &CommitGraphFile{File: fd, Version: header.Version}
+
+	var fanout, bases int64
+	for _, chunk := range table[:len(table)-1] {
+		off := int64(chunk.Offset)
+
+		switch chunk.ID {
+		case graphChunkOIDFanout:
+			fanout = off
+		case graphChunkOIDLookup:
+			g.oidLookup = off
+		case graphChunkCommitData:
+			g.commitData = off
+		case graphChunkExtraEdges:
+			g.extraEdges = off
+		case graphChunkBaseGraphs:
+			bases = off
+		}
+	}
+
+	if fanout == 0 || g.oidLookup == 0 || g.commitData == 0 {
+		return nil, fmt.Errorf("git: commit-graph is missing required chunks")
+	}
+
+	_, err = fd.Seek(fanout, 0)
+	if err != nil {
+		return nil, fmt.Errorf("git: io error: %v", err)
+	}
+
+	err = binary.Read(fd, binary.BigEndian, &g.FO)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read commit-graph fanout: %v", err)
+	}
+
+	if header.Bases > 0 {
+		if bases == 0 {
+			return nil, fmt.Errorf("git: commit-graph is missing base graph chunk")
+		}
+
+		g.Bases = make([]SHA1, header.Bases)
+		for i := range g.Bases {
+			_, err = fd.ReadAt(g.Bases[i][:], bases+int64(i)*20)
+			if err != nil {
+				return nil, fmt.Errorf("git: could not read commit-graph bases: %v", err)
+			}
+		}
+	}
+
+	return g, nil
+}
+
+//Count returns the number of commits in the file.
+func (g *CommitGraphFile) Count() int {
+	return int(g.FO[255])
+}
+
+//ReadSHA1 reads the SHA1 stored at position pos.
+func (g *CommitGraphFile) ReadSHA1(chksum *SHA1, pos int) error {
+	_, err := g.ReadAt(chksum[0:20], g.oidLookup+int64(pos)*20)
+	return err
+}
+
+func (g *CommitGraphFile) findSHA1(target SHA1) (int, bool) {
+
+	//see PackIndex.findSHA1 for an explanation
+	s, e := g.FO.Bounds(target[0])
+
+	for s < e {
+		midpoint := s + (e-s+1)/2
+
+		var sha SHA1
+		err := g.ReadSHA1(&sha, midpoint-1)
+		if err != nil {
+			return 0, false
+		}
+
+		switch bytes.Compare(target[:], sha[:]) {
+		case -1:
+			e = midpoint - 1
+		case +1:
+			s = midpoint
+		default:
+			return midpoint - 1, true
+		}
+	}
+
+	return 0, false
+}
+
+//commitGraphChain is a stack of commit-graph files, the base
+//first. Positions of commits are counted over all layers.
+type commitGraphChain struct {
+	layers []*CommitGraphFile
+	starts []int //position of the first commit of each layer
+}
+
+func newCommitGraphChain(layers []*CommitGraphFile) *commitGraphChain {
+	c := &commitGraphChain{layers: layers, starts: make([]int, len(layers))}
+
+	n := 0
+	for i, g := range layers {
+		c.starts[i] = n
+		n += g.Count()
+	}
+
+	return c
+}
+
+func (c *commitGraphChain) close() {
+	for _, g := range c.layers {
+		g.Close()
+	}
+}
+
+func (c *commitGraphChain) layer(pos int) (*CommitGraphFile, int, error) {
+	for i := len(c.layers) - 1; i >= 0; i-- {
+		if pos >= c.starts[i] {
+			local := pos - c.starts[i]
+			if local >= c.layers[i].Count() {
+				break
+			}
+			return c.layers[i], local, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("git: commit-graph position %d out of range", pos)
+}
+
+//find returns the position of the commit with the given id.
+func (c *commitGraphChain) find(id SHA1) (int, bool) {
+	for i := len(c.layers) - 1; i >= 0; i-- {
+		if pos, ok := c.layers[i].findSHA1(id); ok {
+			return c.starts[i] + pos, true
+		}
+	}
+
+	return 0, false
+}
+
+func (c *commitGraphChain) readSHA1(id *SHA1, pos int) error {
+	g, local, err := c.layer(pos)
+	if err != nil {
+		return err
+	}
+
+	return g.ReadSHA1(id, local)
+}
+
+//entry reads the commit data for the commit at position pos.
+func (c *commitGraphChain) entry(pos int) (*CommitGraphEntry, error) {
+	g, local, err := c.layer(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	//tree[20] + parent1[4] + parent2[4] + generation & time[8]
+	var buf [36]byte
+	_, err = g.ReadAt(buf[:], g.commitData+int64(local)*36)
+	if err != nil {
+		return nil, fmt.Errorf("git: io error: %v", err)
+	}
+
+	entry := &CommitGraphEntry{}
+	copy(entry.Tree[:], buf[:20])
+
+	p1 := binary.BigEndian.Uint32(buf[20:24])
+	p2 := binary.BigEndian.Uint32(buf[24:28])
+
+	//the top 30 bits are the generation, the lower 2 bits
+	//together with the next 32 bits the commit time
+	w := binary.BigEndian.Uint32(buf[28:32])
+	entry.Generation = w >> 2
+	entry.Time = int64(w&0x3)<<32 | int64(binary.BigEndian.Uint32(buf[32:36]))
+
+	var parents []uint32
+	if p1 != graphParentNone {
+		parents = append(parents, p1)
+	}
+
+	if p2 == graphParentNone {
+		//nothing to do
+	} else if p2&graphParentExtraEdge == 0 {
+		parents = append(parents, p2)
+	} else {
+		edges, err := g.readExtraEdges(int64(p2 &^ graphParentExtraEdge))
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, edges...)
+	}
+
+	entry.Parents = make([]SHA1, len(parents))
+	for i, p := range parents {
+		err = c.readSHA1(&entry.Parents[i], int(p))
+		if err != nil {
+			return nil, fmt.Errorf("git: could not read commit-graph parent: %v", err)
+		}
+	}
+
+	return entry, nil
+}
+
+//readExtraEdges reads the list of parents (2nd and following)
+//of octopus merges starting at position start.
+func (g *CommitGraphFile) readExtraEdges(start int64) ([]uint32, error) {
+	if g.extraEdges == 0 {
+		return nil, fmt.Errorf("git: commit-graph extra edge without chunk")
+	}
+
+	var edges []uint32
+	for i := start; ; i++ {
+		var buf [4]byte
+		_, err := g.ReadAt(buf[:], g.extraEdges+i*4)
+		if err != nil {
+			return nil, fmt.Errorf("git: io error: %v", err)
+		}
+
+		e := binary.BigEndian.Uint32(buf[:])
+		edges = append(edges, e&^graphLastEdge)
+
+		if e&graphLastEdge != 0 {
+			break
+		}
+	}
+
+	return edges, nil
+}
+
+//commitGraphs caches the commit-graph (chain) per object
+//directory, together with the state of the files it was
+//loaded from, so it can be reloaded when they change.
+var commitGraphs = struct {
+	sync.Mutex
+	m map[string]*cachedCommitGraph
+}{m: make(map[string]*cachedCommitGraph)}
+
+type cachedCommitGraph struct {
+	chain *commitGraphChain
+	stamp string
+}
+
+//commitGraphStamp returns a string that changes whenever
+//one of the commit-graph files is (re-)written.
+func commitGraphStamp(info string) string {
+	var stamp []string
+	for _, name := range []string{"commit-graph", filepath.Join("commit-graphs", "commit-graph-chain")} {
+		fi, err := os.Stat(filepath.Join(info, name))
+		if err != nil {
+			stamp = append(stamp, "-")
+			continue
+		}
+		stamp = append(stamp, fmt.Sprintf("%d:%d", fi.ModTime().UnixNano(), fi.Size()))
+	}
+	return strings.Join(stamp, ",")
+}
+
+//commitGraph returns the commit-graph of the repository
+//or nil if there is none (or it could not be read).
+func (repo *Repository) commitGraph() *commitGraphChain {
+	info := filepath.Join(repo.Path, "objects", "info")
+	if abs, err := filepath.Abs(info); err == nil {
+		info = abs
+	}
+
+	stamp := commitGraphStamp(info)
+
+	commitGraphs.Lock()
+	defer commitGraphs.Unlock()
+
+	cached, ok := commitGraphs.m[info]
+	if ok && cached.stamp == stamp {
+		return cached.chain
+	}
+
+	//NB: the old files are not closed since they might still be
+	// in use, they will be closed when they are garbage collected
+	chain, err := loadCommitGraph(info)
+	if err != nil {
+		chain = nil
+	}
+
+	commitGraphs.m[info] = &cachedCommitGraph{chain: chain, stamp: stamp}
+	return chain
+}
+
+//loadCommitGraph loads either "info/commit-graph" or, if that does
+//not exist, the split commit-graph listed in the chain file.
+func loadCommitGraph(info string) (*commitGraphChain, error) {
+	g, err := CommitGraphFileOpen(filepath.Join(info, "commit-graph"))
+	if err == nil {
+		return newCommitGraphChain([]*CommitGraphFile{g}), nil
+	}
+
+	dir := filepath.Join(info, "commit-graphs")
+	fd, err := os.Open(filepath.Join(dir, "commit-graph-chain"))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var layers []*CommitGraphFile
+	chain := newCommitGraphChain(nil)
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		g, err := CommitGraphFileOpen(filepath.Join(dir, fmt.Sprintf("graph-%s.graph", line)))
+		if err != nil {
+			chain.layers = layers
+			chain.close()
+			return nil, err
+		}
+
+		layers = append(layers, g)
+
+		//every layer must list all the layers below as its bases
+		if len(g.Bases) != len(layers)-1 {
+			chain.layers = layers
+			chain.close()
+			return nil, fmt.Errorf("git: commit-graph chain is inconsistent")
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		chain.layers = layers
+		chain.close()
+		return nil, err
+	} else if len(layers) == 0 {
+		return nil, fmt.Errorf("git: empty commit-graph chain")
+	}
+
+	return newCommitGraphChain(layers), nil
+}
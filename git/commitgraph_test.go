@@ -0,0 +1,187 @@
+package git
+
+import (
+	"fmt"
+	"testing"
+)
+
+//makeMergeRepo creates a history with a merge and an
+//octopus merge and returns the ids of all commits.
+func makeMergeRepo(tr *testRepo) map[string]SHA1 {
+	ids := make(map[string]SHA1)
+
+	ids["root"] = tr.commit("root", map[string]string{"root.txt": "root\n"})
+	for _, b := range []string{"a", "b", "c"} {
+		tr.git("checkout", "-q", "-b", b, ids["root"].String())
+		ids[b] = tr.commit(b, map[string]string{b + ".txt": b + "\n"})
+	}
+
+	tr.git("checkout", "-q", "a")
+	tr.git("merge", "-q", "--no-ff", "-m", "merge b", "b")
+	ids["merge"] = tr.revParse("HEAD")
+
+	tr.git("checkout", "-q", "-b", "d", ids["root"].String())
+	ids["d"] = tr.commit("d", map[string]string{"d.txt": "d\n"})
+	tr.git("checkout", "-q", "-b", "e", ids["root"].String())
+	ids["e"] = tr.commit("e", map[string]string{"e.txt": "e\n"})
+
+	tr.git("checkout", "-q", "a")
+	tr.git("merge", "-q", "--no-ff", "-m", "octopus", "c", "d", "e")
+	ids["octopus"] = tr.revParse("HEAD")
+	ids["tip"] = tr.commit("tip", map[string]string{"tip.txt": "tip\n"})
+
+	return ids
+}
+
+func checkCommitGraph(t *testing.T, tr *testRepo, ids map[string]SHA1) {
+	graph := tr.commitGraph()
+	if graph == nil {
+		t.Fatalf("expected commit-graph to be loaded")
+	}
+
+	for name, id := range ids {
+		pos, ok := graph.find(id)
+		if !ok {
+			t.Fatalf("commit %s (%s) not in commit-graph", name, id)
+		}
+
+		entry, err := graph.entry(pos)
+		if err != nil {
+			t.Fatalf("could not read commit-graph entry for %s: %v", name, err)
+		}
+
+		obj, err := tr.OpenObject(id)
+		if err != nil {
+			t.Fatalf("could not open commit %s: %v", name, err)
+		}
+		commit := obj.(*Commit)
+
+		if entry.Tree != commit.Tree {
+			t.Fatalf("%s: tree mismatch: %s vs %s", name, entry.Tree, commit.Tree)
+		} else if fmt.Sprint(entry.Parents) != fmt.Sprint(commit.Parent) {
+			t.Fatalf("%s: parent mismatch: %v vs %v", name, entry.Parents, commit.Parent)
+		} else if entry.Time != commit.Committer.Date.Unix() {
+			t.Fatalf("%s: time mismatch: %d vs %d", name, entry.Time, commit.Committer.Date.Unix())
+		}
+	}
+
+	if pos, ok := graph.find(SHA1{}); ok {
+		t.Fatalf("found all-zero sha1 @ %d", pos)
+	}
+}
+
+func TestCommitGraphFile(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	ids := makeMergeRepo(tr)
+	tr.git("commit-graph", "write", "--reachable")
+	checkCommitGraph(t, tr, ids)
+
+	graph := tr.commitGraph()
+	root, _ := graph.find(ids["root"])
+	tip, _ := graph.find(ids["tip"])
+
+	re, _ := graph.entry(root)
+	te, _ := graph.entry(tip)
+	if re.Generation != 1 || te.Generation != 5 {
+		t.Fatalf("unexpected generations: root: %d, tip: %d", re.Generation, te.Generation)
+	}
+}
+
+func TestCommitGraphSplit(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	ids := makeMergeRepo(tr)
+	tr.git("commit-graph", "write", "--reachable", "--split")
+
+	for i := 0; i < 3; i++ {
+		ids[fmt.Sprintf("new%d", i)] = tr.commit("new", nil)
+	}
+	tr.git("commit-graph", "write", "--reachable", "--split=no-merge")
+
+	graph := tr.commitGraph()
+	if graph == nil || len(graph.layers) != 2 {
+		t.Fatalf("expected a split commit-graph with two layers")
+	}
+
+	checkCommitGraph(t, tr, ids)
+}
+
+func TestCommitGraphWalk(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	ids := makeMergeRepo(tr)
+
+	//the results must be the same with and without commit-graph
+	for _, withGraph := range []bool{false, true} {
+		if withGraph {
+			tr.git("commit-graph", "write", "--reachable")
+			//a commit that is not in the commit-graph
+			ids["after"] = tr.commit("after", nil)
+		}
+
+		cg := NewCommitGraph(tr.Repository)
+		if withGraph != (cg.graph != nil) {
+			t.Fatalf("commit-graph presence mismatch (expected: %v)", withGraph)
+		}
+
+		ancestry := []struct {
+			a, b string
+			res  bool
+		}{
+			{"root", "tip", true},
+			{"e", "tip", true},
+			{"b", "merge", true},
+			{"c", "merge", false},
+			{"tip", "root", false},
+			{"a", "b", false},
+			{"merge", "merge", true},
+		}
+
+		if withGraph {
+			ancestry = append(ancestry, struct {
+				a, b string
+				res  bool
+			}{"root", "after", true})
+		}
+
+		for _, tt := range ancestry {
+			res, err := cg.IsAncestor(ids[tt.a], ids[tt.b])
+			if err != nil {
+				t.Fatalf("IsAncestor(%s, %s) failed: %v", tt.a, tt.b, err)
+			} else if res != tt.res {
+				t.Fatalf("IsAncestor(%s, %s) => %v, expected %v [graph: %v]", tt.a, tt.b, res, tt.res, withGraph)
+			}
+		}
+
+		base, err := cg.AddTip(ids["c"])
+		if err != nil {
+			t.Fatalf("could not add tip: %v", err)
+		}
+		base.Flags = NodeColorRed
+
+		ref, err := cg.AddTip(ids["merge"])
+		if err != nil {
+			t.Fatalf("could not add tip: %v", err)
+		}
+		ref.Flags = NodeColorGreen
+
+		err = cg.PaintDownToCommon()
+		if err != nil {
+			t.Fatalf("PaintDownToCommon failed: %v", err)
+		}
+
+		root := cg.commits[ids["root"]]
+		if root == nil || root.Flags&NodeColorYellow != NodeColorYellow {
+			t.Fatalf("expected root commit to be painted yellow [graph: %v]", withGraph)
+		}
+
+		commit, err := cg.Commit(root)
+		if err != nil || commit.Message != "root\n" {
+			t.Fatalf("could not load root commit object: %v", err)
+		}
+	}
+}
@@ -19,6 +19,7 @@ type Delta struct {
 	SizeTarget int64
 
 	pf  *PackFile
+	off int64 //offset of the delta object itself
 	op  DeltaOp
 	err error
 }
@@ -46,6 +47,7 @@ func parseDelta(obj gitObject) (*Delta, error) {
 	//therefore git.Source is must be a *packReader
 	source := delta.source.(*packReader)
 	delta.pf = source.fd
+	delta.off = source.start
 
 	var err error
 	if obj.otype == ObjRefDelta {
@@ -195,36 +197,42 @@ func (d *Delta) NextOp() bool {
 		return false
 	}
 
-	b, err := readByte(d.source)
+	d.op, d.err = readDeltaOp(d.source)
+	return d.err == nil
+}
+
+//readDeltaOp reads a single operation from the delta data r.
+//At the end of the data io.EOF is returned.
+func readDeltaOp(r io.Reader) (op DeltaOp, err error) {
+	b, err := readByte(r)
 	if err != nil {
-		return false
+		return op, err
 	}
 
 	if b&0x80 != 0 {
-		d.op.Op = DeltaOpCopy
-		op := b & 0x7F
-		d.op.Offset, d.err = decodeInt(d.source, op, 4)
-		if d.err != nil {
-			return false
+		op.Op = DeltaOpCopy
+		code := b & 0x7F
+		op.Offset, err = decodeInt(r, code, 4)
+		if err != nil {
+			return op, err
 		}
 
-		d.op.Size, d.err = decodeInt(d.source, op>>4, 3)
-		if d.err != nil {
-			return false
+		op.Size, err = decodeInt(r, code>>4, 3)
+		if err != nil {
+			return op, err
 		}
 
-		if d.op.Size == 0 {
-			d.op.Size = 0x10000
+		if op.Size == 0 {
+			op.Size = 0x10000
 		}
 	} else if n := b; n > 0 {
-		d.op.Op = DeltaOpInsert
-		d.op.Size = int64(n)
+		op.Op = DeltaOpInsert
+		op.Size = int64(n)
 	} else {
-		d.err = fmt.Errorf("git: unknown delta op code")
-		return false
+		err = fmt.Errorf("git: unknown delta op code")
 	}
 
-	return true
+	return op, err
 }
 
 //Patch applies the delta data onto r and writes the result to w.
@@ -275,6 +283,10 @@ type deltaChain struct {
 	baseObj gitObject
 	baseOff int64
 
+	//base is set instead of baseObj if the
+	//base object was found in the deltaBaseCache
+	base *deltaEntry
+
 	links []Delta
 }
 
@@ -282,6 +294,14 @@ func (c *deltaChain) Len() int {
 	return len(c.links)
 }
 
+//objectType returns the type of the object the chain resolves to.
+func (c *deltaChain) objectType() ObjectType {
+	if c.base != nil {
+		return c.base.otype
+	}
+	return c.baseObj.otype
+}
+
 type objectSource interface {
 	openRawObject(id SHA1) (gitObject, error)
 }
@@ -292,11 +312,19 @@ func buildDeltaChain(d *Delta, s objectSource) (*deltaChain, error) {
 
 	for err == nil {
 
+		if len(chain.links) >= MaxDeltaDepth {
+			err = ErrDeltaTooDeep
+			break
+		}
+
 		chain.links = append(chain.links, *d)
 
 		var obj gitObject
 		if d.otype == ObjRefDelta {
 			obj, err = s.openRawObject(d.BaseRef)
+		} else if entry, ok := deltaBaseCache.get(d.pf.Name(), d.BaseOff); ok {
+			chain.base = entry
+			break
 		} else {
 			obj, err = d.pf.readRawObject(d.BaseOff)
 		}
@@ -305,9 +333,22 @@ func buildDeltaChain(d *Delta, s objectSource) (*deltaChain, error) {
 			break
 		}
 
+		pf, off, packed := obj.packLocation()
+
+		if packed && d.otype == ObjRefDelta {
+			if entry, ok := deltaBaseCache.get(pf.Name(), off); ok {
+				obj.Close()
+				chain.base = entry
+				break
+			}
+		}
+
 		if IsStandardObject(obj.otype) {
 			chain.baseObj = obj
-			chain.baseOff = d.BaseOff
+			chain.baseOff = -1
+			if packed {
+				chain.baseOff = off
+			}
 			break
 		} else if !IsDeltaObject(obj.otype) {
 			err = fmt.Errorf("git: unexpected object type in delta chain")
@@ -325,44 +366,97 @@ func buildDeltaChain(d *Delta, s objectSource) (*deltaChain, error) {
 	return &chain, nil
 }
 
-func (c *deltaChain) resolve() (Object, error) {
+//readBase returns the type and the data of the base object of
+//the chain, either from the cache or by reading the object.
+func (c *deltaChain) readBase() (ObjectType, []byte, error) {
+	if c.base != nil {
+		return c.base.otype, c.base.data, nil
+	}
 
-	ibuf := bytes.NewBuffer(make([]byte, 0, c.baseObj.Size()))
-	n, err := io.Copy(ibuf, c.baseObj.source)
+	size := c.baseObj.Size()
+	if size > MaxDeltaSize {
+		return 0, nil, ErrDeltaTooLarge
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	n, err := io.Copy(buf, c.baseObj.source)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 
-	if n != c.baseObj.Size() {
-		return nil, io.ErrUnexpectedEOF
+	if n != size {
+		return 0, nil, io.ErrUnexpectedEOF
 	}
 
-	obuf := bytes.NewBuffer(make([]byte, 0, c.baseObj.Size()))
+	data := buf.Bytes()
+
+	if pf, _, ok := c.baseObj.packLocation(); ok {
+		deltaBaseCache.add(pf.Name(), c.baseOff, c.baseObj.otype, data)
+	}
+
+	return c.baseObj.otype, data, nil
+}
+
+func (c *deltaChain) resolve() (Object, error) {
+	otype, data, err := c.resolveData()
+	if err != nil {
+		return nil, err
+	}
+
+	obj := gitObject{otype, int64(len(data)), ioutil.NopCloser(bytes.NewReader(data))}
+	return parseObject(obj)
+}
+
+//resolveData applies all the deltas of the chain to the base
+//object and returns the type and the data of the result.
+func (c *deltaChain) resolveData() (ObjectType, []byte, error) {
+
+	otype, data, err := c.readBase()
+	if err != nil {
+		return 0, nil, err
+	}
 
 	for i := len(c.links); i > 0; i-- {
 		lk := c.links[i-1]
 
-		if lk.SizeTarget > int64(^uint(0)>>1) {
-			return nil, fmt.Errorf("git: target to large for delta unpatching")
+		if lk.SizeTarget > MaxDeltaSize {
+			return 0, nil, ErrDeltaTooLarge
+		} else if lk.SizeSource != int64(len(data)) {
+			return 0, nil, fmt.Errorf("git: source size mismatch while patching delta object")
 		}
 
-		obuf.Grow(int(lk.SizeTarget))
-		obuf.Truncate(0)
+		//NB: a new buffer for every link, since the data of
+		// the previous one might now be in the cache
+		obuf := bytes.NewBuffer(make([]byte, 0, lk.SizeTarget))
 
-		err = lk.Patch(bytes.NewReader(ibuf.Bytes()), obuf)
+		err = lk.Patch(bytes.NewReader(data), obuf)
 
 		if err != nil {
-			return nil, err
+			return 0, nil, err
 		}
 
 		if lk.SizeTarget != int64(obuf.Len()) {
-			return nil, fmt.Errorf("git: size mismatch while patching delta object")
+			return 0, nil, fmt.Errorf("git: size mismatch while patching delta object")
 		}
 
-		obuf, ibuf = ibuf, obuf
+		data = obuf.Bytes()
+		deltaBaseCache.add(lk.pf.Name(), lk.off, otype, data)
 	}
 
-	//ibuf is holding the data
-	obj := gitObject{c.baseObj.otype, int64(ibuf.Len()), ioutil.NopCloser(ibuf)}
-	return parseObject(obj)
+	return otype, data, nil
+}
+
+//packLocation returns the pack file and the offset therein
+//for objects that have been read from a pack file.
+func (o *gitObject) packLocation() (*PackFile, int64, bool) {
+	src := o.source
+	if z, ok := src.(*zlibReadCloser); ok {
+		src = z.source
+	}
+
+	if r, ok := src.(*packReader); ok {
+		return r.fd, r.start, true
+	}
+
+	return nil, 0, false
 }
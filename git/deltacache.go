@@ -0,0 +1,130 @@
+package git
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+//Limits for the resolution of delta chains. They should be
+//set, if at all, before any objects are opened.
+var (
+	//MaxDeltaDepth is the maximum number of deltas in a chain
+	//that will be followed when resolving a delta object.
+	MaxDeltaDepth = 1000
+
+	//MaxDeltaSize is the maximum size (in bytes) of any
+	//object that will be produced while resolving a chain.
+	MaxDeltaSize int64 = 512 << 20
+)
+
+//Errors returned when resolving a delta chain would
+//exceed MaxDeltaDepth or MaxDeltaSize.
+var (
+	ErrDeltaTooDeep  = errors.New("git: delta chain exceeds maximum depth")
+	ErrDeltaTooLarge = errors.New("git: delta object exceeds maximum size")
+)
+
+//DefaultDeltaCacheSize is the initial capacity (in bytes)
+//of the cache of resolved delta base objects.
+const DefaultDeltaCacheSize = 64 << 20
+
+//deltaKey identifies an object by its position in a pack file.
+type deltaKey struct {
+	pack string
+	off  int64
+}
+
+type deltaEntry struct {
+	key   deltaKey
+	otype ObjectType
+	data  []byte
+}
+
+//deltaCache is a size bounded LRU cache for objects that
+//have been read or resolved while resolving delta chains.
+//The cached data must be treated as read-only.
+type deltaCache struct {
+	mu sync.Mutex
+
+	limit int64
+	size  int64
+
+	lru     *list.List //front is the most recently used
+	entries map[deltaKey]*list.Element
+}
+
+func newDeltaCache(limit int64) *deltaCache {
+	return &deltaCache{
+		limit:   limit,
+		lru:     list.New(),
+		entries: make(map[deltaKey]*list.Element),
+	}
+}
+
+//deltaBaseCache is shared by all repositories.
+var deltaBaseCache = newDeltaCache(DefaultDeltaCacheSize)
+
+//SetDeltaCacheSize sets the capacity (in bytes) of the cache
+//for resolved delta base objects. A size of 0 disables it.
+func SetDeltaCacheSize(size int64) {
+	c := deltaBaseCache
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = size
+	c.evict()
+}
+
+func (c *deltaCache) get(pack string, off int64) (*deltaEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[deltaKey{pack, off}]
+	if !ok {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return el.Value.(*deltaEntry), true
+}
+
+func (c *deltaCache) add(pack string, off int64, otype ObjectType, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := deltaKey{pack, off}
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+
+	//a single object should not be able to
+	//flush the whole cache
+	size := int64(len(data))
+	if size > c.limit/4 {
+		return
+	}
+
+	entry := &deltaEntry{key: key, otype: otype, data: data}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += size
+
+	c.evict()
+}
+
+//evict removes the least recently used entries until the
+//size of the cache is within its limit. Must be called with
+//c.mu held.
+func (c *deltaCache) evict() {
+	for c.size > c.limit {
+		el := c.lru.Back()
+		if el == nil {
+			break
+		}
+
+		entry := c.lru.Remove(el).(*deltaEntry)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.data))
+	}
+}
@@ -0,0 +1,147 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDeltaCacheLRU(t *testing.T) {
+	c := newDeltaCache(100)
+
+	c.add("a", 1, ObjBlob, make([]byte, 20))
+	c.add("a", 2, ObjBlob, make([]byte, 20))
+	c.add("b", 1, ObjBlob, make([]byte, 20))
+
+	if _, ok := c.get("a", 1); !ok {
+		t.Fatalf("expected (a, 1) to be cached")
+	}
+
+	// too big for the cache, must be ignored
+	c.add("c", 1, ObjBlob, make([]byte, 30))
+	if _, ok := c.get("c", 1); ok {
+		t.Fatalf("expected (c, 1) to be rejected")
+	}
+
+	// (a, 2) is now the least recently used entry
+	c.add("d", 1, ObjBlob, make([]byte, 25))
+	c.add("d", 2, ObjBlob, make([]byte, 25))
+
+	if _, ok := c.get("a", 2); ok {
+		t.Fatalf("expected (a, 2) to be evicted")
+	}
+
+	for _, key := range []deltaKey{{"a", 1}, {"b", 1}, {"d", 1}, {"d", 2}} {
+		if _, ok := c.get(key.pack, key.off); !ok {
+			t.Fatalf("expected %v to be cached", key)
+		}
+	}
+
+	if c.size > c.limit {
+		t.Fatalf("cache size %d exceeds limit %d", c.size, c.limit)
+	}
+}
+
+func makeDeltaRepo(t *testing.T) (*testRepo, SHA1) {
+	tr := newTestRepo(t)
+
+	var base bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&base, "line %d of a rather boring data file\n", i)
+	}
+
+	data := base.Bytes()
+	for i := 0; i < 10; i++ {
+		data = append(data, []byte(fmt.Sprintf("appended line %d\n", i))...)
+		tr.commit(fmt.Sprintf("commit %d", i), map[string]string{"data.txt": string(data)})
+	}
+
+	tr.git("repack", "-a", "-d", "-f", "-q", "--depth=50", "--window=50")
+	return tr, tr.revParse("HEAD~9:data.txt")
+}
+
+func TestDeltaChainLimits(t *testing.T) {
+	tr, oid := makeDeltaRepo(t)
+	defer tr.cleanup()
+
+	pf, off, err := tr.packs().findObject(oid)
+	if err != nil {
+		t.Fatalf("could not find object: %v", err)
+	}
+
+	obj, err := pf.OpenObject(off)
+	if err != nil {
+		t.Fatalf("could not open object: %v", err)
+	}
+
+	delta, ok := obj.(*Delta)
+	if !ok {
+		t.Skip("[W] Object not stored as delta. Skipping test")
+	}
+
+	chain, err := buildDeltaChain(delta, tr)
+	if err != nil {
+		t.Fatalf("could not build delta chain: %v", err)
+	}
+	depth := chain.Len()
+
+	t.Logf("delta chain depth: %d", depth)
+
+	defer func(d int, s int64) {
+		MaxDeltaDepth, MaxDeltaSize = d, s
+	}(MaxDeltaDepth, MaxDeltaSize)
+
+	SetDeltaCacheSize(0)
+	defer SetDeltaCacheSize(DefaultDeltaCacheSize)
+
+	MaxDeltaDepth = depth - 1
+	_, err = tr.OpenObject(oid)
+	if err != ErrDeltaTooDeep {
+		t.Fatalf("expected ErrDeltaTooDeep, got: %v", err)
+	}
+
+	MaxDeltaDepth = depth
+	MaxDeltaSize = 1024
+	_, err = tr.OpenObject(oid)
+	if err != ErrDeltaTooLarge {
+		t.Fatalf("expected ErrDeltaTooLarge, got: %v", err)
+	}
+}
+
+func TestDeltaChainCache(t *testing.T) {
+	tr, oid := makeDeltaRepo(t)
+	defer tr.cleanup()
+
+	SetDeltaCacheSize(DefaultDeltaCacheSize)
+
+	//every blob must resolve to the same data with
+	//and without the cache being populated
+	for k := 0; k < 2; k++ {
+		for i := 0; i < 10; i++ {
+			id := tr.revParse(fmt.Sprintf("HEAD~%d:data.txt", i))
+			obj, err := tr.OpenObject(id)
+			if err != nil {
+				t.Fatalf("could not open blob %s: %v", id, err)
+			}
+
+			data, err := ioutil.ReadAll(obj.(*Blob))
+			if err != nil {
+				t.Fatalf("could not read blob %s: %v", id, err)
+			}
+
+			wanted := tr.git("cat-file", "blob", id.String())
+			if string(bytes.TrimSpace(data)) != wanted {
+				t.Fatalf("blob %s: content mismatch", id)
+			}
+		}
+	}
+
+	if _, err := tr.OpenObject(oid); err != nil {
+		t.Fatalf("could not open object: %v", err)
+	}
+
+	if len(deltaBaseCache.entries) == 0 {
+		t.Fatalf("expected cache to be populated")
+	}
+}
@@ -0,0 +1,492 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//FsckKind is the kind of a problem found by Fsck.
+type FsckKind string
+
+//The kinds of problems reported by Fsck. All but
+//FsckDangling are errors.
+const (
+	FsckCorrupt      FsckKind = "corrupt"       //object or file cannot be read or parsed
+	FsckHashMismatch FsckKind = "hash-mismatch" //object data does not match its id
+	FsckBadChecksum  FsckKind = "bad-checksum"  //pack or index checksum is wrong
+	FsckTreeOrder    FsckKind = "tree-order"    //tree entries not sorted or duplicated
+	FsckTreeMode     FsckKind = "tree-mode"     //invalid mode of a tree entry
+	FsckTreeName     FsckKind = "tree-name"     //invalid name of a tree entry
+	FsckMissing      FsckKind = "missing"       //object reachable from refs not found
+	FsckDangling     FsckKind = "dangling"      //object not reachable from anything
+)
+
+//FsckIssue is a problem found by Fsck. ID is zero for problems not
+//related to a single object, e.g. checksums of packs; Path is the
+//file or ref the problem was found in, if any.
+type FsckIssue struct {
+	Kind    FsckKind
+	ID      SHA1
+	Path    string
+	Message string
+}
+
+//IsError checks if the issue is an actual error, as
+//opposed to a dangling object.
+func (i FsckIssue) IsError() bool {
+	return i.Kind != FsckDangling
+}
+
+func (i FsckIssue) String() string {
+	var b bytes.Buffer
+	b.WriteString(string(i.Kind))
+	if i.ID != (SHA1{}) {
+		b.WriteString(" " + i.ID.String())
+	}
+	if i.Path != "" {
+		b.WriteString(" " + i.Path)
+	}
+	b.WriteString(": " + i.Message)
+	return b.String()
+}
+
+//FsckReport is the result of Fsck.
+type FsckReport struct {
+	Objects int //number of objects checked, copies in several packs count once
+	Packs   int
+	Issues  []FsckIssue
+}
+
+//OK checks if there are no errors in the report.
+func (r *FsckReport) OK() bool {
+	for _, issue := range r.Issues {
+		if issue.IsError() {
+			return false
+		}
+	}
+	return true
+}
+
+//fsckObject is an object found by Fsck.
+type fsckObject struct {
+	otype ObjectType
+	links []SHA1 //the objects it refers to
+}
+
+type fsck struct {
+	repo    *Repository
+	report  *FsckReport
+	objects map[SHA1]*fsckObject
+	shallow map[SHA1]bool
+}
+
+//Fsck verifies the object store of the repository: the ids of all
+//loose and packed objects are checked against their data, the
+//checksums of packs and their indices are verified, commits, tags and
+//trees are parsed, with the entries of trees checked for order, mode
+//and name. Then objects reachable from the refs and reflogs are checked
+//for existence (the parents of shallow commits are not required), and
+//objects not reachable from anywhere are reported as dangling.
+//The error is only non-nil if the check itself fails.
+func (repo *Repository) Fsck() (*FsckReport, error) {
+	shallow, err := repo.ShallowCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &fsck{
+		repo:    repo,
+		report:  &FsckReport{},
+		objects: make(map[SHA1]*fsckObject),
+		shallow: shallow,
+	}
+
+	err = fs.checkLoose()
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.checkPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.checkConnectivity()
+	if err != nil {
+		return nil, err
+	}
+
+	fs.report.Objects = len(fs.objects)
+	return fs.report, nil
+}
+
+func (fs *fsck) issue(kind FsckKind, id SHA1, path string, format string, args ...interface{}) {
+	fs.report.Issues = append(fs.report.Issues, FsckIssue{kind, id, path, fmt.Sprintf(format, args...)})
+}
+
+func (fs *fsck) checkLoose() error {
+	dirs, err := filepath.Glob(filepath.Join(fs.repo.Path, "objects", "[0-9a-f][0-9a-f]"))
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range files {
+			path := filepath.Join(dir, fi.Name())
+			id, err := ParseSHA1(filepath.Base(dir) + fi.Name())
+			if err != nil {
+				//temporary files and the like
+				continue
+			}
+
+			obj, err := openRawObject(path)
+			if err != nil {
+				fs.corrupt(id, path, err)
+				continue
+			}
+
+			otype, data, err := fs.repo.readRawData(obj)
+			fs.checkObject(id, path, otype, data, err)
+		}
+	}
+
+	return nil
+}
+
+func (fs *fsck) checkPacks() error {
+	files, err := filepath.Glob(filepath.Join(fs.repo.Path, "objects", "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range files {
+		fs.report.Packs++
+		err = fs.checkPack(strings.TrimSuffix(path, ".idx"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//checkPack checks the pack name (without extension), i.e. the
+//checksums of the pack and its index and all objects therein.
+func (fs *fsck) checkPack(name string) error {
+	idxSum, err := fileChecksum(name + ".idx")
+	if err != nil {
+		fs.issue(FsckCorrupt, SHA1{}, name+".idx", "%v", err)
+		return nil
+	} else if idxSum.stored != idxSum.actual {
+		fs.issue(FsckBadChecksum, SHA1{}, name+".idx", "index checksum mismatch")
+	}
+
+	packSum, err := fileChecksum(name + ".pack")
+	if err != nil {
+		fs.issue(FsckCorrupt, SHA1{}, name+".pack", "%v", err)
+		return nil
+	} else if packSum.stored != packSum.actual {
+		fs.issue(FsckBadChecksum, SHA1{}, name+".pack", "pack checksum mismatch")
+	} else if packSum.stored != idxSum.extra {
+		fs.issue(FsckBadChecksum, SHA1{}, name+".idx", "index is for a different pack")
+	}
+
+	idx, err := PackIndexOpen(name + ".idx")
+	if err != nil {
+		fs.issue(FsckCorrupt, SHA1{}, name+".idx", "%v", err)
+		return nil
+	}
+	defer idx.Close()
+
+	pf, err := idx.OpenPackFile()
+	if err != nil {
+		fs.issue(FsckCorrupt, SHA1{}, name+".pack", "%v", err)
+		return nil
+	}
+	defer pf.Close()
+
+	if n := int(idx.FO[255]); n != int(pf.ObjCount) {
+		fs.issue(FsckCorrupt, SHA1{}, name+".idx", "index has %d objects, pack %d", n, pf.ObjCount)
+	}
+
+	path := name + ".pack"
+	for pos := 0; pos < int(idx.FO[255]); pos++ {
+		var id SHA1
+		err = idx.ReadSHA1(&id, pos)
+		if err != nil {
+			fs.issue(FsckCorrupt, SHA1{}, name+".idx", "%v", err)
+			return nil
+		}
+
+		off, err := idx.ReadOffset(pos)
+		if err != nil {
+			fs.corrupt(id, name+".idx", err)
+			continue
+		}
+
+		obj, err := pf.readRawObject(off)
+		if err != nil {
+			fs.corrupt(id, path, err)
+			continue
+		}
+
+		otype, data, err := fs.repo.readRawData(obj)
+		fs.checkObject(id, path, otype, data, err)
+	}
+
+	return nil
+}
+
+//corrupt records the unreadable object id.
+func (fs *fsck) corrupt(id SHA1, path string, err error) {
+	fs.issue(FsckCorrupt, id, path, "%v", err)
+	fs.markBad(id)
+}
+
+//markBad records the broken object id, so it is not
+//reported as missing (or dangling) as well.
+func (fs *fsck) markBad(id SHA1) {
+	if _, ok := fs.objects[id]; !ok {
+		fs.objects[id] = &fsckObject{}
+	}
+}
+
+//checkObject checks the data of the object id, which has been
+//read from path, and records it together with its links.
+func (fs *fsck) checkObject(id SHA1, path string, otype ObjectType, data []byte, err error) {
+	if err != nil {
+		fs.corrupt(id, path, err)
+		return
+	} else if actual := hashObject(otype, data); actual != id {
+		fs.issue(FsckHashMismatch, id, path, "%s data hashes to %s", otype, actual)
+		fs.markBad(id)
+		return
+	}
+
+	if known, ok := fs.objects[id]; ok && known.otype != 0 {
+		//another copy, in another pack, already checked
+		return
+	}
+
+	obj, err := parseObject(gitObject{otype, int64(len(data)), ioutil.NopCloser(bytes.NewReader(data))})
+	if err != nil {
+		fs.corrupt(id, path, err)
+		return
+	}
+
+	fo := &fsckObject{otype: otype}
+	switch o := obj.(type) {
+	case *Commit:
+		fo.links = []SHA1{o.Tree}
+		if !fs.shallow[id] {
+			//parents of shallow commits are missing
+			fo.links = append(fo.links, o.Parent...)
+		}
+	case *Tag:
+		fo.links = []SHA1{o.Object}
+	case *Tree:
+		fo.links, err = fs.checkTree(id, path, o)
+	}
+
+	if err != nil {
+		fs.corrupt(id, path, err)
+		return
+	}
+
+	fs.objects[id] = fo
+}
+
+//checkTree checks the order, modes and names of the entries of
+//the tree and returns the ids of the entries (but submodules).
+func (fs *fsck) checkTree(id SHA1, path string, tree *Tree) ([]SHA1, error) {
+	var links []SHA1
+	var last string
+	names := make(map[string]bool)
+
+	for tree.Next() {
+		e := tree.Entry()
+
+		switch e.Mode {
+		case 0100644, 0100755, 0120000, 040000, gitModeGitlink:
+		default:
+			fs.issue(FsckTreeMode, id, path, "entry %q has invalid mode %o", e.Name, uint32(e.Mode))
+		}
+
+		switch {
+		case e.Name == "" || e.Name == "." || e.Name == ".." || strings.ContainsRune(e.Name, '/'):
+			fs.issue(FsckTreeName, id, path, "invalid entry name %q", e.Name)
+		case strings.EqualFold(e.Name, ".git"):
+			fs.issue(FsckTreeName, id, path, "entry named %q", e.Name)
+		}
+
+		key := e.Name
+		if e.Mode == 040000 {
+			key += "/"
+		}
+
+		if names[e.Name] {
+			fs.issue(FsckTreeOrder, id, path, "duplicate entry %q", e.Name)
+		} else if key < last {
+			fs.issue(FsckTreeOrder, id, path, "entry %q not sorted", e.Name)
+		}
+		names[e.Name] = true
+		last = key
+
+		if e.Mode&gitModeTypeMask != gitModeGitlink {
+			links = append(links, e.ID)
+		}
+	}
+
+	return links, tree.Err()
+}
+
+//checkConnectivity checks that all objects reachable from refs and
+//reflogs exist and reports objects that are not reachable at all.
+func (fs *fsck) checkConnectivity() error {
+	refs, err := fs.repo.listRefs()
+	if err != nil {
+		return err
+	}
+
+	reachable := make(map[SHA1]bool)
+	var queue []SHA1
+
+	for _, ref := range refs {
+		if ref.id == (SHA1{}) {
+			//unborn HEAD
+			continue
+		} else if _, ok := fs.objects[ref.id]; !ok && !fs.borrowed(ref.id) {
+			fs.issue(FsckMissing, ref.id, ref.name, "ref points to missing object")
+		} else if !ok {
+			reachable[ref.id] = true
+		} else if !reachable[ref.id] {
+			reachable[ref.id] = true
+			queue = append(queue, ref.id)
+		}
+	}
+
+	//like git, the entries of the reflogs count as roots too;
+	//they might refer to objects that are pruned already
+	logged, err := fs.repo.reflogIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range logged {
+		if _, ok := fs.objects[id]; ok && !reachable[id] {
+			reachable[id] = true
+			queue = append(queue, id)
+		}
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		obj := fs.objects[id]
+		for _, link := range obj.links {
+			if reachable[link] {
+				continue
+			} else if _, ok := fs.objects[link]; !ok {
+				if !fs.borrowed(link) {
+					fs.issue(FsckMissing, link, "", "referenced by %s %s", obj.otype, id)
+				}
+				reachable[link] = true
+				continue
+			}
+
+			reachable[link] = true
+			queue = append(queue, link)
+		}
+	}
+
+	//like git, only the tips of unreachable
+	//histories are reported as dangling
+	referenced := make(map[SHA1]bool)
+	for id, obj := range fs.objects {
+		if !reachable[id] {
+			for _, link := range obj.links {
+				referenced[link] = true
+			}
+		}
+	}
+
+	var dangling []SHA1
+	for id, obj := range fs.objects {
+		if !reachable[id] && !referenced[id] && obj.otype != 0 {
+			dangling = append(dangling, id)
+		}
+	}
+
+	sort.Sort(sha1Slice(dangling))
+	for _, id := range dangling {
+		fs.issue(FsckDangling, id, "", "dangling %s", fs.objects[id].otype)
+	}
+
+	return nil
+}
+
+//borrowed checks if the object id, which is not in the object
+//directory of the repository, is found in one of its alternates.
+//Objects of alternates are not checked (nor followed), that is
+//up to the Fsck of the repositories they belong to.
+func (fs *fsck) borrowed(id SHA1) bool {
+	return fs.repo.hasObject(id)
+}
+
+type sha1Slice []SHA1
+
+func (s sha1Slice) Len() int           { return len(s) }
+func (s sha1Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sha1Slice) Less(i, j int) bool { return bytes.Compare(s[i][:], s[j][:]) < 0 }
+
+//fileSum holds the checksum stored at the end of a pack or
+//index file, the actual checksum of the data before it and
+//the 20 bytes before the stored checksum (for indices, the
+//checksum of the pack).
+type fileSum struct {
+	stored, actual, extra SHA1
+}
+
+func fileChecksum(path string) (fileSum, error) {
+	var sum fileSum
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer fd.Close()
+
+	fi, err := fd.Stat()
+	if err != nil {
+		return sum, err
+	} else if fi.Size() < 40 {
+		return sum, fmt.Errorf("git: file too short")
+	}
+
+	h := sha1.New()
+	_, err = io.CopyN(h, fd, fi.Size()-20)
+	if err != nil {
+		return sum, err
+	}
+	copy(sum.actual[:], h.Sum(nil))
+
+	_, err = io.ReadFull(fd, sum.stored[:])
+	if err == nil {
+		_, err = fd.ReadAt(sum.extra[:], fi.Size()-40)
+	}
+
+	return sum, err
+}
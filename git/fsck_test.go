@@ -0,0 +1,144 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//fsckIssues runs Fsck and returns the issues as strings, with
+//the paths relative to the repository.
+func fsckIssues(t *testing.T, tr *testRepo) []string {
+	report, err := tr.Fsck()
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+
+	var issues []string
+	for _, issue := range report.Issues {
+		issue.Path = strings.TrimPrefix(issue.Path, tr.Path+string(filepath.Separator))
+		issues = append(issues, issue.String())
+	}
+	return issues
+}
+
+func TestFsck(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("first", map[string]string{"a.txt": "a\n", "dir/b.txt": "b\n"})
+	tr.git("tag", "-a", "-m", "tag", "v1.0")
+	tr.git("repack", "-a", "-d", "-q")
+	second := tr.commit("second", map[string]string{"c.txt": "c\n"})
+
+	//empty objects have no data after the header
+	empty, err := tr.writeObjectData(ObjTree, nil)
+	if err != nil {
+		t.Fatalf("could not write empty tree: %v", err)
+	}
+	tr.git("tag", "empty", empty.String())
+
+	report, err := tr.Fsck()
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	} else if !report.OK() || len(report.Issues) != 0 {
+		t.Fatalf("unexpected issues in healthy repository: %v", report.Issues)
+	} else if report.Objects != 10 || report.Packs != 1 {
+		t.Fatalf("unexpected number of objects (%d) or packs (%d)", report.Objects, report.Packs)
+	}
+
+	//a tree with entries in the wrong order and a strange mode
+	var buf bytes.Buffer
+	for _, e := range []string{"100644 b", "100664 a", "100644 a"} {
+		fmt.Fprintf(&buf, "%s\x00", e)
+		buf.Write(second[:])
+	}
+	tree, err := tr.writeObjectData(ObjTree, buf.Bytes())
+	if err != nil {
+		t.Fatalf("could not write tree: %v", err)
+	}
+
+	issues := fsckIssues(t, tr)
+	wanted := []string{
+		fmt.Sprintf("tree-mode %s objects/%s/%s: entry \"a\" has invalid mode 100664", tree, tree.String()[:2], tree.String()[2:]),
+		fmt.Sprintf("tree-order %s objects/%s/%s: entry \"a\" not sorted", tree, tree.String()[:2], tree.String()[2:]),
+		fmt.Sprintf("tree-order %s objects/%s/%s: duplicate entry \"a\"", tree, tree.String()[:2], tree.String()[2:]),
+		fmt.Sprintf("dangling %s: dangling tree", tree),
+	}
+	if strings.Join(issues, "\n") != strings.Join(wanted, "\n") {
+		t.Fatalf("unexpected issues:\n%s\nexpected:\n%s", strings.Join(issues, "\n"), strings.Join(wanted, "\n"))
+	}
+
+	//objects kept by reflogs are not dangling, pruned entries are ignored
+	logs := filepath.Join(tr.Path, "logs", "refs", "heads")
+	os.MkdirAll(logs, 0755)
+	entry := fmt.Sprintf("%s %s A U Thor <author@example.com> 1400000000 +0000\tgone\n", hashObject(ObjBlob, []byte("pruned\n")), tree)
+	if err := ioutil.WriteFile(filepath.Join(logs, "gone"), []byte(entry), 0644); err != nil {
+		t.Fatalf("could not write reflog: %v", err)
+	}
+
+	issues = fsckIssues(t, tr)
+	if strings.Join(issues, "\n") != strings.Join(wanted[:3], "\n") {
+		t.Fatalf("unexpected issues:\n%s\nexpected:\n%s", strings.Join(issues, "\n"), strings.Join(wanted[:3], "\n"))
+	}
+	os.Remove(filepath.Join(logs, "gone"))
+	os.Remove(filepath.Join(tr.Path, "objects", tree.String()[:2], tree.String()[2:]))
+
+	//replace the blob c.txt with different content
+	blob := tr.revParse("HEAD:c.txt")
+	path := filepath.Join(tr.Path, "objects", blob.String()[:2], blob.String()[2:])
+	buf.Reset()
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte("blob 2\x00x\n"))
+	zw.Close()
+	os.Chmod(path, 0644)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("could not write object: %v", err)
+	}
+
+	//and remove the tree of the second commit
+	root := tr.revParse("HEAD^{tree}")
+	os.Remove(filepath.Join(tr.Path, "objects", root.String()[:2], root.String()[2:]))
+
+	issues = fsckIssues(t, tr)
+	wanted = []string{
+		fmt.Sprintf("hash-mismatch %s objects/%s/%s: blob data hashes to %s", blob, blob.String()[:2], blob.String()[2:], hashObject(ObjBlob, []byte("x\n"))),
+		fmt.Sprintf("missing %s: referenced by commit %s", root, second),
+	}
+	if strings.Join(issues, "\n") != strings.Join(wanted, "\n") {
+		t.Fatalf("unexpected issues:\n%s\nexpected:\n%s", strings.Join(issues, "\n"), strings.Join(wanted, "\n"))
+	}
+
+	//corrupt the pack, in the middle of the data
+	packs, _ := filepath.Glob(filepath.Join(tr.Path, "objects", "pack", "*.pack"))
+	data, err := ioutil.ReadFile(packs[0])
+	if err != nil {
+		t.Fatalf("could not read pack: %v", err)
+	}
+
+	data[len(data)/2] ^= 0xff
+	os.Chmod(packs[0], 0644)
+	if err := ioutil.WriteFile(packs[0], data, 0644); err != nil {
+		t.Fatalf("could not write pack: %v", err)
+	}
+
+	report, err = tr.Fsck()
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	} else if report.OK() {
+		t.Fatalf("expected errors for corrupted pack")
+	}
+
+	found := false
+	for _, issue := range report.Issues {
+		found = found || (issue.Kind == FsckBadChecksum && issue.Path == packs[0])
+	}
+	if !found {
+		t.Fatalf("expected pack checksum mismatch, got %v", report.Issues)
+	}
+}
@@ -3,6 +3,7 @@ package git
 import (
 	"container/heap"
 	"fmt"
+	"time"
 )
 
 type NodeFlag uint32
@@ -23,28 +24,92 @@ type CommitNode struct {
 	parents []*CommitNode
 	Flags   NodeFlag
 	ID      SHA1
+
+	//parent ids, date and generation are either from the
+	//commit-graph file or from the commit object
+	parentIDs  []SHA1
+	date       time.Time
+	generation uint32
 }
 
 func (n *CommitNode) Parents() []*CommitNode {
 	return n.parents
 }
 
+//Date returns the commit date. For commits loaded from the
+//commit-graph file the time zone information is not available.
+func (n *CommitNode) Date() time.Time {
+	return n.date
+}
+
+//Generation returns the generation number of the commit as
+//stored in the commit-graph file, or GenerationInfinity if
+//the commit is not contained in it.
+func (n *CommitNode) Generation() uint32 {
+	return n.generation
+}
+
 type CommitGraph struct {
 	tips []*CommitNode
 
 	commits map[SHA1]*CommitNode
 	repo    *Repository
+	graph   *commitGraphChain
+	shallow map[SHA1]bool
+	err     error //reading the shallow file failed
 }
 
+//NewCommitGraph creates a new CommitGraph for the repository.
+//If the repository has a commit-graph file (or a chain of split
+//commit-graph files), commits contained therein are loaded from
+//it instead of from the object store. In shallow repositories,
+//the shallow commits have no parents in the graph. If the shallow
+//file cannot be read, the error is returned when commits are loaded.
 func NewCommitGraph(repo *Repository) *CommitGraph {
-	return &CommitGraph{repo: repo, commits: make(map[SHA1]*CommitNode, 0)}
+	shallow, err := repo.ShallowCommits()
+
+	return &CommitGraph{
+		repo:    repo,
+		commits: make(map[SHA1]*CommitNode, 0),
+		graph:   repo.commitGraph(),
+		shallow: shallow,
+		err:     err,
+	}
+}
+
+//IsShallow checks if node is a shallow commit of the repository,
+//i.e. one whose parents are not available.
+func (c *CommitGraph) IsShallow(node *CommitNode) bool {
+	return c.shallow[node.ID]
 }
 
 func (c *CommitGraph) openObject(oid SHA1) (*CommitNode, error) {
-	if node, ok := c.commits[oid]; ok {
+	if c.err != nil {
+		return nil, c.err
+	} else if node, ok := c.commits[oid]; ok {
 		return node, nil
 	}
 
+	if c.graph != nil {
+		if pos, ok := c.graph.find(oid); ok {
+			entry, err := c.graph.entry(pos)
+			if err == nil {
+				node := &CommitNode{
+					ID:         oid,
+					parentIDs:  entry.Parents,
+					date:       time.Unix(entry.Time, 0),
+					generation: entry.Generation,
+				}
+				if c.shallow[oid] {
+					node.parentIDs = nil
+				}
+				c.commits[oid] = node
+				return node, nil
+			}
+			//fall back to reading the object
+		}
+	}
+
 	obj, err := c.repo.OpenObject(oid)
 
 	if err != nil {
@@ -56,12 +121,42 @@ func (c *CommitGraph) openObject(oid SHA1) (*CommitNode, error) {
 		return nil, fmt.Errorf("object [%s] not of type commit", oid)
 	}
 
-	node := &CommitNode{commit: commit, ID: oid}
+	node := &CommitNode{
+		commit:     commit,
+		ID:         oid,
+		parentIDs:  commit.Parent,
+		date:       commit.Date(),
+		generation: GenerationInfinity,
+	}
+	if c.shallow[oid] {
+		node.parentIDs = nil
+	}
 	c.commits[oid] = node
 
 	return node, nil
 }
 
+//Commit returns the commit object for the node. For nodes loaded
+//from the commit-graph file, the object is opened on demand.
+func (c *CommitGraph) Commit(node *CommitNode) (*Commit, error) {
+	if node.commit != nil {
+		return node.commit, nil
+	}
+
+	obj, err := c.repo.OpenObject(node.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, ok := obj.(*Commit)
+	if !ok {
+		return nil, fmt.Errorf("object [%s] not of type commit", node.ID)
+	}
+
+	node.commit = commit
+	return commit, nil
+}
+
 func (c *CommitGraph) AddTip(oid SHA1) (*CommitNode, error) {
 	node, err := c.openObject(oid)
 
@@ -74,9 +169,13 @@ func (c *CommitGraph) AddTip(oid SHA1) (*CommitNode, error) {
 }
 
 func (c *CommitGraph) loadParents(node *CommitNode) error {
-	if len(node.parents) != len(node.commit.Parent) {
-		node.parents = make([]*CommitNode, len(node.commit.Parent))
-		for i, parent := range node.commit.Parent {
+	if c.err != nil {
+		return c.err
+	}
+
+	if len(node.parents) != len(node.parentIDs) {
+		node.parents = make([]*CommitNode, len(node.parentIDs))
+		for i, parent := range node.parentIDs {
 			var err error
 			node.parents[i], err = c.openObject(parent)
 			if err != nil {
@@ -89,7 +188,10 @@ func (c *CommitGraph) loadParents(node *CommitNode) error {
 }
 
 //youngestFirst is a priority queue implemented via a 'container/heap'
-//the latter is a min-heap, which nicely aligns with times in epoch
+//the latter is a min-heap, which nicely aligns with times in epoch.
+//Commits are ordered by generation first, so that, if generation
+//numbers are available, no commit is visited before its children
+//even in the presence of clock skew.
 type youngestFirst []*CommitNode
 
 func (y youngestFirst) Len() int {
@@ -102,7 +204,11 @@ func (y youngestFirst) Less(i, j int) bool {
 
 	ic, jc := y[i], y[j]
 
-	return ic.commit.Date().After(jc.commit.Date())
+	if ic.generation != jc.generation {
+		return ic.generation > jc.generation
+	}
+
+	return ic.date.After(jc.date)
 }
 
 func (y youngestFirst) Swap(i, j int) {
@@ -204,3 +310,51 @@ func (c *CommitGraph) VisitCommits(fn CommitVisitor) {
 		}
 	}
 }
+
+//IsAncestor reports whether the commit ancestor is reachable from
+//the commit descendant. Generation numbers from the commit-graph are
+//used to stop the walk as soon as only commits that are older (in
+//terms of generation) than the ancestor are left.
+func (c *CommitGraph) IsAncestor(ancestor, descendant SHA1) (bool, error) {
+	target, err := c.openObject(ancestor)
+	if err != nil {
+		return false, err
+	}
+
+	start, err := c.openObject(descendant)
+	if err != nil {
+		return false, err
+	}
+
+	seen := map[SHA1]bool{start.ID: true}
+	pq := youngestFirst{start}
+
+	for len(pq) != 0 {
+		node := heap.Pop(&pq).(*CommitNode)
+
+		if node == target {
+			return true, nil
+		}
+
+		//a commit can only have ancestors with a lower generation
+		if target.generation != GenerationInfinity && node.generation <= target.generation {
+			continue
+		}
+
+		err = c.loadParents(node)
+		if err != nil {
+			return false, err
+		}
+
+		for _, parent := range node.parents {
+			if seen[parent.ID] {
+				continue
+			}
+
+			seen[parent.ID] = true
+			heap.Push(&pq, parent)
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,513 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//packInput reads the pack stream, writes everything that was
+//read to the (temporary) pack file and keeps track of the offset,
+//the checksum of the pack and the crc32 of the current entry.
+//It implements io.ByteReader, so the zlib decompressor does not
+//read beyond the end of the compressed data.
+type packInput struct {
+	r   *bufio.Reader
+	w   io.Writer
+	sum hash.Hash
+	crc hash.Hash32
+	off int64
+	err error
+}
+
+func (p *packInput) consumed(data []byte) {
+	p.off += int64(len(data))
+	p.sum.Write(data)
+	p.crc.Write(data)
+	if p.err == nil {
+		_, p.err = p.w.Write(data)
+	}
+}
+
+func (p *packInput) Read(data []byte) (int, error) {
+	n, err := p.r.Read(data)
+	p.consumed(data[:n])
+	if err == nil {
+		err = p.err
+	}
+	return n, err
+}
+
+func (p *packInput) ReadByte() (byte, error) {
+	b, err := p.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p.consumed([]byte{b})
+	return b, p.err
+}
+
+//indexEntry is an object of the pack being indexed.
+type indexEntry struct {
+	packObject
+
+	dataOff int64 //offset of the compressed data
+	baseOff int64 //for OFS_DELTA objects
+	baseRef SHA1  //for REF_DELTA objects
+}
+
+//packIndexer reads a pack, resolves its deltas and writes its index.
+type packIndexer struct {
+	repo  *Repository
+	fd    *os.File
+	count uint32 //number of objects according to the pack header
+
+	entries  []*indexEntry
+	byOffset map[int64]*indexEntry
+	byID     map[SHA1]bool
+
+	//deltas by their base
+	ofsChildren map[int64][]*indexEntry
+	refChildren map[SHA1][]*indexEntry
+}
+
+//indexPack reads a pack from r, stores it together with its index in
+//the repository and returns its path (without extension) and the ids
+//of all objects therein. Bases of REF_DELTA objects that are missing
+//from the pack, i.e. thin packs, are read from the repository and
+//appended to the pack, like "git index-pack --fix-thin" does. A pack
+//without objects is verified, but not stored; the path is empty then.
+//If the same pack exists already, it is left alone and created is
+//false, i.e. the pack must not be removed if the push is rejected.
+func (repo *Repository) indexPack(r io.Reader) (name string, ids []SHA1, created bool, err error) {
+	dir := filepath.Join(repo.Path, "objects", "pack")
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	fd, err := ioutil.TempFile(dir, "tmp_pack_")
+	if err != nil {
+		return "", nil, false, err
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	ix := &packIndexer{
+		repo:        repo,
+		fd:          fd,
+		byOffset:    make(map[int64]*indexEntry),
+		byID:        make(map[SHA1]bool),
+		ofsChildren: make(map[int64][]*indexEntry),
+		refChildren: make(map[SHA1][]*indexEntry),
+	}
+
+	checksum, err := ix.read(r)
+	if err != nil {
+		return "", nil, false, err
+	} else if len(ix.entries) == 0 {
+		return "", nil, false, nil
+	}
+
+	err = ix.resolveDeltas()
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	ids = make([]SHA1, len(ix.entries))
+	objects := make([]*packObject, len(ix.entries))
+	for i, e := range ix.entries {
+		ids[i] = e.id
+		objects[i] = &e.packObject
+	}
+
+	//thin packs were completed, i.e. the pack has changed
+	if int(ix.count) != len(objects) {
+		checksum, err = ix.fixHeader(len(objects))
+	} else {
+		_, err = fd.Write(checksum[:])
+	}
+
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	idx, err := ioutil.TempFile(dir, "tmp_idx_")
+	if err != nil {
+		return "", nil, false, err
+	}
+	defer os.Remove(idx.Name())
+	defer idx.Close()
+
+	err = writePackIndex(idx, objects, checksum)
+	for _, f := range []*os.File{fd, idx} {
+		if err == nil {
+			err = f.Sync()
+		}
+		if err == nil {
+			err = f.Close()
+		}
+	}
+
+	if err != nil {
+		return "", nil, false, fmt.Errorf("git: could not write pack: %v", err)
+	}
+
+	//NB: the index is put in place last, see WritePack; links, unlike
+	//renames, do not replace an identical pack that refs depend on
+	name = filepath.Join(dir, "pack-"+checksum.String())
+	err = os.Link(fd.Name(), name+".pack")
+	if os.IsExist(err) {
+		return name, ids, false, nil
+	} else if err == nil {
+		err = os.Link(idx.Name(), name+".idx")
+		if err != nil {
+			os.Remove(name + ".pack")
+		}
+	}
+	if err != nil {
+		return "", nil, false, fmt.Errorf("git: could not write pack: %v", err)
+	}
+
+	return name, ids, true, nil
+}
+
+//read reads the pack header and all entries from r, computes the
+//ids of the non-delta objects and returns the checksum of the pack,
+//which is not yet written to the pack file.
+func (ix *packIndexer) read(r io.Reader) (SHA1, error) {
+	var checksum SHA1
+
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	in := &packInput{r: br, w: ix.fd, sum: sha1.New(), crc: crc32.NewIEEE()}
+
+	var header PackHeader
+	err := binary.Read(in, binary.BigEndian, &header)
+	if err != nil {
+		return checksum, fmt.Errorf("git: could not read pack header: %v", err)
+	} else if !bytes.Equal(header.Sig[:], []byte("PACK")) || (header.Version != 2 && header.Version != 3) {
+		return checksum, fmt.Errorf("git: invalid pack header")
+	}
+
+	ix.count = header.Objects
+	for i := uint32(0); i < header.Objects; i++ {
+		err = ix.readEntry(in)
+		if err != nil {
+			return checksum, fmt.Errorf("git: could not read pack entry %d: %v", i, err)
+		}
+	}
+
+	if in.err != nil {
+		return checksum, in.err
+	}
+
+	_, err = io.ReadFull(br, checksum[:])
+	if err != nil {
+		return checksum, fmt.Errorf("git: could not read pack checksum: %v", err)
+	} else if !bytes.Equal(checksum[:], in.sum.Sum(nil)) {
+		return checksum, fmt.Errorf("git: pack checksum mismatch")
+	}
+
+	return checksum, nil
+}
+
+func (ix *packIndexer) readEntry(in *packInput) error {
+	in.crc.Reset()
+	e := &indexEntry{}
+	e.offset = in.off
+
+	//object header, see PackFile.readRawObject
+	b, err := in.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	e.otype = ObjectType((b & 0x70) >> 4)
+	e.size = int64(b & 0xF)
+	if b&0x80 != 0 {
+		s, err := readVarSize(in, 4)
+		if err != nil {
+			return err
+		}
+		e.size += s
+	}
+
+	switch {
+	case e.otype == ObjOFSDelta:
+		off, err := readVarint(in)
+		if err != nil {
+			return err
+		} else if off <= 0 || off > e.offset {
+			return fmt.Errorf("invalid delta base offset")
+		}
+
+		e.baseOff = e.offset - off
+		if _, ok := ix.byOffset[e.baseOff]; !ok {
+			return fmt.Errorf("no object at delta base offset")
+		}
+		ix.ofsChildren[e.baseOff] = append(ix.ofsChildren[e.baseOff], e)
+	case e.otype == ObjRefDelta:
+		_, err = io.ReadFull(in, e.baseRef[:])
+		if err != nil {
+			return err
+		}
+		ix.refChildren[e.baseRef] = append(ix.refChildren[e.baseRef], e)
+	case !IsStandardObject(e.otype):
+		return fmt.Errorf("invalid object type %d", e.otype)
+	}
+
+	e.dataOff = in.off
+
+	zr, err := zlib.NewReader(in)
+	if err != nil {
+		return err
+	}
+
+	//the id of regular objects is computed while reading, for
+	//deltas, the data is read again once the base is known
+	var w io.Writer = ioutil.Discard
+	var h hash.Hash
+	if IsStandardObject(e.otype) {
+		h = sha1.New()
+		fmt.Fprintf(h, "%s %d\x00", e.otype, e.size)
+		w = h
+	}
+
+	n, err := io.Copy(w, zr)
+	if err == nil {
+		err = zr.Close()
+	}
+
+	if err != nil {
+		return err
+	} else if n != e.size {
+		return fmt.Errorf("object size mismatch")
+	}
+
+	if h != nil {
+		copy(e.id[:], h.Sum(nil))
+		ix.byID[e.id] = true
+	}
+
+	e.crc = in.crc.Sum32()
+	ix.entries = append(ix.entries, e)
+	ix.byOffset[e.offset] = e
+	return nil
+}
+
+//readData reads and decompresses the data of e from the pack file.
+func (ix *packIndexer) readData(e *indexEntry) ([]byte, error) {
+	if e.size > MaxDeltaSize {
+		return nil, ErrDeltaTooLarge
+	}
+
+	zr, err := zlib.NewReader(bufio.NewReader(io.NewSectionReader(ix.fd, e.dataOff, 1<<62)))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	data := make([]byte, e.size)
+	_, err = io.ReadFull(zr, data)
+	return data, err
+}
+
+//resolveDeltas computes the ids of all delta objects, by applying
+//them to their bases, starting with the regular objects of the pack.
+//Then the remaining REF_DELTA objects are resolved with bases from
+//the repository, which are appended to the pack.
+func (ix *packIndexer) resolveDeltas() error {
+	for _, e := range ix.entries {
+		if !IsStandardObject(e.otype) {
+			continue
+		}
+
+		err := ix.resolveChildren(e, nil, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	//the missing bases are collected in pack order,
+	//so the resulting pack is deterministic
+	var missing []SHA1
+	for _, e := range ix.entries {
+		if e.otype == ObjRefDelta && e.depth == 0 && !ix.byID[e.baseRef] {
+			missing = append(missing, e.baseRef)
+			ix.byID[e.baseRef] = true
+		}
+	}
+
+	for _, id := range missing {
+		otype, data, err := ix.repo.readObjectData(id)
+		if err != nil {
+			return fmt.Errorf("git: missing delta base %s: %v", id, err)
+		}
+
+		base, err := ix.appendObject(id, otype, data)
+		if err != nil {
+			return err
+		}
+
+		err = ix.resolveChildren(base, data, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, e := range ix.entries {
+		if e.id == (SHA1{}) {
+			return fmt.Errorf("git: could not resolve delta at offset %d", e.offset)
+		}
+	}
+
+	return nil
+}
+
+//resolveChildren applies the deltas based on base, whose data is read
+//from the pack if nil, and then recursively the deltas based on those.
+//The resolved deltas get their type from base and their depth set.
+func (ix *packIndexer) resolveChildren(base *indexEntry, data []byte, depth int) error {
+	children := append(ix.ofsChildren[base.offset], ix.refChildren[base.id]...)
+	if len(children) == 0 {
+		return nil
+	} else if depth >= MaxDeltaDepth {
+		return ErrDeltaTooDeep
+	}
+
+	if data == nil {
+		var err error
+		data, err = ix.readData(base)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, child := range children {
+		if child.depth != 0 {
+			//resolved already, via a duplicate base object
+			continue
+		}
+
+		delta, err := ix.readData(child)
+		if err != nil {
+			return err
+		}
+
+		result, err := patchDelta(data, delta)
+		if err != nil {
+			return fmt.Errorf("git: could not apply delta at offset %d: %v", child.offset, err)
+		}
+
+		child.id = hashObject(base.otype, result)
+		child.otype = base.otype
+		child.depth = depth + 1
+		ix.byID[child.id] = true
+
+		err = ix.resolveChildren(child, result, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//appendObject appends an object, which is not stored as delta,
+//to the end of the pack file (before the checksum).
+func (ix *packIndexer) appendObject(id SHA1, otype ObjectType, data []byte) (*indexEntry, error) {
+	off, err := ix.fd.Seek(0, os.SEEK_END)
+	if err != nil {
+		return nil, err
+	}
+
+	crc := crc32.NewIEEE()
+	err = writePackEntry(io.MultiWriter(ix.fd, crc), otype, data, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &indexEntry{dataOff: -1}
+	e.id, e.otype, e.size = id, otype, int64(len(data))
+	e.offset, e.crc = off, crc.Sum32()
+	ix.entries = append(ix.entries, e)
+	return e, nil
+}
+
+//fixHeader updates the number of objects in the pack header,
+//computes the new checksum and appends it to the pack.
+func (ix *packIndexer) fixHeader(count int) (SHA1, error) {
+	var checksum SHA1
+
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(count))
+	_, err := ix.fd.WriteAt(n[:], 8)
+	if err != nil {
+		return checksum, err
+	}
+
+	_, err = ix.fd.Seek(0, os.SEEK_SET)
+	if err != nil {
+		return checksum, err
+	}
+
+	sum := sha1.New()
+	_, err = io.Copy(sum, ix.fd)
+	if err != nil {
+		return checksum, err
+	}
+
+	copy(checksum[:], sum.Sum(nil))
+	_, err = ix.fd.Write(checksum[:])
+	return checksum, err
+}
+
+//patchDelta applies the delta data (as stored in packs, without
+//the base reference) to base and returns the result.
+func patchDelta(base, data []byte) ([]byte, error) {
+	delta := Delta{gitObject: gitObject{
+		otype:  ObjRefDelta,
+		size:   int64(len(data)),
+		source: ioutil.NopCloser(bytes.NewReader(data)),
+	}}
+
+	var err error
+	delta.SizeSource, err = readVarSize(delta.source, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	delta.SizeTarget, err = readVarSize(delta.source, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if delta.SizeSource != int64(len(base)) {
+		return nil, fmt.Errorf("git: source size mismatch while patching delta object")
+	} else if delta.SizeTarget > MaxDeltaSize {
+		return nil, ErrDeltaTooLarge
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, delta.SizeTarget))
+	err = delta.Patch(bytes.NewReader(base), buf)
+	if err != nil {
+		return nil, err
+	} else if int64(buf.Len()) != delta.SizeTarget {
+		return nil, fmt.Errorf("git: size mismatch while patching delta object")
+	}
+
+	return buf.Bytes(), nil
+}
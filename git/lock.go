@@ -0,0 +1,59 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+//ErrRepoBusy is returned by TryLockExclusive if the
+//repository is locked by someone else.
+var ErrRepoBusy = errors.New("git: repository is busy")
+
+//RepoLock is an advisory lock of a whole repository, based on
+//flock(2) on the file "gin.lock" in the repository. It works
+//across processes: pushes hold it shared, maintenance (repack,
+//prune, ...) holds it exclusively, so that maintenance never
+//runs concurrently with a push.
+type RepoLock struct {
+	file *os.File
+}
+
+//LockShared takes the repository lock in shared mode,
+//waiting for an exclusive holder to release it.
+func (repo *Repository) LockShared() (*RepoLock, error) {
+	return repo.lock(syscall.LOCK_SH)
+}
+
+//TryLockExclusive takes the repository lock in exclusive mode.
+//It does not wait, but returns ErrRepoBusy if the lock is held.
+func (repo *Repository) TryLockExclusive() (*RepoLock, error) {
+	return repo.lock(syscall.LOCK_EX | syscall.LOCK_NB)
+}
+
+func (repo *Repository) lock(how int) (*RepoLock, error) {
+	path := filepath.Join(repo.Path, "gin.lock")
+	fd, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not open repository lock: %v", err)
+	}
+
+	err = syscall.Flock(int(fd.Fd()), how)
+	if err == syscall.EWOULDBLOCK {
+		fd.Close()
+		return nil, ErrRepoBusy
+	} else if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("git: could not lock repository: %v", err)
+	}
+
+	return &RepoLock{fd}, nil
+}
+
+//Unlock releases the lock.
+func (l *RepoLock) Unlock() error {
+	//closing the file releases the lock
+	return l.file.Close()
+}
@@ -0,0 +1,299 @@
+package git
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//LogOptions limits the commits returned by a CommitWalker.
+type LogOptions struct {
+	MaxCount int       //maximal number of commits to return, 0 means no limit
+	Skip     int       //number of commits to skip before returning any
+	Since    time.Time //only commits more recent than Since, if not zero
+	Until    time.Time //only commits older than Until, if not zero
+}
+
+//CommitWalker walks the history of a set of commits from the
+//youngest to the oldest commit, ordered by commit date, like
+//"git log" does.
+type CommitWalker struct {
+	graph *CommitGraph
+	opts  LogOptions
+	queue byCommitDate
+
+	node  *CommitNode
+	count int
+	err   error
+}
+
+//NewCommitWalker returns a CommitWalker for the history of the
+//commits tips, limited by opts.
+func (repo *Repository) NewCommitWalker(tips []SHA1, opts LogOptions) (*CommitWalker, error) {
+	w := &CommitWalker{graph: NewCommitGraph(repo), opts: opts}
+
+	for _, oid := range tips {
+		node, err := w.graph.openObject(oid)
+		if err != nil {
+			return nil, err
+		}
+		w.push(node)
+	}
+
+	return w, nil
+}
+
+func (w *CommitWalker) push(node *CommitNode) {
+	if node.Flags&NodeFlagSeen != 0 {
+		return
+	}
+	node.Flags |= NodeFlagSeen
+	heap.Push(&w.queue, node)
+}
+
+//Next advances the walker to the next commit. It returns false
+//if there are no more commits or if an error occurred. Use Err()
+//to distinguish between the two conditions.
+func (w *CommitWalker) Next() bool {
+	if w.err != nil {
+		return false
+	}
+
+	for {
+		if w.queue.Len() == 0 || (w.opts.MaxCount > 0 && w.count >= w.opts.MaxCount) {
+			w.node = nil
+			return false
+		}
+
+		node := heap.Pop(&w.queue).(*CommitNode)
+
+		//commits older than Since are not shown and their history
+		//is not traversed any further
+		if !w.opts.Since.IsZero() && node.Date().Before(w.opts.Since) {
+			continue
+		}
+
+		for _, pid := range node.parentIDs {
+			parent, err := w.graph.openObject(pid)
+			if err != nil {
+				w.err = err
+				return false
+			}
+			w.push(parent)
+		}
+
+		if !w.opts.Until.IsZero() && node.Date().After(w.opts.Until) {
+			continue
+		} else if w.opts.Skip > 0 {
+			w.opts.Skip--
+			continue
+		}
+
+		w.node = node
+		w.count++
+		return true
+	}
+}
+
+//Err returns the error that stopped the walk, if any.
+func (w *CommitWalker) Err() error {
+	return w.err
+}
+
+//ID returns the id of the current commit.
+func (w *CommitWalker) ID() SHA1 {
+	return w.node.ID
+}
+
+//Commit returns the current commit object.
+func (w *CommitWalker) Commit() (*Commit, error) {
+	return w.graph.Commit(w.node)
+}
+
+//Changes returns the paths changed by the current commit with
+//respect to its parent, in the format of "git log --name-status".
+//Like "git log", nothing is returned for merge commits.
+func (w *CommitWalker) Changes() ([]string, error) {
+	commit, err := w.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	//NB: the parents of the node, which has none if it is shallow
+	var base SHA1
+	switch len(w.node.parentIDs) {
+	case 0:
+	case 1:
+		parent, err := w.graph.openObject(w.node.parentIDs[0])
+		if err != nil {
+			return nil, err
+		}
+
+		pc, err := w.graph.Commit(parent)
+		if err != nil {
+			return nil, err
+		}
+		base = pc.Tree
+	default:
+		return nil, nil
+	}
+
+	changes, err := w.graph.repo.DiffTrees(base, commit.Tree, DiffOptions{DetectRenames: true})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, len(changes))
+	for i := range changes {
+		res[i] = changes[i].NameStatus()
+	}
+
+	return res, nil
+}
+
+//Summary returns the CommitSummary for the current commit.
+func (w *CommitWalker) Summary() (CommitSummary, error) {
+	commit, err := w.Commit()
+	if err != nil {
+		return CommitSummary{}, err
+	}
+
+	changes, err := w.Changes()
+	if err != nil {
+		return CommitSummary{}, err
+	}
+
+	date := commit.Author.Date.In(commit.Author.Offset)
+	return CommitSummary{
+		Commit:       w.node.ID.String(),
+		Committer:    commit.Committer.Name,
+		Author:       commit.Author.Name,
+		DateIso:      date.Format("2006-01-02 15:04:05 -0700"),
+		DateRelative: relativeDate(date, time.Now()),
+		Subject:      commitSubject(commit.Message),
+		Changes:      changes,
+	}, nil
+}
+
+//Log returns the summaries of the commits reachable from ref,
+//which can be any revision understood by RevParse, limited by opts.
+func (repo *Repository) Log(ref string, opts LogOptions) ([]CommitSummary, error) {
+	oid, err := repo.RevParse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	oid, err = repo.peel(oid, ObjCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := repo.NewCommitWalker([]SHA1{oid}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []CommitSummary
+	for w.Next() {
+		summary, err := w.Summary()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, summary)
+	}
+
+	return res, w.Err()
+}
+
+//commitSubject returns the subject of a commit message, i.e.
+//the first paragraph with the line breaks replaced by spaces.
+func commitSubject(msg string) string {
+	msg = strings.TrimLeft(msg, "\n")
+	if idx := strings.Index(msg, "\n\n"); idx > -1 {
+		msg = msg[:idx]
+	}
+
+	lines := strings.Split(strings.TrimSpace(msg), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+
+	return strings.Join(lines, " ")
+}
+
+//relativeDate formats the time passed between then and now
+//the same way as git does for "--date=relative".
+func relativeDate(then, now time.Time) string {
+	diff := int64(now.Sub(then) / time.Second)
+
+	if diff < 0 {
+		return "in the future"
+	} else if diff < 90 {
+		return plural(diff, "second") + " ago"
+	}
+
+	diff = (diff + 30) / 60
+	if diff < 90 {
+		return plural(diff, "minute") + " ago"
+	}
+
+	diff = (diff + 30) / 60
+	if diff < 36 {
+		return plural(diff, "hour") + " ago"
+	}
+
+	//from here on diff is in days
+	diff = (diff + 12) / 24
+	if diff < 14 {
+		return plural(diff, "day") + " ago"
+	} else if diff < 70 {
+		return plural((diff+3)/7, "week") + " ago"
+	} else if diff < 365 {
+		return plural((diff+15)/30, "month") + " ago"
+	} else if diff < 1825 {
+		months := (diff*12*2 + 365) / (365 * 2)
+		years := plural(months/12, "year")
+		if months%12 == 0 {
+			return years + " ago"
+		}
+		return years + ", " + plural(months%12, "month") + " ago"
+	}
+
+	return plural((diff+183)/365, "year") + " ago"
+}
+
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+//byCommitDate orders CommitNodes from the youngest to
+//the oldest commit, by commit date only (cf. youngestFirst).
+type byCommitDate []*CommitNode
+
+func (b byCommitDate) Len() int {
+	return len(b)
+}
+
+func (b byCommitDate) Less(i, j int) bool {
+	return b[i].Date().After(b[j].Date())
+}
+
+func (b byCommitDate) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+func (b *byCommitDate) Push(x interface{}) {
+	*b = append(*b, x.(*CommitNode))
+}
+
+func (b *byCommitDate) Pop() interface{} {
+	old := *b
+	n := len(old)
+	x := old[n-1]
+	*b = old[0 : n-1]
+	return x
+}
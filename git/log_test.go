@@ -0,0 +1,107 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommitWalker(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	ids := makeMergeRepo(tr)
+	tr.commit("add dir\n\nwith a body", map[string]string{"dir/a.txt": "a\n", "dir/sub/b.txt": "b\n", "x": "x\n"})
+	tr.commit("modify and delete", map[string]string{"dir/a.txt": "A\n", "dir/sub/b.txt": "", "tip.txt": "TIP\n"})
+	tr.git("mv", "dir/a.txt", "moved.txt")
+	//the file must be removed before the directory is created
+	for _, f := range []struct{ name, content string }{{"x", ""}, {"x/y.txt", "y\n"}} {
+		tr.write(map[string]string{f.name: f.content})
+	}
+	tr.commit("file to dir", nil)
+	tr.git("tag", "-a", "-m", "a tag", "v1.0")
+
+	tr.git("commit-graph", "write", "--reachable")
+	ids["after"] = tr.commit("after graph", map[string]string{"root.txt": "changed\n"})
+
+	since := time.Unix(1500000000, 0).Add(time.Duration(tr.tick-20) * time.Minute)
+	until := time.Unix(1500000000, 0).Add(time.Duration(tr.tick-5) * time.Minute)
+
+	cases := []struct {
+		ref  string
+		opts LogOptions
+		args []string
+	}{
+		{"HEAD", LogOptions{}, nil},
+		{"v1.0", LogOptions{}, nil},
+		{"HEAD~5^3", LogOptions{}, nil},
+		{ids["merge"].String(), LogOptions{}, nil},
+		{"HEAD", LogOptions{MaxCount: 3}, []string{"--max-count=3"}},
+		{"HEAD", LogOptions{Skip: 2, MaxCount: 4}, []string{"--skip=2", "--max-count=4"}},
+		{"HEAD", LogOptions{Since: since}, []string{fmt.Sprintf("--since=%d", since.Unix())}},
+		{"HEAD", LogOptions{Until: until}, []string{fmt.Sprintf("--until=%d", until.Unix())}},
+	}
+
+	for _, tt := range cases {
+		res, err := tr.Log(tt.ref, tt.opts)
+		if err != nil {
+			t.Fatalf("Log(%s, %+v) failed: %v", tt.ref, tt.opts, err)
+		}
+
+		args := append([]string{"log", "--format=%H", tt.ref}, tt.args...)
+		wanted := strings.Fields(tr.git(args...))
+
+		if len(res) != len(wanted) {
+			t.Fatalf("Log(%s, %+v): got %d commits, expected %d", tt.ref, tt.opts, len(res), len(wanted))
+		}
+
+		for i, c := range res {
+			if c.Commit != wanted[i] {
+				t.Fatalf("Log(%s, %+v): commit %d: got %s, expected %s", tt.ref, tt.opts, i, c.Commit, wanted[i])
+			}
+
+			info := tr.git("show", "-s", "--format=%an%n%cn%n%ai%n%s", c.Commit)
+			got := strings.Join([]string{c.Author, c.Committer, c.DateIso, c.Subject}, "\n")
+			if got != info {
+				t.Fatalf("summary mismatch for %s:\n%s\nexpected:\n%s", c.Commit, got, info)
+			}
+
+			changes := tr.git("diff-tree", "-r", "--root", "--no-commit-id", "-M", "--name-status", c.Commit)
+			if strings.Join(c.Changes, "\n") != changes {
+				t.Fatalf("changes mismatch for %s:\n%q\nexpected:\n%q", c.Commit, c.Changes, changes)
+			}
+		}
+	}
+
+	if _, err := tr.Log("doesnotexist", LogOptions{}); err == nil {
+		t.Fatalf("expected error for non-existing ref")
+	}
+}
+
+func TestRelativeDate(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	cases := []struct {
+		diff time.Duration
+		res  string
+	}{
+		{time.Second, "1 second ago"},
+		{89 * time.Second, "89 seconds ago"},
+		{90 * time.Second, "2 minutes ago"},
+		{5 * time.Hour, "5 hours ago"},
+		{3 * 24 * time.Hour, "3 days ago"},
+		{20 * 24 * time.Hour, "3 weeks ago"},
+		{100 * 24 * time.Hour, "3 months ago"},
+		{400 * 24 * time.Hour, "1 year, 1 month ago"},
+		{730 * 24 * time.Hour, "2 years ago"},
+		{3650 * 24 * time.Hour, "10 years ago"},
+	}
+
+	for _, tt := range cases {
+		res := relativeDate(now.Add(-tt.diff), now)
+		if res != tt.res {
+			t.Errorf("relativeDate(-%v) => %q, expected %q", tt.diff, res, tt.res)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Resources:
+//  https://github.com/git/git/blob/master/Documentation/gitformat-pack.txt
+//  (section "multi-pack-index (MIDX) files have the following format")
+
+//Chunk ids used in multi-pack-index files.
+const (
+	midxChunkPackNames    = 0x504e414d // "PNAM"
+	midxChunkOIDFanout    = 0x4f494446 // "OIDF"
+	midxChunkOIDLookup    = 0x4f49444c // "OIDL"
+	midxChunkOffsets      = 0x4f4f4646 // "OOFF"
+	midxChunkLargeOffsets = 0x4c4f4646 // "LOFF"
+)
+
+//MultiPackIndex represents a git multi-pack-index (MIDX) file,
+//i.e. a single index for the objects of many pack files.
+type MultiPackIndex struct {
+	*os.File
+
+	Version byte
+	Packs   []string //names of the pack index files, i.e. "pack-<sha1>.idx"
+	FO      FanOut
+
+	oidLookup    int64
+	offsets      int64
+	largeOffsets int64
+}
+
+//MultiPackIndexOpen opens the multi-pack-index file at path
+//and reads its header, the chunk table, the pack names and
+//the fan-out table. Only version 1 with SHA1 ids is supported.
+func MultiPackIndexOpen(path string) (*MultiPackIndex, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read multi-pack-index: %v", err)
+	}
+
+	midx, err := readMultiPackIndex(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return midx, nil
+}
+
+func readMultiPackIndex(fd *os.File) (*MultiPackIndex, error) {
+	var header struct {
+		Sig        [4]byte
+		Version    byte
+		OIDVersion byte
+		Chunks     byte
+		Bases      byte
+		Packs      uint32
+	}
+
+	err := binary.Read(fd, binary.BigEndian, &header)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read multi-pack-index header: %v", err)
+	}
+
+	if string(header.Sig[:]) != "MIDX" {
+		return nil, fmt.Errorf("git: multi-pack-index signature error")
+	} else if header.Version != 1 {
+		return nil, fmt.Errorf("git: unsupported multi-pack-index version: %d", header.Version)
+	} else if header.OIDVersion != 1 {
+		return nil, fmt.Errorf("git: unsupported multi-pack-index object id version: %d", header.OIDVersion)
+	} else if header.Bases != 0 {
+		return nil, fmt.Errorf("git: multi-pack-index base files not supported")
+	}
+
+	//chunk table: (id[4], offset[8]) per chunk, plus a terminating entry
+	table := make([]struct {
+		ID     uint32
+		Offset uint64
+	}, int(header.Chunks)+1)
+
+	err = binary.Read(fd, binary.BigEndian, table)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read multi-pack-index chunks: %v", err)
+	}
+
+	midx := &MultiPackIndex{File: fd, Version: header.Version}
+
+	var names, fanout int64
+	var namesLen int64
+	for i, chunk := range table[:len(table)-1] {
+		off := int64(chunk.Offset)
+
+		switch chunk.ID {
+		case midxChunkPackNames:
+			names = off
+			namesLen = int64(table[i+1].Offset) - off
+		case midxChunkOIDFanout:
+			fanout = off
+		case midxChunkOIDLookup:
+			midx.oidLookup = off
+		case midxChunkOffsets:
+			midx.offsets = off
+		case midxChunkLargeOffsets:
+			midx.largeOffsets = off
+		}
+	}
+
+	if names == 0 || fanout == 0 || midx.oidLookup == 0 || midx.offsets == 0 {
+		return nil, fmt.Errorf("git: multi-pack-index is missing required chunks")
+	}
+
+	if namesLen < 0 || namesLen > 1<<24 {
+		return nil, fmt.Errorf("git: multi-pack-index pack name chunk corrupt")
+	}
+
+	data := make([]byte, namesLen)
+	_, err = fd.ReadAt(data, names)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read multi-pack-index pack names: %v", err)
+	}
+
+	for _, name := range bytes.Split(data, []byte{0}) {
+		if len(name) == 0 {
+			continue //padding
+		}
+		midx.Packs = append(midx.Packs, string(name))
+	}
+
+	if len(midx.Packs) != int(header.Packs) {
+		return nil, fmt.Errorf("git: multi-pack-index pack count mismatch")
+	}
+
+	_, err = fd.Seek(fanout, 0)
+	if err != nil {
+		return nil, fmt.Errorf("git: io error: %v", err)
+	}
+
+	err = binary.Read(fd, binary.BigEndian, &midx.FO)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read multi-pack-index fanout: %v", err)
+	}
+
+	return midx, nil
+}
+
+//Count returns the number of objects in the index.
+func (m *MultiPackIndex) Count() int {
+	return int(m.FO[255])
+}
+
+//PackName returns the base name of the pack file with the
+//given pack-int-id, i.e. without the ".idx" or ".pack" suffix.
+func (m *MultiPackIndex) PackName(pack int) string {
+	return strings.TrimSuffix(m.Packs[pack], ".idx")
+}
+
+//ReadSHA1 reads the SHA1 stored at position pos.
+func (m *MultiPackIndex) ReadSHA1(chksum *SHA1, pos int) error {
+	_, err := m.ReadAt(chksum[0:20], m.oidLookup+int64(pos)*20)
+	return err
+}
+
+//ReadOffset returns the pack-int-id of the pack file containing
+//the object at position pos and the offset therein.
+func (m *MultiPackIndex) ReadOffset(pos int) (int, int64, error) {
+	var buf [8]byte
+	_, err := m.ReadAt(buf[:], m.offsets+int64(pos)*8)
+	if err != nil {
+		return 0, -1, fmt.Errorf("git: io error: %v", err)
+	}
+
+	pack := binary.BigEndian.Uint32(buf[:4])
+	offset := binary.BigEndian.Uint32(buf[4:])
+
+	if int(pack) >= len(m.Packs) {
+		return 0, -1, fmt.Errorf("git: multi-pack-index: invalid pack id %d", pack)
+	}
+
+	//msb set -> the rest is the position in the large offset table
+	if offset&(1<<31) == 0 {
+		return int(pack), int64(offset), nil
+	} else if m.largeOffsets == 0 {
+		return 0, -1, fmt.Errorf("git: multi-pack-index: large offset without chunk")
+	}
+
+	pos = int(offset &^ (1 << 31))
+	_, err = m.ReadAt(buf[:], m.largeOffsets+int64(pos)*8)
+	if err != nil {
+		return 0, -1, fmt.Errorf("git: io error: %v", err)
+	}
+
+	return int(pack), int64(binary.BigEndian.Uint64(buf[:])), nil
+}
+
+//FindOffset searches the index for the object with the id target
+//and returns the pack-int-id of the pack file it is stored in,
+//together with its offset in that pack file.
+func (m *MultiPackIndex) FindOffset(target SHA1) (int, int64, error) {
+
+	//see PackIndex.findSHA1 for an explanation
+	s, e := m.FO.Bounds(target[0])
+
+	for s < e {
+		midpoint := s + (e-s+1)/2
+
+		var sha SHA1
+		err := m.ReadSHA1(&sha, midpoint-1)
+		if err != nil {
+			return 0, -1, fmt.Errorf("git: io error: %v", err)
+		}
+
+		switch bytes.Compare(target[:], sha[:]) {
+		case -1:
+			e = midpoint - 1
+		case +1:
+			s = midpoint
+		default:
+			return m.ReadOffset(midpoint - 1)
+		}
+	}
+
+	return 0, -1, fmt.Errorf("git: sha1 not found in multi-pack-index")
+}
@@ -0,0 +1,106 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMultiPackIndex(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	var commits []SHA1
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("file%d.txt", i)
+		commits = append(commits, tr.commit(name, map[string]string{name: name}))
+		tr.git("repack", "-d", "-q")
+	}
+
+	tr.git("multi-pack-index", "write")
+
+	path := filepath.Join(tr.Path, "objects", "pack", "multi-pack-index")
+	midx, err := MultiPackIndexOpen(path)
+	if err != nil {
+		t.Fatalf("could not open multi-pack-index: %v", err)
+	}
+	defer midx.Close()
+
+	if len(midx.Packs) != 3 {
+		t.Fatalf("expected 3 packs in multi-pack-index, got %d", len(midx.Packs))
+	}
+
+	//every object in the midx must be found at the same
+	//offset as in the index of its pack
+	for k := 0; k < midx.Count(); k++ {
+		var oid SHA1
+		err := midx.ReadSHA1(&oid, k)
+		if err != nil {
+			t.Fatalf("could not read sha1 at %d: %v", k, err)
+		}
+
+		pid, off, err := midx.FindOffset(oid)
+		if err != nil {
+			t.Fatalf("could not find %s in multi-pack-index: %v", oid, err)
+		}
+
+		idx, err := PackIndexOpen(filepath.Join(filepath.Dir(path), midx.Packs[pid]))
+		if err != nil {
+			t.Fatalf("could not open pack index: %v", err)
+		}
+
+		o2, err := idx.FindOffset(oid)
+		idx.Close()
+		if err != nil {
+			t.Fatalf("%s not found in pack %s: %v", oid, midx.Packs[pid], err)
+		} else if o2 != off {
+			t.Fatalf("offset mismatch for %s: %d vs %d", oid, off, o2)
+		}
+	}
+
+	var missing SHA1
+	if _, _, err := midx.FindOffset(missing); err == nil {
+		t.Fatalf("found all-zero sha1 in multi-pack-index")
+	}
+
+	reg := tr.packs()
+	err = reg.refresh(true)
+	if err != nil {
+		t.Fatalf("could not refresh registry: %v", err)
+	}
+
+	if reg.midx == nil || len(reg.midxPacks) != 3 || len(reg.packs) != 0 {
+		t.Fatalf("expected all packs to be accessed via multi-pack-index")
+	}
+
+	for _, id := range commits {
+		obj, err := tr.OpenObject(id)
+		if err != nil {
+			t.Fatalf("could not open %s via multi-pack-index: %v", id, err)
+		}
+		obj.Close()
+	}
+
+	//a new pack, not covered by the midx
+	commits = append(commits, tr.commit("new", map[string]string{"new.txt": "new"}))
+	tr.git("repack", "-d", "-q")
+
+	//and without the midx, fall back to the pack indices
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatalf("could not remove multi-pack-index: %v", err)
+	}
+
+	for _, id := range commits {
+		obj, err := tr.OpenObject(id)
+		if err != nil {
+			t.Fatalf("could not open %s without multi-pack-index: %v", id, err)
+		}
+		obj.Close()
+	}
+
+	if reg.midx != nil || len(reg.packs) != 4 {
+		t.Fatalf("expected 4 packs accessed via their own index")
+	}
+}
@@ -43,6 +43,19 @@ type Signature struct {
 	Offset *time.Location
 }
 
+//NewSignature creates the signature of name and email at date,
+//with the offset of the time zone of date.
+func NewSignature(name, email string, date time.Time) Signature {
+	_, offset := date.Zone()
+	sign, abs := '+', offset
+	if offset < 0 {
+		sign, abs = '-', -offset
+	}
+
+	zone := fmt.Sprintf("%c%02d%02d", sign, abs/3600, abs%3600/60)
+	return Signature{Name: name, Email: email, Date: date, Offset: time.FixedZone(zone, offset)}
+}
+
 func (s Signature) String() string {
 	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.Date.Unix(), s.Offset)
 }
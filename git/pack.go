@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Resources:
@@ -52,11 +55,18 @@ type PackIndex struct {
 //PackFile is git pack file with the actual
 //data in it. It should normally not be used
 //directly.
+//The underlying file is re-opened on demand
+//after it has been released, so that objects
+//read from the pack stay usable.
 type PackFile struct {
-	*os.File
-
 	Version  uint32
 	ObjCount uint32
+
+	path string
+	used int64 //last read, in unix nanoseconds
+
+	mu sync.RWMutex
+	fd *os.File
 }
 
 //PackIndexOpen opens the git pack file with the given
@@ -133,25 +143,33 @@ func (pi *PackIndex) ReadOffset(pos int) (int64, error) {
 	//header[2*4] + FanOut[256*4] + n * (sha1[20]+crc[4])
 	start := int64(2*4+256*4) + int64(pi.FO[255]*24) + int64(pos*4)
 
-	var offset uint32
-
-	_, err := pi.Seek(start, 0)
+	//NB: we use ReadAt and not Seek & Read, so that the index
+	// can be shared between goroutines (cf. packRegistry)
+	var buf [4]byte
+	_, err := pi.ReadAt(buf[:], start)
 	if err != nil {
 		return -1, fmt.Errorf("git: io error: %v", err)
 	}
 
-	err = binary.Read(pi, binary.BigEndian, &offset)
-	if err != nil {
-		return -1, err
-	}
+	offset := binary.BigEndian.Uint32(buf[:])
 
 	//see if msb is set, if so this is an
 	// offset into the 64b_offset table
-	if val := uint32(1<<31) & offset; val != 0 {
-		return -1, fmt.Errorf("git: > 31 bit offests not implemented. Meh")
+	if val := uint32(1<<31) & offset; val == 0 {
+		return int64(offset), nil
 	}
 
-	return int64(offset), nil
+	//the 64b_offset table follows the 32 bit offsets
+	n := int64(pi.FO[255])
+	large := int64(2*4+256*4) + n*(20+4+4) + int64(offset&^(1<<31))*8
+
+	var buf64 [8]byte
+	_, err = pi.ReadAt(buf64[:], large)
+	if err != nil {
+		return -1, fmt.Errorf("git: io error: %v", err)
+	}
+
+	return int64(binary.BigEndian.Uint64(buf64[:])), nil
 }
 
 func (pi *PackIndex) findSHA1(target SHA1) (int, error) {
@@ -269,24 +287,80 @@ func OpenPackFile(path string) (*PackFile, error) {
 	var header PackHeader
 	err = binary.Read(osfd, binary.BigEndian, &header)
 	if err != nil {
+		osfd.Close()
 		return nil, fmt.Errorf("git: could not read header: %v", err)
 	}
 
 	if string(header.Sig[:]) != "PACK" {
+		osfd.Close()
 		return nil, fmt.Errorf("git: packfile signature error")
 	}
 
 	if header.Version != 2 {
+		osfd.Close()
 		return nil, fmt.Errorf("git: unsupported packfile version")
 	}
 
-	fd := &PackFile{File: osfd,
+	fd := &PackFile{fd: osfd, path: path,
 		Version:  header.Version,
-		ObjCount: header.Objects}
+		ObjCount: header.Objects,
+		used:     time.Now().UnixNano()}
 
 	return fd, nil
 }
 
+//Name returns the path of the pack file.
+func (pf *PackFile) Name() string {
+	return pf.path
+}
+
+//ReadAt reads len(data) bytes from the pack file
+//at offset off. If the file has been released,
+//it is opened again.
+func (pf *PackFile) ReadAt(data []byte, off int64) (int, error) {
+	atomic.StoreInt64(&pf.used, time.Now().UnixNano())
+
+	for {
+		pf.mu.RLock()
+		if fd := pf.fd; fd != nil {
+			n, err := fd.ReadAt(data, off)
+			pf.mu.RUnlock()
+			return n, err
+		}
+		pf.mu.RUnlock()
+
+		pf.mu.Lock()
+		if pf.fd == nil {
+			fd, err := os.Open(pf.path)
+			if err != nil {
+				pf.mu.Unlock()
+				return 0, fmt.Errorf("git: could not reopen pack: %v", err)
+			}
+			pf.fd = fd
+		}
+		pf.mu.Unlock()
+	}
+}
+
+//Close releases the underlying file, see ReadAt.
+func (pf *PackFile) Close() error {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	if pf.fd == nil {
+		return nil
+	}
+
+	err := pf.fd.Close()
+	pf.fd = nil
+	return err
+}
+
+//idle returns for how long the pack file has not been read from.
+func (pf *PackFile) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&pf.used)))
+}
+
 func (pf *PackFile) readRawObject(offset int64) (gitObject, error) {
 	r := newPackReader(pf, offset)
 
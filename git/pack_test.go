@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -136,3 +139,44 @@ func TestPackBasic(t *testing.T) {
 		}
 	}
 }
+
+func TestPackIndexLargeOffsets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gin-git-idx")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	//offsets beyond 2 GiB go to the table of 64 bit offsets
+	offsets := []int64{12, 0x7FFFFFFF, 0x80000000, 5<<32 + 7}
+	var objects []*packObject
+	for i, off := range offsets {
+		id := SHA1{byte(0xF0 - i*0x10), byte(i)}
+		objects = append(objects, &packObject{id: id, offset: off, crc: uint32(i)})
+	}
+
+	path := filepath.Join(dir, "pack-test.idx")
+	fd, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("could not create index: %v", err)
+	}
+
+	err = writePackIndex(fd, objects, SHA1{})
+	fd.Close()
+	if err != nil {
+		t.Fatalf("could not write index: %v", err)
+	}
+
+	idx, err := PackIndexOpen(path)
+	if err != nil {
+		t.Fatalf("could not open index: %v", err)
+	}
+	defer idx.Close()
+
+	for i, obj := range objects {
+		off, err := idx.FindOffset(obj.id)
+		if err != nil || off != offsets[i] {
+			t.Fatalf("unexpected offset of %s: %d (%v), expected %d", obj.id, off, err, offsets[i])
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//packRegistry keeps the pack indices and pack files of one
+//object directory open between object lookups. It watches the
+//pack directory for changes, so that packs added by a push or
+//removed by a repack are picked up. If a multi-pack-index is
+//present, it is used for all the packs it covers.
+//It is safe for concurrent use.
+type packRegistry struct {
+	dir  string    //the "objects/pack" directory
+	used time.Time //guarded by registries
+
+	mu    sync.RWMutex
+	packs []*pack //packs not covered by midx
+	mtime time.Time
+
+	midx      *MultiPackIndex
+	midxPacks []*pack //indexed by pack-int-id
+
+	retired []*pack //packs gone from dir, see closeRetired
+}
+
+//pack is an open pack index together with its pack file.
+//For packs covered by the multi-pack-index, idx may be nil.
+type pack struct {
+	name string //path to the pack, without ".idx" or ".pack"
+
+	idx *PackIndex
+	pf  *PackFile
+}
+
+//close closes the files of the pack. Since the pack file
+//is re-opened on demand, objects read from it stay usable.
+func (p *pack) close() {
+	if p.idx != nil {
+		p.idx.Close()
+		p.idx = nil
+	}
+	p.pf.Close()
+}
+
+//packRegistryMax is the maximum number of registries, i.e.
+//repositories, whose packs are kept open. The least recently
+//used ones are closed beyond that. Registries not used for
+//packIdleTimeout are closed as well.
+var (
+	packRegistryMax = 256
+	packIdleTimeout = 5 * time.Minute
+)
+
+//registries maps the absolute path of pack directories
+//to their packRegistry, so all Repository values for the
+//same repository share the open files.
+var registries = struct {
+	sync.Mutex
+	m     map[string]*packRegistry
+	swept time.Time //last check for idle registries
+}{m: make(map[string]*packRegistry)}
+
+//packRegistryFor returns the (shared) registry for the pack
+//directory in the object directory objdir.
+func packRegistryFor(objdir string) *packRegistry {
+	dir := filepath.Join(objdir, "pack")
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	registries.Lock()
+	defer registries.Unlock()
+
+	reg, ok := registries.m[dir]
+	if !ok {
+		reg = &packRegistry{dir: dir}
+		registries.m[dir] = reg
+	}
+
+	now := time.Now()
+	reg.used = now
+	evictRegistries(now)
+
+	return reg
+}
+
+//evictRegistries closes and removes the registries that have been
+//idle for too long and, if there are still too many, the least
+//recently used ones. Must be called with registries locked.
+//NB: A registry might still be in use while it is closed, which
+// is fine, since it will just open the packs again; these files
+// are then closed by the finalizer of os.File.
+func evictRegistries(now time.Time) {
+	if now.Sub(registries.swept) > packIdleTimeout {
+		registries.swept = now
+		for dir, reg := range registries.m {
+			if now.Sub(reg.used) > packIdleTimeout {
+				delete(registries.m, dir)
+				reg.close()
+				continue
+			}
+
+			reg.mu.Lock()
+			reg.closeRetired()
+			reg.mu.Unlock()
+		}
+	}
+
+	for len(registries.m) > packRegistryMax {
+		var lru *packRegistry
+		for _, reg := range registries.m {
+			if lru == nil || reg.used.Before(lru.used) {
+				lru = reg
+			}
+		}
+
+		delete(registries.m, lru.dir)
+		lru.close()
+	}
+}
+
+//close closes all packs of the registry.
+func (reg *packRegistry) close() {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, packs := range [][]*pack{reg.packs, reg.midxPacks, reg.retired} {
+		for _, p := range packs {
+			p.close()
+		}
+	}
+
+	reg.packs, reg.retired, reg.mtime = nil, nil, time.Time{}
+	reg.setMultiPackIndex(nil, nil)
+}
+
+//closeRetired closes the packs that are gone from the pack directory
+//as soon as they have not been read from for packIdleTimeout, since
+//objects handed out earlier might still be reading from them and the
+//files cannot be opened again. Must be called with reg.mu held for
+//writing.
+func (reg *packRegistry) closeRetired() {
+	retired := reg.retired[:0]
+	for _, p := range reg.retired {
+		if p.pf.idle() < packIdleTimeout {
+			retired = append(retired, p)
+			continue
+		}
+		p.close()
+	}
+	reg.retired = retired
+}
+
+//packs returns the pack registry of the repository.
+func (repo *Repository) packs() *packRegistry {
+	return packRegistryFor(filepath.Join(repo.Path, "objects"))
+}
+
+//refresh rescans the pack directory. Unless force is true,
+//the scan is skipped if the directory has not been modified
+//since the last scan.
+func (reg *packRegistry) refresh(force bool) error {
+	fi, err := os.Stat(reg.dir)
+	if err != nil && os.IsNotExist(err) {
+		//no pack dir, i.e. no packs (yet)
+		reg.mu.Lock()
+		reg.retire(nil, nil)
+		reg.packs, reg.mtime = nil, time.Time{}
+		reg.setMultiPackIndex(nil, nil)
+		reg.mu.Unlock()
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("git: could not stat pack dir: %v", err)
+	}
+
+	reg.mu.RLock()
+	current := fi.ModTime().Equal(reg.mtime) && reg.packs != nil
+	reg.mu.RUnlock()
+
+	if current && !force {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(reg.dir, "*.idx"))
+	if err != nil {
+		return err
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	known := make(map[string]*pack, len(reg.packs))
+	for _, p := range reg.packs {
+		known[p.name] = p
+	}
+	for _, p := range reg.midxPacks {
+		known[p.name] = p
+	}
+
+	midx, covered := reg.loadMultiPackIndex(known)
+	inMidx := make(map[string]bool, len(covered))
+	for _, p := range covered {
+		inMidx[p.name] = true
+	}
+
+	packs := make([]*pack, 0, len(files))
+	for _, f := range files {
+		name := f[:len(f)-4]
+
+		if inMidx[name] {
+			continue
+		} else if p, ok := known[name]; ok && p.idx != nil {
+			packs = append(packs, p)
+			continue
+		}
+
+		p, err := openPack(name)
+		if err != nil {
+			//the index might be written, but the pack not
+			// (yet), we just skip it and try again next time
+			continue
+		}
+
+		packs = append(packs, p)
+	}
+
+	reg.retire(packs, covered)
+	reg.packs = packs
+	reg.mtime = fi.ModTime()
+	reg.setMultiPackIndex(midx, covered)
+
+	return nil
+}
+
+//retire moves the packs that are not in any of the new lists of
+//packs to the retired ones, which are closed once they are idle.
+//Must be called with reg.mu held for writing.
+func (reg *packRegistry) retire(lists ...[]*pack) {
+	keep := make(map[*pack]bool)
+	for _, packs := range lists {
+		for _, p := range packs {
+			keep[p] = true
+		}
+	}
+
+	for _, packs := range [][]*pack{reg.packs, reg.midxPacks} {
+		for _, p := range packs {
+			if keep[p] {
+				continue
+			}
+
+			//the index is only used with reg.mu held
+			if p.idx != nil {
+				p.idx.Close()
+				p.idx = nil
+			}
+			reg.retired = append(reg.retired, p)
+		}
+	}
+
+	reg.closeRetired()
+}
+
+//loadMultiPackIndex opens the multi-pack-index, if there is any,
+//and the pack files covered by it. If any of them cannot be opened
+//the multi-pack-index is ignored, i.e. all packs will be accessed
+//via their own index.
+func (reg *packRegistry) loadMultiPackIndex(known map[string]*pack) (*MultiPackIndex, []*pack) {
+	midx, err := MultiPackIndexOpen(filepath.Join(reg.dir, "multi-pack-index"))
+	if err != nil {
+		return nil, nil
+	}
+
+	covered := make([]*pack, len(midx.Packs))
+	for i := range midx.Packs {
+		name := filepath.Join(reg.dir, midx.PackName(i))
+
+		if p, ok := known[name]; ok {
+			covered[i] = p
+			continue
+		}
+
+		pf, err := OpenPackFile(name + ".pack")
+		if err != nil {
+			midx.Close()
+			return nil, nil
+		}
+
+		covered[i] = &pack{name: name, pf: pf}
+	}
+
+	return midx, covered
+}
+
+//setMultiPackIndex replaces the current multi-pack-index.
+//Must be called with reg.mu held for writing.
+func (reg *packRegistry) setMultiPackIndex(midx *MultiPackIndex, covered []*pack) {
+	//the midx itself is only used while holding reg.mu,
+	//so it is safe to close the old one here
+	if old := reg.midx; old != nil && old != midx {
+		old.Close()
+	}
+
+	reg.midx, reg.midxPacks = midx, covered
+}
+
+func openPack(name string) (*pack, error) {
+	idx, err := PackIndexOpen(name + ".idx")
+	if err != nil {
+		return nil, err
+	}
+
+	pf, err := idx.OpenPackFile()
+	if err != nil {
+		idx.Close()
+		return nil, err
+	}
+
+	return &pack{name: name, idx: idx, pf: pf}, nil
+}
+
+//lookup searches all currently known packs for the object.
+func (reg *packRegistry) lookup(id SHA1) (*PackFile, int64, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	if reg.midx != nil {
+		pid, off, err := reg.midx.FindOffset(id)
+		if err == nil {
+			return reg.midxPacks[pid].pf, off, true
+		}
+	}
+
+	for _, p := range reg.packs {
+		off, err := p.idx.FindOffset(id)
+		if err != nil {
+			continue
+		}
+
+		return p.pf, off, true
+	}
+
+	return nil, 0, false
+}
+
+//findObject returns the pack file and the offset therein for
+//the object with the given id. If the object cannot be found
+//in the known packs, the pack directory is rescanned once.
+func (reg *packRegistry) findObject(id SHA1) (*PackFile, int64, error) {
+	err := reg.refresh(false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pf, off, ok := reg.lookup(id)
+	if ok {
+		return pf, off, nil
+	}
+
+	err = reg.refresh(true)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pf, off, ok = reg.lookup(id)
+	if ok {
+		return pf, off, nil
+	}
+
+	// from inspecting the os.isNotExist source it
+	// seems that if we have "not found" in the message
+	// os.IsNotExist() report true, which is what we want
+	return nil, 0, fmt.Errorf("git: object not found")
+}
+
+//findPackedObject searches the packs of the object directories dirs,
+//in order, for the object id. Like findObject, the pack directories
+//are rescanned once if the object cannot be found in the known packs.
+func findPackedObject(dirs []string, id SHA1) (*PackFile, int64, error) {
+	for _, force := range []bool{false, true} {
+		for _, dir := range dirs {
+			reg := packRegistryFor(dir)
+			err := reg.refresh(force)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			pf, off, ok := reg.lookup(id)
+			if ok {
+				return pf, off, nil
+			}
+		}
+	}
+
+	return nil, 0, fmt.Errorf("git: object not found")
+}
+
+//list returns the paths (without ".idx") of all known packs.
+func (reg *packRegistry) list() ([]string, error) {
+	err := reg.refresh(false)
+	if err != nil {
+		return nil, err
+	}
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	var names []string
+	for _, p := range reg.midxPacks {
+		names = append(names, p.name)
+	}
+	for _, p := range reg.packs {
+		names = append(names, p.name)
+	}
+
+	return names, nil
+}
+
+//findPrefix adds the ids of all objects in the packs that start
+//with the hex encoded prefix to found.
+func (reg *packRegistry) findPrefix(prefix string, found map[SHA1]bool) error {
+	err := reg.refresh(false)
+	if err != nil {
+		return err
+	}
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	if reg.midx != nil {
+		err = prefixMatches(reg.midx.FO, reg.midx.ReadSHA1, prefix, found)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range reg.packs {
+		err = prefixMatches(p.idx.FO, p.idx.ReadSHA1, prefix, found)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testRepo is a non-bare git repository in a temporary
+//directory, created with the git binary. Repository
+//points to its ".git" directory.
+type testRepo struct {
+	*Repository
+
+	t    *testing.T
+	work string
+	tick int
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("[W] Could not find git binary. Skipping test")
+	}
+
+	dir, err := ioutil.TempDir("", "gin-git-test")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+
+	tr := &testRepo{t: t, work: dir}
+	tr.git("init", "-q")
+	tr.Repository = &Repository{Path: filepath.Join(dir, ".git")}
+	return tr
+}
+
+func (tr *testRepo) cleanup() {
+	err := os.RemoveAll(tr.work)
+	if err != nil {
+		tr.t.Logf("[W] Could not remove test git dir: %q", tr.work)
+	}
+}
+
+//git runs git in the working tree and returns the trimmed output.
+//Author and committer dates advance by one minute with every call.
+func (tr *testRepo) git(args ...string) string {
+	tr.tick++
+	date := fmt.Sprintf("%d +0200", 1500000000+tr.tick*60)
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = tr.work
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=A U Thor", "GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_COMMITTER_NAME=C O Mitter", "GIT_COMMITTER_EMAIL=committer@example.com",
+		"GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date,
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+tr.work)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		tr.t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+//write writes (or, if content is empty, removes) the files in the working tree.
+func (tr *testRepo) write(files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(tr.work, name)
+
+		if content == "" {
+			tr.git("rm", "-q", "--", name)
+			continue
+		}
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			tr.t.Fatalf("could not create dir: %v", err)
+		}
+
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			tr.t.Fatalf("could not write file: %v", err)
+		}
+	}
+}
+
+//commit writes the files, commits all changes and returns the id of the new commit.
+func (tr *testRepo) commit(msg string, files map[string]string) SHA1 {
+	tr.write(files)
+	tr.git("add", "-A")
+	tr.git("commit", "-q", "--allow-empty", "-m", msg)
+	return tr.revParse("HEAD")
+}
+
+func (tr *testRepo) revParse(rev string) SHA1 {
+	id, err := ParseSHA1(tr.git("rev-parse", rev))
+	if err != nil {
+		tr.t.Fatalf("could not parse rev-parse output: %v", err)
+	}
+	return id
+}
+
+func TestPackRegistry(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("first", map[string]string{"a.txt": "a\n"})
+	tr.git("repack", "-a", "-d", "-q")
+
+	reg := tr.packs()
+	packs, err := reg.list()
+	if err != nil {
+		t.Fatalf("could not list packs: %v", err)
+	} else if len(packs) != 1 {
+		t.Fatalf("expected 1 pack, got %d", len(packs))
+	}
+
+	if other := (&Repository{Path: tr.Path + "/"}).packs(); other != reg {
+		t.Fatalf("expected the same registry for the same repository")
+	}
+
+	obj, err := tr.OpenObject(first)
+	if err != nil {
+		t.Fatalf("could not open packed commit: %v", err)
+	}
+	obj.Close()
+
+	// a new pack: the object is only found after a rescan
+	second := tr.commit("second", map[string]string{"b.txt": "b\n"})
+	tr.git("repack", "-d", "-q")
+
+	obj, err = tr.OpenObject(second)
+	if err != nil {
+		t.Fatalf("could not open commit in new pack: %v", err)
+	}
+	obj.Close()
+
+	// a full repack removes the old packs
+	tr.git("repack", "-a", "-d", "-q")
+
+	obj, err = tr.OpenObject(first)
+	if err != nil {
+		t.Fatalf("could not open commit after repack: %v", err)
+	}
+	obj.Close()
+
+	err = reg.refresh(true)
+	if err != nil {
+		t.Fatalf("could not refresh registry: %v", err)
+	}
+
+	packs, err = reg.list()
+	if err != nil {
+		t.Fatalf("could not list packs: %v", err)
+	} else if len(packs) != 1 {
+		t.Fatalf("expected 1 pack after full repack, got %d", len(packs))
+	}
+
+	// concurrent lookups
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 50; k++ {
+				for _, id := range []SHA1{first, second} {
+					obj, err := tr.OpenObject(id)
+					if err != nil {
+						t.Errorf("concurrent OpenObject(%s) failed: %v", id, err)
+						return
+					}
+					obj.Close()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var missing SHA1
+	_, err = tr.OpenObject(missing)
+	if err == nil {
+		t.Fatalf("found object that should not exist")
+	}
+}
+
+func TestPackRegistryEviction(t *testing.T) {
+	defer func(max int, idle time.Duration) {
+		packRegistryMax, packIdleTimeout = max, idle
+	}(packRegistryMax, packIdleTimeout)
+	packRegistryMax = 2
+
+	var repos []*testRepo
+	var blobs []SHA1
+	for i := 0; i < 3; i++ {
+		tr := newTestRepo(t)
+		defer tr.cleanup()
+
+		tr.commit("first", map[string]string{"a.txt": fmt.Sprintf("%d\n", i)})
+		tr.git("repack", "-a", "-d", "-q")
+		repos, blobs = append(repos, tr), append(blobs, tr.revParse("HEAD:a.txt"))
+	}
+
+	first := repos[0].packs()
+	if err := first.refresh(false); err != nil || len(first.packs) != 1 {
+		t.Fatalf("unexpected packs %v (%v)", first.packs, err)
+	}
+	p := first.packs[0]
+
+	obj, err := repos[0].OpenObject(blobs[0])
+	if err != nil {
+		t.Fatalf("could not open blob: %v", err)
+	}
+	defer obj.Close()
+
+	//the least recently used registry is closed
+	repos[1].packs()
+	repos[2].packs()
+
+	registries.Lock()
+	_, ok := registries.m[first.dir]
+	n := len(registries.m)
+	registries.Unlock()
+
+	if ok || n > packRegistryMax {
+		t.Fatalf("registry not evicted, %d registries", n)
+	} else if p.idx != nil || p.pf.fd != nil {
+		t.Fatalf("pack of evicted registry not closed")
+	}
+
+	//objects read before stay usable
+	data, err := ioutil.ReadAll(obj.(*Blob))
+	if err != nil || string(data) != "0\n" {
+		t.Fatalf("unexpected blob content %q (%v)", data, err)
+	}
+
+	//packs gone after a repack are closed once idle
+	tr := repos[2]
+	reg := tr.packs()
+	if err := reg.refresh(false); err != nil || len(reg.packs) != 1 {
+		t.Fatalf("unexpected packs %v (%v)", reg.packs, err)
+	}
+	old := reg.packs[0]
+
+	tr.commit("second", map[string]string{"b.txt": "b\n"})
+	tr.git("repack", "-a", "-d", "-q")
+
+	if err := reg.refresh(true); err != nil || len(reg.retired) != 1 || old.pf.fd == nil {
+		t.Fatalf("expected the old pack to be retired, but still open (%v)", err)
+	}
+
+	packIdleTimeout = 0
+	if err := reg.refresh(true); err != nil || len(reg.retired) != 0 || old.pf.fd != nil {
+		t.Fatalf("expected the old pack to be closed (%v)", err)
+	}
+}
@@ -0,0 +1,628 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"unicode"
+)
+
+//Defaults for the delta search of PackWriter.
+const (
+	DefaultPackWindow = 10
+	DefaultPackDepth  = 50
+
+	//DefaultBigFileThreshold is the size (in bytes) above which
+	//objects are not considered for deltas, like git's
+	//core.bigFileThreshold.
+	DefaultBigFileThreshold = 32 << 20
+)
+
+//deltaBlockSize is the size of the blocks of the base object that
+//are indexed for the delta search; matches must be at least that
+//long to be used.
+const deltaBlockSize = 16
+
+//maxDeltaCopy is the largest size of a single copy operation.
+//Larger sizes are possible in the delta format, but git itself
+//never writes them.
+const maxDeltaCopy = 0x10000
+
+//maxDeltaBucket limits the number of base offsets per hash,
+//so that highly repetitive data does not slow down the search.
+const maxDeltaBucket = 64
+
+//PackWriter writes a set of objects as version 2 pack file together
+//with its (version 2) index. If Window is greater than zero, objects
+//are stored as OFS_DELTA against one of the Window previous objects
+//of the same type, if that is smaller. Objects are sorted by type,
+//name hint and size for that, like git does. Blobs larger than
+//BigFileThreshold are neither deltified nor used as delta base, but
+//are streamed from the repository, so they are never read into memory
+//as a whole.
+type PackWriter struct {
+	Window   int //number of objects to try as delta base, 0 disables deltas
+	MaxDepth int //maximal length of the delta chains
+
+	BigFileThreshold int64 //size of objects not to deltify, 0 means default
+
+	repo    *Repository
+	objects []*packObject
+	seen    map[SHA1]bool
+}
+
+//packObject is an object to be written by the PackWriter.
+type packObject struct {
+	id    SHA1
+	otype ObjectType
+	size  int64
+	hint  uint32
+
+	data []byte //only for objects added via AddData
+
+	offset int64
+	crc    uint32
+	depth  int
+}
+
+//packBase is an object in the delta search window.
+type packBase struct {
+	obj   *packObject
+	data  []byte
+	index map[uint32][]int
+}
+
+//NewPackWriter creates a PackWriter for objects of repo, which can be
+//nil if all objects are added via AddData. Deltas are enabled with the
+//default window and depth.
+func NewPackWriter(repo *Repository) *PackWriter {
+	return &PackWriter{
+		Window:           DefaultPackWindow,
+		MaxDepth:         DefaultPackDepth,
+		BigFileThreshold: DefaultBigFileThreshold,
+		repo:             repo,
+		seen:             make(map[SHA1]bool),
+	}
+}
+
+//Len returns the number of objects added so far.
+func (pw *PackWriter) Len() int {
+	return len(pw.objects)
+}
+
+//AddObject adds the object id of the repository to the pack. The hint,
+//usually the path of the object, is used to group similar objects for
+//the delta search. Objects that were already added are ignored.
+func (pw *PackWriter) AddObject(id SHA1, hint string) error {
+	if pw.seen[id] {
+		return nil
+	} else if pw.repo == nil {
+		return fmt.Errorf("git: pack writer has no repository")
+	}
+
+	otype, size, err := pw.repo.readObjectInfo(id)
+	if err != nil {
+		return err
+	}
+
+	pw.add(&packObject{id: id, otype: otype, size: size, hint: packNameHash(hint)})
+	return nil
+}
+
+//AddData adds an object of type otype with the given data, i.e.
+//without the object header, to the pack and returns its id.
+func (pw *PackWriter) AddData(otype ObjectType, data []byte) SHA1 {
+	id := hashObject(otype, data)
+	if !pw.seen[id] {
+		pw.add(&packObject{id: id, otype: otype, size: int64(len(data)), data: data})
+	}
+	return id
+}
+
+func (pw *PackWriter) add(obj *packObject) {
+	pw.seen[obj.id] = true
+	pw.objects = append(pw.objects, obj)
+}
+
+//packNameHash is git's hash for name hints, which sorts files
+//with the same name (and similar suffixes) next to each other.
+func packNameHash(name string) uint32 {
+	var h uint32
+	for _, c := range []byte(name) {
+		if unicode.IsSpace(rune(c)) {
+			continue
+		}
+		h = (h >> 2) + uint32(c)<<24
+	}
+	return h
+}
+
+type byPackOrder []*packObject
+
+func (o byPackOrder) Len() int      { return len(o) }
+func (o byPackOrder) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o byPackOrder) Less(i, j int) bool {
+	a, b := o[i], o[j]
+	if a.otype != b.otype {
+		return a.otype < b.otype
+	} else if a.hint != b.hint {
+		return a.hint < b.hint
+	}
+	return a.size > b.size
+}
+
+type bySHA1 []*packObject
+
+func (o bySHA1) Len() int           { return len(o) }
+func (o bySHA1) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o bySHA1) Less(i, j int) bool { return bytes.Compare(o[i].id[:], o[j].id[:]) < 0 }
+
+//packHashWriter keeps track of the offset and the checksum
+//of the data written to the underlying writer.
+type packHashWriter struct {
+	w   io.Writer
+	sum hash.Hash
+	crc hash.Hash32
+	off int64
+}
+
+func (p *packHashWriter) Write(data []byte) (int, error) {
+	n, err := p.w.Write(data)
+	p.sum.Write(data[:n])
+	p.crc.Write(data[:n])
+	p.off += int64(n)
+	return n, err
+}
+
+//Write writes the pack to pack and the index to idx and returns the
+//checksum of the pack, which is also used for its file name by git.
+func (pw *PackWriter) Write(pack, idx io.Writer) (SHA1, error) {
+	var checksum SHA1
+
+	objects := pw.objects
+	if pw.Window > 0 {
+		//NB: in this order, delta bases are always written
+		// before the deltas, as required for OFS_DELTA
+		objects = make([]*packObject, len(pw.objects))
+		copy(objects, pw.objects)
+		sort.Stable(byPackOrder(objects))
+	}
+
+	out := &packHashWriter{w: pack, sum: sha1.New(), crc: crc32.NewIEEE()}
+	header := PackHeader{Version: 2, Objects: uint32(len(objects))}
+	copy(header.Sig[:], "PACK")
+
+	err := binary.Write(out, binary.BigEndian, &header)
+	if err != nil {
+		return checksum, err
+	}
+
+	threshold := pw.BigFileThreshold
+	if threshold <= 0 {
+		threshold = DefaultBigFileThreshold
+	}
+
+	var window []*packBase
+	for _, obj := range objects {
+		if obj.size > threshold && obj.data == nil && obj.otype == ObjBlob {
+			out.crc.Reset()
+			obj.offset = out.off
+
+			err = pw.writeBigBlob(out, obj)
+			if err != nil {
+				return checksum, err
+			}
+			obj.crc = out.crc.Sum32()
+			continue
+		}
+
+		data := obj.data
+		if data == nil {
+			var otype ObjectType
+			otype, data, err = pw.repo.readObjectData(obj.id)
+			if err != nil {
+				return checksum, err
+			} else if otype != obj.otype {
+				return checksum, fmt.Errorf("git: object %s changed type", obj.id)
+			}
+		}
+
+		var base *packObject
+		var delta []byte
+		if obj.size <= threshold {
+			base, delta = pw.findDelta(window, obj, data)
+		}
+
+		out.crc.Reset()
+		obj.offset = out.off
+
+		if base != nil {
+			obj.depth = base.depth + 1
+			err = writePackEntry(out, ObjOFSDelta, delta, obj.offset-base.offset)
+		} else {
+			err = writePackEntry(out, obj.otype, data, 0)
+		}
+
+		if err != nil {
+			return checksum, err
+		}
+		obj.crc = out.crc.Sum32()
+
+		if pw.Window > 0 && obj.size <= threshold {
+			if len(window) == pw.Window {
+				window = window[1:]
+			}
+			window = append(window, &packBase{obj: obj, data: data})
+		}
+	}
+
+	copy(checksum[:], out.sum.Sum(nil))
+	_, err = pack.Write(checksum[:])
+	if err != nil {
+		return checksum, err
+	}
+
+	return checksum, writePackIndex(idx, objects, checksum)
+}
+
+//writeBigBlob writes the blob obj as a whole, i.e. not as delta,
+//with its data streamed from the repository.
+func (pw *PackWriter) writeBigBlob(w io.Writer, obj *packObject) error {
+	br, err := pw.repo.OpenBlobReader(obj.id)
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+
+	if br.Size() != obj.size {
+		return fmt.Errorf("git: object %s changed size", obj.id)
+	}
+
+	err = writePackEntryHeader(w, ObjBlob, obj.size, 0)
+	if err != nil {
+		return err
+	}
+
+	zw := zlib.NewWriter(w)
+	n, err := io.Copy(zw, br)
+	if err != nil {
+		return err
+	} else if n != obj.size {
+		return io.ErrUnexpectedEOF
+	}
+
+	return zw.Close()
+}
+
+//findDelta searches the window for the best delta base for obj,
+//i.e. the one yielding the smallest delta. It returns nil, if no
+//delta is small enough to be worth it.
+func (pw *PackWriter) findDelta(window []*packBase, obj *packObject, data []byte) (*packObject, []byte) {
+	depth := pw.MaxDepth
+	if depth <= 0 {
+		depth = DefaultPackDepth
+	}
+
+	//like git: the delta must be at most half the size
+	//of the object, minus the overhead of the delta entry
+	limit := len(data)/2 - 20
+
+	var best *packObject
+	var delta []byte
+	for i := len(window) - 1; i >= 0 && limit > 0; i-- {
+		b := window[i]
+		if b.obj.otype != obj.otype || b.obj.depth >= depth {
+			continue
+		} else if len(b.data) < len(data)/32 || len(data) < len(b.data)/32 {
+			continue
+		}
+
+		if b.index == nil {
+			b.index = makeDeltaIndex(b.data)
+		}
+
+		d := makeDelta(b.data, b.index, data, limit)
+		if d != nil {
+			best, delta = b.obj, d
+			limit = len(d) - 1
+		}
+	}
+
+	return best, delta
+}
+
+//makeDeltaIndex hashes the non-overlapping blocks of base.
+func makeDeltaIndex(base []byte) map[uint32][]int {
+	index := make(map[uint32][]int)
+	for off := 0; off+deltaBlockSize <= len(base); off += deltaBlockSize {
+		h := deltaBlockHash(base[off : off+deltaBlockSize])
+		if len(index[h]) < maxDeltaBucket {
+			index[h] = append(index[h], off)
+		}
+	}
+	return index
+}
+
+func deltaBlockHash(block []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range block {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h
+}
+
+//makeDelta computes the delta that turns base into target, in git's
+//delta format. If the delta gets larger than limit bytes, nil is
+//returned.
+func makeDelta(base []byte, index map[uint32][]int, target []byte, limit int) []byte {
+	var buf bytes.Buffer
+	writeDeltaSize(&buf, int64(len(base)))
+	writeDeltaSize(&buf, int64(len(target)))
+
+	pos, lit := 0, 0
+	for pos+deltaBlockSize <= len(target) && buf.Len() <= limit {
+		h := deltaBlockHash(target[pos : pos+deltaBlockSize])
+
+		start, length := 0, 0
+		for _, off := range index[h] {
+			n := 0
+			for off+n < len(base) && pos+n < len(target) && base[off+n] == target[pos+n] {
+				n++
+			}
+			if n > length {
+				start, length = off, n
+			}
+		}
+
+		if length < deltaBlockSize {
+			pos++
+			continue
+		}
+
+		//extend the match backwards into the pending insert
+		for start > 0 && pos > lit && base[start-1] == target[pos-1] {
+			start--
+			pos--
+			length++
+		}
+
+		writeDeltaInsert(&buf, target[lit:pos])
+		writeDeltaCopy(&buf, start, length)
+		pos += length
+		lit = pos
+	}
+
+	writeDeltaInsert(&buf, target[lit:])
+	if buf.Len() > limit {
+		return nil
+	}
+
+	return buf.Bytes()
+}
+
+//writeDeltaSize writes the size in the format of delta headers,
+//seven bits at a time, least significant first.
+func writeDeltaSize(buf *bytes.Buffer, size int64) {
+	for size >= 0x80 {
+		buf.WriteByte(byte(size) | 0x80)
+		size >>= 7
+	}
+	buf.WriteByte(byte(size))
+}
+
+func writeDeltaInsert(buf *bytes.Buffer, data []byte) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > 0x7F {
+			n = 0x7F
+		}
+		buf.WriteByte(byte(n))
+		buf.Write(data[:n])
+		data = data[n:]
+	}
+}
+
+//writeDeltaCopy writes copy operations, see Delta.NextOp for
+//the format. A size of 0x10000 is encoded as zero.
+func writeDeltaCopy(buf *bytes.Buffer, offset, size int) {
+	for size > 0 {
+		n := size
+		if n > maxDeltaCopy {
+			n = maxDeltaCopy
+		}
+
+		var args [7]byte
+		op, k := byte(0x80), 0
+		for i := uint(0); i < 4; i++ {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				op |= 1 << i
+				args[k] = b
+				k++
+			}
+		}
+		for i := uint(0); i < 3; i++ {
+			if b := byte(n >> (8 * i)); b != 0 && n != maxDeltaCopy {
+				op |= 1 << (4 + i)
+				args[k] = b
+				k++
+			}
+		}
+
+		buf.WriteByte(op)
+		buf.Write(args[:k])
+
+		offset += n
+		size -= n
+	}
+}
+
+//writePackEntry writes the object header, the base offset for
+//OFS_DELTA objects and the compressed data.
+func writePackEntry(w io.Writer, otype ObjectType, data []byte, baseOff int64) error {
+	err := writePackEntryHeader(w, otype, int64(len(data)), baseOff)
+	if err != nil {
+		return err
+	}
+
+	zw := zlib.NewWriter(w)
+	_, err = zw.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+//writePackEntryHeader writes the object header, for an object
+//of otype with (uncompressed) size bytes, and the base offset
+//for OFS_DELTA objects.
+func writePackEntryHeader(w io.Writer, otype ObjectType, datasize int64, baseOff int64) error {
+	//object header format, see PackFile.readRawObject:
+	//[mttt xxxx] followed by [mxxx xxxx]*
+	var header [10 + 10]byte
+	size := uint64(datasize)
+	b := byte(otype)<<4 | byte(size&0x0F)
+	size >>= 4
+
+	n := 0
+	for size != 0 {
+		header[n] = b | 0x80
+		n++
+		b = byte(size & 0x7F)
+		size >>= 7
+	}
+	header[n] = b
+	n++
+
+	if otype == ObjOFSDelta {
+		//big-endian, with one added for every continuation
+		//byte, see readVarint
+		var ofs [10]byte
+		i := len(ofs) - 1
+		ofs[i] = byte(baseOff & 0x7F)
+		for baseOff >>= 7; baseOff != 0; baseOff >>= 7 {
+			baseOff--
+			i--
+			ofs[i] = byte(baseOff&0x7F) | 0x80
+		}
+		n += copy(header[n:], ofs[i:])
+	}
+
+	_, err := w.Write(header[:n])
+	return err
+}
+
+//writePackIndex writes the version 2 index for objects.
+func writePackIndex(w io.Writer, objects []*packObject, checksum SHA1) error {
+	sorted := make([]*packObject, len(objects))
+	copy(sorted, objects)
+	sort.Sort(bySHA1(sorted))
+
+	sum := sha1.New()
+	out := io.MultiWriter(w, sum)
+
+	var fo FanOut
+	for _, obj := range sorted {
+		for i := int(obj.id[0]); i < len(fo); i++ {
+			fo[i]++
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\377tOc")
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	binary.Write(&buf, binary.BigEndian, &fo)
+
+	for _, obj := range sorted {
+		buf.Write(obj.id[:])
+	}
+
+	for _, obj := range sorted {
+		binary.Write(&buf, binary.BigEndian, obj.crc)
+	}
+
+	//offsets that do not fit into 31 bits are stored
+	//in a separate table of 64 bit offsets
+	var large []uint64
+	for _, obj := range sorted {
+		off := uint32(obj.offset)
+		if obj.offset > 0x7FFFFFFF {
+			off = uint32(len(large)) | 0x80000000
+			large = append(large, uint64(obj.offset))
+		}
+		binary.Write(&buf, binary.BigEndian, off)
+	}
+
+	for _, off := range large {
+		binary.Write(&buf, binary.BigEndian, off)
+	}
+
+	buf.Write(checksum[:])
+
+	_, err := buf.WriteTo(out)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(sum.Sum(nil))
+	return err
+}
+
+//WritePack writes the pack of pw (and its index) to the pack
+//directory of the repository and returns its checksum. The
+//pack is visible to readers only once it is complete.
+func (repo *Repository) WritePack(pw *PackWriter) (SHA1, error) {
+	var checksum SHA1
+
+	dir := filepath.Join(repo.Path, "objects", "pack")
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return checksum, err
+	}
+
+	var tmp [2]*os.File
+	for i := range tmp {
+		tmp[i], err = ioutil.TempFile(dir, "tmp_pack_")
+		if err != nil {
+			break
+		}
+		defer os.Remove(tmp[i].Name())
+		defer tmp[i].Close()
+	}
+
+	if err == nil {
+		checksum, err = pw.Write(tmp[0], tmp[1])
+	}
+
+	for i := 0; i < len(tmp) && err == nil; i++ {
+		if err = tmp[i].Sync(); err == nil {
+			err = tmp[i].Close()
+		}
+	}
+
+	if err != nil {
+		return checksum, fmt.Errorf("git: could not write pack: %v", err)
+	}
+
+	//NB: the index is renamed last, since packs
+	// are found via their index files
+	name := filepath.Join(dir, "pack-"+checksum.String())
+	for i, ext := range []string{".pack", ".idx"} {
+		err = os.Rename(tmp[i].Name(), name+ext)
+		if err != nil {
+			return checksum, fmt.Errorf("git: could not write pack: %v", err)
+		}
+	}
+
+	return checksum, nil
+}
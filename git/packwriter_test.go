@@ -0,0 +1,233 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPackWriter(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	var lines []string
+	for i := 0; i < 300; i++ {
+		lines = append(lines, fmt.Sprintf("value %d = %d", i, i*i))
+	}
+
+	for i := 0; i < 5; i++ {
+		lines[i*50] = fmt.Sprintf("changed in %d", i)
+		tr.commit(fmt.Sprintf("commit %d", i), map[string]string{
+			"data.txt":       strings.Join(lines, "\n"),
+			"sub/small.txt":  fmt.Sprintf("small %d\n", i),
+			"sub/binary.bin": strings.Repeat(fmt.Sprintf("%c\x00", 'a'+i), 1000),
+		})
+	}
+	tr.git("tag", "-a", "-m", "a tag", "v1.0")
+
+	//pack some objects first, so that deltas are read as well
+	tr.git("repack", "-q", "-a", "-d")
+	tr.commit("loose", map[string]string{"loose.txt": "loose\n"})
+
+	type object struct {
+		id   SHA1
+		path string
+	}
+
+	var objects []object
+	for _, l := range strings.Split(tr.git("rev-list", "--objects", "--all"), "\n") {
+		fields := strings.SplitN(l, " ", 2)
+		oid, err := ParseSHA1(fields[0])
+		if err != nil {
+			t.Fatalf("could not parse rev-list output %q", l)
+		}
+
+		obj := object{id: oid}
+		if len(fields) > 1 {
+			obj.path = fields[1]
+		}
+		objects = append(objects, obj)
+	}
+
+	for _, window := range []int{0, DefaultPackWindow} {
+		dst := newTestRepo(t)
+		defer dst.cleanup()
+
+		pw := NewPackWriter(tr.Repository)
+		pw.Window = window
+		for _, obj := range objects {
+			err := pw.AddObject(obj.id, obj.path)
+			if err != nil {
+				t.Fatalf("could not add %s: %v", obj.id, err)
+			}
+		}
+
+		//adding objects twice is a noop
+		pw.AddObject(objects[0].id, "")
+		if pw.Len() != len(objects) {
+			t.Fatalf("expected %d objects, got %d", len(objects), pw.Len())
+		}
+
+		checksum, err := dst.WritePack(pw)
+		if err != nil {
+			t.Fatalf("WritePack failed: %v", err)
+		}
+
+		name := filepath.Join(dst.Path, "objects", "pack", "pack-"+checksum.String())
+		out := dst.git("verify-pack", "-v", name+".idx")
+		if deltas := strings.Contains(out, "chain length = 1:"); deltas != (window > 0) {
+			t.Fatalf("window %d: unexpected deltas in pack:\n%s", window, out)
+		}
+
+		//the index must be the same as the one git creates
+		dst.git("index-pack", "-o", "git.idx", name+".pack")
+		ours, err := ioutil.ReadFile(name + ".idx")
+		if err != nil {
+			t.Fatalf("could not read index: %v", err)
+		}
+
+		theirs, err := ioutil.ReadFile(filepath.Join(dst.work, "git.idx"))
+		if err != nil {
+			t.Fatalf("could not read index: %v", err)
+		}
+
+		if !bytes.Equal(ours, theirs) {
+			t.Fatalf("window %d: index differs from the one of git index-pack", window)
+		}
+
+		for _, obj := range objects {
+			otype, data, err := dst.readObjectData(obj.id)
+			if err != nil {
+				t.Fatalf("could not read %s from pack: %v", obj.id, err)
+			}
+
+			wtype, wanted, err := tr.readObjectData(obj.id)
+			if err != nil {
+				t.Fatalf("could not read %s: %v", obj.id, err)
+			}
+
+			if otype != wtype || !bytes.Equal(data, wanted) {
+				t.Fatalf("object %s differs after packing", obj.id)
+			}
+		}
+
+		if res := dst.git("cat-file", "-p", tr.revParse("v1.0:data.txt").String()); res != strings.Join(lines, "\n") {
+			t.Fatalf("unexpected content of data.txt from pack")
+		}
+	}
+}
+
+func TestPackWriterBigFiles(t *testing.T) {
+	defer func(size int64) { StreamBlobSize = size }(StreamBlobSize)
+	StreamBlobSize = 1024
+
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	var lines []string
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, fmt.Sprintf("value %d = %d", i, i*i))
+	}
+
+	tr.commit("first", map[string]string{"big.txt": strings.Join(lines, "\n"), "small.txt": "small\n"})
+	lines[1000] = "changed"
+	tr.commit("second", map[string]string{"big.txt": strings.Join(lines, "\n"), "small.txt": "small\nchanged\n"})
+
+	//the big blobs are read from deltas
+	tr.git("repack", "-q", "-a", "-d")
+
+	pw := NewPackWriter(tr.Repository)
+	pw.BigFileThreshold = 4096
+
+	var big []SHA1
+	for _, rev := range []string{"HEAD~1", "HEAD"} {
+		for _, path := range []string{"", ":big.txt", ":small.txt"} {
+			id := tr.revParse(rev + path)
+			if err := pw.AddObject(id, strings.TrimPrefix(path, ":")); err != nil {
+				t.Fatalf("could not add %s: %v", id, err)
+			}
+		}
+		if err := pw.AddObject(tr.revParse(rev+"^{tree}"), ""); err != nil {
+			t.Fatalf("could not add tree: %v", err)
+		}
+		big = append(big, tr.revParse(rev+":big.txt"))
+	}
+
+	dst := newTestRepo(t)
+	defer dst.cleanup()
+
+	checksum, err := dst.WritePack(pw)
+	if err != nil {
+		t.Fatalf("WritePack failed: %v", err)
+	}
+
+	name := filepath.Join(dst.Path, "objects", "pack", "pack-"+checksum.String())
+	for _, l := range strings.Split(dst.git("verify-pack", "-v", name+".idx"), "\n") {
+		fields := strings.Fields(l)
+		for _, id := range big {
+			if len(fields) > 5 && fields[0] == id.String() {
+				t.Fatalf("big blob %s stored as delta: %s", id, l)
+			}
+		}
+	}
+
+	for _, id := range big {
+		if dst.git("cat-file", "-p", id.String()) != tr.git("cat-file", "-p", id.String()) {
+			t.Fatalf("big blob %s differs after packing", id)
+		}
+	}
+}
+
+func TestPackWriterData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gin-git-test")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pw := NewPackWriter(nil)
+
+	var data []byte
+	for i := 0; i < 2000; i++ {
+		data = append(data, fmt.Sprintf("line %d\n", i)...)
+	}
+
+	a := pw.AddData(ObjBlob, data)
+	//a copy larger than 0x10000 and an offset > 0xFFFF
+	big := bytes.Repeat(data, 10)
+	b := pw.AddData(ObjBlob, append(big, "appended\n"...))
+	c := pw.AddData(ObjBlob, append([]byte("prepended\n"), big...))
+
+	if err := pw.AddObject(SHA1{}, ""); err == nil {
+		t.Fatalf("expected error for AddObject without repository")
+	}
+
+	repo, err := InitBareRepository(filepath.Join(dir, "repo.git"))
+	if err != nil {
+		t.Fatalf("could not init repository: %v", err)
+	}
+
+	_, err = repo.WritePack(pw)
+	if err != nil {
+		t.Fatalf("WritePack failed: %v", err)
+	}
+
+	for _, id := range []SHA1{a, b, c} {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			t.Fatalf("could not open %s: %v", id, err)
+		}
+
+		var buf bytes.Buffer
+		obj.WriteTo(&buf)
+		obj.Close()
+
+		if hashObject(ObjBlob, buf.Bytes()[bytes.IndexByte(buf.Bytes(), 0)+1:]) != id {
+			t.Fatalf("object %s differs after packing", id)
+		}
+	}
+}
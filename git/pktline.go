@@ -0,0 +1,131 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+//maxPktData is the maximal payload of a single pkt-line.
+const maxPktData = 65516
+
+//pktKind distinguishes data packets from the special
+//packets of the pkt-line format.
+type pktKind int
+
+//The pkt-line packet kinds.
+const (
+	pktData        pktKind = iota
+	pktFlush               //"0000"
+	pktDelim               //"0001", protocol v2 only
+	pktResponseEnd         //"0002", protocol v2 only
+)
+
+//pktReader reads the pkt-line format of the git protocol: every
+//packet starts with its length (including the length itself) as
+//four hex digits.
+type pktReader struct {
+	r *bufio.Reader
+}
+
+func newPktReader(r io.Reader) *pktReader {
+	return &pktReader{bufio.NewReader(r)}
+}
+
+//next reads the next packet. io.EOF is only returned if
+//the stream ends before the first byte of a packet.
+func (p *pktReader) next() (pktKind, []byte, error) {
+	var head [4]byte
+	_, err := io.ReadFull(p.r, head[:])
+	if err == io.ErrUnexpectedEOF {
+		return pktData, nil, fmt.Errorf("git: truncated pkt-line")
+	} else if err != nil {
+		return pktData, nil, err
+	}
+
+	n, err := strconv.ParseUint(string(head[:]), 16, 16)
+	if err != nil {
+		return pktData, nil, fmt.Errorf("git: invalid pkt-line length %q", head)
+	}
+
+	switch {
+	case n < 3:
+		return pktKind(n + 1), nil, nil
+	case n == 3 || n > maxPktData+4:
+		return pktData, nil, fmt.Errorf("git: invalid pkt-line length %d", n)
+	}
+
+	data := make([]byte, n-4)
+	_, err = io.ReadFull(p.r, data)
+	if err != nil {
+		return pktData, nil, fmt.Errorf("git: truncated pkt-line: %v", err)
+	}
+
+	return pktData, data, nil
+}
+
+//pktWriter writes pkt-lines. The first error is kept
+//and all further writes are skipped, see Err().
+type pktWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *pktWriter) write(data []byte) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, "%04x", len(data)+4)
+	if p.err == nil {
+		_, p.err = p.w.Write(data)
+	}
+}
+
+//Printf writes a data packet with the formatted string.
+func (p *pktWriter) Printf(format string, args ...interface{}) {
+	p.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+//Flush writes a flush packet.
+func (p *pktWriter) Flush() {
+	if p.err == nil {
+		_, p.err = io.WriteString(p.w, "0000")
+	}
+}
+
+//Delim writes a delimiter packet.
+func (p *pktWriter) Delim() {
+	if p.err == nil {
+		_, p.err = io.WriteString(p.w, "0001")
+	}
+}
+
+//Err returns the first error encountered while writing.
+func (p *pktWriter) Err() error {
+	return p.err
+}
+
+//sidebandWriter multiplexes data into packets of a
+//side-band channel (1: data, 2: progress, 3: error).
+type sidebandWriter struct {
+	pw   *pktWriter
+	band byte
+}
+
+func (s *sidebandWriter) Write(data []byte) (int, error) {
+	n := 0
+	for n < len(data) {
+		chunk := data[n:]
+		if len(chunk) > maxPktData-1 {
+			chunk = chunk[:maxPktData-1]
+		}
+
+		s.pw.write(append([]byte{s.band}, chunk...))
+		if s.pw.err != nil {
+			return n, s.pw.err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
@@ -0,0 +1,434 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//receivePackCaps are the capabilities advertised by ReceivePack.
+const receivePackCaps = "report-status delete-refs side-band-64k quiet atomic ofs-delta"
+
+//RefCommand is a ref update requested by a push. The zero id as
+//Old means the ref is created, as New that it is deleted.
+type RefCommand struct {
+	Name     string
+	Old, New SHA1
+
+	Err error //why the command was rejected, if it was
+}
+
+//ReceivePack accepts pushes to the repository via the git wire
+//protocol (version 0 and 1, since version 2 does not cover pushes).
+//The pack sent by the client is stored with its index (thin packs
+//are completed), the objects are checked for connectivity and the
+//refs are then updated with a single RefTransaction, i.e. either all
+//of the commands succeed or none.
+type ReceivePack struct {
+	Repo  *Repository
+	Agent string
+
+	//Who is recorded as identity in the reflogs.
+	Who Signature
+
+	//Check, if set, is called for every command before any ref is
+	//updated; a non-nil error rejects the command (and with it the
+	//whole push). The objects of the push are available by then.
+	Check func(cmd *RefCommand) error
+}
+
+//NewReceivePack creates a ReceivePack for the repository
+//with the default agent.
+func NewReceivePack(repo *Repository, who Signature) *ReceivePack {
+	return &ReceivePack{Repo: repo, Agent: DefaultAgent, Who: who}
+}
+
+//Advertise writes the refs of the repository together with the
+//capabilities.
+func (rp *ReceivePack) Advertise(w io.Writer) error {
+	refs, err := rp.Repo.listRefs()
+	if err != nil {
+		return err
+	}
+
+	caps := receivePackCaps + " agent=" + rp.Agent
+	pw := &pktWriter{w: w}
+	for _, ref := range refs {
+		//like git, only the actual refs are advertised
+		if ref.symref != "" || ref.id == (SHA1{}) {
+			continue
+		}
+
+		if caps != "" {
+			pw.Printf("%s %s\x00%s\n", ref.id, ref.name, caps)
+			caps = ""
+		} else {
+			pw.Printf("%s %s\n", ref.id, ref.name)
+		}
+	}
+
+	if caps != "" {
+		pw.Printf("%s capabilities^{}\x00%s\n", SHA1{}, caps)
+	}
+
+	pw.Flush()
+	return pw.Err()
+}
+
+//Serve reads the commands and the pack from r, applies them and
+//writes the status report to w, if the client asked for it.
+func (rp *ReceivePack) Serve(r io.Reader, w io.Writer) error {
+	pr := newPktReader(r)
+	cmds, shallow, caps, err := readRefCommands(pr)
+	if err != nil || len(cmds) == 0 {
+		return err
+	}
+
+	capSet := make(map[string]bool)
+	for _, c := range strings.Fields(caps) {
+		capSet[c] = true
+	}
+
+	progress := io.Writer(nil)
+	out := &pktWriter{w: w}
+	if capSet["side-band-64k"] {
+		if !capSet["quiet"] {
+			progress = &sidebandWriter{out, 2}
+		}
+		out = &pktWriter{w: &sidebandWriter{out, 1}}
+	}
+
+	//repository maintenance waits for the push
+	lock, err := rp.Repo.LockShared()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	deletesOnly := true
+	for _, cmd := range cmds {
+		deletesOnly = deletesOnly && cmd.New == (SHA1{})
+	}
+
+	//the client sends no pack at all if it only deletes refs
+	var packName string
+	var packed []SHA1
+	created := false
+	if !deletesOnly {
+		packName, packed, created, err = rp.Repo.indexPack(pr.r)
+	}
+
+	unpackErr := err
+	if unpackErr == nil {
+		if progress != nil && len(packed) > 0 {
+			fmt.Fprintf(progress, "Received %d objects, done.\n", len(packed))
+		}
+		err = rp.apply(cmds, packed, shallow)
+	}
+
+	//objects of rejected pushes are not kept, unless
+	//the pack existed already and is in use
+	if err != nil && created {
+		os.Remove(packName + ".idx")
+		os.Remove(packName + ".pack")
+	}
+
+	if capSet["report-status"] {
+		if unpackErr != nil {
+			out.Printf("unpack %s\n", oneLine(unpackErr))
+		} else {
+			out.Printf("unpack ok\n")
+		}
+
+		for _, cmd := range cmds {
+			if cmd.Err != nil {
+				out.Printf("ng %s %s\n", cmd.Name, oneLine(cmd.Err))
+			} else {
+				out.Printf("ok %s\n", cmd.Name)
+			}
+		}
+		out.Flush()
+	}
+
+	if capSet["side-band-64k"] {
+		(&pktWriter{w: w}).Flush()
+	}
+
+	if werr := out.Err(); err == nil {
+		err = werr
+	}
+
+	return err
+}
+
+//readRefCommands reads the "<old> <new> <ref>" lines up to the flush
+//packet. The capabilities are sent after a NUL byte in the first line.
+//Clients with a shallow repository send their shallow commits first,
+//as "shallow <id>" lines.
+func readRefCommands(pr *pktReader) ([]*RefCommand, []SHA1, string, error) {
+	var cmds []*RefCommand
+	var shallow []SHA1
+	var caps string
+
+	for {
+		kind, data, err := pr.next()
+		if err == io.EOF && len(cmds) == 0 {
+			return nil, nil, "", nil
+		} else if err != nil {
+			return nil, nil, "", err
+		} else if kind == pktFlush {
+			return cmds, shallow, caps, nil
+		} else if kind != pktData {
+			return nil, nil, "", fmt.Errorf("git: unexpected packet in command list")
+		}
+
+		line := strings.TrimSuffix(string(data), "\n")
+		if i := strings.IndexByte(line, 0); i > -1 {
+			line, caps = line[:i], line[i+1:]
+		}
+
+		if strings.HasPrefix(line, "shallow ") {
+			id, err := ParseSHA1(line[8:])
+			if err != nil {
+				return nil, nil, "", fmt.Errorf("git: invalid shallow line %q: %v", line, err)
+			}
+			shallow = append(shallow, id)
+			continue
+		} else if strings.HasPrefix(line, "push-cert") {
+			return nil, nil, "", fmt.Errorf("git: signed pushes are not supported")
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, nil, "", fmt.Errorf("git: invalid command %q", line)
+		}
+
+		cmd := &RefCommand{Name: fields[2]}
+		cmd.Old, err = ParseSHA1(fields[0])
+		if err == nil {
+			cmd.New, err = ParseSHA1(fields[1])
+		}
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("git: invalid command %q: %v", line, err)
+		}
+
+		cmds = append(cmds, cmd)
+	}
+}
+
+//apply checks the commands and carries them out in a single
+//transaction. If any of them fails, all of them fail.
+func (rp *ReceivePack) apply(cmds []*RefCommand, packed []SHA1, shallow []SHA1) error {
+	conn := &connectivity{
+		repo:    rp.Repo,
+		inPack:  make(map[SHA1]bool, len(packed)),
+		shallow: make(map[SHA1]bool, len(shallow)),
+		done:    make(map[SHA1]bool),
+	}
+	for _, id := range packed {
+		conn.inPack[id] = true
+	}
+	for _, id := range shallow {
+		conn.shallow[id] = true
+	}
+
+	var failed *RefCommand
+	for _, cmd := range cmds {
+		cmd.Err = CheckRefName(cmd.Name)
+		if cmd.Err == nil && cmd.Name == "HEAD" {
+			cmd.Err = fmt.Errorf("HEAD cannot be pushed to")
+		}
+
+		if cmd.Err == nil && cmd.New != (SHA1{}) {
+			cmd.Err = conn.check(cmd.New)
+		}
+
+		if cmd.Err == nil && rp.Check != nil {
+			cmd.Err = rp.Check(cmd)
+		}
+
+		if cmd.Err != nil && failed == nil {
+			failed = cmd
+		}
+	}
+
+	if failed == nil {
+		tx := rp.Repo.NewRefTransaction(rp.Who, "push")
+		for _, cmd := range cmds {
+			tx.Update(cmd.Name, cmd.New, cmd.Old)
+		}
+
+		err := tx.Commit()
+		if err == nil {
+			return nil
+		}
+
+		//the transaction does not tell which update failed
+		for _, cmd := range cmds {
+			cmd.Err = err
+		}
+		return err
+	}
+
+	for _, cmd := range cmds {
+		if cmd.Err == nil {
+			cmd.Err = fmt.Errorf("atomic push failed")
+		}
+	}
+
+	return fmt.Errorf("git: push rejected: %s: %v", failed.Name, failed.Err)
+}
+
+//connectivity checks that the objects reachable from the new values
+//of the refs exist, like "git rev-list --objects <new> --not --all"
+//does. Objects of the pack of the push (inPack) are followed, but so
+//are objects of the repository that are not reachable from its refs:
+//unreachable objects kept for the grace period of PruneLoose are not
+//necessarily complete. Commits reachable from the refs are.
+type connectivity struct {
+	repo    *Repository
+	inPack  map[SHA1]bool
+	shallow map[SHA1]bool //the shallow commits of the client
+	done    map[SHA1]bool //objects checked already, for all commands
+
+	known map[SHA1]bool //commits reachable from the refs, see loadKnown
+}
+
+//connLink is an object to check, with its type if it is known.
+type connLink struct {
+	id    SHA1
+	otype ObjectType
+
+	shallowParent bool //parent of a shallow commit of the client
+}
+
+//check checks the objects reachable from id. Like git without
+//"receive.shallowUpdate", pushes from shallow clients are rejected
+//if they need parents of the client's shallow commits that the
+//repository does not have, i.e. if they would make it shallow.
+func (c *connectivity) check(id SHA1) error {
+	queue := []connLink{{id: id}}
+	c.done[id] = true
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		if !c.inPack[cur.id] {
+			if !c.repo.hasObject(cur.id) {
+				if cur.shallowParent {
+					return fmt.Errorf("shallow update not allowed")
+				}
+				return fmt.Errorf("missing object %s", cur.id)
+			} else if cur.otype == ObjBlob {
+				continue
+			} else if cur.otype == ObjCommit {
+				err := c.loadKnown()
+				if err != nil {
+					return err
+				} else if c.known[cur.id] {
+					continue
+				}
+			}
+		}
+
+		var links []connLink
+		obj, err := c.repo.OpenObject(cur.id)
+		if err != nil {
+			return err
+		}
+
+		switch o := obj.(type) {
+		case *Commit:
+			if !c.inPack[cur.id] {
+				//a tag of a commit, which might be known
+				if err = c.loadKnown(); err == nil && c.known[cur.id] {
+					break
+				}
+			}
+
+			links = append(links, connLink{id: o.Tree, otype: ObjTree})
+			for _, parent := range o.Parent {
+				links = append(links, connLink{parent, ObjCommit, c.shallow[cur.id]})
+			}
+		case *Tag:
+			links = []connLink{{id: o.Object}}
+		case *Tree:
+			for o.Next() {
+				e := o.Entry()
+				if e.Mode&gitModeTypeMask != gitModeGitlink {
+					links = append(links, connLink{id: e.ID, otype: e.Type})
+				}
+			}
+			err = o.Err()
+		}
+		obj.Close()
+
+		if err != nil {
+			return err
+		}
+
+		for _, link := range links {
+			if !c.done[link.id] {
+				c.done[link.id] = true
+				queue = append(queue, link)
+			}
+		}
+	}
+
+	return nil
+}
+
+//loadKnown determines the commits reachable from the refs,
+//once they are needed.
+func (c *connectivity) loadKnown() error {
+	if c.known != nil {
+		return nil
+	}
+
+	refs, err := c.repo.listRefs()
+	if err != nil {
+		return err
+	}
+
+	known := make(map[SHA1]bool)
+	graph := NewCommitGraph(c.repo)
+	var queue []SHA1
+	for _, ref := range refs {
+		if ref.id == (SHA1{}) {
+			continue
+		}
+
+		//refs to trees and blobs are fine too
+		oid, err := c.repo.peel(ref.id, ObjCommit)
+		if err == nil && !known[oid] {
+			known[oid] = true
+			queue = append(queue, oid)
+		}
+	}
+
+	for len(queue) > 0 {
+		node, err := graph.openObject(queue[0])
+		queue = queue[1:]
+		if err != nil {
+			return err
+		}
+
+		for _, pid := range node.parentIDs {
+			if !known[pid] {
+				known[pid] = true
+				queue = append(queue, pid)
+			}
+		}
+	}
+
+	c.known = known
+	return nil
+}
+
+//oneLine formats err for the status report, which is line based.
+func oneLine(err error) string {
+	msg := strings.TrimPrefix(err.Error(), "git: ")
+	return strings.Replace(msg, "\n", " ", -1)
+}
@@ -0,0 +1,240 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//receivePackServer serves the repository for pushes via the smart
+//HTTP protocol, with ReceivePack handling the requests.
+func receivePackServer(t *testing.T, rp *ReceivePack) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch {
+		case r.URL.Path == "/repo.git/info/refs" && r.URL.Query().Get("service") == "git-receive-pack":
+			w.Header().Set("Content-Type", "application/x-git-receive-pack-advertisement")
+			io.WriteString(w, "001f# service=git-receive-pack\n0000")
+			err = rp.Advertise(w)
+		case r.URL.Path == "/repo.git/git-receive-pack":
+			w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
+			err = rp.Serve(r.Body, w)
+		default:
+			http.NotFound(w, r)
+		}
+
+		if err != nil {
+			t.Logf("receive-pack for %s: %v", r.URL.Path, err)
+		}
+	}))
+}
+
+func TestReceivePack(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	bare := filepath.Join(tr.work, ".git", "bare.git")
+	tr.git("init", "-q", "--bare", bare)
+	remote := &Repository{Path: bare}
+
+	who := Signature{Name: "alice", Email: "alice@gin"}
+	rp := NewReceivePack(remote, who)
+	rp.Check = func(cmd *RefCommand) error {
+		if strings.HasPrefix(cmd.Name, "refs/heads/forbidden") {
+			return os.ErrPermission
+		}
+		return nil
+	}
+
+	ts := receivePackServer(t, rp)
+	defer ts.Close()
+	url := ts.URL + "/repo.git"
+
+	//the ref advertisement of an empty repository
+	var adv bytes.Buffer
+	if err := rp.Advertise(&adv); err != nil || !strings.Contains(adv.String(), SHA1{}.String()+" capabilities^{}\x00") {
+		t.Fatalf("unexpected advertisement %q (%v)", adv.String(), err)
+	}
+
+	//a file large enough for git to send a delta against it
+	lines := strings.Repeat("some line of text in a big file\n", 500)
+	first := tr.commit("first", map[string]string{"big.txt": lines, "dir/a.txt": "a\n"})
+	tr.git("tag", "-a", "-m", "version 1", "v1.0")
+	tr.git("push", "-q", url, "master", "v1.0")
+
+	if id := tr.git("--git-dir", bare, "rev-parse", "master"); id != first.String() {
+		t.Fatalf("pushed master is %s, expected %s", id, first)
+	} else if id := tr.git("--git-dir", bare, "rev-parse", "v1.0"); id != tr.revParse("v1.0").String() {
+		t.Fatalf("pushed tag is %s, expected %s", id, tr.revParse("v1.0"))
+	}
+
+	reflog := tr.git("--git-dir", bare, "reflog", "show", "--format=%gn <%ge> %gs", "master")
+	if reflog != "alice <alice@gin> push" {
+		t.Fatalf("unexpected reflog %q", reflog)
+	}
+
+	//the second push is sent as thin pack
+	second := tr.commit("second", map[string]string{"big.txt": lines + "one more line\n"})
+	out := tr.git("push", "--thin", "--porcelain", url, "master")
+	if !strings.Contains(out, "refs/heads/master:refs/heads/master") {
+		t.Fatalf("unexpected push output:\n%s", out)
+	} else if id := tr.git("--git-dir", bare, "rev-parse", "master"); id != second.String() {
+		t.Fatalf("pushed master is %s, expected %s", id, second)
+	}
+
+	tr.git("--git-dir", bare, "fsck", "--strict", "--no-dangling")
+	packs, _ := filepath.Glob(filepath.Join(bare, "objects", "pack", "*.idx"))
+	for _, idx := range packs {
+		tr.git("verify-pack", idx)
+	}
+
+	//rejected commands fail the whole push, no objects are kept
+	tr.commit("third", map[string]string{"dir/b.txt": "b\n"})
+	tr.git("branch", "forbidden")
+	cmd := []string{"push", "--porcelain", url, "master", "forbidden"}
+	if out, err := runGit(tr, cmd...); err == nil {
+		t.Fatalf("expected push to fail:\n%s", out)
+	} else if id := tr.git("--git-dir", bare, "rev-parse", "master"); id != second.String() {
+		t.Fatalf("rejected push updated master to %s", id)
+	}
+
+	if after, _ := filepath.Glob(filepath.Join(bare, "objects", "pack", "*.idx")); len(after) != len(packs) {
+		t.Fatalf("pack of rejected push was kept: %v", after)
+	}
+
+	//a rejected push of a pack that exists already does not remove it
+	data, err := ioutil.ReadFile(strings.TrimSuffix(packs[0], ".idx") + ".pack")
+	if err != nil {
+		t.Fatalf("could not read pack: %v", err)
+	}
+
+	var again bytes.Buffer
+	pw := &pktWriter{w: &again}
+	pw.Printf("%s %s refs/heads/forbidden\x00report-status\n", SHA1{}, first)
+	pw.Flush()
+	again.Write(data)
+
+	var res bytes.Buffer
+	if err := rp.Serve(&again, &res); err == nil || !strings.Contains(res.String(), "ng refs/heads/forbidden ") {
+		t.Fatalf("expected push to fail, got %q", res.String())
+	} else if _, err := os.Stat(packs[0]); err != nil {
+		t.Fatalf("existing pack removed by rejected push: %v", err)
+	}
+	tr.git("--git-dir", bare, "fsck", "--strict", "--no-dangling")
+
+	//deletes and creates in one push
+	tr.git("push", "-q", url, second.String()+":refs/heads/other")
+	tr.git("push", "-q", url, ":refs/heads/other", "master:refs/heads/new")
+	if refs := tr.git("--git-dir", bare, "for-each-ref", "--format=%(refname)"); refs != "refs/heads/master\nrefs/heads/new\nrefs/tags/v1.0" {
+		t.Fatalf("unexpected refs after push:\n%s", refs)
+	}
+
+	//stale old values are rejected
+	var req bytes.Buffer
+	pw = &pktWriter{w: &req}
+	pw.Printf("%s %s refs/heads/new\x00report-status\n", first, SHA1{})
+	pw.Flush()
+
+	res.Reset()
+	err = rp.Serve(&req, &res)
+	if err == nil || !strings.Contains(res.String(), "ng refs/heads/new ") {
+		t.Fatalf("expected stale delete to fail, got %q", res.String())
+	} else if id := tr.git("--git-dir", bare, "rev-parse", "new"); id != tr.revParse("master").String() {
+		t.Fatalf("stale delete changed ref to %s", id)
+	}
+
+	tr.git("--git-dir", bare, "fsck", "--strict", "--no-dangling")
+}
+
+//runGit runs git like testRepo.git, but returns errors instead of failing.
+func runGit(tr *testRepo, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = tr.work
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestReceivePackConnectivity(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	bare := filepath.Join(tr.work, ".git", "bare.git")
+	tr.git("init", "-q", "--bare", bare)
+	remote := &Repository{Path: bare}
+
+	rp := NewReceivePack(remote, Signature{Name: "alice", Email: "alice@gin"})
+	ts := receivePackServer(t, rp)
+	defer ts.Close()
+	url := ts.URL + "/repo.git"
+
+	tr.commit("first", map[string]string{"a.txt": "a\n"})
+	tr.commit("second", map[string]string{"a.txt": "aa\n"})
+	tr.git("push", "-q", url, "master")
+
+	//pushes from shallow clones are fine, if they need no missing parents
+	clone := filepath.Join(tr.work, ".git", "shallow")
+	tr.git("clone", "-q", "--depth", "1", "file://"+bare, clone)
+	tr.git("-C", clone, "commit", "-q", "--allow-empty", "-m", "from shallow")
+	tr.git("-C", clone, "push", "-q", url, "master")
+	if id := tr.git("--git-dir", bare, "rev-parse", "master"); id != tr.git("-C", clone, "rev-parse", "HEAD") {
+		t.Fatalf("push from shallow clone did not update master: %s", id)
+	}
+
+	push := func(shallow []SHA1, cmd string, pack []byte) string {
+		var req, res bytes.Buffer
+		pw := &pktWriter{w: &req}
+		for _, id := range shallow {
+			pw.Printf("shallow %s\n", id)
+		}
+		pw.Printf("%s\x00report-status\n", cmd)
+		pw.Flush()
+		req.Write(pack)
+
+		rp.Serve(&req, &res)
+		return res.String()
+	}
+
+	//an unreachable commit, whose tree was pruned already
+	who := NewSignature("alice", "alice@gin", time.Now())
+	broken, err := remote.WriteObject(&Commit{Tree: SHA1{1, 2, 3}, Author: who, Committer: who, Message: "broken\n"})
+	if err != nil {
+		t.Fatalf("could not write commit: %v", err)
+	}
+
+	empty := []byte("PACK\x00\x00\x00\x02\x00\x00\x00\x00")
+	sum := sha1.Sum(empty)
+	empty = append(empty, sum[:]...)
+
+	if res := push(nil, fmt.Sprintf("%s %s refs/heads/broken", SHA1{}, broken), empty); !strings.Contains(res, "ng refs/heads/broken missing object") {
+		t.Fatalf("expected push of incomplete commit to fail, got %q", res)
+	}
+
+	//history the repository does not have would make it shallow
+	tr.git("checkout", "-q", "--orphan", "side")
+	base := tr.commit("side base", map[string]string{"b.txt": "b\n"})
+	tip := tr.commit("side tip", map[string]string{"b.txt": "bb\n"})
+
+	cmd := exec.Command("git", "pack-objects", "--revs", "--stdout", "-q")
+	cmd.Dir = tr.work
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s\n^%s\n", tip, base))
+	pack, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("could not create pack: %v", err)
+	}
+
+	if res := push([]SHA1{tip}, fmt.Sprintf("%s %s refs/heads/side", SHA1{}, tip), pack); !strings.Contains(res, "ng refs/heads/side shallow update not allowed") {
+		t.Fatalf("expected shallow update to fail, got %q", res)
+	}
+
+	tr.git("--git-dir", bare, "fsck", "--strict", "--no-dangling")
+}
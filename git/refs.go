@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -244,3 +245,136 @@ func (repo *Repository) findPackedRef(name string) (Ref, error) {
 	}
 	return nil, fmt.Errorf("ref with name %q not found", name)
 }
+
+//refInfo is a ref together with the id it resolves to. For
+//symbolic refs, symref is the name of the ref pointed to. The
+//id is the zero id for symbolic refs to non-existing refs, e.g.
+//HEAD in an empty repository.
+type refInfo struct {
+	name   string
+	id     SHA1
+	symref string
+}
+
+type byRefInfoName []refInfo
+
+func (r byRefInfoName) Len() int           { return len(r) }
+func (r byRefInfoName) Less(i, j int) bool { return r[i].name < r[j].name }
+func (r byRefInfoName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+//listRefs returns HEAD and all loose and packed refs, sorted by
+//name. Loose refs take precedence over packed ones.
+func (repo *Repository) listRefs() ([]refInfo, error) {
+	ids := make(map[string]SHA1)
+
+	packed, err := repo.loadPackedRefs()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("git: could not read packed-refs: %v", err)
+	}
+
+	for _, ref := range packed {
+		ids[refPath(ref)], _ = ref.Resolve()
+	}
+
+	symrefs := make(map[string]string)
+	base := filepath.Join(repo.Path, "refs")
+	err = filepath.Walk(base, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == base {
+				return nil
+			}
+			return err
+		} else if fi.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(repo.Path, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if bytes.HasPrefix(data, []byte("ref:")) {
+			symrefs[name] = strings.TrimSpace(string(data[4:]))
+			return nil
+		}
+
+		id, err := ParseSHA1(string(data))
+		if err != nil {
+			//like git, ignore broken refs
+			return nil
+		}
+		ids[name] = id
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read refs: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(repo.Path, "HEAD"))
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read HEAD: %v", err)
+	} else if bytes.HasPrefix(data, []byte("ref:")) {
+		symrefs["HEAD"] = strings.TrimSpace(string(data[4:]))
+	} else if id, err := ParseSHA1(string(data)); err == nil {
+		ids["HEAD"] = id
+	}
+
+	var refs []refInfo
+	for name, id := range ids {
+		refs = append(refs, refInfo{name: name, id: id})
+	}
+
+	for name, target := range symrefs {
+		refs = append(refs, refInfo{name: name, id: ids[target], symref: target})
+	}
+
+	sort.Sort(byRefInfoName(refs))
+	return refs, nil
+}
+
+//CopyRefs creates all refs of src in the repository, which must not
+//have any of them yet, and points HEAD to the same branch as in src.
+//The objects are not copied, i.e. they must be found in the repository
+//already, e.g. via its alternates. Symbolic refs other than HEAD are
+//skipped. The reflogs are written with who as identity.
+func (repo *Repository) CopyRefs(src *Repository, who Signature) error {
+	refs, err := src.listRefs()
+	if err != nil {
+		return err
+	}
+
+	head := ""
+	tx := repo.NewRefTransaction(who, "fork: from "+src.Path)
+	for _, ref := range refs {
+		if ref.name == "HEAD" && ref.symref != "" {
+			head = "ref: " + ref.symref
+		} else if ref.name == "HEAD" {
+			head = ref.id.String()
+		} else if ref.symref == "" {
+			tx.Create(ref.name, ref.id)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if head == "" {
+		return nil
+	}
+
+	err = ioutil.WriteFile(filepath.Join(repo.Path, "HEAD"), []byte(head+"\n"), 0666)
+	if err != nil {
+		return fmt.Errorf("git: could not write HEAD: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,440 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//maxSymrefDepth is the maximal number of symbolic refs followed
+//when updating a ref, like git's SYMREF_MAXDEPTH.
+const maxSymrefDepth = 5
+
+//RefTransaction updates a set of refs atomically: either all of the
+//updates are carried out or none. Every ref is locked via a ".lock"
+//file, like git does, and its current value is compared to the
+//expected one, before any ref is changed. Updates are recorded in the
+//reflogs in "logs/", with Who as identity.
+type RefTransaction struct {
+	Who     Signature //the acting user, the date is set on Commit if zero
+	Message string    //the reflog message
+
+	repo    *Repository
+	updates []*refUpdate
+}
+
+type refUpdate struct {
+	name     string //the name given by the user
+	old, new SHA1   //the zero id means the ref does not exist
+
+	//set while locked
+	target  string //name of the ref actually updated (symrefs resolved)
+	current SHA1
+	packed  bool
+	lock    string
+}
+
+//NewRefTransaction starts a new transaction for the refs of repo,
+//with who as identity and msg as message for the reflogs.
+func (repo *Repository) NewRefTransaction(who Signature, msg string) *RefTransaction {
+	return &RefTransaction{Who: who, Message: msg, repo: repo}
+}
+
+//Create adds the creation of the ref name, pointing to id, to the
+//transaction. The ref must not exist.
+func (tx *RefTransaction) Create(name string, id SHA1) {
+	tx.Update(name, id, SHA1{})
+}
+
+//Update adds the update of the ref name to newID to the transaction,
+//provided the ref points to oldID at the time of the commit. The zero
+//id for oldID means the ref must not exist (yet), for newID that the
+//ref is deleted. Symbolic refs, e.g. HEAD, are followed, i.e. the ref
+//they point to is updated.
+func (tx *RefTransaction) Update(name string, newID, oldID SHA1) {
+	tx.updates = append(tx.updates, &refUpdate{name: name, old: oldID, new: newID})
+}
+
+//Delete adds the deletion of the ref name, currently pointing to
+//oldID, to the transaction. Refs are deleted from "packed-refs" too.
+func (tx *RefTransaction) Delete(name string, oldID SHA1) {
+	tx.Update(name, SHA1{}, oldID)
+}
+
+//Commit locks all refs, checks their current values and carries out
+//the updates. If any of the checks fails, no ref is changed.
+func (tx *RefTransaction) Commit() error {
+	for _, u := range tx.updates {
+		if err := CheckRefName(u.name); err != nil {
+			return err
+		} else if u.old == (SHA1{}) && u.new == (SHA1{}) {
+			return fmt.Errorf("git: cannot delete non-existing ref %q", u.name)
+		}
+	}
+
+	defer tx.unlock()
+
+	seen := make(map[string]bool)
+	for _, u := range tx.updates {
+		err := tx.lock(u)
+		if err != nil {
+			return err
+		} else if seen[u.target] {
+			return fmt.Errorf("git: multiple updates for ref %q", u.target)
+		}
+		seen[u.target] = true
+
+		if u.current != u.old {
+			if u.old == (SHA1{}) {
+				return fmt.Errorf("git: cannot create ref %q: it exists already", u.name)
+			}
+			return fmt.Errorf("git: cannot update ref %q: expected %s, but is %s", u.name, u.old, u.current)
+		}
+
+		if u.new != (SHA1{}) {
+			err = tx.writeLock(u)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := tx.deletePacked()
+	if err != nil {
+		return err
+	}
+
+	date := tx.Who.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var head string
+	if data, err := ioutil.ReadFile(filepath.Join(tx.repo.Path, "HEAD")); err == nil && bytes.HasPrefix(data, []byte("ref:")) {
+		head = strings.TrimSpace(string(data[4:]))
+	}
+
+	for _, u := range tx.updates {
+		path := filepath.Join(tx.repo.Path, u.target)
+
+		if u.new == (SHA1{}) {
+			err = os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("git: could not delete ref %q: %v", u.target, err)
+			}
+
+			os.Remove(filepath.Join(tx.repo.Path, "logs", u.target))
+			tx.repo.removeEmptyRefDirs(u.target)
+			continue
+		}
+
+		err = os.Rename(u.lock, path)
+		if err != nil {
+			return fmt.Errorf("git: could not update ref %q: %v", u.target, err)
+		}
+		u.lock = ""
+
+		//like git, updates of the current branch are
+		//recorded in the reflog of HEAD as well
+		entry := tx.reflogEntry(u, date)
+		err = tx.repo.appendReflog(u.target, entry)
+		if err == nil && u.target != u.name {
+			err = tx.repo.appendReflog(u.name, entry)
+		} else if err == nil && u.name != "HEAD" && head == u.target {
+			err = tx.repo.appendReflog("HEAD", entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//lock follows symbolic refs from u.name, locks the final ref and
+//reads its current value.
+func (tx *RefTransaction) lock(u *refUpdate) error {
+	name := u.name
+	for i := 0; ; i++ {
+		if i == maxSymrefDepth {
+			return fmt.Errorf("git: too many levels of symbolic refs for %q", u.name)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(tx.repo.Path, name))
+		if err != nil || !bytes.HasPrefix(data, []byte("ref:")) {
+			break
+		}
+
+		name = strings.TrimSpace(string(data[4:]))
+		if err := CheckRefName(name); err != nil {
+			return err
+		}
+	}
+
+	u.target = name
+	path := filepath.Join(tx.repo.Path, name)
+
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return fmt.Errorf("git: could not lock ref %q: %v", name, err)
+	}
+
+	fd, err := os.OpenFile(path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return fmt.Errorf("git: could not lock ref %q: %s.lock exists", name, name)
+	} else if err != nil {
+		return fmt.Errorf("git: could not lock ref %q: %v", name, err)
+	}
+	fd.Close()
+	u.lock = path + ".lock"
+
+	u.current, u.packed, err = tx.repo.readRefID(name)
+	return err
+}
+
+//writeLock writes the new value of the ref to its lock file.
+func (tx *RefTransaction) writeLock(u *refUpdate) error {
+	obj, err := tx.repo.OpenObject(u.new)
+	if err != nil {
+		return fmt.Errorf("git: cannot update ref %q: %v", u.name, err)
+	}
+	obj.Close()
+
+	if strings.HasPrefix(u.target, "refs/heads/") && obj.Type() != ObjCommit {
+		return fmt.Errorf("git: cannot update branch %q to non-commit %s", u.target, u.new)
+	}
+
+	fd, err := os.OpenFile(u.lock, os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("git: could not write ref %q: %v", u.target, err)
+	}
+
+	_, err = fmt.Fprintf(fd, "%s\n", u.new)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return fmt.Errorf("git: could not write ref %q: %v", u.target, err)
+	}
+
+	return nil
+}
+
+//unlock removes all remaining lock files.
+func (tx *RefTransaction) unlock() {
+	for _, u := range tx.updates {
+		if u.lock != "" {
+			os.Remove(u.lock)
+			u.lock = ""
+		}
+	}
+}
+
+//deletePacked rewrites "packed-refs" without the refs
+//that are deleted in the transaction.
+func (tx *RefTransaction) deletePacked() error {
+	deleted := make(map[string]bool)
+	for _, u := range tx.updates {
+		if u.new == (SHA1{}) && u.packed {
+			deleted[u.target] = true
+		}
+	}
+
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(tx.repo.Path, "packed-refs")
+	lock, err := os.OpenFile(path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return fmt.Errorf("git: could not lock packed-refs: packed-refs.lock exists")
+	} else if err != nil {
+		return fmt.Errorf("git: could not lock packed-refs: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		lock.Close()
+		os.Remove(path + ".lock")
+		return fmt.Errorf("git: could not read packed-refs: %v", err)
+	}
+
+	//keep everything but the lines of the deleted refs
+	//and their peeled ids ("^<sha1>") following them
+	w := bufio.NewWriter(lock)
+	skip := false
+	for _, l := range strings.SplitAfter(string(data), "\n") {
+		if strings.HasPrefix(l, "^") && skip {
+			continue
+		}
+
+		_, name := split2(strings.TrimRight(l, "\n"), " ")
+		skip = !strings.HasPrefix(l, "#") && deleted[name]
+		if !skip {
+			w.WriteString(l)
+		}
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = lock.Sync()
+	}
+	if cerr := lock.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".lock", path)
+	}
+
+	if err != nil {
+		os.Remove(path + ".lock")
+		return fmt.Errorf("git: could not write packed-refs: %v", err)
+	}
+
+	return nil
+}
+
+//readRefID returns the id the (non-symbolic) ref name points to, or
+//the zero id if it does not exist, and whether it is in packed-refs.
+func (repo *Repository) readRefID(name string) (SHA1, bool, error) {
+	var id SHA1
+	packed := false
+
+	refs, err := repo.loadPackedRefs()
+	if err != nil && !os.IsNotExist(err) {
+		return id, false, fmt.Errorf("git: could not read packed-refs: %v", err)
+	}
+
+	for _, ref := range refs {
+		if refPath(ref) == name {
+			id, _ = ref.Resolve()
+			packed = true
+			break
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(repo.Path, name))
+	if os.IsNotExist(err) {
+		return id, packed, nil
+	} else if err != nil {
+		return id, packed, fmt.Errorf("git: could not read ref %q: %v", name, err)
+	}
+
+	id, err = ParseSHA1(string(data))
+	if err != nil {
+		return id, packed, fmt.Errorf("git: invalid ref %q: %q", name, data)
+	}
+
+	return id, packed, nil
+}
+
+//refPath returns the path of the ref relative to the
+//repository, e.g. "refs/heads/master" for branches.
+func refPath(r Ref) string {
+	switch r.Namespace() {
+	case "#special":
+		return r.Name()
+	case "#branch":
+		return "refs/heads/" + r.Name()
+	}
+	return "refs/" + r.Fullname()
+}
+
+//removeEmptyRefDirs removes the directories of the deleted ref name
+//(and its reflog), if they are empty; "refs/<ns>" is kept.
+func (repo *Repository) removeEmptyRefDirs(name string) {
+	for _, base := range []string{repo.Path, filepath.Join(repo.Path, "logs")} {
+		comps := strings.Split(name, "/")
+		for n := len(comps) - 1; n > 2; n-- {
+			if os.Remove(filepath.Join(base, filepath.Join(comps[:n]...))) != nil {
+				break
+			}
+		}
+	}
+}
+
+//reflogEntry formats the reflog line for u:
+//"<old> <new> <name> <<email>> <unix time> <tz>\t<message>\n"
+func (tx *RefTransaction) reflogEntry(u *refUpdate, date time.Time) string {
+	if tx.Who.Offset != nil {
+		date = date.In(tx.Who.Offset)
+	}
+
+	_, offset := date.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+
+	msg := strings.Replace(strings.TrimSpace(tx.Message), "\n", " ", -1)
+	return fmt.Sprintf("%s %s %s <%s> %d %c%02d%02d\t%s\n", u.current, u.new,
+		tx.Who.Name, tx.Who.Email, date.Unix(), sign, offset/3600, offset%3600/60, msg)
+}
+
+//appendReflog appends entry to the reflog of the ref name.
+func (repo *Repository) appendReflog(name, entry string) error {
+	path := filepath.Join(repo.Path, "logs", name)
+
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return fmt.Errorf("git: could not write reflog: %v", err)
+	}
+
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return fmt.Errorf("git: could not write reflog: %v", err)
+	}
+
+	_, err = fd.WriteString(entry)
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return fmt.Errorf("git: could not write reflog: %v", err)
+	}
+
+	return nil
+}
+
+//CheckRefName checks if name is a valid full ref name, i.e. starts
+//with "refs/" (or is HEAD) and follows the rules of
+//"git check-ref-format".
+func CheckRefName(name string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("git: invalid ref name %q: %s", name, reason)
+	}
+
+	if name == "HEAD" {
+		return nil
+	} else if !strings.HasPrefix(name, "refs/") {
+		return invalid("must start with refs/")
+	} else if strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") {
+		return invalid("must not end with / or .")
+	} else if strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return invalid("must not contain .. or @{")
+	}
+
+	for _, c := range name {
+		if c < 040 || c == 0177 || strings.ContainsRune(" ~^:?*[\\", c) {
+			return invalid(fmt.Sprintf("invalid character %q", c))
+		}
+	}
+
+	for _, comp := range strings.Split(name, "/") {
+		if comp == "" || strings.HasPrefix(comp, ".") || strings.HasSuffix(comp, ".lock") {
+			return invalid(fmt.Sprintf("invalid component %q", comp))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefTransaction(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("first", map[string]string{"a.txt": "a\n"})
+	second := tr.commit("second", map[string]string{"a.txt": "b\n"})
+	tr.git("branch", "packed", first.String())
+	tr.git("tag", "-a", "-m", "tag", "v1.0", first.String())
+	tr.git("pack-refs", "--all")
+
+	who := Signature{
+		Name:   "alice",
+		Email:  "alice@gin",
+		Date:   time.Unix(1500000000, 0),
+		Offset: time.FixedZone("+0200", 7200),
+	}
+
+	tx := tr.NewRefTransaction(who, "created\nby test")
+	tx.Create("refs/heads/new", first)
+	tx.Update("HEAD", first, second)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("could not commit transaction: %v", err)
+	}
+
+	if id := tr.revParse("new"); id != first {
+		t.Fatalf("new branch points to %s, expected %s", id, first)
+	} else if id := tr.revParse("master"); id != first {
+		t.Fatalf("master points to %s, expected %s", id, first)
+	}
+
+	//HEAD is updated via master and logged for both
+	for _, name := range []string{"HEAD", "master"} {
+		res := tr.git("reflog", "show", "-1", "--format=%H %gn <%ge> %gs", name)
+		if wanted := first.String() + " alice <alice@gin> created by test"; res != wanted {
+			t.Fatalf("unexpected reflog for %s: %q, expected %q", name, res, wanted)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(tr.Path, "logs", "refs", "heads", "new"))
+	if wanted := "0000000000000000000000000000000000000000 " + first.String() +
+		" alice <alice@gin> 1500000000 +0200\tcreated by test\n"; err != nil || string(data) != wanted {
+		t.Fatalf("unexpected reflog: %q (%v), expected %q", data, err, wanted)
+	}
+
+	//failed compare-and-swap: nothing changes
+	tx = tr.NewRefTransaction(who, "failing")
+	tx.Update("refs/heads/new", second, first)
+	tx.Update("refs/heads/master", second, second)
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("expected transaction to fail")
+	} else if id := tr.revParse("new"); id != first {
+		t.Fatalf("failed transaction updated ref")
+	}
+
+	tx = tr.NewRefTransaction(who, "exists")
+	tx.Create("refs/heads/packed", second)
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("expected error creating existing (packed) ref")
+	}
+
+	//existing lock files are honored
+	lock := filepath.Join(tr.Path, "refs", "heads", "new.lock")
+	if err := ioutil.WriteFile(lock, nil, 0666); err != nil {
+		t.Fatalf("could not create lock file: %v", err)
+	}
+
+	tx = tr.NewRefTransaction(who, "locked")
+	tx.Update("refs/heads/new", second, first)
+	err = tx.Commit()
+	os.Remove(lock)
+	if err == nil || !strings.Contains(err.Error(), "lock") {
+		t.Fatalf("expected lock error, got %v", err)
+	}
+
+	//delete a packed ref and update a packed tag
+	tx = tr.NewRefTransaction(who, "delete")
+	tx.Delete("refs/heads/packed", first)
+	tx.Delete("refs/heads/new", first)
+	tx.Update("refs/tags/v1.0", second, tr.revParse("refs/tags/v1.0"))
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("could not commit transaction: %v", err)
+	}
+
+	res := tr.git("show-ref")
+	if strings.Contains(res, "refs/heads/packed") || strings.Contains(res, "refs/heads/new") {
+		t.Fatalf("deleted refs still exist:\n%s", res)
+	} else if tr.revParse("v1.0") != second {
+		t.Fatalf("tag was not updated")
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(tr.Path, "packed-refs"))
+	if err != nil || strings.Contains(string(data), "refs/heads/packed") {
+		t.Fatalf("deleted ref still in packed-refs: %q (%v)", data, err)
+	} else if _, err := os.Stat(filepath.Join(tr.Path, "logs", "refs", "heads", "new")); !os.IsNotExist(err) {
+		t.Fatalf("reflog of deleted ref still exists")
+	}
+
+	tr.git("fsck", "--no-dangling")
+
+	matches, _ := filepath.Glob(filepath.Join(tr.Path, "refs", "*", "*.lock"))
+	if len(matches) > 0 {
+		t.Fatalf("lock files left behind: %v", matches)
+	}
+
+	for _, name := range []string{"master", "refs/heads/a..b", "refs/heads/x.lock", "refs/heads/a b", "refs/heads/.x", "refs/heads/x/"} {
+		tx = tr.NewRefTransaction(who, "invalid")
+		tx.Create(name, first)
+		if err := tx.Commit(); err == nil {
+			t.Fatalf("expected error for invalid ref name %q", name)
+		}
+	}
+
+	tx = tr.NewRefTransaction(who, "non-commit")
+	tx.Create("refs/heads/tree", tr.revParse("HEAD^{tree}"))
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("expected error for branch pointing to a tree")
+	}
+}
@@ -0,0 +1,709 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//walkObject is an object found by an objectWalk, with the path
+//it was found at as name hint for the PackWriter.
+type walkObject struct {
+	id   SHA1
+	hint string
+}
+
+//objectWalk collects the objects reachable from a set of roots.
+//The parents of shallow commits are not followed.
+type objectWalk struct {
+	repo    *Repository
+	seen    map[SHA1]bool
+	objects []walkObject
+	shallow map[SHA1]bool
+}
+
+func newObjectWalk(repo *Repository) (*objectWalk, error) {
+	shallow, err := repo.ShallowCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectWalk{repo: repo, seen: make(map[SHA1]bool), shallow: shallow}, nil
+}
+
+//add adds the object id and all objects reachable from it. Blobs
+//are not read. If lenient is true, missing objects are skipped
+//instead of failing the walk.
+func (ow *objectWalk) add(id SHA1, lenient bool) error {
+	if ow.seen[id] {
+		return nil
+	}
+	ow.seen[id] = true
+
+	queue := []walkObject{{id: id}}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		obj, err := ow.repo.OpenObject(cur.id)
+		if err != nil && lenient {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("git: could not read %s: %v", cur.id, err)
+		}
+
+		ow.objects = append(ow.objects, cur)
+
+		var links []walkObject
+		switch o := obj.(type) {
+		case *Commit:
+			links = append(links, walkObject{id: o.Tree})
+			for _, parent := range o.Parent {
+				if !ow.shallow[cur.id] {
+					links = append(links, walkObject{id: parent})
+				}
+			}
+		case *Tag:
+			links = append(links, walkObject{id: o.Object})
+		case *Tree:
+			for o.Next() {
+				e := o.Entry()
+				hint := path.Join(cur.hint, e.Name)
+				if e.Mode&gitModeTypeMask == gitModeGitlink || ow.seen[e.ID] {
+					continue
+				} else if e.Type == ObjTree {
+					links = append(links, walkObject{e.ID, hint})
+				} else if !lenient || ow.repo.hasObject(e.ID) {
+					ow.seen[e.ID] = true
+					ow.objects = append(ow.objects, walkObject{e.ID, hint})
+				}
+			}
+			err = o.Err()
+		}
+		obj.Close()
+
+		if err != nil {
+			return fmt.Errorf("git: could not read %s: %v", cur.id, err)
+		}
+
+		for _, link := range links {
+			if !ow.seen[link.id] {
+				ow.seen[link.id] = true
+				queue = append(queue, link)
+			}
+		}
+	}
+
+	return nil
+}
+
+//walkReachable walks all objects reachable from the refs, which must
+//be complete, and from the entries of the reflogs, which might refer
+//to objects that are gone already.
+func (repo *Repository) walkReachable() (*objectWalk, error) {
+	refs, err := repo.listRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	walk, err := newObjectWalk(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		if ref.id == (SHA1{}) {
+			continue
+		}
+
+		err = walk.add(ref.id, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ids, err := repo.reflogIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		walk.add(id, true)
+	}
+
+	return walk, nil
+}
+
+//reflogIDs returns the ids of the entries of all reflogs.
+func (repo *Repository) reflogIDs() ([]SHA1, error) {
+	var ids []SHA1
+	base := filepath.Join(repo.Path, "logs")
+	err := filepath.Walk(base, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == base {
+				return nil
+			}
+			return err
+		} else if fi.IsDir() {
+			return nil
+		}
+
+		fd, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+
+		//"<old> <new> <committer>\t<message>"
+		s := bufio.NewScanner(fd)
+		for s.Scan() {
+			fields := strings.SplitN(s.Text(), " ", 3)
+			for i := 0; i < len(fields) && i < 2; i++ {
+				if id, err := ParseSHA1(fields[i]); err == nil && id != (SHA1{}) {
+					ids = append(ids, id)
+				}
+			}
+		}
+		return s.Err()
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read reflogs: %v", err)
+	}
+
+	return ids, nil
+}
+
+//looseObject is a loose object file.
+type looseObject struct {
+	id    SHA1
+	path  string
+	mtime time.Time
+}
+
+//looseObjects returns all loose objects of the repository.
+func (repo *Repository) looseObjects() ([]looseObject, error) {
+	dirs, err := filepath.Glob(filepath.Join(repo.Path, "objects", "[0-9a-f][0-9a-f]"))
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []looseObject
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fi := range files {
+			id, err := ParseSHA1(filepath.Base(dir) + fi.Name())
+			if err != nil {
+				//temporary files and the like
+				continue
+			}
+
+			objects = append(objects, looseObject{id, filepath.Join(dir, fi.Name()), fi.ModTime()})
+		}
+	}
+
+	return objects, nil
+}
+
+//CountObjects returns the number of loose objects and
+//of packs, like "git count-objects" does.
+func (repo *Repository) CountObjects() (loose int, packs int, err error) {
+	objects, err := repo.looseObjects()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(repo.Path, "objects", "pack", "*.idx"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return len(objects), len(files), nil
+}
+
+//packedObjects returns the ids of all objects in the pack with
+//the index file idx.
+func packedObjects(idx string) ([]SHA1, error) {
+	pi, err := PackIndexOpen(idx)
+	if err != nil {
+		return nil, err
+	}
+	defer pi.Close()
+
+	ids := make([]SHA1, int(pi.FO[255]))
+	for pos := range ids {
+		err = pi.ReadSHA1(&ids[pos], pos)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, nil
+}
+
+//cruftKeep is the content of the ".keep" file of cruft packs, which
+//tells them apart from packs that are kept for other reasons.
+const cruftKeep = "gin: unreachable objects\n"
+
+//isCruftPack checks if the pack name is a cruft pack.
+func isCruftPack(name string) bool {
+	data, err := ioutil.ReadFile(name + ".keep")
+	return err == nil && string(data) == cruftKeep
+}
+
+//CountCruftPacks returns the number of cruft packs
+//written by RepackCruft.
+func (repo *Repository) CountCruftPacks() (int, error) {
+	files, err := filepath.Glob(filepath.Join(repo.Path, "objects", "pack", "*.keep"))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, f := range files {
+		if isCruftPack(strings.TrimSuffix(f, ".keep")) {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+//RepackResult describes what Repack did.
+type RepackResult struct {
+	Pack      SHA1 //the new pack, the zero id if nothing was packed
+	Objects   int  //number of objects in the new pack
+	Removed   int  //number of packs replaced by the new one
+	Loose     int  //number of loose objects removed, since they are packed now
+	Unpacked  int  //number of unreachable objects of the removed packs kept as loose objects
+	Cruft     int  //number of unreachable objects written to the cruft pack, see RepackCruft
+	CruftPack SHA1 //the new cruft pack, the zero id if none was written
+}
+
+//Repack writes all objects reachable from refs and reflogs into a
+//single new pack and removes the old packs and the loose objects,
+//like "git repack -a -d -A" followed by "git prune-packed" does.
+//Packs with a ".keep" file are left alone, so are objects that are
+//only found in the alternates of the repository. Unreachable objects
+//of the removed packs are kept as loose objects with the modification
+//time of their pack, so that PruneLoose removes them after its grace
+//period, unless the pack is older than expire already. Cruft packs
+//are replaced like any other pack.
+//The caller should hold the exclusive lock of the repository.
+func (repo *Repository) Repack(expire time.Time) (*RepackResult, error) {
+	return repo.repack(expire, false)
+}
+
+//RepackCruft repacks the repository like Repack, but keeps the
+//unreachable objects of the removed packs, and the unreachable loose
+//objects, in a cruft pack instead, i.e. a pack with a ".keep" file.
+//Cruft packs are left alone by later calls of RepackCruft, so nothing
+//is ever pruned from them. This is meant for repositories that others
+//borrow objects from via their alternates, which might need objects
+//that are unreachable from the refs of the repository itself.
+//The caller should hold the exclusive lock of the repository.
+func (repo *Repository) RepackCruft() (*RepackResult, error) {
+	return repo.repack(time.Time{}, true)
+}
+
+func (repo *Repository) repack(expire time.Time, cruft bool) (*RepackResult, error) {
+	walk, err := repo.walkReachable()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(repo.Path, "objects", "pack")
+	files, err := filepath.Glob(filepath.Join(dir, "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make(map[SHA1]bool)
+	var old []string
+	for _, f := range files {
+		name := strings.TrimSuffix(f, ".idx")
+		if _, err := os.Stat(name + ".keep"); err != nil || (!cruft && isCruftPack(name)) {
+			old = append(old, name)
+			continue
+		}
+
+		ids, err := packedObjects(f)
+		if err != nil {
+			return nil, fmt.Errorf("git: could not read kept pack: %v", err)
+		}
+		for _, id := range ids {
+			kept[id] = true
+		}
+	}
+
+	alts, err := repo.Alternates()
+	if err != nil {
+		return nil, err
+	}
+
+	pw := NewPackWriter(repo)
+	packed := make(map[SHA1]bool, len(walk.objects))
+	for _, obj := range walk.objects {
+		packed[obj.id] = true
+		if kept[obj.id] {
+			continue
+		} else if len(alts) > 0 && !repo.hasLocalObject(obj.id) {
+			//borrowed from an alternate, like "git repack -l"
+			continue
+		}
+
+		err = pw.AddObject(obj.id, obj.hint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := &RepackResult{Objects: pw.Len()}
+	newName := ""
+	if pw.Len() > 0 {
+		res.Pack, err = repo.WritePack(pw)
+		if err != nil {
+			return nil, err
+		}
+		newName = filepath.Join(dir, "pack-"+res.Pack.String())
+	}
+
+	loose, err := repo.looseObjects()
+	if err != nil {
+		return res, err
+	}
+
+	cruftName := ""
+	var unreachable map[SHA1]bool
+	if cruft {
+		unreachable, err = repo.writeCruftPack(old, loose, walk.seen, kept, res)
+		if err != nil {
+			return res, err
+		}
+		cruftName = filepath.Join(dir, "pack-"+res.CruftPack.String())
+	}
+
+	//all old packs are unpacked before the first is removed,
+	//to have the bases of their deltas still around
+	var removed []string
+	for _, name := range old {
+		if name == newName || name == cruftName {
+			continue
+		}
+
+		if !cruft {
+			n, err := repo.unpackUnreachable(name, walk.seen, expire)
+			if err != nil {
+				return res, err
+			}
+			res.Unpacked += n
+		}
+
+		removed = append(removed, name)
+	}
+
+	//the index goes first, since packs are found via it
+	for _, name := range removed {
+		for _, ext := range []string{".idx", ".pack", ".bitmap", ".rev", ".keep"} {
+			err = os.Remove(name + ext)
+			if err != nil && !os.IsNotExist(err) {
+				return res, fmt.Errorf("git: could not remove old pack: %v", err)
+			}
+		}
+		res.Removed++
+	}
+
+	//the multi-pack-index refers to the old packs
+	if len(removed) > 0 {
+		err = os.Remove(filepath.Join(dir, "multi-pack-index"))
+		if err != nil && !os.IsNotExist(err) {
+			return res, fmt.Errorf("git: could not remove multi-pack-index: %v", err)
+		}
+	}
+
+	for _, obj := range loose {
+		if !packed[obj.id] && !kept[obj.id] && !unreachable[obj.id] {
+			continue
+		}
+
+		err = os.Remove(obj.path)
+		if err != nil {
+			return res, fmt.Errorf("git: could not remove packed object: %v", err)
+		}
+		res.Loose++
+
+		//fails unless the directory is empty now
+		os.Remove(filepath.Dir(obj.path))
+	}
+
+	return res, nil
+}
+
+//writeCruftPack writes the objects of the packs in old and the loose
+//objects, which are neither reachable nor in a kept pack, to a cruft
+//pack and returns them. Nothing is written if there are none.
+func (repo *Repository) writeCruftPack(old []string, loose []looseObject, reachable, kept map[SHA1]bool, res *RepackResult) (map[SHA1]bool, error) {
+	unreachable := make(map[SHA1]bool)
+	add := func(id SHA1) {
+		if !reachable[id] && !kept[id] {
+			unreachable[id] = true
+		}
+	}
+
+	for _, name := range old {
+		ids, err := packedObjects(name + ".idx")
+		if err != nil {
+			return nil, fmt.Errorf("git: could not read pack index: %v", err)
+		}
+		for _, id := range ids {
+			add(id)
+		}
+	}
+
+	for _, obj := range loose {
+		add(obj.id)
+	}
+
+	if len(unreachable) == 0 {
+		return unreachable, nil
+	}
+
+	pw := NewPackWriter(repo)
+	for id := range unreachable {
+		err := pw.AddObject(id, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := repo.WritePack(pw)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Join(repo.Path, "objects", "pack", "pack-"+id.String())
+	err = ioutil.WriteFile(name+".keep", []byte(cruftKeep), 0666)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not write keep file: %v", err)
+	}
+
+	res.CruftPack = id
+	res.Cruft = pw.Len()
+	return unreachable, nil
+}
+
+//unpackUnreachable writes the objects of the pack name which are not
+//reachable as loose objects, with the modification time of the pack.
+//Nothing is written if the pack was last modified before expire.
+func (repo *Repository) unpackUnreachable(name string, reachable map[SHA1]bool, expire time.Time) (int, error) {
+	fi, err := os.Stat(name + ".pack")
+	if err != nil {
+		return 0, fmt.Errorf("git: could not stat pack: %v", err)
+	} else if fi.ModTime().Before(expire) {
+		return 0, nil
+	}
+
+	ids, err := packedObjects(name + ".idx")
+	if err != nil {
+		return 0, fmt.Errorf("git: could not read pack index: %v", err)
+	}
+
+	n := 0
+	for _, id := range ids {
+		idstr := id.String()
+		path := filepath.Join(repo.Path, "objects", idstr[:2], idstr[2:])
+		if reachable[id] {
+			continue
+		} else if _, err := os.Stat(path); err == nil {
+			continue
+		}
+
+		otype, data, err := repo.readObjectData(id)
+		if err == nil {
+			err = repo.writeLoose(id, otype, data)
+		}
+		if err == nil {
+			err = os.Chtimes(path, fi.ModTime(), fi.ModTime())
+		}
+		if err != nil {
+			return n, fmt.Errorf("git: could not unpack %s: %v", id, err)
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+//PruneLoose removes the loose objects that are unreachable and were
+//last modified before expire, like "git prune --expire" does. Like
+//git, objects reachable from more recent loose objects are kept, since
+//these might be part of an operation in progress. Temporary files left
+//behind by aborted writes are removed as well, once they are older
+//than expire. It returns the number of removed objects.
+//The caller should hold the exclusive lock of the repository.
+func (repo *Repository) PruneLoose(expire time.Time) (int, error) {
+	walk, err := repo.walkReachable()
+	if err != nil {
+		return 0, err
+	}
+
+	loose, err := repo.looseObjects()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, obj := range loose {
+		if !obj.mtime.Before(expire) {
+			walk.add(obj.id, true)
+		}
+	}
+
+	n := 0
+	for _, obj := range loose {
+		if walk.seen[obj.id] {
+			continue
+		}
+
+		err = os.Remove(obj.path)
+		if err != nil {
+			return n, fmt.Errorf("git: could not prune object: %v", err)
+		}
+		n++
+
+		//fails unless the directory is empty now
+		os.Remove(filepath.Dir(obj.path))
+	}
+
+	tmps, err := filepath.Glob(filepath.Join(repo.Path, "objects", "[0-9a-f][0-9a-f]", "tmp_obj_*"))
+	if err != nil {
+		return n, err
+	}
+
+	packTmps, err := filepath.Glob(filepath.Join(repo.Path, "objects", "pack", "tmp_*"))
+	if err != nil {
+		return n, err
+	}
+
+	for _, tmp := range append(tmps, packTmps...) {
+		if fi, err := os.Stat(tmp); err == nil && fi.ModTime().Before(expire) {
+			os.Remove(tmp)
+		}
+	}
+
+	return n, nil
+}
+
+//PackRefs moves all loose refs but symbolic ones to "packed-refs",
+//like "git pack-refs --all --prune" does. Annotated tags are stored
+//together with the id they peel to. It returns the number of loose
+//refs that were removed.
+//The caller should hold the exclusive lock of the repository.
+func (repo *Repository) PackRefs() (int, error) {
+	path := filepath.Join(repo.Path, "packed-refs")
+	lock, err := os.OpenFile(path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return 0, fmt.Errorf("git: could not lock packed-refs: packed-refs.lock exists")
+	} else if err != nil {
+		return 0, fmt.Errorf("git: could not lock packed-refs: %v", err)
+	}
+	defer os.Remove(path + ".lock")
+
+	refs, err := repo.listRefs()
+	if err != nil {
+		lock.Close()
+		return 0, err
+	}
+
+	//listRefs is sorted by name, like packed-refs must be
+	w := bufio.NewWriter(lock)
+	w.WriteString("# pack-refs with: peeled fully-peeled sorted \n")
+	for _, ref := range refs {
+		if ref.name == "HEAD" || ref.symref != "" || ref.id == (SHA1{}) {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s %s\n", ref.id, ref.name)
+
+		peeled, err := repo.peel(ref.id, 0)
+		if err != nil {
+			lock.Close()
+			return 0, fmt.Errorf("git: could not peel %q: %v", ref.name, err)
+		} else if peeled != ref.id {
+			fmt.Fprintf(w, "^%s\n", peeled)
+		}
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = lock.Sync()
+	}
+	if cerr := lock.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".lock", path)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("git: could not write packed-refs: %v", err)
+	}
+
+	n := 0
+	for _, ref := range refs {
+		if ref.name == "HEAD" || ref.symref != "" || ref.id == (SHA1{}) {
+			continue
+		}
+
+		ok, err := repo.pruneLooseRef(ref.name, ref.id)
+		if err != nil {
+			return n, err
+		} else if ok {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+//pruneLooseRef removes the loose ref name, if it still points to id
+//and is not locked by a ref transaction.
+func (repo *Repository) pruneLooseRef(name string, id SHA1) (bool, error) {
+	path := filepath.Join(repo.Path, filepath.FromSlash(name))
+	fd, err := os.OpenFile(path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) || os.IsNotExist(err) {
+		//locked or packed only
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("git: could not lock ref %q: %v", name, err)
+	}
+	fd.Close()
+	defer os.Remove(path + ".lock")
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("git: could not read ref %q: %v", name, err)
+	}
+
+	if current, err := ParseSHA1(string(data)); err != nil || current != id {
+		return false, nil
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return false, fmt.Errorf("git: could not remove ref %q: %v", name, err)
+	}
+
+	os.Remove(path + ".lock")
+	repo.removeEmptyRefDirs(name)
+	return true, nil
+}
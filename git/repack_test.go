@@ -0,0 +1,196 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepack(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("first", map[string]string{"a.txt": "a\n", "dir/b.txt": "b\n"})
+	tr.git("tag", "-a", "-m", "tag", "v1.0")
+	tr.git("repack", "-q")
+	tr.commit("second", map[string]string{"a.txt": "aa\n"})
+	tr.git("repack", "-q")
+	third := tr.commit("third", map[string]string{"dir/c.txt": "c\n"})
+
+	//unreachable objects, one in a pack and one loose
+	pw := NewPackWriter(nil)
+	inPack := pw.AddData(ObjBlob, []byte("unreachable, packed\n"))
+	if _, err := tr.WritePack(pw); err != nil {
+		t.Fatalf("could not write pack: %v", err)
+	}
+
+	loose, err := tr.writeObjectData(ObjBlob, []byte("unreachable, loose\n"))
+	if err != nil {
+		t.Fatalf("could not write object: %v", err)
+	}
+
+	objects := tr.git("rev-list", "--objects", "--all")
+
+	res, err := tr.Repack(time.Time{})
+	if err != nil {
+		t.Fatalf("repack failed: %v", err)
+	} else if res.Removed != 3 || res.Unpacked != 1 || res.Loose == 0 {
+		t.Fatalf("unexpected repack result: %+v", res)
+	}
+
+	tr.git("fsck", "--strict", "--no-dangling")
+	if n, packs, _ := tr.CountObjects(); n != 2 || packs != 1 {
+		t.Fatalf("expected 2 loose objects and 1 pack after repack, got %d and %d", n, packs)
+	} else if res.Objects != len(strings.Split(objects, "\n")) {
+		t.Fatalf("expected %d objects in the pack, got %d", len(strings.Split(objects, "\n")), res.Objects)
+	} else if id := tr.revParse("HEAD"); id != third {
+		t.Fatalf("HEAD is %s after repack, expected %s", id, third)
+	}
+
+	//the objects are still too recent
+	n, err := tr.PruneLoose(time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Fatalf("pruned %d objects (%v), expected none", n, err)
+	}
+
+	n, err = tr.PruneLoose(time.Now().Add(time.Minute))
+	if err != nil || n != 2 {
+		t.Fatalf("pruned %d objects (%v), expected 2", n, err)
+	} else if tr.hasObject(inPack) || tr.hasObject(loose) {
+		t.Fatalf("unreachable objects were not pruned")
+	}
+	tr.git("fsck", "--strict")
+
+	//repacking again does not lose anything
+	res, err = tr.Repack(time.Time{})
+	if err != nil || res.Unpacked != 0 || res.Objects != len(strings.Split(objects, "\n")) {
+		t.Fatalf("unexpected result for second repack %+v (%v)", res, err)
+	}
+	tr.git("fsck", "--strict")
+
+	n, err = tr.PackRefs()
+	if err != nil || n != 2 {
+		t.Fatalf("packed %d refs (%v), expected 2", n, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tr.Path, "refs", "heads", "master")); !os.IsNotExist(err) {
+		t.Fatalf("loose ref still exists after PackRefs")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(tr.Path, "packed-refs"))
+	if err != nil {
+		t.Fatalf("could not read packed-refs: %v", err)
+	}
+
+	tag := tr.revParse("v1.0")
+	wanted := "# pack-refs with: peeled fully-peeled sorted \n" +
+		third.String() + " refs/heads/master\n" +
+		tag.String() + " refs/tags/v1.0\n" +
+		"^" + tr.revParse("v1.0^{}").String() + "\n"
+	if string(data) != wanted {
+		t.Fatalf("unexpected packed-refs:\n%s\nexpected:\n%s", data, wanted)
+	}
+
+	if refs := tr.git("show-ref"); !strings.Contains(refs, third.String()+" refs/heads/master") {
+		t.Fatalf("git cannot read packed refs: %q", refs)
+	}
+	tr.git("fsck", "--strict")
+}
+
+func TestRepackCruft(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("first", map[string]string{"a.txt": "a\n"})
+	tr.git("repack", "-q")
+	tr.commit("second", map[string]string{"a.txt": "aa\n"})
+
+	pw := NewPackWriter(nil)
+	inPack := pw.AddData(ObjBlob, []byte("unreachable, packed\n"))
+	if _, err := tr.WritePack(pw); err != nil {
+		t.Fatalf("could not write pack: %v", err)
+	}
+
+	loose, err := tr.writeObjectData(ObjBlob, []byte("unreachable, loose\n"))
+	if err != nil {
+		t.Fatalf("could not write object: %v", err)
+	}
+
+	res, err := tr.RepackCruft()
+	if err != nil {
+		t.Fatalf("repack failed: %v", err)
+	} else if res.Cruft != 2 || res.Unpacked != 0 || res.Removed != 2 || res.CruftPack == (SHA1{}) {
+		t.Fatalf("unexpected repack result: %+v", res)
+	}
+
+	keep := filepath.Join(tr.Path, "objects", "pack", "pack-"+res.CruftPack.String()+".keep")
+	if _, err := os.Stat(keep); err != nil {
+		t.Fatalf("cruft pack not kept: %v", err)
+	} else if n, packs, _ := tr.CountObjects(); n != 0 || packs != 2 {
+		t.Fatalf("expected no loose objects and 2 packs, got %d and %d", n, packs)
+	} else if !tr.hasObject(inPack) || !tr.hasObject(loose) {
+		t.Fatalf("unreachable objects are gone")
+	}
+	tr.git("fsck", "--strict")
+
+	//the cruft pack is left alone, the regular one is unchanged
+	another, err := tr.writeObjectData(ObjBlob, []byte("unreachable, too\n"))
+	if err != nil {
+		t.Fatalf("could not write object: %v", err)
+	}
+
+	res, err = tr.RepackCruft()
+	if err != nil || res.Cruft != 1 || res.Removed != 0 {
+		t.Fatalf("unexpected result for second repack %+v (%v)", res, err)
+	} else if n, err := tr.CountCruftPacks(); err != nil || n != 2 {
+		t.Fatalf("expected 2 cruft packs, got %d (%v)", n, err)
+	}
+
+	//a regular repack unpacks the cruft again
+	res, err = tr.Repack(time.Time{})
+	if err != nil || res.Unpacked != 3 || res.Removed != 2 {
+		t.Fatalf("unexpected result for regular repack %+v (%v)", res, err)
+	} else if n, err := tr.CountCruftPacks(); err != nil || n != 0 {
+		t.Fatalf("expected no cruft packs, got %d (%v)", n, err)
+	} else if n, packs, _ := tr.CountObjects(); n != 3 || packs != 1 {
+		t.Fatalf("expected 3 loose objects and 1 pack, got %d and %d", n, packs)
+	} else if !tr.hasObject(another) {
+		t.Fatalf("unreachable object is gone")
+	}
+	tr.git("fsck", "--strict")
+}
+
+func TestRepoLock(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	shared, err := tr.LockShared()
+	if err != nil {
+		t.Fatalf("could not lock repository: %v", err)
+	}
+
+	other, err := tr.LockShared()
+	if err != nil {
+		t.Fatalf("could not lock repository twice: %v", err)
+	}
+
+	if _, err := tr.TryLockExclusive(); err != ErrRepoBusy {
+		t.Fatalf("expected busy repository, got %v", err)
+	}
+
+	shared.Unlock()
+	other.Unlock()
+
+	exclusive, err := tr.TryLockExclusive()
+	if err != nil {
+		t.Fatalf("could not lock unused repository: %v", err)
+	}
+
+	if _, err := tr.TryLockExclusive(); err != ErrRepoBusy {
+		t.Fatalf("expected busy repository, got %v", err)
+	}
+	exclusive.Unlock()
+}
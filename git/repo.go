@@ -1,8 +1,6 @@
 package git
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -131,66 +129,127 @@ func (repo *Repository) OpenObject(id SHA1) (Object, error) {
 		return nil, err
 	}
 
+	//NB: buildDeltaChain and resolve enforce MaxDeltaDepth
+	// and MaxDeltaSize respectively
 	chain, err := buildDeltaChain(delta, repo)
 
 	if err != nil {
 		return nil, err
 	}
 
-	//TODO: check depth, and especially expected memory usage
-	// beofre actually patching it
+	//large blobs are read on demand, see StreamBlobSize
+	if size := delta.SizeTarget; size > StreamBlobSize && chain.objectType() == ObjBlob {
+		br, err := chain.blobReader(repo)
+		if err != nil {
+			return nil, err
+		}
+		return &Blob{gitObject{ObjBlob, size, br}}, nil
+	}
 
 	return chain.resolve()
 }
 
+//openRawObject opens the object id, which is searched for as loose
+//object and in the packs of the repository and of its alternates.
 func (repo *Repository) openRawObject(id SHA1) (gitObject, error) {
+	dirs, err := repo.objectDirs()
+	if err != nil {
+		return gitObject{}, err
+	}
+
 	idstr := id.String()
-	opath := filepath.Join(repo.Path, "objects", idstr[:2], idstr[2:])
+	for _, dir := range dirs {
+		obj, err := openRawObject(filepath.Join(dir, idstr[:2], idstr[2:]))
+		if err == nil {
+			return obj, nil
+		} else if !os.IsNotExist(err) {
+			return obj, err
+		}
+	}
+
+	pf, off, err := findPackedObject(dirs, id)
+	if err != nil {
+		return gitObject{}, err
+	}
 
-	obj, err := openRawObject(opath)
+	return pf.readRawObject(off)
+}
 
-	if err == nil {
-		return obj, nil
-	} else if err != nil && !os.IsNotExist(err) {
-		return obj, err
+//readObjectInfo returns the type and the size of the object id
+//without reading its data. For delta objects, the chain is
+//followed to the base object to determine the type.
+func (repo *Repository) readObjectInfo(id SHA1) (ObjectType, int64, error) {
+	obj, err := repo.openRawObject(id)
+	if err != nil {
+		return 0, 0, err
 	}
+	defer obj.Close()
 
-	indicies := repo.loadPackIndices()
+	if IsStandardObject(obj.otype) {
+		return obj.otype, obj.size, nil
+	} else if !IsDeltaObject(obj.otype) {
+		return 0, 0, fmt.Errorf("git: unsupported object")
+	}
 
-	for _, f := range indicies {
+	delta, err := parseDelta(obj)
+	if err != nil {
+		return 0, 0, err
+	}
 
-		idx, err := PackIndexOpen(f)
-		if err != nil {
-			continue
-		}
+	chain, err := buildDeltaChain(delta, repo)
+	if err != nil {
+		return 0, 0, err
+	}
 
-		//TODO: we should leave index files open,
-		defer idx.Close()
+	if chain.base != nil {
+		return chain.base.otype, delta.SizeTarget, nil
+	}
 
-		off, err := idx.FindOffset(id)
+	chain.baseObj.Close()
+	return chain.baseObj.otype, delta.SizeTarget, nil
+}
 
-		if err != nil {
-			continue
-		}
+//readObjectData returns the type and the (uncompressed) data
+//of the object id, i.e. without the "<type> <size>\0" header.
+//Delta objects are resolved.
+func (repo *Repository) readObjectData(id SHA1) (ObjectType, []byte, error) {
+	obj, err := repo.openRawObject(id)
+	if err != nil {
+		return 0, nil, err
+	}
 
-		pf, err := idx.OpenPackFile()
-		if err != nil {
-			return gitObject{}, err
-		}
+	return repo.readRawData(obj)
+}
 
-		obj, err := pf.readRawObject(off)
+//readRawData reads the data of the raw object obj, like
+//readObjectData does.
+func (repo *Repository) readRawData(obj gitObject) (ObjectType, []byte, error) {
+	if IsStandardObject(obj.otype) {
+		defer obj.Close()
 
+		data, err := ioutil.ReadAll(obj.source)
 		if err != nil {
-			return gitObject{}, err
+			return 0, nil, err
+		} else if int64(len(data)) != obj.size {
+			return 0, nil, io.ErrUnexpectedEOF
 		}
 
-		return obj, nil
+		return obj.otype, data, nil
+	} else if !IsDeltaObject(obj.otype) {
+		return 0, nil, fmt.Errorf("git: unsupported object")
 	}
 
-	// from inspecting the os.isNotExist source it
-	// seems that if we have "not found" in the message
-	// os.IsNotExist() report true, which is what we want
-	return gitObject{}, fmt.Errorf("git: object not found")
+	delta, err := parseDelta(obj)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	chain, err := buildDeltaChain(delta, repo)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return chain.resolveData()
 }
 
 func (repo *Repository) loadPackIndices() []string {
@@ -268,6 +327,8 @@ func (repo *Repository) Readlink(id SHA1) (string, error) {
 //ObjectForPath will resolve the path to an object
 //for the file tree starting in the node root.
 //The root object can be either a Commit, Tree or Tag.
+//For submodules a *Submodule is returned, with the
+//URL from .gitmodules unless root is a Tree.
 func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, error) {
 
 	var node Object
@@ -312,11 +373,10 @@ func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, erro
 			continue
 		}
 
-		var id *SHA1
+		var entry *TreeEntry
 		for tree.Next() {
-			entry := tree.Entry()
-			if entry.Name == comps[i] {
-				id = &entry.ID
+			if tree.Entry().Name == comps[i] {
+				entry = tree.Entry()
 				break
 			}
 		}
@@ -327,15 +387,17 @@ func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, erro
 				Op:   "find object",
 				Path: cwd,
 				Err:  err}
-		} else if id == nil {
+		} else if entry == nil {
 			cwd := strings.Join(comps[:i+1], "/")
 			return nil, &os.PathError{
 				Op:   "find object",
 				Path: cwd,
 				Err:  os.ErrNotExist}
+		} else if entry.IsSubmodule() {
+			return repo.submoduleForPath(root, comps[:i+1], comps[i+1:], entry.ID)
 		}
 
-		node, err = repo.OpenObject(*id)
+		node, err = repo.OpenObject(entry.ID)
 		if err != nil {
 			cwd := strings.Join(comps[:i+1], "/")
 			return nil, &os.PathError{
@@ -349,15 +411,40 @@ func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, erro
 	return node, nil
 }
 
-// usefmt is the option string used by CommitsForRef to return a formatted git commit log.
-const usefmt = `--pretty=format:
-Commit:=%H%n
-Committer:=%cn%n
-Author:=%an%n
-Date-iso:=%ai%n
-Date-rel:=%ar%n
-Subject:=%s%n
-Changes:=`
+//submoduleForPath returns the submodule pinned at the path comps
+//of the tree of root. The content of submodules is in a different
+//repository, therefore paths within them (rest) do not exist.
+func (repo *Repository) submoduleForPath(root Object, comps, rest []string, commit SHA1) (Object, error) {
+	cwd := strings.Join(comps, "/")
+	if len(rest) > 0 {
+		return nil, &os.PathError{
+			Op:   "enter submodule",
+			Path: cwd + "/" + strings.Join(rest, "/"),
+			Err:  os.ErrNotExist}
+	}
+
+	sm := &Submodule{Path: cwd, Commit: commit}
+
+	//a tree root is consumed by now, and .gitmodules must not
+	//be looked up via itself
+	if _, ok := root.(*Tree); ok || cwd == ".gitmodules" {
+		return sm, nil
+	}
+
+	modules, err := repo.Submodules(root)
+	if err != nil {
+		return nil, &os.PathError{
+			Op:   "read .gitmodules",
+			Path: cwd,
+			Err:  err}
+	}
+
+	if config, ok := modules[cwd]; ok {
+		sm.Name, sm.URL = config.Name, config.URL
+	}
+
+	return sm, nil
+}
 
 // CommitSummary represents a subset of information from a git commit.
 type CommitSummary struct {
@@ -370,80 +457,10 @@ type CommitSummary struct {
 	Changes      []string
 }
 
-// CommitsForRef executes a custom git log command for the specified ref of the
-// associated git repository and returns the resulting byte array.
+// CommitsForRef returns the summaries of all commits reachable from
+// the specified ref, youngest first, as "git log --name-status" would.
 func (repo *Repository) CommitsForRef(ref string) ([]CommitSummary, error) {
-
-	raw, err := commitsForRef(repo.Path, ref, usefmt)
-	if err != nil {
-		return nil, err
-	}
-
-	sep := ":="
-	var comList []CommitSummary
-	r := bytes.NewReader(raw)
-	br := bufio.NewReader(r)
-
-	var changesFlag bool
-	for {
-		// Consume line until newline character
-		l, err := br.ReadString('\n')
-
-		if strings.Contains(l, sep) {
-			splitList := strings.SplitN(l, sep, 2)
-
-			key := splitList[0]
-			val := splitList[1]
-			switch key {
-			case "Commit":
-				// reset non key line flags
-				changesFlag = false
-				newCommit := CommitSummary{Commit: val}
-				comList = append(comList, newCommit)
-			case "Committer":
-				comList[len(comList)-1].Committer = val
-			case "Author":
-				comList[len(comList)-1].Author = val
-			case "Date-iso":
-				comList[len(comList)-1].DateIso = val
-			case "Date-rel":
-				comList[len(comList)-1].DateRelative = val
-			case "Subject":
-				comList[len(comList)-1].Subject = val
-			case "Changes":
-				// Setting changes flag so we know, that the next lines are probably file change notification lines.
-				changesFlag = true
-			default:
-				fmt.Printf("[W] commits: unexpected key %q, value %q\n", key, strings.Trim(val, "\n"))
-			}
-		} else if changesFlag && strings.Contains(l, "\t") {
-			comList[len(comList)-1].Changes = append(comList[len(comList)-1].Changes, l)
-		}
-
-		// Breaks at the latest when EOF err is raised
-		if err != nil {
-			break
-		}
-	}
-	if err != io.EOF && err != nil {
-		return nil, err
-	}
-
-	return comList, nil
-}
-
-// commitsForRef executes a custom git log command for the specified ref of the
-// given git repository with the specified log format string and returns the resulting byte array.
-// Function is kept private to force handling of the []byte inside the package.
-func commitsForRef(repoPath, ref, usefmt string) ([]byte, error) {
-	gdir := fmt.Sprintf("--git-dir=%s", repoPath)
-
-	cmd := exec.Command("git", gdir, "log", ref, usefmt, "--name-status")
-	body, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed running git log: %s\n", err.Error())
-	}
-	return body, nil
+	return repo.Log(ref, LogOptions{})
 }
 
 // BranchExists runs the "git branch <branchname> --list" command.
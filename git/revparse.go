@@ -0,0 +1,317 @@
+package git
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//MinAbbrev is the minimal length of abbreviated object ids.
+const MinAbbrev = 4
+
+//RevParse resolves the revision expression rev to an object id,
+//like "git rev-parse" does. Supported are:
+//  <sha1>, <abbreviated sha1>, <refname>, HEAD, @
+//  <rev>~<n>          the n-th first-parent ancestor
+//  <rev>^<n>          the n-th parent (^0 is the commit itself)
+//  <rev>^{<type>}     peel tags (and commits) until type is reached
+//  <rev>^{}           peel tags until a non-tag object is reached
+//  <rev>:<path>       the object at path in the tree of rev
+//Annotated tags are peeled as needed, e.g. "v1.0~1" is the
+//parent of the commit the tag v1.0 points to.
+func (repo *Repository) RevParse(rev string) (SHA1, error) {
+	if idx := strings.Index(rev, ":"); idx > -1 {
+		return repo.revParsePath(rev[:idx], rev[idx+1:])
+	}
+
+	base := rev
+	idx := strings.IndexAny(rev, "~^")
+	if idx > -1 {
+		base = rev[:idx]
+	}
+
+	oid, err := repo.resolveName(base)
+	if err != nil {
+		return oid, err
+	}
+
+	for s := rev[len(base):]; len(s) > 0; {
+		op := s[0]
+		s = s[1:]
+
+		if op == '^' && strings.HasPrefix(s, "{") {
+			end := strings.Index(s, "}")
+			if end < 0 {
+				return oid, fmt.Errorf("git: invalid revision %q", rev)
+			}
+
+			var want ObjectType
+			if name := s[1:end]; name != "" {
+				want, err = ParseObjectType(name)
+				if err != nil {
+					return oid, fmt.Errorf("git: invalid revision %q: %v", rev, err)
+				}
+			}
+
+			s = s[end+1:]
+			oid, err = repo.peel(oid, want)
+			if err != nil {
+				return oid, err
+			}
+			continue
+		}
+
+		n := 1
+		end := strings.IndexAny(s, "~^")
+		if end < 0 {
+			end = len(s)
+		}
+
+		if end > 0 {
+			n, err = strconv.Atoi(s[:end])
+			if err != nil || n < 0 {
+				return oid, fmt.Errorf("git: invalid revision %q", rev)
+			}
+			s = s[end:]
+		}
+
+		if op == '~' {
+			oid, err = repo.nthParent(oid, 1, n)
+		} else if n == 0 {
+			oid, err = repo.peel(oid, ObjCommit)
+		} else {
+			oid, err = repo.nthParent(oid, n, 1)
+		}
+
+		if err != nil {
+			return oid, fmt.Errorf("git: could not resolve %q: %v", rev, err)
+		}
+	}
+
+	return oid, nil
+}
+
+//resolveName resolves a ref name or a (possibly abbreviated)
+//object id. Like git, ref names take precedence over abbreviated
+//ids, but not over full ones.
+func (repo *Repository) resolveName(name string) (SHA1, error) {
+	if name == "" {
+		return SHA1{}, fmt.Errorf("git: empty revision")
+	} else if name == "@" {
+		name = "HEAD"
+	}
+
+	if len(name) == 40 {
+		if oid, err := ParseSHA1(name); err == nil {
+			return oid, nil
+		}
+	}
+
+	ref, err := repo.OpenRef(name)
+	if err == nil {
+		return ref.Resolve()
+	}
+
+	if len(name) >= MinAbbrev && isHex(name) {
+		return repo.findAbbrev(strings.ToLower(name))
+	}
+
+	return SHA1{}, fmt.Errorf("git: unknown revision %q", name)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+//findAbbrev returns the id of the single object whose (hex encoded)
+//id starts with prefix. Loose objects and packs are searched, but
+//not those of the alternates, which might contain objects that are
+//not reachable from the refs of the repository, see CheckReachable.
+func (repo *Repository) findAbbrev(prefix string) (SHA1, error) {
+	found := make(map[SHA1]bool)
+
+	dir := filepath.Join(repo.Path, "objects", prefix[:2])
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return SHA1{}, fmt.Errorf("git: could not read object dir: %v", err)
+	}
+
+	for _, fi := range files {
+		if !strings.HasPrefix(prefix[:2]+fi.Name(), prefix) {
+			continue
+		}
+		if oid, err := ParseSHA1(prefix[:2] + fi.Name()); err == nil {
+			found[oid] = true
+		}
+	}
+
+	err = repo.packs().findPrefix(prefix, found)
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	switch len(found) {
+	case 0:
+		return SHA1{}, fmt.Errorf("git: unknown revision %q", prefix)
+	case 1:
+		for oid := range found {
+			return oid, nil
+		}
+	}
+
+	return SHA1{}, fmt.Errorf("git: short sha1 %s is ambiguous", prefix)
+}
+
+//prefixMatches adds the ids starting with the hex encoded prefix
+//of an index with the fan-out table fo and ids sorted in ascending
+//order, readable via read, to found.
+func prefixMatches(fo FanOut, read func(*SHA1, int) error, prefix string, found map[SHA1]bool) error {
+	first, err := hex.DecodeString(prefix[:2])
+	if err != nil {
+		return err
+	}
+
+	s, e := fo.Bounds(first[0])
+
+	//find the first id >= prefix in [s, e)
+	lo, hi := s, e
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		var oid SHA1
+		if err := read(&oid, mid); err != nil {
+			return fmt.Errorf("git: io error: %v", err)
+		}
+
+		if oid.String() < prefix {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	for i := lo; i < e; i++ {
+		var oid SHA1
+		if err := read(&oid, i); err != nil {
+			return fmt.Errorf("git: io error: %v", err)
+		} else if !strings.HasPrefix(oid.String(), prefix) {
+			break
+		}
+		found[oid] = true
+	}
+
+	return nil
+}
+
+//peel follows tags (and for trees, commits) from oid until an object
+//of type want is reached. If want is zero, only tags are peeled.
+func (repo *Repository) peel(oid SHA1, want ObjectType) (SHA1, error) {
+	for {
+		obj, err := repo.OpenObject(oid)
+		if err != nil {
+			return oid, err
+		}
+		obj.Close()
+
+		if obj.Type() == want || (want == 0 && obj.Type() != ObjTag) {
+			return oid, nil
+		}
+
+		switch o := obj.(type) {
+		case *Tag:
+			oid = o.Object
+		case *Commit:
+			if want != ObjTree {
+				return oid, fmt.Errorf("git: %s is a commit, not a %s", oid, want)
+			}
+			oid = o.Tree
+		default:
+			return oid, fmt.Errorf("git: %s is a %s, not a %s", oid, obj.Type(), want)
+		}
+	}
+}
+
+//nthParent follows the n-th parent of the commit oid count times.
+func (repo *Repository) nthParent(oid SHA1, n, count int) (SHA1, error) {
+	oid, err := repo.peel(oid, ObjCommit)
+	if err != nil {
+		return oid, err
+	}
+
+	for i := 0; i < count; i++ {
+		obj, err := repo.OpenObject(oid)
+		if err != nil {
+			return oid, err
+		}
+		obj.Close()
+
+		commit := obj.(*Commit)
+		if n > len(commit.Parent) {
+			return oid, fmt.Errorf("git: commit %s has no parent %d", oid, n)
+		}
+
+		oid = commit.Parent[n-1]
+	}
+
+	return oid, nil
+}
+
+//revParsePath resolves "<rev>:<path>", i.e. the object at path
+//in the tree of the revision rev.
+func (repo *Repository) revParsePath(rev, pathstr string) (SHA1, error) {
+	if rev == "" {
+		return SHA1{}, fmt.Errorf("git: the index is not supported (in %q)", ":"+pathstr)
+	}
+
+	oid, err := repo.RevParse(rev)
+	if err != nil {
+		return oid, err
+	}
+
+	oid, err = repo.peel(oid, ObjTree)
+	if err != nil {
+		return oid, err
+	}
+
+	return repo.lookupPath(oid, pathstr)
+}
+
+//lookupPath returns the id of the object at path in the tree root.
+func (repo *Repository) lookupPath(root SHA1, pathstr string) (SHA1, error) {
+	oid := root
+	for _, comp := range strings.Split(pathstr, "/") {
+		if comp == "" || comp == "." {
+			continue
+		}
+
+		entries, err := repo.readTree(oid)
+		if err != nil {
+			return oid, &os.PathError{Op: "find object", Path: pathstr, Err: err}
+		}
+
+		var entry *TreeEntry
+		for _, e := range entries {
+			if e.Name == comp {
+				entry = e
+				break
+			}
+		}
+
+		if entry == nil {
+			return oid, &os.PathError{Op: "find object", Path: pathstr, Err: os.ErrNotExist}
+		}
+
+		oid = entry.ID
+	}
+
+	return oid, nil
+}
@@ -0,0 +1,87 @@
+package git
+
+import (
+	"testing"
+)
+
+func TestRevParse(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	ids := makeMergeRepo(tr)
+	tr.commit("dirs", map[string]string{"dir/sub/file.txt": "content\n"})
+	tr.git("tag", "-a", "-m", "annotated", "v1.0")
+	tr.git("tag", "-a", "-m", "tag of tag", "v1.0-outer", "v1.0")
+	tr.git("tag", "light", ids["merge"].String())
+
+	revs := []string{
+		"HEAD",
+		"master",
+		"@",
+		"HEAD~1",
+		"HEAD~",
+		"HEAD~~",
+		"HEAD^",
+		"HEAD^^^2",
+		"HEAD~2^3",
+		"HEAD~2^4~1",
+		"HEAD^0",
+		"HEAD~1^{tree}",
+		"HEAD^{commit}",
+		"v1.0",
+		"v1.0^{}",
+		"v1.0^{commit}",
+		"v1.0~1",
+		"v1.0-outer",
+		"v1.0-outer^{tag}",
+		"v1.0-outer^{}",
+		"v1.0-outer^{tree}",
+		"light^2",
+		"HEAD:dir",
+		"HEAD:dir/sub/file.txt",
+		"v1.0:root.txt",
+		"HEAD~3:a.txt",
+		ids["root"].String(),
+		ids["root"].String()[:7],
+		ids["root"].String()[:7] + "^{tree}",
+	}
+
+	check := func() {
+		for _, rev := range revs {
+			oid, err := tr.RevParse(rev)
+			if err != nil {
+				t.Fatalf("RevParse(%q) failed: %v", rev, err)
+			}
+
+			if wanted := tr.revParse(rev); oid != wanted {
+				t.Fatalf("RevParse(%q) => %s, expected %s", rev, oid, wanted)
+			}
+		}
+	}
+
+	//loose objects first, then packed
+	check()
+	tr.git("repack", "-a", "-d", "-q")
+	check()
+
+	invalid := []string{
+		"",
+		"doesnotexist",
+		"HEAD~x",
+		"HEAD^5",
+		ids["root"].String() + "^",
+		"HEAD^{blob}",
+		"HEAD^{foo}",
+		"HEAD^{tree",
+		"HEAD:doesnotexist",
+		"HEAD:root.txt/x",
+		":root.txt",
+		"00000000",
+	}
+
+	for _, rev := range invalid {
+		if oid, err := tr.RevParse(rev); err == nil {
+			t.Fatalf("RevParse(%q) => %s, expected error", rev, oid)
+		}
+	}
+}
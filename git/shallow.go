@@ -0,0 +1,52 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//ShallowCommits returns the shallow commits of the repository, as
+//listed in its "shallow" file, i.e. the commits whose parents are
+//not in the repository because it was cloned or fetched with a
+//limited depth. The parents of these commits must not be followed.
+//The map is empty if the repository is not shallow.
+func (repo *Repository) ShallowCommits() (map[SHA1]bool, error) {
+	shallow := make(map[SHA1]bool)
+
+	fd, err := os.Open(filepath.Join(repo.Path, "shallow"))
+	if err != nil && os.IsNotExist(err) {
+		return shallow, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("git: could not read shallow: %v", err)
+	}
+	defer fd.Close()
+
+	s := bufio.NewScanner(fd)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		id, err := ParseSHA1(line)
+		if err != nil {
+			return nil, fmt.Errorf("git: invalid shallow commit %q", line)
+		}
+		shallow[id] = true
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("git: could not read shallow: %v", err)
+	}
+
+	return shallow, nil
+}
+
+//IsShallow checks if the repository is a shallow one.
+func (repo *Repository) IsShallow() bool {
+	fi, err := os.Stat(filepath.Join(repo.Path, "shallow"))
+	return err == nil && fi.Size() > 0
+}
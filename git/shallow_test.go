@@ -0,0 +1,131 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShallowRepository(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("first", map[string]string{"a.txt": "a\n"})
+	tr.commit("second", map[string]string{"a.txt": "a\nb\n"})
+	boundary := tr.commit("third", map[string]string{"a.txt": "a\nb\nc\n", "dir/d.txt": "d\n"})
+	head := tr.commit("fourth", map[string]string{"a.txt": "a\nb\nc\nd\n"})
+
+	dir, err := ioutil.TempDir("", "gin-git-shallow")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	//the shallow mirror, with the last two commits
+	path := filepath.Join(dir, "mirror.git")
+	tr.git("clone", "-q", "--bare", "--depth", "2", "file://"+tr.work, path)
+	repo := &Repository{Path: path}
+
+	shallow, err := repo.ShallowCommits()
+	if err != nil || len(shallow) != 1 || !shallow[boundary] || !repo.IsShallow() {
+		t.Fatalf("unexpected shallow commits %v (%v)", shallow, err)
+	} else if tr.IsShallow() {
+		t.Fatalf("full repository is shallow")
+	}
+
+	walker, err := repo.NewCommitWalker([]SHA1{head}, LogOptions{})
+	if err != nil {
+		t.Fatalf("could not create walker: %v", err)
+	}
+
+	var ids []SHA1
+	for walker.Next() {
+		ids = append(ids, walker.ID())
+
+		//the shallow commit is shown like a root commit
+		changes, err := walker.Changes()
+		if err != nil {
+			t.Fatalf("could not get changes of %s: %v", walker.ID(), err)
+		} else if walker.ID() == boundary && len(changes) != 2 {
+			t.Fatalf("unexpected changes of shallow commit: %v", changes)
+		}
+	}
+	if err := walker.Err(); err != nil || len(ids) != 2 || ids[1] != boundary {
+		t.Fatalf("unexpected history %v (%v)", ids, err)
+	}
+
+	lines, err := repo.Blame(head, "a.txt")
+	if err != nil {
+		t.Fatalf("blame failed: %v", err)
+	} else if len(lines) != 4 || lines[0].Commit != boundary || lines[3].Commit != head {
+		t.Fatalf("unexpected blame %+v", lines)
+	}
+
+	report, err := repo.Fsck()
+	if err != nil || !report.OK() {
+		t.Fatalf("unexpected fsck result %v (%v)", report, err)
+	}
+
+	_, err = repo.Repack(time.Time{})
+	if err != nil {
+		t.Fatalf("repack failed: %v", err)
+	}
+	tr.git("--git-dir", path, "fsck", "--strict")
+
+	//clones of the mirror are shallow as well
+	ts := uploadPackServer(t, repo)
+	defer ts.Close()
+	url := ts.URL + "/repo.git"
+
+	for _, c := range []struct {
+		name    string
+		args    []string
+		commits string
+	}{
+		{"full", nil, "2"},
+		{"depth", []string{"--depth", "1"}, "1"},
+	} {
+		clone := filepath.Join(dir, c.name)
+		args := append([]string{"-c", "protocol.version=2", "clone", "-q"}, c.args...)
+		tr.git(append(args, url, clone)...)
+
+		tr.git("-C", clone, "fsck", "--strict")
+		if n := tr.git("-C", clone, "rev-list", "--count", "HEAD"); n != c.commits {
+			t.Fatalf("clone %s has %s commits, expected %s", c.name, n, c.commits)
+		} else if _, err := os.Stat(filepath.Join(clone, ".git", "shallow")); err != nil {
+			t.Fatalf("clone %s is not shallow: %v", c.name, err)
+		}
+	}
+
+	//the shallow commit is sent once, even though it is at the depth
+	var req bytes.Buffer
+	pw := &pktWriter{w: &req}
+	pw.Printf("command=fetch\n")
+	pw.Delim()
+	pw.Printf("want %s\n", head)
+	pw.Printf("deepen 2\n")
+	pw.Printf("done\n")
+	pw.Flush()
+
+	var res bytes.Buffer
+	err = NewUploadPack(repo).Serve(bytes.NewReader(req.Bytes()), &res)
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	} else if n := strings.Count(res.String(), "shallow "+boundary.String()); n != 1 {
+		t.Fatalf("shallow commit sent %d times, expected once", n)
+	}
+
+	//a broken shallow file is an error, not a complete history
+	err = ioutil.WriteFile(filepath.Join(path, "shallow"), []byte("broken\n"), 0644)
+	if err != nil {
+		t.Fatalf("could not write shallow file: %v", err)
+	}
+
+	if _, err := NewCommitGraph(repo).AddTip(head); err == nil {
+		t.Fatalf("expected error for broken shallow file")
+	}
+}
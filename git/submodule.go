@@ -0,0 +1,178 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+//Submodule is a gitlink entry of a tree, i.e. the commit of another
+//repository pinned at Path. The commit itself is not contained in the
+//repository. Name and URL come from the ".gitmodules" file of the
+//same revision and are empty if the submodule is not listed there.
+type Submodule struct {
+	Name   string
+	Path   string
+	Commit SHA1
+	URL    string
+}
+
+//Type returns ObjCommit, the type of the pinned object.
+func (sm *Submodule) Type() ObjectType {
+	return ObjCommit
+}
+
+//Size is always zero, since the commit is not in the repository.
+func (sm *Submodule) Size() int64 {
+	return 0
+}
+
+//WriteTo always fails, since the commit is not in the repository.
+func (sm *Submodule) WriteTo(w io.Writer) (int64, error) {
+	return 0, fmt.Errorf("git: submodule commit %s is not in the repository", sm.Commit)
+}
+
+//Close is a no-op.
+func (sm *Submodule) Close() error {
+	return nil
+}
+
+//IsSubmodule checks if the entry is a gitlink, i.e. a submodule.
+func (e *TreeEntry) IsSubmodule() bool {
+	return e.Mode&gitModeTypeMask == gitModeGitlink
+}
+
+//Submodules returns the submodules listed in the ".gitmodules" file
+//of the tree of root, which can be a Commit, Tree or Tag, keyed by
+//their path. The pinned commits are recorded in the tree only and
+//thus not set, see ObjectForPath. The map is empty if there is no
+//".gitmodules" file.
+func (repo *Repository) Submodules(root Object) (map[string]*Submodule, error) {
+	obj, err := repo.ObjectForPath(root, ".gitmodules")
+	if err != nil && os.IsNotExist(err) {
+		return make(map[string]*Submodule), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		return nil, fmt.Errorf("git: .gitmodules is a %s, not a blob", obj.Type())
+	}
+
+	return parseGitmodules(blob)
+}
+
+var submoduleSection = regexp.MustCompile(`^\[\s*(?i:submodule)\s+"((?:[^"\\]|\\.)*)"\s*\]`)
+
+//parseGitmodules parses the (config file formatted) content of a
+//".gitmodules" file. Only the "path" and "url" of the "submodule"
+//sections are of interest, everything else is skipped.
+func parseGitmodules(r io.Reader) (map[string]*Submodule, error) {
+	var names []string
+	byName := make(map[string]*Submodule)
+
+	var cur *Submodule
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[':
+			cur = nil
+			if m := submoduleSection.FindStringSubmatch(line); m != nil {
+				name := unescapeConfig(m[1])
+				if cur = byName[name]; cur == nil {
+					cur = &Submodule{Name: name}
+					byName[name] = cur
+					names = append(names, name)
+				}
+			}
+			continue
+		case cur == nil:
+			continue
+		}
+
+		key, value := split2(line, "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "path":
+			cur.Path = path.Clean(strings.Trim(parseConfigValue(value), "/"))
+		case "url":
+			cur.URL = parseConfigValue(value)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("git: could not read .gitmodules: %v", err)
+	}
+
+	//in case of duplicate paths, the first one wins
+	modules := make(map[string]*Submodule)
+	for _, name := range names {
+		sm := byName[name]
+		if _, ok := modules[sm.Path]; sm.Path != "." && !ok {
+			modules[sm.Path] = sm
+		}
+	}
+
+	return modules, nil
+}
+
+//parseConfigValue returns the value of a config file line, i.e.
+//the part after the "=", with quotes and escapes resolved and
+//trailing comments removed.
+func parseConfigValue(raw string) string {
+	var value []byte
+	quoted := false
+	space := 0 //unquoted whitespace at the end of value
+
+	raw = strings.TrimSpace(raw)
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+			space = 0
+			continue
+		case (c == '#' || c == ';') && !quoted:
+			return string(value[:len(value)-space])
+		case c == '\\' && i+1 < len(raw):
+			i++
+			switch c = raw[i]; c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			}
+			space = 0
+		case (c == ' ' || c == '\t') && !quoted:
+			space++
+		default:
+			space = 0
+		}
+		value = append(value, c)
+	}
+
+	return string(value[:len(value)-space])
+}
+
+//unescapeConfig resolves the escapes in a quoted subsection name.
+func unescapeConfig(str string) string {
+	var res []byte
+	for i := 0; i < len(str); i++ {
+		if str[i] == '\\' && i+1 < len(str) {
+			i++
+		}
+		res = append(res, str[i])
+	}
+	return string(res)
+}
@@ -0,0 +1,106 @@
+package git
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseGitmodules(t *testing.T) {
+	const gitmodules = `# submodules
+[submodule "lib"]
+	path = lib/
+	url = https://example.com/alice/lib.git ; the library
+[core]
+	url = ignored
+[Submodule "with \"quotes\""]
+	Path = "dir/with space "
+	URL = ../other\ name.git
+[submodule "lib"]
+	branch = main
+[submodule "dup"]
+	path = lib
+	url = ignored
+`
+
+	modules, err := parseGitmodules(strings.NewReader(gitmodules))
+	if err != nil {
+		t.Fatalf("could not parse .gitmodules: %v", err)
+	} else if len(modules) != 2 {
+		t.Fatalf("unexpected submodules %v", modules)
+	}
+
+	for _, wanted := range []Submodule{
+		{Name: "lib", Path: "lib", URL: "https://example.com/alice/lib.git"},
+		{Name: `with "quotes"`, Path: "dir/with space ", URL: "../other name.git"},
+	} {
+		if sm, ok := modules[wanted.Path]; !ok || *sm != wanted {
+			t.Fatalf("unexpected submodule at %q: %+v, expected %+v", wanted.Path, sm, wanted)
+		}
+	}
+}
+
+func TestSubmoduleForPath(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	pinned := tr.commit("first", map[string]string{"a.txt": "a\n"})
+
+	//no checkouts of the submodules, so no "git add -A"
+	tr.write(map[string]string{".gitmodules": "[submodule \"sub\"]\n\tpath = dir/sub\n\turl = ../sub.git\n"})
+	tr.git("add", ".gitmodules")
+	tr.git("update-index", "--add", "--cacheinfo", "160000,"+pinned.String()+",dir/sub")
+	tr.git("update-index", "--add", "--cacheinfo", "160000,"+pinned.String()+",other")
+	tr.git("commit", "-q", "-m", "second")
+	head := tr.revParse("HEAD")
+
+	for _, c := range []struct {
+		path   string
+		wanted Submodule
+	}{
+		{"dir/sub", Submodule{Name: "sub", Path: "dir/sub", Commit: pinned, URL: "../sub.git"}},
+		{"/other/", Submodule{Path: "other", Commit: pinned}},
+	} {
+		root, err := tr.OpenObject(head)
+		if err != nil {
+			t.Fatalf("could not open commit: %v", err)
+		}
+
+		obj, err := tr.ObjectForPath(root, c.path)
+		if err != nil {
+			t.Fatalf("could not get object for %q: %v", c.path, err)
+		}
+
+		sm, ok := obj.(*Submodule)
+		if !ok || *sm != c.wanted {
+			t.Fatalf("unexpected object for %q: %+v, expected %+v", c.path, obj, c.wanted)
+		}
+	}
+
+	//the content of submodules is not in the repository
+	root, _ := tr.OpenObject(head)
+	if _, err := tr.ObjectForPath(root, "dir/sub/a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error for path within submodule, got %v", err)
+	}
+
+	//without .gitmodules
+	root, _ = tr.OpenObject(pinned)
+	if modules, err := tr.Submodules(root); err != nil || len(modules) != 0 {
+		t.Fatalf("unexpected submodules %v (%v)", modules, err)
+	}
+
+	tree, err := tr.OpenObject(tr.revParse("HEAD:dir"))
+	if err != nil {
+		t.Fatalf("could not open tree: %v", err)
+	}
+
+	entries := 0
+	for dir := tree.(*Tree); dir.Next(); entries++ {
+		if e := dir.Entry(); !e.IsSubmodule() || e.ID != pinned {
+			t.Fatalf("unexpected entry %+v", e)
+		}
+	}
+	if entries != 1 {
+		t.Fatalf("expected one entry in tree, got %d", entries)
+	}
+}
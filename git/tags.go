@@ -0,0 +1,183 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//ErrTagNotFound is returned for tags that do not exist.
+var ErrTagNotFound = errors.New("git: tag not found")
+
+//ErrTagExists is returned by CreateTag if the tag exists already.
+var ErrTagExists = errors.New("git: tag exists already")
+
+//ErrTagTarget is returned by CreateTag if the target does not exist or
+//is not reachable, see Repository.CheckReachable.
+var ErrTagTarget = errors.New("git: tag target not found")
+
+//TagInfo describes a tag of the repository. Annotated tags point to
+//a tag object, which is then Tag; lightweight tags point directly to
+//the tagged object and Tag is nil.
+type TagInfo struct {
+	Name string //without "refs/tags/"
+	ID   SHA1   //the object the ref points to
+	Tag  *Tag
+
+	//Peeled is the first object that is not a tag
+	//when following the tag (objects), usually a commit.
+	Peeled SHA1
+}
+
+//ListTags returns all tags of the repository, sorted by name.
+func (repo *Repository) ListTags() ([]TagInfo, error) {
+	refs, err := repo.listRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []TagInfo
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.name, "refs/tags/") || ref.symref != "" {
+			continue
+		}
+
+		info, err := repo.tagInfo(ref.name, ref.id)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, info)
+	}
+
+	return tags, nil
+}
+
+//ReadTag returns the tag name, e.g. "v1.0".
+func (repo *Repository) ReadTag(name string) (TagInfo, error) {
+	ref := "refs/tags/" + name
+	if err := CheckRefName(ref); err != nil {
+		return TagInfo{}, err
+	}
+
+	id, _, err := repo.readRefID(ref)
+	if err != nil {
+		return TagInfo{}, err
+	} else if id == (SHA1{}) {
+		return TagInfo{}, ErrTagNotFound
+	}
+
+	return repo.tagInfo(ref, id)
+}
+
+//tagInfo reads the tag object, if ref points to one, and peels it.
+func (repo *Repository) tagInfo(ref string, id SHA1) (TagInfo, error) {
+	info := TagInfo{Name: strings.TrimPrefix(ref, "refs/tags/"), ID: id}
+
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return info, fmt.Errorf("git: could not read tag %s: %v", info.Name, err)
+	}
+	obj.Close()
+
+	tag, ok := obj.(*Tag)
+	if !ok {
+		info.Peeled = id
+		return info, nil
+	}
+
+	info.Tag = tag
+	info.Peeled, err = repo.peel(tag.Object, 0)
+	if err != nil {
+		return info, fmt.Errorf("git: could not peel tag %s: %v", info.Name, err)
+	}
+
+	return info, nil
+}
+
+//CreateTag creates the tag name for the object target. If message
+//is empty, the tag is a lightweight tag, otherwise a tag object with
+//tagger and message is written, i.e. an annotated tag. The creation
+//is recorded in the reflog with tagger as identity. Targets that are
+//only found in the alternates must be reachable from the refs, tags
+//would make them reachable otherwise. Like pushes, it holds the shared
+//repository lock, i.e. it waits for the maintenance.
+func (repo *Repository) CreateTag(name string, target SHA1, tagger Signature, message string) (TagInfo, error) {
+	ref := "refs/tags/" + name
+	if err := CheckRefName(ref); err != nil {
+		return TagInfo{}, err
+	}
+
+	lock, err := repo.LockShared()
+	if err != nil {
+		return TagInfo{}, err
+	}
+	defer lock.Unlock()
+
+	if id, _, err := repo.readRefID(ref); err != nil {
+		return TagInfo{}, err
+	} else if id != (SHA1{}) {
+		return TagInfo{}, ErrTagExists
+	}
+
+	obj, err := repo.OpenObject(target)
+	if err != nil {
+		return TagInfo{}, ErrTagTarget
+	}
+	obj.Close()
+
+	if repo.CheckReachable(target) != nil {
+		return TagInfo{}, ErrTagTarget
+	}
+
+	id := target
+	if message != "" {
+		if !strings.HasSuffix(message, "\n") {
+			message += "\n"
+		}
+
+		id, err = repo.WriteObject(&Tag{
+			Object:  target,
+			ObjType: obj.Type(),
+			Tag:     name,
+			Tagger:  tagger,
+			Message: message,
+		})
+		if err != nil {
+			return TagInfo{}, err
+		}
+	}
+
+	tx := repo.NewRefTransaction(tagger, "tag: "+name)
+	tx.Create(ref, id)
+	if err := tx.Commit(); err != nil {
+		return TagInfo{}, err
+	}
+
+	return repo.tagInfo(ref, id)
+}
+
+//DeleteTag deletes the tag name, with who as identity for the reflog.
+//It holds the shared repository lock, like CreateTag.
+func (repo *Repository) DeleteTag(name string, who Signature) error {
+	ref := "refs/tags/" + name
+	if err := CheckRefName(ref); err != nil {
+		return err
+	}
+
+	lock, err := repo.LockShared()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	id, _, err := repo.readRefID(ref)
+	if err != nil {
+		return err
+	} else if id == (SHA1{}) {
+		return ErrTagNotFound
+	}
+
+	tx := repo.NewRefTransaction(who, "tag: delete "+name)
+	tx.Delete(ref, id)
+	return tx.Commit()
+}
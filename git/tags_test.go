@@ -0,0 +1,118 @@
+package git
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTags(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("first", map[string]string{"a.txt": "a\n"})
+	tr.git("tag", "light")
+	tr.git("tag", "-a", "-m", "Release 1.0", "v1.0")
+	tr.git("tag", "-a", "-m", "tag of a tag", "nested", "v1.0")
+	second := tr.commit("second", map[string]string{"a.txt": "aa\n"})
+	tr.git("pack-refs", "--all")
+
+	tags, err := tr.ListTags()
+	if err != nil {
+		t.Fatalf("could not list tags: %v", err)
+	}
+
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+		if tag.Peeled != first {
+			t.Errorf("tag %s peeled to %s, expected %s", tag.Name, tag.Peeled, first)
+		}
+	}
+	if list := strings.Join(names, " "); list != "light nested v1.0" {
+		t.Fatalf("unexpected tags: %s", list)
+	}
+
+	if tags[0].Tag != nil || tags[0].ID != first {
+		t.Fatalf("expected lightweight tag pointing to %s, got %+v", first, tags[0])
+	}
+
+	v1 := tags[2]
+	if v1.Tag == nil || v1.ID != tr.revParse("v1.0") {
+		t.Fatalf("expected annotated tag v1.0, got %+v", v1)
+	} else if v1.Tag.Message != "Release 1.0\n" || v1.Tag.Object != first || v1.Tag.ObjType != ObjCommit {
+		t.Fatalf("unexpected tag object: %+v", v1.Tag)
+	}
+
+	if nested := tags[1]; nested.Tag == nil || nested.Tag.Object != v1.ID || nested.Tag.ObjType != ObjTag {
+		t.Fatalf("unexpected tag of a tag: %+v", nested)
+	}
+
+	who := NewSignature("Alice", "alice@example.com", time.Unix(1462210432, 0).In(time.FixedZone("", -90*60)))
+	if s := who.String(); s != "Alice <alice@example.com> 1462210432 -0130" {
+		t.Fatalf("unexpected signature %q", s)
+	}
+
+	info, err := tr.CreateTag("v2.0", second, who, "Release 2.0")
+	if err != nil {
+		t.Fatalf("could not create tag: %v", err)
+	} else if info.Tag == nil || info.Peeled != second || info.ID != tr.revParse("v2.0") {
+		t.Fatalf("unexpected new tag: %+v", info)
+	}
+
+	if out := tr.git("cat-file", "tag", "v2.0"); !strings.Contains(out, "tagger Alice <alice@example.com> 1462210432 -0130\n\nRelease 2.0") {
+		t.Fatalf("unexpected tag object:\n%s", out)
+	}
+
+	if _, err := tr.CreateTag("v2.0", second, who, ""); err != ErrTagExists {
+		t.Fatalf("expected ErrTagExists, got %v", err)
+	} else if _, err := tr.CreateTag("bad..name", second, who, ""); err == nil {
+		t.Fatalf("invalid tag name was accepted")
+	}
+
+	info, err = tr.CreateTag("latest", second, who, "")
+	if err != nil || info.Tag != nil || info.ID != second {
+		t.Fatalf("unexpected lightweight tag %+v (%v)", info, err)
+	} else if _, err := tr.CreateTag("missing", SHA1{1}, who, ""); err != ErrTagTarget {
+		t.Fatalf("expected ErrTagTarget, got %v", err)
+	}
+	tr.git("fsck", "--strict")
+
+	//tags are not created during maintenance
+	lock, err := tr.TryLockExclusive()
+	if err != nil {
+		t.Fatalf("could not lock repository: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := tr.CreateTag("waiting", second, who, "")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("tag created while the repository was locked: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	lock.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("could not create tag after unlock: %v", err)
+	}
+
+	//packed and loose tags can be deleted
+	for _, name := range []string{"v1.0", "latest", "waiting"} {
+		if err := tr.DeleteTag(name, who); err != nil {
+			t.Fatalf("could not delete tag %s: %v", name, err)
+		} else if _, err := tr.ReadTag(name); err != ErrTagNotFound {
+			t.Fatalf("expected ErrTagNotFound after deletion of %s, got %v", name, err)
+		}
+	}
+
+	if err := tr.DeleteTag("v1.0", who); err != ErrTagNotFound {
+		t.Fatalf("expected ErrTagNotFound, got %v", err)
+	} else if out := tr.git("tag", "-l"); out != "light\nnested\nv2.0" {
+		t.Fatalf("unexpected tags after deletion: %q", out)
+	}
+}
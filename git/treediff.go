@@ -0,0 +1,582 @@
+package git
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+//File modes as stored (raw) in TreeEntry.Mode.
+const (
+	gitModeTypeMask = 0170000
+	gitModeSymlink  = 0120000
+	gitModeGitlink  = 0160000
+)
+
+//ChangeStatus is the kind of a TreeChange.
+type ChangeStatus byte
+
+//The ChangeStatus values, named after the letters
+//used by "git diff --name-status".
+const (
+	ChangeAdded       ChangeStatus = 'A'
+	ChangeDeleted     ChangeStatus = 'D'
+	ChangeModified    ChangeStatus = 'M'
+	ChangeTypeChanged ChangeStatus = 'T'
+	ChangeRenamed     ChangeStatus = 'R'
+	ChangeCopied      ChangeStatus = 'C'
+)
+
+//TreeChange describes how a single path differs between two
+//trees. For added entries the Old* fields, for deleted entries
+//the New* fields are empty.
+type TreeChange struct {
+	Status ChangeStatus
+
+	OldPath string
+	NewPath string
+	OldMode os.FileMode
+	NewMode os.FileMode
+	OldID   SHA1
+	NewID   SHA1
+
+	//Similarity of old and new content in percent,
+	//only set for renames and copies.
+	Similarity int
+
+	//Annex keys, if the entries are links to annexed files.
+	OldAnnexKey string
+	NewAnnexKey string
+}
+
+//Path returns the new path of the change, or
+//the old one for deleted entries.
+func (c *TreeChange) Path() string {
+	if c.Status == ChangeDeleted {
+		return c.OldPath
+	}
+	return c.NewPath
+}
+
+//NameStatus returns the change in the format of
+//"git diff --name-status", e.g. "M\tfile" or "R087\told\tnew".
+func (c *TreeChange) NameStatus() string {
+	switch c.Status {
+	case ChangeRenamed, ChangeCopied:
+		return fmt.Sprintf("%c%03d\t%s\t%s", c.Status, c.Similarity, c.OldPath, c.NewPath)
+	}
+	return fmt.Sprintf("%c\t%s", c.Status, c.Path())
+}
+
+//DiffOptions controls the rename and copy detection of DiffTrees.
+type DiffOptions struct {
+	DetectRenames bool
+	DetectCopies  bool //from modified and deleted files, implies DetectRenames
+
+	//Threshold is the minimal similarity (in percent) for
+	//two files to be considered a rename or copy (default 50).
+	Threshold int
+
+	//RenameLimit is the maximal number of files on either side
+	//for which inexact renames are detected (default 1000).
+	RenameLimit int
+}
+
+//DefaultRenameThreshold and DefaultRenameLimit are used if
+//DiffOptions.Threshold or DiffOptions.RenameLimit are zero.
+const (
+	DefaultRenameThreshold = 50
+	DefaultRenameLimit     = 1000
+)
+
+//maxSimilaritySize is the size limit for blobs that
+//are compared to detect inexact renames and copies.
+const maxSimilaritySize = 16 * 1024 * 1024
+
+//DiffTrees recursively compares the trees a and b and returns the
+//changes in path order. Either tree can be the zero id, which stands
+//for the empty tree. Commits are taken to mean their root trees.
+//
+//Links to annexed files are compared by their annex key, i.e. links
+//that point to the same annexed content are considered unchanged,
+//and files that are replaced by annexed files (or vice versa) are
+//reported as modified instead of type-changed.
+func (repo *Repository) DiffTrees(a, b SHA1, opts DiffOptions) ([]TreeChange, error) {
+	var err error
+	if a, err = repo.diffRoot(a); err != nil {
+		return nil, err
+	} else if b, err = repo.diffRoot(b); err != nil {
+		return nil, err
+	}
+
+	changes, err := repo.diffTrees(a, b, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DetectRenames || opts.DetectCopies {
+		changes, err = repo.detectRenames(changes, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+
+func (repo *Repository) diffRoot(id SHA1) (SHA1, error) {
+	if id == (SHA1{}) {
+		return id, nil
+	}
+	return repo.peel(id, ObjTree)
+}
+
+//readTree returns all entries of the tree with the given id.
+//The zero id is taken to be the empty tree.
+func (repo *Repository) readTree(id SHA1) ([]*TreeEntry, error) {
+	if id == (SHA1{}) {
+		return nil, nil
+	}
+
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	tree, ok := obj.(*Tree)
+	if !ok {
+		return nil, fmt.Errorf("git: object [%s] not of type tree", id)
+	}
+
+	var entries []*TreeEntry
+	for tree.Next() {
+		entries = append(entries, tree.Entry())
+	}
+
+	return entries, tree.Err()
+}
+
+//readBlob returns the content of the blob with the given id,
+//or an error if it is larger than limit.
+func (repo *Repository) readBlob(id SHA1, limit int64) ([]byte, error) {
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		return nil, fmt.Errorf("git: object [%s] not of type blob", id)
+	} else if blob.Size() > limit {
+		return nil, fmt.Errorf("git: blob [%s] too large", id)
+	}
+
+	return ioutil.ReadAll(blob)
+}
+
+//annexKey returns the annex key if the entry with the given
+//mode and id is a link into the annex object store.
+func (repo *Repository) annexKey(mode os.FileMode, id SHA1) (string, error) {
+	if mode&gitModeTypeMask != gitModeSymlink {
+		return "", nil
+	}
+
+	data, err := repo.readBlob(id, 4096)
+	if err != nil {
+		return "", err
+	}
+
+	target := string(data)
+	if !strings.Contains(target, ".git/annex/objects/") {
+		return "", nil
+	}
+
+	return path.Base(target), nil
+}
+
+//treeEntryName returns the name of the entry as used for sorting
+//within git trees, i.e. with a trailing slash for sub-trees.
+func treeEntryName(e *TreeEntry) string {
+	if e.Type == ObjTree {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+//diffTrees recursively compares the trees a and b and returns
+//the changed paths (prefixed with prefix) in tree order.
+func (repo *Repository) diffTrees(a, b SHA1, prefix string) ([]TreeChange, error) {
+	if a == b {
+		return nil, nil
+	}
+
+	ea, err := repo.readTree(a)
+	if err != nil {
+		return nil, err
+	}
+
+	eb, err := repo.readTree(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []TreeChange
+	emit := func(status ChangeStatus, x, y *TreeEntry) error {
+		c := TreeChange{Status: status}
+
+		//directories are never reported themselves,
+		//only the files (and links) they contain
+		var old, cur SHA1
+		isTree := false
+		if x != nil && x.Type == ObjTree {
+			old, isTree = x.ID, true
+		}
+		if y != nil && y.Type == ObjTree {
+			cur, isTree = y.ID, true
+		}
+
+		if isTree {
+			var name string
+			if x != nil {
+				name = x.Name
+			} else {
+				name = y.Name
+			}
+
+			sub, err := repo.diffTrees(old, cur, path.Join(prefix, name))
+			if err != nil {
+				return err
+			}
+			changes = append(changes, sub...)
+			return nil
+		}
+
+		var err error
+		if x != nil {
+			c.OldPath, c.OldMode, c.OldID = path.Join(prefix, x.Name), x.Mode, x.ID
+			if c.OldAnnexKey, err = repo.annexKey(x.Mode, x.ID); err != nil {
+				return err
+			}
+		}
+		if y != nil {
+			c.NewPath, c.NewMode, c.NewID = path.Join(prefix, y.Name), y.Mode, y.ID
+			if c.NewAnnexKey, err = repo.annexKey(y.Mode, y.ID); err != nil {
+				return err
+			}
+		}
+
+		if x != nil && y != nil {
+			if c.OldAnnexKey != "" && c.OldAnnexKey == c.NewAnnexKey {
+				//retargeted link, same content
+				return nil
+			} else if c.OldAnnexKey != "" || c.NewAnnexKey != "" {
+				c.Status = ChangeModified
+			}
+		}
+
+		changes = append(changes, c)
+		return nil
+	}
+
+	i, j := 0, 0
+	for i < len(ea) || j < len(eb) {
+		var x, y *TreeEntry
+		if i < len(ea) {
+			x = ea[i]
+		}
+		if j < len(eb) {
+			y = eb[j]
+		}
+
+		switch {
+		case y == nil || (x != nil && treeEntryName(x) < treeEntryName(y)):
+			err = emit(ChangeDeleted, x, nil)
+			i++
+		case x == nil || treeEntryName(x) > treeEntryName(y):
+			err = emit(ChangeAdded, nil, y)
+			j++
+		default:
+			i++
+			j++
+
+			if x.ID == y.ID && x.Mode == y.Mode {
+				continue
+			} else if x.Type != ObjTree && x.Mode&gitModeTypeMask != y.Mode&gitModeTypeMask {
+				err = emit(ChangeTypeChanged, x, y)
+			} else {
+				err = emit(ChangeModified, x, y)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+
+//renameCandidate is a file that might have been renamed or copied.
+type renameCandidate struct {
+	change *TreeChange
+	path   string
+	mode   os.FileMode
+	id     SHA1
+	annex  string
+
+	used bool
+	sig  map[uint64]int //chunk hash -> number of bytes
+	size int
+}
+
+func (c *renameCandidate) content() string {
+	if c.annex != "" {
+		return "annex:" + c.annex
+	}
+	return c.id.String()
+}
+
+//detectRenames pairs deleted (and, for copies, modified) files with
+//added files of the same or similar content.
+func (repo *Repository) detectRenames(changes []TreeChange, opts DiffOptions) ([]TreeChange, error) {
+	threshold := opts.Threshold
+	if threshold <= 0 {
+		threshold = DefaultRenameThreshold
+	}
+
+	limit := opts.RenameLimit
+	if limit <= 0 {
+		limit = DefaultRenameLimit
+	}
+
+	var srcs, dsts []*renameCandidate
+	for i := range changes {
+		c := &changes[i]
+		switch {
+		case c.OldMode&gitModeTypeMask == gitModeGitlink || c.NewMode&gitModeTypeMask == gitModeGitlink:
+			continue
+		case c.Status == ChangeAdded:
+			dsts = append(dsts, &renameCandidate{change: c, path: c.NewPath, mode: c.NewMode, id: c.NewID, annex: c.NewAnnexKey})
+		case c.Status == ChangeDeleted || (opts.DetectCopies && c.Status == ChangeModified):
+			srcs = append(srcs, &renameCandidate{change: c, path: c.OldPath, mode: c.OldMode, id: c.OldID, annex: c.OldAnnexKey})
+		}
+	}
+
+	if len(srcs) == 0 || len(dsts) == 0 {
+		return changes, nil
+	}
+
+	pairs := make(map[*renameCandidate]*renameCandidate) //dst -> src
+	scores := make(map[*renameCandidate]int)
+
+	//exact matches first, preferring deleted sources
+	byContent := make(map[string][]*renameCandidate)
+	for _, s := range srcs {
+		key := s.content()
+		if s.change.Status == ChangeDeleted {
+			byContent[key] = append([]*renameCandidate{s}, byContent[key]...)
+		} else {
+			byContent[key] = append(byContent[key], s)
+		}
+	}
+
+	for _, d := range dsts {
+		for _, s := range byContent[d.content()] {
+			if s.mode&gitModeTypeMask != d.mode&gitModeTypeMask {
+				continue
+			} else if s.used && !opts.DetectCopies {
+				continue
+			}
+
+			pairs[d], scores[d] = s, 100
+			if s.change.Status == ChangeDeleted {
+				s.used = true
+			}
+			break
+		}
+	}
+
+	//inexact matches between the remaining regular files
+	if len(srcs) <= limit && len(dsts) <= limit {
+		err := repo.matchSimilar(srcs, dsts, pairs, scores, threshold, opts.DetectCopies)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(pairs) == 0 {
+		return changes, nil
+	}
+
+	dstFor := make(map[*TreeChange]*renameCandidate, len(dsts))
+	for _, d := range dsts {
+		dstFor[d.change] = d
+	}
+
+	//a deleted source that is paired with several destinations is
+	//renamed to one of them (the first) and copied to the others
+	renamed := make(map[string]bool)
+	var res []TreeChange
+	for i := range changes {
+		c := &changes[i]
+
+		d := dstFor[c]
+		if d == nil || pairs[d] == nil {
+			res = append(res, *c)
+			continue
+		}
+
+		s := pairs[d]
+		status := ChangeCopied
+		if s.change.Status == ChangeDeleted && !renamed[s.path] {
+			status, renamed[s.path] = ChangeRenamed, true
+		}
+
+		res = append(res, TreeChange{
+			Status:      status,
+			OldPath:     s.path,
+			NewPath:     d.path,
+			OldMode:     s.mode,
+			NewMode:     d.mode,
+			OldID:       s.id,
+			NewID:       d.id,
+			Similarity:  scores[d],
+			OldAnnexKey: s.annex,
+			NewAnnexKey: d.annex,
+		})
+	}
+
+	//drop the deleted entries that have been renamed
+	out := res[:0]
+	for _, c := range res {
+		if c.Status == ChangeDeleted && renamed[c.OldPath] {
+			continue
+		}
+		out = append(out, c)
+	}
+
+	sort.Stable(byChangePath(out))
+	return out, nil
+}
+
+type similarPair struct {
+	src, dst *renameCandidate
+	score    int
+}
+
+type byScore []similarPair
+
+func (b byScore) Len() int           { return len(b) }
+func (b byScore) Less(i, j int) bool { return b[i].score > b[j].score }
+func (b byScore) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+//matchSimilar pairs the unpaired destinations with the most similar
+//source, if the similarity is at least threshold.
+func (repo *Repository) matchSimilar(srcs, dsts []*renameCandidate, pairs map[*renameCandidate]*renameCandidate, scores map[*renameCandidate]int, threshold int, copies bool) error {
+	usable := func(c *renameCandidate) bool {
+		return c.annex == "" && c.mode&gitModeTypeMask != gitModeSymlink
+	}
+
+	var candidates []similarPair
+	for _, d := range dsts {
+		if pairs[d] != nil || !usable(d) {
+			continue
+		}
+
+		for _, s := range srcs {
+			if !usable(s) || (s.used && !copies) {
+				continue
+			}
+
+			for _, c := range []*renameCandidate{s, d} {
+				if c.sig != nil {
+					continue
+				}
+
+				data, err := repo.readBlob(c.id, maxSimilaritySize)
+				if err != nil {
+					//too large, not comparable
+					c.sig = make(map[uint64]int)
+					continue
+				}
+				c.sig, c.size = chunkSignature(data), len(data)
+			}
+
+			score := similarity(s, d)
+			if score >= threshold {
+				candidates = append(candidates, similarPair{s, d, score})
+			}
+		}
+	}
+
+	sort.Stable(byScore(candidates))
+	for _, p := range candidates {
+		if pairs[p.dst] != nil || (p.src.used && !copies) {
+			continue
+		}
+
+		pairs[p.dst], scores[p.dst] = p.src, p.score
+		if p.src.change.Status == ChangeDeleted {
+			p.src.used = true
+		}
+	}
+
+	return nil
+}
+
+//chunkSignature splits data into lines (or chunks of at most
+//64 bytes) and counts the bytes per distinct chunk.
+func chunkSignature(data []byte) map[uint64]int {
+	sig := make(map[uint64]int)
+	for len(data) > 0 {
+		n := 0
+		for n < len(data) && n < 64 {
+			n++
+			if data[n-1] == '\n' {
+				break
+			}
+		}
+
+		h := fnv.New64a()
+		h.Write(data[:n])
+		sig[h.Sum64()] += n
+		data = data[n:]
+	}
+	return sig
+}
+
+//similarity returns the percentage of content shared by a and b,
+//relative to the larger of the two.
+func similarity(a, b *renameCandidate) int {
+	max := a.size
+	if b.size > max {
+		max = b.size
+	}
+
+	if max == 0 {
+		return 0
+	}
+
+	shared := 0
+	for h, n := range a.sig {
+		m := b.sig[h]
+		if m < n {
+			n = m
+		}
+		shared += n
+	}
+
+	return shared * 100 / max
+}
+
+type byChangePath []TreeChange
+
+func (b byChangePath) Len() int           { return len(b) }
+func (b byChangePath) Less(i, j int) bool { return b[i].Path() < b[j].Path() }
+func (b byChangePath) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,173 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func diffNameStatus(t *testing.T, tr *testRepo, a, b SHA1, opts DiffOptions) string {
+	changes, err := tr.DiffTrees(a, b, opts)
+	if err != nil {
+		t.Fatalf("DiffTrees(%s, %s) failed: %v", a, b, err)
+	}
+
+	var lines []string
+	for i := range changes {
+		lines = append(lines, changes[i].NameStatus())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDiffTrees(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	var text string
+	for i := 0; i < 20; i++ {
+		text += fmt.Sprintf("line %d of some text\n", i)
+	}
+
+	base := tr.commit("base", map[string]string{
+		"a.txt":          "a\n",
+		"b.txt":          text,
+		"c.txt":          "c\n",
+		"dir/d.txt":      "d\n",
+		"dir/sub/e.txt":  "e\n",
+		"dir/sub/f.txt":  "f\n",
+		"to-be-dir":      "file\n",
+		"unchanged.txt":  "unchanged\n",
+		"copy/source.md": text + "source only\n",
+	})
+
+	tr.git("mv", "c.txt", "renamed.txt")
+	tr.git("mv", "b.txt", "similar.txt")
+	tr.write(map[string]string{"dir/sub/e.txt": "", "to-be-dir": ""})
+
+	head := tr.commit("changes", map[string]string{
+		"a.txt":            "A\n",
+		"similar.txt":      text + "one more line\n",
+		"dir/d.txt":        "D\n",
+		"dir/new/g.txt":    "g\n",
+		"to-be-dir/h.txt":  "h\n",
+		"copy/source.md":   text + "source changed\n",
+		"copy/copy.md":     text + "source only\n",
+		"copy/exact-c.txt": "f\n",
+	})
+
+	for _, tt := range []struct {
+		opts DiffOptions
+		args []string
+	}{
+		{DiffOptions{}, nil},
+		{DiffOptions{DetectRenames: true}, []string{"-M"}},
+		{DiffOptions{DetectCopies: true}, []string{"-C"}},
+		{DiffOptions{DetectRenames: true, Threshold: 99}, []string{"-M99%"}},
+	} {
+		res := diffNameStatus(t, tr, base, head, tt.opts)
+		args := append([]string{"diff-tree", "-r", "--name-status"}, tt.args...)
+		if len(tt.args) == 0 {
+			args = append(args, "--no-renames")
+		}
+		wanted := tr.git(append(args, base.String(), head.String())...)
+
+		if res != wanted {
+			t.Fatalf("DiffTrees(%+v):\n%s\nexpected:\n%s", tt.opts, res, wanted)
+		}
+	}
+
+	//reverse and against the empty tree
+	res := diffNameStatus(t, tr, head, base, DiffOptions{})
+	if wanted := tr.git("diff-tree", "-r", "--name-status", "--no-renames", head.String(), base.String()); res != wanted {
+		t.Fatalf("reverse DiffTrees:\n%s\nexpected:\n%s", res, wanted)
+	}
+
+	res = diffNameStatus(t, tr, SHA1{}, base, DiffOptions{})
+	if wanted := tr.git("diff-tree", "-r", "--name-status", "--root", base.String()); !strings.HasSuffix(wanted, res) {
+		t.Fatalf("DiffTrees from empty tree:\n%s\nexpected:\n%s", res, wanted)
+	}
+
+	changes, err := tr.DiffTrees(base, head, DiffOptions{})
+	if err != nil {
+		t.Fatalf("DiffTrees failed: %v", err)
+	}
+
+	for _, c := range changes {
+		if c.Path() != "a.txt" {
+			continue
+		}
+
+		if c.Status != ChangeModified || c.OldMode != 0100644 || c.NewMode != 0100644 ||
+			c.OldID != tr.revParse(base.String()+":a.txt") || c.NewID != tr.revParse(head.String()+":a.txt") {
+			t.Fatalf("unexpected change for a.txt: %+v", c)
+		}
+	}
+}
+
+func TestDiffTreesAnnex(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	key1 := "SHA256E-s5--1111111111111111111111111111111111111111111111111111111111111111.bin"
+	key2 := "SHA256E-s6--2222222222222222222222222222222222222222222222222222222222222222.bin"
+
+	link := func(name, key string) {
+		target := ".git/annex/objects/Xx/Yy/" + key + "/" + key
+		target = strings.Repeat("../", strings.Count(name, "/")) + target
+
+		path := filepath.Join(tr.work, name)
+		os.Remove(path)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = os.Symlink(target, path)
+		}
+		if err != nil {
+			t.Fatalf("could not create link: %v", err)
+		}
+	}
+
+	link("data.bin", key1)
+	link("retarget.bin", key1)
+	base := tr.commit("base", map[string]string{"plain.bin": "plain\n", "keep.txt": "keep\n"})
+
+	//move into a sub dir, i.e. new link target, same content
+	os.Remove(filepath.Join(tr.work, "data.bin"))
+	link("sub/data.bin", key1)
+	//new annexed content
+	link("retarget.bin", key2)
+	//and a file that is now annexed
+	link("plain.bin", key2)
+	head := tr.commit("annex changes", nil)
+
+	changes, err := tr.DiffTrees(base, head, DiffOptions{DetectRenames: true})
+	if err != nil {
+		t.Fatalf("DiffTrees failed: %v", err)
+	}
+
+	wanted := []struct {
+		status   ChangeStatus
+		path     string
+		old, new string
+	}{
+		{ChangeModified, "plain.bin", "", key2},
+		{ChangeModified, "retarget.bin", key1, key2},
+		{ChangeRenamed, "sub/data.bin", key1, key1},
+	}
+
+	if len(changes) != len(wanted) {
+		t.Fatalf("expected %d changes, got %d: %+v", len(wanted), len(changes), changes)
+	}
+
+	for i, w := range wanted {
+		c := changes[i]
+		if c.Status != w.status || c.Path() != w.path || c.OldAnnexKey != w.old || c.NewAnnexKey != w.new {
+			t.Fatalf("change %d: got %+v, expected %+v", i, c, w)
+		}
+	}
+
+	if changes[2].OldPath != "data.bin" || changes[2].Similarity != 100 {
+		t.Fatalf("unexpected rename: %+v", changes[2])
+	}
+}
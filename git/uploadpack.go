@@ -0,0 +1,969 @@
+package git
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DefaultAgent is the agent advertised by UploadPack.
+const DefaultAgent = "gin-repo"
+
+//Flags used by UploadPack to mark commits in the CommitGraph.
+const (
+	nodeQueued NodeFlag = 1 << (8 + iota)
+	nodePopped
+	nodeUninteresting
+	nodeCommon
+)
+
+//UploadPack serves fetches and clones from the repository via the
+//version 2 of the git wire protocol, i.e. the "ls-refs" and "fetch"
+//commands. Packs are generated with PackWriter, thin packs are not
+//produced. Shallow fetches (deepen, deepen-since, deepen-not) and the
+//"blob:none" and "blob:limit=<n>" filters are supported, fetches from
+//shallow repositories as well.
+type UploadPack struct {
+	Repo   *Repository
+	Agent  string
+	Window int //delta window of the generated packs, see PackWriter
+}
+
+//NewUploadPack creates an UploadPack for the repository with
+//the default agent and delta window.
+func NewUploadPack(repo *Repository) *UploadPack {
+	return &UploadPack{Repo: repo, Agent: DefaultAgent, Window: DefaultPackWindow}
+}
+
+//Advertise writes the capability advertisement, which is
+//sent to clients before any command.
+func (up *UploadPack) Advertise(w io.Writer) error {
+	pw := &pktWriter{w: w}
+	pw.Printf("version 2\n")
+	pw.Printf("agent=%s\n", up.Agent)
+	pw.Printf("ls-refs=unborn\n")
+	pw.Printf("fetch=shallow filter\n")
+	pw.Printf("server-option\n")
+	pw.Printf("object-format=sha1\n")
+	pw.Flush()
+	return pw.Err()
+}
+
+//Serve reads commands from r and writes the responses to w, until r
+//is exhausted. For the stateless HTTP transport, r contains a single
+//command.
+func (up *UploadPack) Serve(r io.Reader, w io.Writer) error {
+	pr := newPktReader(r)
+	bw := bufio.NewWriter(w)
+	pw := &pktWriter{w: bw}
+
+	for {
+		cmd, args, err := readCommand(pr)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch cmd {
+		case "ls-refs":
+			err = up.lsRefs(args, pw)
+		case "fetch":
+			err = up.fetch(args, pw)
+		default:
+			err = fmt.Errorf("git: unknown command %q", cmd)
+		}
+
+		if err != nil {
+			//tell the client, if we still can
+			pw.Printf("ERR %v\n", err)
+			bw.Flush()
+			return err
+		} else if err = pw.Err(); err != nil {
+			return err
+		} else if err = bw.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+//readCommand reads a protocol v2 command request, i.e. "command=<cmd>",
+//the capabilities, a delimiter and the arguments, up to the flush packet.
+//Capabilities are not used and therefore skipped.
+func readCommand(pr *pktReader) (string, []string, error) {
+	var cmd string
+	var args []string
+
+	inArgs := false
+	for first := true; ; first = false {
+		kind, data, err := pr.next()
+		if err == io.EOF && !first {
+			err = fmt.Errorf("git: unexpected end of command")
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch kind {
+		case pktFlush:
+			if cmd == "" {
+				return "", nil, fmt.Errorf("git: missing command")
+			}
+			return cmd, args, nil
+		case pktDelim:
+			inArgs = true
+			continue
+		case pktResponseEnd:
+			return "", nil, fmt.Errorf("git: unexpected response-end packet")
+		}
+
+		line := strings.TrimSuffix(string(data), "\n")
+		if first {
+			if !strings.HasPrefix(line, "command=") {
+				return "", nil, fmt.Errorf("git: expected command, got %q", line)
+			}
+			cmd = line[8:]
+		} else if inArgs {
+			args = append(args, line)
+		}
+	}
+}
+
+func (up *UploadPack) lsRefs(args []string, pw *pktWriter) error {
+	var symrefs, peel, unborn bool
+	var prefixes []string
+
+	for _, arg := range args {
+		switch {
+		case arg == "symrefs":
+			symrefs = true
+		case arg == "peel":
+			peel = true
+		case arg == "unborn":
+			unborn = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, arg[11:])
+		}
+	}
+
+	refs, err := up.Repo.listRefs()
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		matches := len(prefixes) == 0
+		for _, prefix := range prefixes {
+			matches = matches || strings.HasPrefix(ref.name, prefix)
+		}
+
+		if !matches {
+			continue
+		}
+
+		line := ref.id.String() + " " + ref.name
+		if ref.id == (SHA1{}) {
+			if !unborn || ref.name != "HEAD" {
+				continue
+			}
+			line = "unborn HEAD"
+		}
+
+		if symrefs && ref.symref != "" {
+			line += " symref-target:" + ref.symref
+		}
+
+		if peel && ref.id != (SHA1{}) {
+			peeled, err := up.Repo.peel(ref.id, 0)
+			if err == nil && peeled != ref.id {
+				line += " peeled:" + peeled.String()
+			}
+		}
+
+		pw.Printf("%s\n", line)
+	}
+
+	pw.Flush()
+	return nil
+}
+
+//fetchRequest holds the arguments of the fetch command.
+type fetchRequest struct {
+	wants, haves []SHA1
+	shallows     []SHA1 //the client's shallow commits
+
+	done       bool
+	noProgress bool
+	includeTag bool
+	ofsDelta   bool
+
+	depth    int
+	relative bool
+	since    time.Time
+	not      []string
+
+	blobLimit int64 //-1 means no filter
+}
+
+func (req *fetchRequest) deepen() bool {
+	return req.depth > 0 || !req.since.IsZero() || len(req.not) > 0
+}
+
+func parseFetchRequest(args []string) (*fetchRequest, error) {
+	req := &fetchRequest{blobLimit: -1}
+
+	for _, arg := range args {
+		name, value := split2(arg, " ")
+
+		var err error
+		switch name {
+		case "want", "have", "shallow":
+			var oid SHA1
+			oid, err = ParseSHA1(value)
+			switch name {
+			case "want":
+				req.wants = append(req.wants, oid)
+			case "have":
+				req.haves = append(req.haves, oid)
+			default:
+				req.shallows = append(req.shallows, oid)
+			}
+		case "done":
+			req.done = true
+		case "no-progress":
+			req.noProgress = true
+		case "include-tag":
+			req.includeTag = true
+		case "ofs-delta":
+			req.ofsDelta = true
+		case "thin-pack":
+			//we never send thin packs
+		case "deepen":
+			req.depth, err = strconv.Atoi(value)
+			if err == nil && req.depth < 1 {
+				err = fmt.Errorf("depth must be positive")
+			}
+		case "deepen-relative":
+			req.relative = true
+		case "deepen-since":
+			var ts int64
+			ts, err = strconv.ParseInt(value, 10, 64)
+			req.since = time.Unix(ts, 0)
+		case "deepen-not":
+			req.not = append(req.not, value)
+		case "filter":
+			switch {
+			case value == "blob:none":
+				req.blobLimit = 0
+			case strings.HasPrefix(value, "blob:limit="):
+				req.blobLimit, err = parseFilterSize(value[11:])
+			default:
+				err = fmt.Errorf("unsupported filter")
+			}
+		default:
+			err = fmt.Errorf("unknown argument")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("git: invalid fetch argument %q: %v", arg, err)
+		}
+	}
+
+	if len(req.wants) == 0 {
+		return nil, fmt.Errorf("git: fetch without wants")
+	}
+
+	return req, nil
+}
+
+//parseFilterSize parses sizes like "1024", "10k" or "1m".
+func parseFilterSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		mult = 1 << 30
+	}
+
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return n * mult, nil
+}
+
+func (up *UploadPack) fetch(args []string, pw *pktWriter) error {
+	req, err := parseFetchRequest(args)
+	if err != nil {
+		return err
+	}
+
+	graph := NewCommitGraph(up.Repo)
+
+	err = up.checkWants(graph, req.wants)
+	if err != nil {
+		return err
+	}
+
+	//the haves we have as well
+	var common []*CommitNode
+	for _, oid := range req.haves {
+		if node, err := graph.openObject(oid); err == nil && node.Flags&nodeCommon == 0 {
+			node.Flags |= nodeCommon
+			common = append(common, node)
+		}
+	}
+
+	if !req.done {
+		pw.Printf("acknowledgments\n")
+		if len(common) == 0 {
+			pw.Printf("NAK\n")
+		}
+		for _, node := range common {
+			pw.Printf("ACK %s\n", node.ID)
+		}
+
+		if len(common) == 0 || !up.okToGiveUp(graph, req.wants, common) {
+			pw.Flush()
+			return nil
+		}
+
+		pw.Printf("ready\n")
+		pw.Delim()
+	}
+
+	walk := &fetchWalk{repo: up.Repo, graph: graph, req: req, common: common}
+	err = walk.run()
+	if err != nil {
+		return err
+	}
+
+	if req.deepen() || len(req.shallows) > 0 || len(walk.shallow) > 0 {
+		pw.Printf("shallow-info\n")
+		for _, oid := range walk.shallow {
+			pw.Printf("shallow %s\n", oid)
+		}
+		for _, oid := range walk.unshallow {
+			pw.Printf("unshallow %s\n", oid)
+		}
+		pw.Delim()
+	}
+
+	pack := NewPackWriter(up.Repo)
+	pack.Window = up.Window
+	if !req.ofsDelta {
+		pack.Window = 0
+	}
+
+	err = walk.addObjects(pack)
+	if err != nil {
+		return err
+	}
+
+	pw.Printf("packfile\n")
+	if !req.noProgress {
+		progress := &sidebandWriter{pw, 2}
+		fmt.Fprintf(progress, "Counting objects: %d, done.\n", pack.Len())
+	}
+
+	_, err = pack.Write(&sidebandWriter{pw, 1}, ioutil.Discard)
+	if err != nil {
+		return err
+	}
+
+	pw.Flush()
+	return nil
+}
+
+//checkWants makes sure the client only gets what the refs of the
+//repository point to, see findUnreachable.
+func (up *UploadPack) checkWants(graph *CommitGraph, wants []SHA1) error {
+	oid, found, err := up.Repo.findUnreachable(graph, wants)
+	if err != nil {
+		return err
+	} else if found {
+		return fmt.Errorf("git: upload-pack: not our ref %s", oid)
+	}
+
+	return nil
+}
+
+//okToGiveUp checks if every wanted commit has one of the common
+//commits as ancestor, i.e. if the client has sent enough haves.
+//The search stops at commits older than the oldest common commit.
+func (up *UploadPack) okToGiveUp(graph *CommitGraph, wants []SHA1, common []*CommitNode) bool {
+	oldest := common[0].Date()
+	for _, node := range common {
+		if node.Date().Before(oldest) {
+			oldest = node.Date()
+		}
+	}
+
+	for _, oid := range wants {
+		start, err := graph.openObject(oid)
+		if err != nil {
+			//not a commit, nothing to negotiate
+			continue
+		}
+
+		found := false
+		seen := map[SHA1]bool{oid: true}
+		queue := []*CommitNode{start}
+		for len(queue) > 0 && !found {
+			node := queue[0]
+			queue = queue[1:]
+
+			if node.Flags&nodeCommon != 0 {
+				found = true
+				break
+			} else if node.Date().Before(oldest) {
+				continue
+			}
+
+			if graph.loadParents(node) != nil {
+				return false
+			}
+
+			for _, parent := range node.parents {
+				if !seen[parent.ID] {
+					seen[parent.ID] = true
+					queue = append(queue, parent)
+				}
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+//fetchWalk determines the commits and objects to send for a
+//fetch request and the shallow boundary.
+type fetchWalk struct {
+	repo   *Repository
+	graph  *CommitGraph
+	req    *fetchRequest
+	common []*CommitNode
+
+	clientShallow map[SHA1]bool
+
+	wantCommits []*CommitNode
+	extra       []SHA1 //wanted tags, trees and blobs
+
+	commits   []*CommitNode //the commits to send
+	edges     []*CommitNode //commits the client has, parents of commits to send
+	shallow   []SHA1
+	unshallow []SHA1
+}
+
+func (fw *fetchWalk) run() error {
+	fw.clientShallow = make(map[SHA1]bool)
+	for _, oid := range fw.req.shallows {
+		fw.clientShallow[oid] = true
+	}
+
+	for _, oid := range fw.req.wants {
+		err := fw.addWant(oid)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if fw.req.deepen() {
+		err = fw.deepen()
+	} else {
+		err = fw.limit()
+	}
+	if err != nil {
+		return err
+	}
+
+	//commits that are shallow in the repository itself, i.e. that
+	//have no parents in the graph, are shallow for the client too,
+	//unless deepen made them shallow already
+	seen := make(map[SHA1]bool, len(fw.shallow))
+	for _, oid := range fw.shallow {
+		seen[oid] = true
+	}
+
+	for _, node := range fw.commits {
+		if fw.graph.IsShallow(node) && !fw.clientShallow[node.ID] && !seen[node.ID] {
+			fw.shallow = append(fw.shallow, node.ID)
+		}
+	}
+
+	return nil
+}
+
+//addWant peels wanted tags and sorts wanted objects into commits
+//and other objects.
+func (fw *fetchWalk) addWant(oid SHA1) error {
+	for {
+		obj, err := fw.repo.OpenObject(oid)
+		if err != nil {
+			return err
+		}
+		obj.Close()
+
+		switch o := obj.(type) {
+		case *Tag:
+			fw.extra = append(fw.extra, oid)
+			oid = o.Object
+			continue
+		case *Commit:
+			node, err := fw.graph.openObject(oid)
+			if err != nil {
+				return err
+			}
+			fw.wantCommits = append(fw.wantCommits, node)
+		default:
+			fw.extra = append(fw.extra, oid)
+		}
+
+		return nil
+	}
+}
+
+//limit finds the commits reachable from the wants, but not from the
+//common commits, by walking both sides in date order until only
+//commits reachable from the common ones are left, like git's
+//limit_list. Parents of the client's shallow commits are not
+//considered to be reachable from them.
+func (fw *fetchWalk) limit() error {
+	var pq youngestFirst
+	interesting := 0
+
+	push := func(node *CommitNode, flags NodeFlag) {
+		node.Flags |= flags | nodeQueued
+		if node.Flags&nodeUninteresting == 0 {
+			interesting++
+		}
+		heap.Push(&pq, node)
+	}
+
+	for _, node := range fw.common {
+		push(node, nodeUninteresting)
+	}
+
+	for _, node := range fw.wantCommits {
+		if node.Flags&nodeQueued == 0 {
+			push(node, 0)
+		}
+	}
+
+	for interesting > 0 {
+		node := heap.Pop(&pq).(*CommitNode)
+		node.Flags |= nodePopped
+
+		uninteresting := node.Flags&nodeUninteresting != 0
+		if !uninteresting {
+			interesting--
+			fw.commits = append(fw.commits, node)
+		} else if fw.clientShallow[node.ID] {
+			continue
+		}
+
+		err := fw.graph.loadParents(node)
+		if err != nil {
+			return err
+		}
+
+		for _, parent := range node.parents {
+			if !uninteresting {
+				if parent.Flags&nodeQueued == 0 {
+					push(parent, 0)
+				}
+				continue
+			}
+
+			if parent.Flags&nodeUninteresting != 0 {
+				continue
+			} else if parent.Flags&nodeQueued != 0 {
+				parent.Flags |= nodeUninteresting
+				if parent.Flags&nodePopped == 0 {
+					interesting--
+				}
+				//NB: its parents must be marked as well
+				heap.Push(&pq, parent)
+				continue
+			}
+
+			push(parent, nodeUninteresting)
+		}
+	}
+
+	//commits found to be reachable from the common ones after
+	//they were visited (clock skew) are not needed after all
+	var commits []*CommitNode
+	for _, node := range fw.commits {
+		if node.Flags&nodeUninteresting == 0 {
+			commits = append(commits, node)
+		}
+	}
+	fw.commits = commits
+
+	fw.findEdges()
+	return nil
+}
+
+//deepen handles the deepen arguments: the history is walked
+//breadth-first from the wants until the requested depth, the
+//date or the excluded revisions are reached. Commits at this
+//boundary become shallow.
+func (fw *fetchWalk) deepen() error {
+	req := fw.req
+
+	excluded := make(map[SHA1]bool)
+	for _, rev := range req.not {
+		oid, err := fw.repo.RevParse(rev)
+		if err != nil {
+			return err
+		}
+
+		err = fw.markReachable(oid, excluded)
+		if err != nil {
+			return err
+		}
+	}
+
+	type item struct {
+		node  *CommitNode
+		depth int //0 if relative and not yet below the client's shallow commits
+	}
+
+	start := 1
+	if req.relative {
+		start = 0
+	}
+
+	var queue []item
+	var included []*CommitNode
+	seen := make(map[SHA1]bool)
+	for _, node := range fw.wantCommits {
+		if !seen[node.ID] && !excluded[node.ID] {
+			seen[node.ID] = true
+			queue = append(queue, item{node, start})
+		}
+	}
+
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		node := it.node
+		included = append(included, node)
+
+		err := fw.graph.loadParents(node)
+		if err != nil {
+			return err
+		}
+
+		depth := it.depth
+		if depth > 0 {
+			depth++
+		} else if fw.clientShallow[node.ID] {
+			depth = 1
+		}
+
+		boundary := len(node.parents) > 0 && req.depth > 0 && it.depth >= req.depth
+		for _, parent := range node.parents {
+			if excluded[parent.ID] || (!req.since.IsZero() && parent.Date().Before(req.since)) {
+				boundary = true
+			}
+		}
+
+		if boundary {
+			if !fw.clientShallow[node.ID] {
+				fw.shallow = append(fw.shallow, node.ID)
+			}
+			continue
+		}
+
+		if fw.clientShallow[node.ID] && len(node.parents) > 0 {
+			fw.unshallow = append(fw.unshallow, node.ID)
+		}
+
+		for _, parent := range node.parents {
+			if !seen[parent.ID] {
+				seen[parent.ID] = true
+				queue = append(queue, item{parent, depth})
+			}
+		}
+	}
+
+	//the commits the client has, i.e. the ones reachable from the
+	//common commits; only commits not older than the oldest commit
+	//to send (with a day of slack for clock skew) are relevant
+	if len(included) > 0 && len(fw.common) > 0 {
+		oldest := included[0].Date()
+		for _, node := range included {
+			if node.Date().Before(oldest) {
+				oldest = node.Date()
+			}
+		}
+		oldest = oldest.Add(-24 * time.Hour)
+
+		queue := append([]*CommitNode(nil), fw.common...)
+		for _, node := range queue {
+			node.Flags |= nodeUninteresting
+		}
+
+		for len(queue) > 0 {
+			node := queue[0]
+			queue = queue[1:]
+
+			if fw.clientShallow[node.ID] || node.Date().Before(oldest) {
+				continue
+			}
+
+			err := fw.graph.loadParents(node)
+			if err != nil {
+				return err
+			}
+
+			for _, parent := range node.parents {
+				if parent.Flags&nodeUninteresting == 0 {
+					parent.Flags |= nodeUninteresting
+					queue = append(queue, parent)
+				}
+			}
+		}
+	}
+
+	for _, node := range included {
+		if node.Flags&nodeUninteresting == 0 {
+			fw.commits = append(fw.commits, node)
+		}
+	}
+
+	fw.findEdges()
+
+	//the client has the full trees of the unshallowed commits
+	for _, oid := range fw.unshallow {
+		if node, err := fw.graph.openObject(oid); err == nil && node.Flags&nodeUninteresting != 0 {
+			fw.edges = append(fw.edges, node)
+		}
+	}
+
+	return nil
+}
+
+//markReachable adds the ids of all commits reachable from oid to set.
+func (fw *fetchWalk) markReachable(oid SHA1, set map[SHA1]bool) error {
+	oid, err := fw.repo.peel(oid, ObjCommit)
+	if err != nil {
+		return err
+	}
+
+	queue := []SHA1{oid}
+	set[oid] = true
+	for len(queue) > 0 {
+		node, err := fw.graph.openObject(queue[0])
+		queue = queue[1:]
+		if err != nil {
+			return err
+		}
+
+		for _, pid := range node.parentIDs {
+			if !set[pid] {
+				set[pid] = true
+				queue = append(queue, pid)
+			}
+		}
+	}
+
+	return nil
+}
+
+//findEdges collects the commits the client has that are
+//parents of the commits to send.
+func (fw *fetchWalk) findEdges() {
+	seen := make(map[SHA1]bool)
+	for _, node := range fw.commits {
+		for _, parent := range node.parents {
+			if parent.Flags&nodeUninteresting != 0 && !seen[parent.ID] {
+				seen[parent.ID] = true
+				fw.edges = append(fw.edges, parent)
+			}
+		}
+	}
+}
+
+//addObjects adds the commits to send and the objects reachable from
+//them, but not from the edge commits, to the pack.
+func (fw *fetchWalk) addObjects(pack *PackWriter) error {
+	//objects of the edge commits are excluded; marking them
+	//as seen, means they are not added to the pack below
+	seen := make(map[SHA1]bool)
+	for _, node := range fw.edges {
+		commit, err := fw.graph.Commit(node)
+		if err != nil {
+			return err
+		}
+
+		err = fw.walkTree(commit.Tree, "", seen, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, node := range fw.commits {
+		err := pack.AddObject(node.ID, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, node := range fw.commits {
+		commit, err := fw.graph.Commit(node)
+		if err != nil {
+			return err
+		}
+
+		err = fw.walkTree(commit.Tree, "", seen, pack)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, oid := range fw.extra {
+		obj, err := fw.repo.OpenObject(oid)
+		if err != nil {
+			return err
+		}
+		obj.Close()
+
+		if obj.Type() == ObjTree {
+			err = fw.walkTree(oid, "", seen, pack)
+		} else {
+			//explicitly wanted blobs are not filtered
+			err = pack.AddObject(oid, "")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if fw.req.includeTag {
+		return fw.addTags(pack)
+	}
+
+	return nil
+}
+
+//walkTree adds the objects reachable from the tree id (at path) to
+//the pack, unless they have been seen before. If pack is nil, the
+//objects are only marked as seen.
+func (fw *fetchWalk) walkTree(id SHA1, path string, seen map[SHA1]bool, pack *PackWriter) error {
+	if seen[id] {
+		return nil
+	}
+	seen[id] = true
+
+	if pack != nil {
+		err := pack.AddObject(id, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	entries, err := fw.repo.readTree(id)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := e.Name
+		if path != "" {
+			name = path + "/" + e.Name
+		}
+
+		switch {
+		case e.Mode&gitModeTypeMask == gitModeGitlink:
+			//submodule commits are not part of the repository
+		case e.Type == ObjTree:
+			err = fw.walkTree(e.ID, name, seen, pack)
+		case seen[e.ID]:
+		default:
+			seen[e.ID] = true
+			if pack != nil && fw.wantBlob(e.ID) {
+				err = pack.AddObject(e.ID, name)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//wantBlob applies the blob filter of the request.
+func (fw *fetchWalk) wantBlob(id SHA1) bool {
+	limit := fw.req.blobLimit
+	if limit < 0 {
+		return true
+	} else if limit == 0 {
+		return false
+	}
+
+	_, size, err := fw.repo.readObjectInfo(id)
+	return err == nil && size < limit
+}
+
+//addTags adds annotated tags pointing to objects in the pack.
+func (fw *fetchWalk) addTags(pack *PackWriter) error {
+	refs, err := fw.repo.listRefs()
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.name, "refs/tags/") || pack.seen[ref.id] {
+			continue
+		}
+
+		//follow chains of tags down to the tagged object
+		var chain []SHA1
+		for oid := ref.id; ; {
+			obj, err := fw.repo.OpenObject(oid)
+			if err != nil {
+				return err
+			}
+			obj.Close()
+
+			tag, ok := obj.(*Tag)
+			if !ok {
+				if pack.seen[oid] {
+					for _, t := range chain {
+						pack.AddObject(t, "")
+					}
+				}
+				break
+			}
+
+			chain = append(chain, oid)
+			oid = tag.Object
+		}
+	}
+
+	return nil
+}
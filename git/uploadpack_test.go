@@ -0,0 +1,231 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//uploadPackServer serves the repository via the smart HTTP
+//protocol, with UploadPack handling the requests.
+func uploadPackServer(t *testing.T, repo *Repository) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		up := NewUploadPack(repo)
+
+		var err error
+		switch r.URL.Path {
+		case "/repo.git/info/refs":
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+			err = up.Advertise(w)
+		case "/repo.git/git-upload-pack":
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+			err = up.Serve(r.Body, w)
+		default:
+			http.NotFound(w, r)
+		}
+
+		if err != nil {
+			t.Errorf("upload-pack failed for %s: %v", r.URL.Path, err)
+		}
+	}))
+}
+
+func TestUploadPack(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("first", map[string]string{"a.txt": "a\n", "dir/b.txt": "b\n"})
+	tr.commit("second", map[string]string{"a.txt": "aa\n"})
+	tr.git("tag", "-a", "-m", "version 1", "v1.0")
+	tr.git("checkout", "-q", "-b", "side", first.String())
+	tr.commit("side", map[string]string{"side.txt": "side\n"})
+	tr.git("checkout", "-q", "master")
+	tr.git("merge", "-q", "--no-edit", "side")
+	third := tr.commit("third", map[string]string{"dir/c.txt": strings.Repeat("c\n", 1000)})
+
+	ts := uploadPackServer(t, tr.Repository)
+	defer ts.Close()
+	url := ts.URL + "/repo.git"
+
+	dir, err := ioutil.TempDir("", "gin-git-clone")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := func(name string, args ...string) string {
+		args = append([]string{"-c", "protocol.version=2", "-C", filepath.Join(dir, name)}, args...)
+		return tr.git(args...)
+	}
+
+	//full clone
+	tr.git("-c", "protocol.version=2", "clone", "-q", url, filepath.Join(dir, "full"))
+	client("full", "fsck", "--strict")
+	if head := client("full", "rev-parse", "HEAD"); head != third.String() {
+		t.Fatalf("cloned HEAD is %s, expected %s", head, third)
+	} else if tags := client("full", "tag"); tags != "v1.0" {
+		t.Fatalf("tag not cloned: %q", tags)
+	}
+
+	//incremental fetch
+	fourth := tr.commit("fourth", map[string]string{"dir/b.txt": "bb\n"})
+	client("full", "fetch", "-q")
+	client("full", "fsck", "--strict")
+	if id := client("full", "rev-parse", "origin/master"); id != fourth.String() {
+		t.Fatalf("fetched origin/master is %s, expected %s", id, fourth)
+	}
+
+	//shallow clone, then deepen it step by step
+	tr.git("-c", "protocol.version=2", "clone", "-q", "--depth", "1", url, filepath.Join(dir, "shallow"))
+	client("shallow", "fsck")
+	if n := client("shallow", "rev-list", "--count", "HEAD"); n != "1" {
+		t.Fatalf("shallow clone has %s commits, expected 1", n)
+	}
+
+	client("shallow", "fetch", "-q", "--deepen", "1")
+	if n := client("shallow", "rev-list", "--count", "HEAD"); n != "2" {
+		t.Fatalf("deepened clone has %s commits, expected 2", n)
+	}
+
+	client("shallow", "fetch", "-q", "--unshallow")
+	client("shallow", "fsck", "--strict")
+	if n := client("shallow", "rev-list", "--count", "HEAD"); n != "6" {
+		t.Fatalf("unshallowed clone has %s commits, expected 6", n)
+	} else if _, err := os.Stat(filepath.Join(dir, "shallow", ".git", "shallow")); !os.IsNotExist(err) {
+		t.Fatalf("clone is still shallow")
+	}
+
+	//the test commits are one minute apart, see testRepo.git
+	since := tr.git("log", "-1", "--format=%ct", fourth.String()+"^")
+	tr.git("-c", "protocol.version=2", "clone", "-q", "--shallow-since", since, url, filepath.Join(dir, "since"))
+	client("since", "fsck")
+	if n := client("since", "rev-list", "--count", "HEAD"); n != "2" {
+		t.Fatalf("clone with --shallow-since has %s commits, expected 2", n)
+	}
+
+	//partial clone, blobs are fetched on demand
+	tr.git("-c", "protocol.version=2", "clone", "-q", "--no-checkout", "--filter=blob:none", url, filepath.Join(dir, "partial"))
+	missing := client("partial", "rev-list", "--objects", "--missing=print", "HEAD")
+	if !strings.Contains(missing, "?") {
+		t.Fatalf("partial clone has all blobs")
+	}
+
+	blob := tr.git("rev-parse", "HEAD:dir/c.txt")
+	if data := client("partial", "cat-file", "-p", blob); data != strings.TrimSpace(strings.Repeat("c\n", 1000)) {
+		t.Fatalf("lazily fetched blob has unexpected content")
+	}
+
+	client("partial", "checkout", "-q", "master")
+	client("partial", "fsck")
+}
+
+func pkt(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+func TestUploadPackLsRefs(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	var req bytes.Buffer
+	pw := &pktWriter{w: &req}
+	pw.Printf("command=ls-refs\n")
+	pw.Delim()
+	pw.Printf("symrefs\n")
+	pw.Printf("unborn\n")
+	pw.Flush()
+
+	//empty repository with unborn HEAD
+	var res bytes.Buffer
+	err := NewUploadPack(tr.Repository).Serve(bytes.NewReader(req.Bytes()), &res)
+	if wanted := pkt("unborn HEAD symref-target:refs/heads/master\n") + "0000"; err != nil || res.String() != wanted {
+		t.Fatalf("unexpected ls-refs response %q (%v), expected %q", res.String(), err, wanted)
+	}
+
+	first := tr.commit("first", nil)
+	tr.git("tag", "-a", "-m", "tag", "v1.0")
+	tag := tr.revParse("v1.0")
+
+	req.Reset()
+	pw.Printf("command=ls-refs\n")
+	pw.Printf("agent=git/2.x\n")
+	pw.Delim()
+	pw.Printf("peel\n")
+	pw.Printf("ref-prefix refs/tags/\n")
+	pw.Flush()
+
+	res.Reset()
+	err = NewUploadPack(tr.Repository).Serve(bytes.NewReader(req.Bytes()), &res)
+	if err != nil {
+		t.Fatalf("ls-refs failed: %v", err)
+	}
+
+	line := tag.String() + " refs/tags/v1.0 peeled:" + first.String() + "\n"
+	if wanted := pkt(line) + "0000"; res.String() != wanted {
+		t.Fatalf("unexpected ls-refs response %q, expected %q", res.String(), wanted)
+	}
+
+	req.Reset()
+	pw.Printf("command=push\n")
+	pw.Flush()
+
+	res.Reset()
+	err = NewUploadPack(tr.Repository).Serve(bytes.NewReader(req.Bytes()), &res)
+	if err == nil || !strings.Contains(res.String(), "ERR ") {
+		t.Fatalf("expected error for unknown command, got %q", res.String())
+	}
+}
+
+func TestUploadPackWants(t *testing.T) {
+	parent := newTestRepo(t)
+	defer parent.cleanup()
+
+	first := parent.commit("first", map[string]string{"a.txt": "a\n"})
+	head := parent.commit("second", map[string]string{"a.txt": "aa\n"})
+	parent.git("checkout", "-q", "-b", "private")
+	private := parent.commit("private", map[string]string{"secret.txt": "secret\n"})
+
+	//a fork shares the objects of its parent, but not its refs
+	fork := newTestRepo(t)
+	defer fork.cleanup()
+
+	err := fork.AddAlternate(filepath.Join(parent.Path, "objects"))
+	if err != nil {
+		t.Fatalf("could not add alternate: %v", err)
+	}
+	fork.git("update-ref", "refs/heads/master", head.String())
+	fork.git("tag", "-a", "-m", "version 1", "v1.0", first.String())
+	tag := fork.revParse("v1.0")
+
+	fetch := func(want SHA1) error {
+		var req bytes.Buffer
+		pw := &pktWriter{w: &req}
+		pw.Printf("command=fetch\n")
+		pw.Delim()
+		pw.Printf("want %s\n", want)
+		pw.Printf("done\n")
+		pw.Flush()
+
+		var res bytes.Buffer
+		return NewUploadPack(fork.Repository).Serve(bytes.NewReader(req.Bytes()), &res)
+	}
+
+	//blobs are fetched by id for partial clones
+	for _, want := range []SHA1{head, first, tag, parent.revParse("master~1:a.txt")} {
+		if err := fetch(want); err != nil {
+			t.Fatalf("fetching %s failed: %v", want, err)
+		}
+	}
+
+	for _, want := range []SHA1{private, parent.revParse("private:secret.txt"), parent.revParse("private^{tree}")} {
+		if err := fetch(want); err == nil || !strings.Contains(err.Error(), "not our ref") {
+			t.Fatalf("expected not our ref error for %s, got %v", want, err)
+		}
+	}
+}
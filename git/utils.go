@@ -1,6 +1,7 @@
 package git
 
 import (
+	"bufio"
 	"bytes"
 	"compress/zlib"
 	"io"
@@ -11,13 +12,16 @@ func readUntilNul(r io.Reader) (string, error) {
 	buf := bytes.NewBuffer(make([]byte, 0))
 	for {
 		var b [1]byte
-		_, err := r.Read(b[:])
-		if err != nil {
-			return "", err
-		} else if b[0] == 0 {
+		n, err := r.Read(b[:])
+		//the header of empty objects ends with the data, i.e.
+		//the NUL byte might come together with io.EOF
+		if n == 1 && b[0] == 0 {
 			break
+		} else if err != nil {
+			return "", err
+		} else if n == 1 {
+			buf.WriteByte(b[0])
 		}
-		buf.WriteByte(b[0])
 	}
 
 	return buf.String(), nil
@@ -57,7 +61,13 @@ func (r *zlibReadCloser) Close() error {
 }
 
 func (o *gitObject) wrapSourceWithDeflate() error {
-	r, err := zlib.NewReader(o.source)
+	src := io.Reader(o.source)
+	if _, ok := o.source.(*packReader); ok {
+		//otherwise zlib reads every byte via ReadAt
+		src = bufio.NewReader(o.source)
+	}
+
+	r, err := zlib.NewReader(src)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,156 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+//SignatureStatus is the result of the verification
+//of the OpenPGP signature of a commit or tag.
+type SignatureStatus string
+
+//The possible results of VerifySignature.
+const (
+	SigNone       SignatureStatus = "unsigned"
+	SigValid      SignatureStatus = "valid"
+	SigUnknownKey SignatureStatus = "unknown key"
+	SigBad        SignatureStatus = "bad signature"
+)
+
+//SignatureCheck is the result of VerifySignature.
+type SignatureCheck struct {
+	Status SignatureStatus
+
+	//KeyID is the id of the key that made the signature,
+	//or 0 if the signature does not name it.
+	KeyID uint64
+
+	//Signer is the owner of the key, for valid signatures.
+	Signer *openpgp.Entity
+}
+
+//VerifySignature checks the OpenPGP signature of the commit or tag
+//id against the public keys of keyring. Errors are only returned if
+//the object cannot be read; broken or mismatching signatures are
+//reported as SigBad.
+func (repo *Repository) VerifySignature(id SHA1, keyring openpgp.KeyRing) (SignatureCheck, error) {
+	otype, data, err := repo.readObjectData(id)
+	if err != nil {
+		return SignatureCheck{}, err
+	}
+
+	var payload, sig []byte
+	switch otype {
+	case ObjCommit:
+		payload, sig = splitCommitSignature(data)
+	case ObjTag:
+		payload, sig = splitTagSignature(data)
+	default:
+		return SignatureCheck{}, fmt.Errorf("git: %s is a %s, which cannot be signed", id, otype)
+	}
+
+	if sig == nil {
+		return SignatureCheck{Status: SigNone}, nil
+	}
+
+	check := SignatureCheck{KeyID: signatureIssuer(sig)}
+	check.Signer, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(payload), bytes.NewReader(sig))
+
+	switch {
+	case err == nil:
+		check.Status = SigValid
+	case err == pgperrors.ErrUnknownIssuer:
+		check.Status = SigUnknownKey
+	default:
+		check.Status = SigBad
+	}
+
+	return check, nil
+}
+
+//splitCommitSignature removes the "gpgsig" header, including its
+//continuation lines, from the raw commit and returns the signature.
+//The rest of the commit is what was signed.
+func splitCommitSignature(data []byte) (payload, sig []byte) {
+	end := bytes.Index(data, []byte("\n\n"))
+	if end == -1 {
+		end = len(data)
+	}
+
+	start := -1
+	if bytes.HasPrefix(data, []byte("gpgsig ")) {
+		start = 0
+	} else if i := bytes.Index(data[:end], []byte("\ngpgsig ")); i > -1 {
+		start = i + 1
+	}
+
+	if start == -1 {
+		return data, nil
+	}
+
+	//the header ends with the first line not starting with a space
+	stop := start
+	for {
+		i := bytes.IndexByte(data[stop:], '\n')
+		if i == -1 {
+			stop = len(data)
+			break
+		}
+		stop += i + 1
+		if stop >= len(data) || data[stop] != ' ' {
+			break
+		}
+	}
+
+	header := data[start+len("gpgsig ") : stop]
+	sig = bytes.Replace(header, []byte("\n "), []byte("\n"), -1)
+
+	payload = make([]byte, 0, len(data)-(stop-start))
+	payload = append(payload, data[:start]...)
+	payload = append(payload, data[stop:]...)
+
+	return payload, sig
+}
+
+//splitTagSignature splits the signature, which is appended to the
+//message of signed tags, from the raw tag.
+func splitTagSignature(data []byte) (payload, sig []byte) {
+	marker := []byte("-----BEGIN PGP SIGNATURE-----")
+
+	i := bytes.LastIndex(data, marker)
+	if i == -1 || (i > 0 && data[i-1] != '\n') {
+		return data, nil
+	}
+
+	return data[:i], data[i:]
+}
+
+//signatureIssuer returns the id of the key that made the armored
+//signature sig, or 0 if it cannot be determined.
+func signatureIssuer(sig []byte) uint64 {
+	block, err := armor.Decode(bytes.NewReader(sig))
+	if err != nil {
+		return 0
+	}
+
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return 0
+	}
+
+	switch s := p.(type) {
+	case *packet.Signature:
+		if s.IssuerKeyId != nil {
+			return *s.IssuerKeyId
+		}
+	case *packet.SignatureV3:
+		return s.IssuerKeyId
+	}
+
+	return 0
+}
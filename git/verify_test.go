@@ -0,0 +1,146 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func TestVerifySignature(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	alice, err := openpgp.NewEntity("Alice", "", "alice@example.com", nil)
+	if err != nil {
+		t.Fatalf("could not create key: %v", err)
+	}
+	mallory, err := openpgp.NewEntity("Mallory", "", "mallory@example.com", nil)
+	if err != nil {
+		t.Fatalf("could not create key: %v", err)
+	}
+	keyring := openpgp.EntityList{alice}
+
+	unsigned := tr.commit("first", map[string]string{"a.txt": "a\n"})
+	_, data, err := tr.readObjectData(unsigned)
+	if err != nil {
+		t.Fatalf("could not read commit: %v", err)
+	}
+
+	//signed commits have the signature as header, after the committer
+	sign := func(signer *openpgp.Entity, payload []byte) string {
+		var sig bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&sig, signer, bytes.NewReader(payload), nil); err != nil {
+			t.Fatalf("could not sign: %v", err)
+		}
+		return sig.String()
+	}
+
+	signCommit := func(signer *openpgp.Entity, payload []byte) SHA1 {
+		sig := strings.Replace(sign(signer, payload), "\n", "\n ", -1)
+		i := bytes.Index(payload, []byte("\n\n"))
+		commit := string(payload[:i+1]) + "gpgsig " + sig + "\n" + string(payload[i+1:])
+
+		id, err := tr.writeObjectData(ObjCommit, []byte(commit))
+		if err != nil {
+			t.Fatalf("could not write commit: %v", err)
+		}
+		return id
+	}
+
+	valid := signCommit(alice, data)
+	unknown := signCommit(mallory, data)
+
+	//the signature of the first commit with a different message
+	bad := signCommit(alice, data)
+	_, badData, _ := tr.readObjectData(bad)
+	badData = bytes.Replace(badData, []byte("first"), []byte("frist"), 1)
+	bad, err = tr.writeObjectData(ObjCommit, badData)
+	if err != nil {
+		t.Fatalf("could not write commit: %v", err)
+	}
+
+	tag := []byte("object " + valid.String() + "\ntype commit\ntag v1.0\n" +
+		"tagger A U Thor <author@example.com> 1500000000 +0200\n\nRelease\n")
+	signedTag, err := tr.writeObjectData(ObjTag, append(tag, sign(alice, tag)+"\n"...))
+	if err != nil {
+		t.Fatalf("could not write tag: %v", err)
+	}
+
+	tr.git("update-ref", "refs/heads/signed", valid.String())
+	tr.git("fsck", "--strict")
+
+	cases := []struct {
+		id     SHA1
+		status SignatureStatus
+		keyID  uint64
+	}{
+		{unsigned, SigNone, 0},
+		{valid, SigValid, alice.PrimaryKey.KeyId},
+		{unknown, SigUnknownKey, mallory.PrimaryKey.KeyId},
+		{bad, SigBad, alice.PrimaryKey.KeyId},
+		{signedTag, SigValid, alice.PrimaryKey.KeyId},
+	}
+
+	for i, c := range cases {
+		check, err := tr.VerifySignature(c.id, keyring)
+		if err != nil {
+			t.Fatalf("[%d] could not verify %s: %v", i, c.id, err)
+		} else if check.Status != c.status || check.KeyID != c.keyID {
+			t.Fatalf("[%d] unexpected result %s (%X), expected %s (%X)", i, check.Status, check.KeyID, c.status, c.keyID)
+		} else if c.status == SigValid && check.Signer != alice {
+			t.Fatalf("[%d] unexpected signer %v", i, check.Signer)
+		}
+	}
+
+	obj, err := tr.OpenObject(signedTag)
+	if err != nil {
+		t.Fatalf("could not open tag: %v", err)
+	}
+	obj.Close()
+	if tag := obj.(*Tag); tag.Message != "Release\n" || !strings.HasPrefix(tag.GPGSig, "-----BEGIN PGP SIGNATURE-----") {
+		t.Fatalf("unexpected signed tag: %+v", tag)
+	}
+
+	blob, _ := tr.writeObjectData(ObjBlob, []byte("a\n"))
+	if _, err := tr.VerifySignature(blob, keyring); err == nil {
+		t.Fatalf("expected error for blob")
+	}
+
+	//commits and tags signed by git and gpg
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("[W] Could not find gpg binary. Skipping the rest of the test")
+	}
+
+	var key bytes.Buffer
+	w, _ := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+	alice.SerializePrivate(w, nil)
+	w.Close()
+
+	home := filepath.Join(tr.work, ".gnupg")
+	os.Mkdir(home, 0700)
+	cmd := exec.Command("gpg", "--homedir", home, "--batch", "--import")
+	cmd.Stdin = &key
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("could not import key: %v\n%s", err, out)
+	}
+	defer exec.Command("gpgconf", "--homedir", home, "--kill", "all").Run()
+
+	tr.git("config", "user.signingkey", alice.PrimaryKey.KeyIdString())
+	tr.write(map[string]string{"b.txt": "b\n"})
+	tr.git("add", "-A")
+	tr.git("commit", "-q", "-S", "-m", "signed\n\nby git")
+	tr.git("tag", "-s", "-m", "signed tag", "v2.0")
+
+	for _, rev := range []string{"HEAD", "v2.0"} {
+		check, err := tr.VerifySignature(tr.revParse(rev), keyring)
+		if err != nil || check.Status != SigValid {
+			t.Fatalf("signature of %s made by git is %s (%v)", rev, check.Status, err)
+		}
+	}
+}
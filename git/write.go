@@ -2,8 +2,15 @@ package git
 
 import (
 	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -204,3 +211,187 @@ func (t *Tag) WriteTo(writer io.Writer) (int64, error) {
 	err = w.Flush()
 	return n, err
 }
+
+//NewBlob creates a blob object with the given content,
+//e.g. to be written via Repository.WriteObject.
+func NewBlob(data []byte) *Blob {
+	source := ioutil.NopCloser(bytes.NewReader(data))
+	return &Blob{gitObject{ObjBlob, int64(len(data)), source}}
+}
+
+//NewTree creates a tree object with the given entries, e.g. to be
+//written via Repository.WriteObject. The entries are sorted as git
+//requires it. Mode must be the git mode, e.g. 0100644 or 040000.
+func NewTree(entries []TreeEntry) *Tree {
+	sorted := make([]TreeEntry, len(entries))
+	copy(sorted, entries)
+	sort.Sort(byTreeOrder(sorted))
+
+	var buf bytes.Buffer
+	for _, e := range sorted {
+		fmt.Fprintf(&buf, "%o %s\x00", e.Mode, e.Name)
+		buf.Write(e.ID[:])
+	}
+
+	source := ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
+	return &Tree{gitObject: gitObject{ObjTree, int64(buf.Len()), source}}
+}
+
+//byTreeOrder sorts tree entries like git: by name, with
+//the names of sub-trees compared as if they ended in "/".
+type byTreeOrder []TreeEntry
+
+func (t byTreeOrder) Len() int      { return len(t) }
+func (t byTreeOrder) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t byTreeOrder) Less(i, j int) bool {
+	key := func(e TreeEntry) string {
+		if e.Mode&gitModeTypeMask == 040000 {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	return key(t[i]) < key(t[j])
+}
+
+//WriteObject stores the object as loose object in the repository and
+//returns its id. The type and the size in the header are determined
+//from the object itself, so commits and tags can be created as plain
+//struct values. If the object already exists, it is not written again.
+//Objects are written to a temporary file first and then renamed, so
+//readers never see partial objects. NB: blobs and trees are read, but
+//not closed.
+func (repo *Repository) WriteObject(obj Object) (SHA1, error) {
+	var buf bytes.Buffer
+	_, err := obj.WriteTo(&buf)
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	//strip the header written by WriteTo
+	data := buf.Bytes()
+	data = data[bytes.IndexByte(data, 0)+1:]
+
+	otype := obj.Type()
+	switch obj.(type) {
+	case *Commit:
+		otype = ObjCommit
+	case *Tree:
+		otype = ObjTree
+	case *Blob:
+		otype = ObjBlob
+	case *Tag:
+		otype = ObjTag
+	}
+
+	return repo.writeObjectData(otype, data)
+}
+
+//writeObjectData stores the data of an object of type otype as
+//loose object, unless the object exists already.
+func (repo *Repository) writeObjectData(otype ObjectType, data []byte) (SHA1, error) {
+	if !IsStandardObject(otype) {
+		return SHA1{}, fmt.Errorf("git: cannot write object of type %s", otype)
+	}
+
+	id := hashObject(otype, data)
+	if repo.hasObject(id) {
+		return id, nil
+	}
+
+	return id, repo.writeLoose(id, otype, data)
+}
+
+//writeLoose writes the object id as loose object, even if it
+//is contained in a pack already.
+func (repo *Repository) writeLoose(id SHA1, otype ObjectType, data []byte) error {
+	idstr := id.String()
+	dir := filepath.Join(repo.Path, "objects", idstr[:2])
+
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return fmt.Errorf("git: could not write object: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(dir, "tmp_obj_")
+	if err != nil {
+		return fmt.Errorf("git: could not write object: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := zlib.NewWriter(tmp)
+	_, err = fmt.Fprintf(zw, "%s %d\x00", otype, len(data))
+	if err == nil {
+		_, err = zw.Write(data)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		//objects are immutable, like git we make them read-only
+		err = os.Chmod(tmp.Name(), 0444)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, idstr[2:]))
+	}
+
+	if err != nil {
+		return fmt.Errorf("git: could not write object: %v", err)
+	}
+
+	return nil
+}
+
+//hashObject returns the id of the object of type otype with data.
+func hashObject(otype ObjectType, data []byte) SHA1 {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", otype, len(data))
+	h.Write(data)
+
+	var id SHA1
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+//hasObject checks if the object id exists, either as loose
+//object or in one of the packs, of the repository or of one
+//of its alternates.
+func (repo *Repository) hasObject(id SHA1) bool {
+	dirs, err := repo.objectDirs()
+	if err != nil {
+		return false
+	}
+
+	idstr := id.String()
+	for _, dir := range dirs {
+		_, err := os.Stat(filepath.Join(dir, idstr[:2], idstr[2:]))
+		if err == nil {
+			return true
+		}
+	}
+
+	_, _, err = findPackedObject(dirs, id)
+	return err == nil
+}
+
+//hasLocalObject checks if the object id exists in the object
+//directory of the repository itself, i.e. not only in one of
+//its alternates.
+func (repo *Repository) hasLocalObject(id SHA1) bool {
+	idstr := id.String()
+	_, err := os.Stat(filepath.Join(repo.Path, "objects", idstr[:2], idstr[2:]))
+	if err == nil {
+		return true
+	}
+
+	_, _, err = repo.packs().findObject(id)
+	return err == nil
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -193,3 +194,95 @@ func TestWriteTag(t *testing.T) {
 		t.Fatalf("sha1(tag) => %q expected %q", x, y)
 	}
 }
+
+func TestWriteObject(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	packed := tr.commit("initial", map[string]string{"packed.txt": "packed\n"})
+	tr.git("repack", "-q", "-a", "-d")
+
+	blob, err := tr.WriteObject(NewBlob([]byte("hello world\n")))
+	if err != nil {
+		t.Fatalf("could not write blob: %v", err)
+	} else if blob.String() != "3b18e512dba79e4c8300dd08aeb37f8e728b8dad" {
+		t.Fatalf("unexpected blob id %s", blob)
+	}
+
+	if res := tr.git("cat-file", "-p", blob.String()); res != "hello world" {
+		t.Fatalf("unexpected blob content %q", res)
+	}
+
+	sub, err := tr.WriteObject(NewTree([]TreeEntry{{Mode: 0100644, Name: "file.txt", ID: blob}}))
+	if err != nil {
+		t.Fatalf("could not write tree: %v", err)
+	}
+
+	entries := []TreeEntry{
+		{Mode: 0100755, Name: "sub.txt", ID: blob},
+		{Mode: 040000, Name: "sub", ID: sub},
+		{Mode: 0120000, Name: "link", ID: blob},
+		{Mode: 0100644, Name: "sub-file", ID: blob},
+	}
+
+	root, err := tr.WriteObject(NewTree(entries))
+	if err != nil {
+		t.Fatalf("could not write tree: %v", err)
+	}
+
+	//NB: sub-trees sort as if their name ended in "/"
+	wanted := fmt.Sprintf("120000 blob %[1]s\tlink\n100644 blob %[1]s\tsub-file\n100755 blob %[1]s\tsub.txt\n040000 tree %[2]s\tsub", blob, sub)
+	if res := tr.git("ls-tree", root.String()); res != wanted {
+		t.Fatalf("unexpected tree:\n%s\nexpected:\n%s", res, wanted)
+	}
+
+	sig := Signature{
+		Name:   "Web Editor",
+		Email:  "web@example.com",
+		Date:   time.Unix(1500000000, 0),
+		Offset: time.FixedZone("+0100", 3600),
+	}
+
+	commit, err := tr.WriteObject(&Commit{
+		Tree:      root,
+		Parent:    []SHA1{packed},
+		Author:    sig,
+		Committer: sig,
+		Message:   "written natively\n",
+	})
+	if err != nil {
+		t.Fatalf("could not write commit: %v", err)
+	}
+
+	tag, err := tr.WriteObject(&Tag{
+		Object:  commit,
+		ObjType: ObjCommit,
+		Tag:     "v1.0",
+		Tagger:  sig,
+		Message: "a tag\n",
+	})
+	if err != nil {
+		t.Fatalf("could not write tag: %v", err)
+	}
+
+	tr.git("update-ref", "refs/tags/v1.0", tag.String())
+	tr.git("fsck", "--strict", "--no-dangling")
+
+	if res := tr.git("log", "--format=%an %s", "v1.0"); res != "Web Editor written natively\nA U Thor initial" {
+		t.Fatalf("unexpected log: %q", res)
+	}
+
+	//existing objects are not written again
+	tr.git("repack", "-q", "-a", "-d")
+	path := filepath.Join(tr.Path, "objects", blob.String()[:2], blob.String()[2:])
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected blob to be packed")
+	}
+
+	_, err = tr.WriteObject(NewBlob([]byte("hello world\n")))
+	if err != nil {
+		t.Fatalf("could not write blob again: %v", err)
+	} else if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("packed object was written as loose object")
+	}
+}
@@ -57,6 +57,10 @@ func (store *GinAuthStore) LookupUserBySSH(fingerprint string) (*User, error) {
 	return user, nil
 }
 
+func (store *GinAuthStore) LookupUserByGPGKey(keyID uint64) (*User, error) {
+	return nil, fmt.Errorf("Not implemented :-(")
+}
+
 func (store *GinAuthStore) TokenForUser(uid string) (string, error) {
 	return "", fmt.Errorf("Not implemented :-(")
 }
@@ -19,6 +19,7 @@ type LocalUserStore struct {
 
 	users    map[string]*User
 	key2User map[string]*User
+	gpg2User map[uint64]*User
 
 	secret []byte
 }
@@ -35,7 +36,7 @@ func (store *LocalUserStore) loadUser(uid string) (User, error) {
 		i++
 	}
 
-	user := User{Uid: uid, Keys: keys}
+	user := User{Uid: uid, Keys: keys, GPGKeys: ReadGPGKeysInDir(base)}
 	return user, nil
 }
 
@@ -55,6 +56,7 @@ func (store *LocalUserStore) setup() error {
 
 	store.users = make(map[string]*User)
 	store.key2User = make(map[string]*User)
+	store.gpg2User = make(map[uint64]*User)
 	for _, fi := range entires {
 		fmt.Fprintf(os.Stderr, "%s\n", fi.Name())
 
@@ -81,6 +83,14 @@ func (store *LocalUserStore) setup() error {
 			fmt.Fprintf(os.Stderr, "[D] key2User: %s <- %q\n",
 				user.Uid, fingerprint)
 		}
+
+		// signatures can be made by subkeys as well
+		for _, e := range user.GPGKeys {
+			store.gpg2User[e.PrimaryKey.KeyId] = &user
+			for _, sub := range e.Subkeys {
+				store.gpg2User[sub.PublicKey.KeyId] = &user
+			}
+		}
 	}
 
 	store.secret, err = auth.ReadSharedSecret()
@@ -96,6 +106,15 @@ func (store *LocalUserStore) LookupUserBySSH(fingerprint string) (*User, error)
 	return nil, fmt.Errorf("could not find user with given fingerprint")
 }
 
+func (store *LocalUserStore) LookupUserByGPGKey(keyID uint64) (*User, error) {
+
+	if user, ok := store.gpg2User[keyID]; ok {
+		return user, nil
+	}
+
+	return nil, fmt.Errorf("could not find user with given key id")
+}
+
 func (store *LocalUserStore) TokenForUser(uid string) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 
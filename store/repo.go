@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/G-Node/gin-repo/git"
 )
@@ -172,6 +173,127 @@ func (store *RepoStore) CreateRepo(id RepoId) (*git.Repository, error) {
 	return repo, nil
 }
 
+// ForkRepo creates the repository dst as a fork of src. The fork borrows
+// the objects of src via alternates, so only objects pushed to the fork
+// later are stored in dst itself. The refs and the description are
+// copied, annexed content is hard-linked. The fork starts out private
+// and unshared; its origin is recorded in "gin/fork-of" and the fork
+// in "gin/forks/<owner>/<name>" of src.
+func (store *RepoStore) ForkRepo(src, dst RepoId) (*git.Repository, error) {
+	origin, err := store.OpenGitRepo(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep maintenance from removing packs while we copy
+	lock, err := origin.LockShared()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	repo, err := store.CreateRepo(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.initFork(origin, repo, src, dst)
+	if err == nil {
+		err = store.addFork(src, dst)
+	}
+	if err != nil {
+		os.RemoveAll(repo.Path)
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+func (store *RepoStore) initFork(origin, repo *git.Repository, src, dst RepoId) error {
+	err := repo.AddAlternate(filepath.Join(origin.Path, "objects"))
+	if err != nil {
+		return err
+	}
+
+	who := git.NewSignature(dst.Owner, "", time.Now())
+	err = repo.CopyRefs(origin, who)
+	if err != nil {
+		return err
+	}
+
+	err = repo.WriteDescription(origin.ReadDescription())
+	if err != nil {
+		return err
+	}
+
+	if origin.HasAnnex() {
+		_, err = repo.LinkAnnexObjects(origin)
+		if err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(repo.Path, "gin", "fork-of")
+	return ioutil.WriteFile(path, []byte(src.String()), 0664)
+}
+
+// addFork records dst as fork of src, in the metadata of src.
+func (store *RepoStore) addFork(src, dst RepoId) error {
+	dir := filepath.Join(store.IdToPath(src), "gin", "forks", dst.Owner)
+	err := os.MkdirAll(dir, 0775)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, dst.Name), nil, 0664)
+}
+
+// ForkOf returns the repository the repository id was forked from.
+// The boolean is false if id is not a fork.
+func (store *RepoStore) ForkOf(id RepoId) (RepoId, bool, error) {
+	path := filepath.Join(store.IdToPath(id), "gin", "fork-of")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return RepoId{}, false, nil
+	} else if err != nil {
+		return RepoId{}, false, err
+	}
+
+	src, err := RepoIdParse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return RepoId{}, false, err
+	}
+
+	return src, true, nil
+}
+
+// ListForks returns the repositories that were forked from the
+// repository id, not including forks of forks. Forks are recorded
+// in "gin/forks" of id; ones that were deleted since are skipped.
+func (store *RepoStore) ListForks(id RepoId) ([]RepoId, error) {
+	pattern := filepath.Join(store.IdToPath(id), "gin", "forks", "*", "*")
+	names, err := filepath.Glob(pattern)
+
+	if err != nil {
+		panic("Bad glob pattern!")
+	}
+
+	var repos []RepoId
+	for _, name := range names {
+		rid := RepoId{Owner: filepath.Base(filepath.Dir(name)), Name: filepath.Base(name)}
+
+		src, ok, err := store.ForkOf(rid)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[W] could not read origin of %s: %v\n", rid, err)
+			continue
+		} else if ok && src == id {
+			repos = append(repos, rid)
+		}
+	}
+
+	return repos, nil
+}
+
 func (store *RepoStore) ListRepos() ([]RepoId, error) {
 	gitpath := store.gitPath()
 	rdir, err := os.Open(gitpath)
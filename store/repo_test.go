@@ -204,3 +204,60 @@ func TestRepoStore_RepoShared(t *testing.T) {
 		t.Fatalf("Expected success when opening %v\n", rid)
 	}
 }
+
+func TestRepoStore_ForkRepo(t *testing.T) {
+	src := RepoId{Owner: "alice", Name: "auth"}
+	dst := RepoId{Owner: "bob", Name: "authfork"}
+
+	// Test fail on existing repository
+	_, err := repos.ForkRepo(src, RepoId{Owner: "bob", Name: "auth"})
+	if !os.IsExist(err) {
+		t.Fatalf("Expected os.ErrExist when forking to existing repository, got: %v\n", err)
+	}
+
+	repo, err := repos.ForkRepo(src, dst)
+	if err != nil {
+		t.Fatalf("Could not fork %v: %v\n", src, err)
+	}
+	defer os.RemoveAll(repo.Path)
+
+	origin, err := repos.OpenGitRepo(src)
+	if err != nil {
+		t.Fatalf("Could not open %v: %v\n", src, err)
+	}
+
+	if repo.ReadDescription() != origin.ReadDescription() {
+		t.Fatalf("Description not copied: %q\n", repo.ReadDescription())
+	}
+
+	alts, err := repo.Alternates()
+	if err != nil || len(alts) != 1 || alts[0] != filepath.Join(origin.Path, "objects") {
+		t.Fatalf("Unexpected alternates %v (%v)\n", alts, err)
+	}
+
+	head, _ := origin.RevParse("HEAD")
+	if id, err := repo.RevParse("HEAD"); err != nil || id != head {
+		t.Fatalf("HEAD of fork is %s (%v), expected %s\n", id, err, head)
+	}
+
+	// Test fork relationship in both directions
+	from, ok, err := repos.ForkOf(dst)
+	if err != nil || !ok || from != src {
+		t.Fatalf("Unexpected origin of fork: %v, %v, %v\n", from, ok, err)
+	}
+	if _, ok, err := repos.ForkOf(src); err != nil || ok {
+		t.Fatalf("Did not expect %v to be a fork: %v\n", src, err)
+	}
+
+	forks, err := repos.ListForks(src)
+	if err != nil || !reflect.DeepEqual(forks, []RepoId{dst}) {
+		t.Fatalf("Unexpected forks of %v: %v (%v)\n", src, forks, err)
+	}
+
+	// Test deleted forks are not listed
+	os.RemoveAll(repo.Path)
+	forks, err = repos.ListForks(src)
+	if err != nil || len(forks) != 0 {
+		t.Fatalf("Unexpected forks of %v after deleting the fork: %v (%v)\n", src, forks, err)
+	}
+}
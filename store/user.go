@@ -9,15 +9,21 @@ import (
 	"strings"
 
 	"github.com/G-Node/gin-repo/ssh"
+	"golang.org/x/crypto/openpgp"
 )
 
+// User is a user of gin-repo with the public SSH keys for
+// authentication and the public OpenPGP keys, that are used
+// to verify the signatures of commits and tags.
 type User struct {
-	Uid  string
-	Keys []ssh.Key
+	Uid     string
+	Keys    []ssh.Key
+	GPGKeys openpgp.EntityList
 }
 
 type UserStore interface {
 	LookupUserBySSH(fingerprint string) (*User, error)
+	LookupUserByGPGKey(keyID uint64) (*User, error)
 	TokenForUser(uid string) (string, error)
 	UserForRequest(r *http.Request) (*User, error)
 }
@@ -44,3 +50,41 @@ func NewUserStore(base string) (UserStore, error) {
 
 	return nil, fmt.Errorf("unknown store type: %v", stype)
 }
+
+// ReadGPGKeysInDir reads the public OpenPGP keys from the files in
+// dir with the extension ".asc" (armored) or ".gpg" (binary).
+func ReadGPGKeysInDir(dir string) openpgp.EntityList {
+	files, _ := ioutil.ReadDir(dir)
+
+	var keys openpgp.EntityList
+	for _, f := range files {
+		name := f.Name()
+		ext := filepath.Ext(name)
+		if ext != ".asc" && ext != ".gpg" {
+			continue
+		}
+
+		fd, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[W] Skipping %s (%v)\n", name, err)
+			continue
+		}
+
+		var el openpgp.EntityList
+		if ext == ".asc" {
+			el, err = openpgp.ReadArmoredKeyRing(fd)
+		} else {
+			el, err = openpgp.ReadKeyRing(fd)
+		}
+		fd.Close()
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[W] Skipping %s, parse error: %v\n", name, err)
+			continue
+		}
+
+		keys = append(keys, el...)
+	}
+
+	return keys
+}
@@ -16,9 +16,16 @@ type CreateRepo struct {
 	Public      bool
 }
 
+// CreateFork is the request to fork a repository. Name is the name of
+// the fork, the name of the forked repository if empty.
+type CreateFork struct {
+	Name string
+}
+
 // Repo is used to export basic information about a repository.
 // Public states whether a repository is publicly available.
 // Shared states whether a repository is shared with a collaborator.
+// ForkOf is the repository ("owner/name") it was forked from, if any.
 type Repo struct {
 	Name        string
 	Owner       string
@@ -26,6 +33,7 @@ type Repo struct {
 	Head        string
 	Public      bool
 	Shared      bool
+	ForkOf      string `json:",omitempty"`
 }
 
 type Branch struct {
@@ -48,11 +56,101 @@ type RefLine struct {
 
 // CommitSummary represents a subset of information from a git commit.
 type CommitSummary struct {
-	Commit       string   `json:"commit"`
-	Committer    string   `json:"committer"`
-	Author       string   `json:"author"`
-	DateIso      string   `json:"dateiso"`
-	DateRelative string   `json:"daterel"`
-	Subject      string   `json:"subject"`
-	Changes      []string `json:"changes"`
+	Commit       string     `json:"commit"`
+	Committer    string     `json:"committer"`
+	Author       string     `json:"author"`
+	DateIso      string     `json:"dateiso"`
+	DateRelative string     `json:"daterel"`
+	Subject      string     `json:"subject"`
+	Changes      []string   `json:"changes"`
+	Signature    *Signature `json:"signature,omitempty"`
+}
+
+// Signature is the result of verifying the OpenPGP signature of a commit
+// or tag against the keys of the users: Status is "valid", "unknown key"
+// or "bad signature". Signer is the user the key belongs to, if known.
+type Signature struct {
+	Status string `json:"status"`
+	KeyID  string `json:"keyid,omitempty"`
+	Signer string `json:"signer,omitempty"`
+}
+
+// FileDiff represents the changes of a single file between two revisions.
+// Lines of hunks are prefixed with ' ', '-' or '+' as in unified diffs.
+type FileDiff struct {
+	Status      string     `json:"status"`
+	OldPath     string     `json:"oldpath,omitempty"`
+	NewPath     string     `json:"newpath,omitempty"`
+	Similarity  int        `json:"similarity,omitempty"`
+	OldAnnexKey string     `json:"oldannexkey,omitempty"`
+	NewAnnexKey string     `json:"newannexkey,omitempty"`
+	Binary      bool       `json:"binary,omitempty"`
+	TooLarge    bool       `json:"toolarge,omitempty"`
+	Hunks       []DiffHunk `json:"hunks,omitempty"`
+}
+
+// DiffHunk is a group of changed lines of a FileDiff.
+type DiffHunk struct {
+	Header string   `json:"header"`
+	Lines  []string `json:"lines"`
+}
+
+// FsckReport is the result of checking the objects and refs of a repository.
+// OK is false if any of the issues is an error, i.e. not just a dangling object.
+type FsckReport struct {
+	Objects int         `json:"objects"`
+	Packs   int         `json:"packs"`
+	OK      bool        `json:"ok"`
+	Issues  []FsckIssue `json:"issues"`
+}
+
+// FsckIssue is a single finding of a FsckReport. Path is relative
+// to the git directory of the repository.
+type FsckIssue struct {
+	Kind    string `json:"kind"`
+	Object  string `json:"object,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+	Error   bool   `json:"error"`
+}
+
+// Maintenance reports what the maintenance (repack, prune and
+// pack-refs) of a repository did. Pack is empty if the repository
+// did not need to be repacked. Cruft is the number of unreachable
+// objects kept in a cruft pack, for repositories with forks.
+type Maintenance struct {
+	Pack         string `json:"pack,omitempty"`
+	Objects      int    `json:"objects"`
+	PacksRemoved int    `json:"packs_removed"`
+	LooseRemoved int    `json:"loose_removed"`
+	Unpacked     int    `json:"unpacked"`
+	Cruft        int    `json:"cruft"`
+	Pruned       int    `json:"pruned"`
+	RefsPacked   int    `json:"refs_packed"`
+}
+
+// Tag describes a tag of a repository. Object is what the tag ref points
+// to, i.e. the tag object for annotated tags, which then point to Target
+// (of type TargetType). For lightweight tags Target is Object. Peeled
+// is the first object that is not a tag, usually a commit.
+type Tag struct {
+	Name       string     `json:"name"`
+	Object     string     `json:"object"`
+	Annotated  bool       `json:"annotated"`
+	Target     string     `json:"target"`
+	TargetType string     `json:"targettype,omitempty"`
+	Peeled     string     `json:"peeled"`
+	Tagger     string     `json:"tagger,omitempty"`
+	Date       string     `json:"date,omitempty"`
+	Message    string     `json:"message,omitempty"`
+	Signed     bool       `json:"signed,omitempty"`
+	Signature  *Signature `json:"signature,omitempty"`
+}
+
+// CreateTag is the request to create the tag Name for the revision
+// Target. The tag is annotated if Message is not empty.
+type CreateTag struct {
+	Name    string `json:"name"`
+	Target  string `json:"target"`
+	Message string `json:"message,omitempty"`
 }